@@ -18,11 +18,22 @@ package apcore
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/paths"
 	"github.com/allinbits/apcore/services"
 	"github.com/allinbits/apcore/util"
+	"github.com/tidwall/gjson"
 )
 
 func doCreateTables(configFilePath string, a app.Application, debug bool, scheme string) error {
@@ -44,7 +55,343 @@ func doCreateTables(configFilePath string, a app.Application, debug bool, scheme
 	if err = tx.Commit(); err != nil {
 		return err
 	}
-	return a.CreateTables(context.Background(), &services.Any{db}, cfg, debug)
+	if err = a.CreateTables(context.Background(), &services.Any{db}, cfg, debug); err != nil {
+		return err
+	}
+	return doMarkMigrationsApplied(configFilePath, a, debug, scheme)
+}
+
+func doMarkMigrationsApplied(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, migrations, _, err := newMigrationService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return migrations.MarkAllApplied(util.Context{context.Background()})
+}
+
+func doMigrate(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, migrations, _, err := newMigrationService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	done, err := migrations.Migrate(util.Context{context.Background()})
+	for _, m := range done {
+		fmt.Printf("Applied %s migration %d: %s\n", m.Component, m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Println("The database schema is already up to date.")
+	}
+	return nil
+}
+
+func doMigrateStatus(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, migrations, _, err := newMigrationService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	status, err := migrations.Status(util.Context{context.Background()})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tVERSION\tSTATE\tAPPLIED AT\tDESCRIPTION")
+	for _, m := range status {
+		state := "pending"
+		appliedAt := "-"
+		if m.Applied {
+			state = "applied"
+			appliedAt = m.AppliedAt.Format(time.RFC3339)
+		}
+		if m.Unknown {
+			state = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", m.Component, m.Version, state, appliedAt, m.Description)
+	}
+	return w.Flush()
+}
+
+func doMigrateDown(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, migrations, _, err := newMigrationService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := migrations.MigrateDown(util.Context{context.Background()})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reverted %s migration %d: %s\n", m.Component, m.Version, m.Description)
+	return nil
+}
+
+func doPolicyDryRun(configFilePath string, a app.Application, debug bool, scheme string, policyFile, inputFile string, limit int) error {
+	if len(policyFile) == 0 {
+		return fmt.Errorf("policy_file flag is not set")
+	}
+	b, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return err
+	}
+	var policy models.Policy
+	if err = json.Unmarshal(b, &policy); err != nil {
+		return fmt.Errorf("cannot parse policy file: %s", err)
+	}
+	db, policies, _, err := newPoliciesService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var res []services.DryRunResult
+	if len(inputFile) > 0 {
+		b, err = ioutil.ReadFile(inputFile)
+		if err != nil {
+			return err
+		} else if !gjson.ValidBytes(b) {
+			return fmt.Errorf("input file %s is not valid JSON", inputFile)
+		}
+		var payloads [][]byte
+		if in := gjson.ParseBytes(b); in.IsArray() {
+			for _, e := range in.Array() {
+				payloads = append(payloads, []byte(e.Raw))
+			}
+		} else {
+			payloads = append(payloads, b)
+		}
+		res, err = policies.DryRun(policy, payloads)
+	} else {
+		res, err = policies.DryRunStored(util.Context{context.Background()}, policy, limit)
+	}
+	if err != nil {
+		return err
+	}
+	n, nErr := 0, 0
+	for _, r := range res {
+		if r.Err != nil {
+			nErr++
+			fmt.Printf("ERROR %s\n    %s\n", r.IRI, r.Err)
+			continue
+		} else if !r.Resolution.Matched {
+			continue
+		}
+		n++
+		fmt.Printf("MATCH %s\n", r.IRI)
+		for _, l := range r.Resolution.MatchLog {
+			fmt.Printf("    %s\n", l)
+		}
+	}
+	fmt.Printf("Policy %q would match %d of %d payloads.\n", policy.Name, n, len(res))
+	if nErr > 0 {
+		fmt.Printf("The policy could not be evaluated against %d payloads.\n", nErr)
+	}
+	return nil
+}
+
+// deliveryFilter builds the filter for the deliveries command line actions.
+func deliveryFilter(c util.Context, users *services.Users, state, host, username string, olderThan time.Duration) (f services.DeliveryFilter, err error) {
+	f = services.DeliveryFilter{
+		State:     state,
+		Host:      host,
+		OlderThan: olderThan,
+	}
+	if len(username) > 0 {
+		var u *services.User
+		u, err = users.UserByUsername(c, username)
+		if err != nil {
+			return
+		} else if u == nil {
+			err = fmt.Errorf("no user with username %q", username)
+			return
+		}
+		f.UserID = paths.UUID(u.ID)
+	}
+	return
+}
+
+func doDeliveriesList(configFilePath string, a app.Application, debug bool, scheme string, state, host, username string, olderThan time.Duration, limit int) error {
+	db, dAttempts, users, _, err := newDeliveryAttemptsService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	c := util.Context{context.Background()}
+	f, err := deliveryFilter(c, users, state, host, username, olderThan)
+	if err != nil {
+		return err
+	}
+	ds, err := dAttempts.ListDeliveries(c, f, limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tATTEMPTS\tCREATED AT\tLAST ATTEMPT\tNEXT ATTEMPT\tDELIVER TO")
+	for _, d := range ds {
+		next := "-"
+		if d.State == models.NewDeliveryAttempt || d.State == models.FailedDeliveryAttempt {
+			next = d.NextAttempt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", d.ID, d.State, d.NAttempts, d.CreateTime.Format(time.RFC3339), d.LastAttempt.Format(time.RFC3339), next, d.DeliverTo)
+	}
+	return w.Flush()
+}
+
+func doDeliveriesRequeue(configFilePath string, a app.Application, debug bool, scheme string, state, host, username string, olderThan time.Duration) error {
+	db, dAttempts, users, _, err := newDeliveryAttemptsService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	c := util.Context{context.Background()}
+	f, err := deliveryFilter(c, users, state, host, username, olderThan)
+	if err != nil {
+		return err
+	}
+	n, err := dAttempts.RequeueDeliveries(c, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Requeued %d deliveries.\n", n)
+	return nil
+}
+
+func doDeliveriesPurge(configFilePath string, a app.Application, debug bool, scheme string, state, host, username string, olderThan time.Duration) error {
+	db, dAttempts, users, _, err := newDeliveryAttemptsService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	c := util.Context{context.Background()}
+	f, err := deliveryFilter(c, users, state, host, username, olderThan)
+	if err != nil {
+		return err
+	}
+	n, err := dAttempts.PurgeDeliveries(c, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d deliveries.\n", n)
+	return nil
+}
+
+func doDeliveriesGoneList(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, dAttempts, _, _, err := newDeliveryAttemptsService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ga, err := dAttempts.ListGoneActors(util.Context{context.Background()})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTOR\tGONE AT\tEXPIRES\tINBOX")
+	for _, g := range ga {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", g.ActorIRI, g.GoneAt.Format(time.RFC3339), g.Expires.Format(time.RFC3339), g.Inbox)
+	}
+	return w.Flush()
+}
+
+func doDeliveriesGoneClear(configFilePath string, a app.Application, debug bool, scheme string, actor string) error {
+	db, dAttempts, _, _, err := newDeliveryAttemptsService(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	c := util.Context{context.Background()}
+	if len(actor) == 0 {
+		n, err := dAttempts.ClearGoneActors(c)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cleared %d gone actors.\n", n)
+		return nil
+	}
+	iri, err := url.Parse(actor)
+	if err != nil {
+		return err
+	}
+	cleared, err := dAttempts.ClearGoneActor(c, iri)
+	if err != nil {
+		return err
+	} else if !cleared {
+		return fmt.Errorf("actor %s is not recorded as gone", actor)
+	}
+	fmt.Printf("Cleared gone actor %s.\n", actor)
+	return nil
+}
+
+func doRotateKeys(configFilePath string, a app.Application, debug bool, scheme string, username string) error {
+	c := context.Background()
+	var userID paths.UUID
+	if len(username) > 0 {
+		db, users, _, err := newUserService(configFilePath, a, debug, scheme)
+		if err != nil {
+			return err
+		}
+		u, err := users.UserByUsername(util.Context{c}, username)
+		db.Close()
+		if err != nil {
+			return err
+		} else if u == nil {
+			return fmt.Errorf("no user with username %q", username)
+		}
+		userID = paths.UUID(u.ID)
+	}
+	_, fw, err := newServer(configFilePath, a, debug)
+	if err != nil {
+		return err
+	}
+	if len(userID) == 0 {
+		if err := fw.RotateInstanceActorKeys(c); err != nil {
+			return err
+		}
+		util.InfoLogger.Info("Rotated the keys of the instance actor")
+		return nil
+	}
+	if err := fw.RotateKeys(c, userID); err != nil {
+		return err
+	}
+	util.InfoLogger.Infof("Rotated the keys of user %q", username)
+	return nil
+}
+
+func doClientsList(configFilePath string, a app.Application, debug bool, scheme string) error {
+	db, oauth, _, err := newOAuth2Service(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	cr, err := oauth.ClientRegistrations(context.Background())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tREGISTERED AT\tREDIRECT URIS")
+	for _, r := range cr {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.ClientID, r.Metadata.ClientName, r.CreateTime.Format(time.RFC3339), strings.Join(r.Metadata.RedirectURIs, " "))
+	}
+	return w.Flush()
+}
+
+func doClientsRevoke(configFilePath string, a app.Application, debug bool, scheme string, clientID string) error {
+	if len(clientID) == 0 {
+		return fmt.Errorf("the client_id flag is required")
+	}
+	db, oauth, _, err := newOAuth2Service(configFilePath, a, debug, scheme)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err = oauth.RevokeClient(context.Background(), clientID); err != nil {
+		return err
+	}
+	util.InfoLogger.Infof("Revoked OAuth2 client %q", clientID)
+	return nil
 }
 
 func doInitAdmin(configFilePath string, a app.Application, debug bool, scheme string) error {
@@ -68,18 +415,10 @@ func doInitAdmin(configFilePath string, a app.Application, debug bool, scheme st
 
 	// Create the user in the database
 	defer db.Close()
-	tx, err := db.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
 	userID, err := users.CreateAdminUser(util.Context{context.Background()}, p, password)
 	if err != nil {
 		return err
 	}
-	if err = tx.Commit(); err != nil {
-		return err
-	}
 	return a.OnCreateAdminUser(context.Background(), userID, &services.Any{db}, c)
 }
 
@@ -91,16 +430,8 @@ func doInitData(configFilePath string, a app.Application, debug bool, scheme str
 
 	// Create the server actor in the database
 	defer db.Close()
-	tx, err := db.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
 	_, err = users.CreateInstanceActorSingleton(util.Context{context.Background()}, scheme, c.ServerConfig.Host, c.ServerConfig.RSAKeySize)
-	if err != nil {
-		return err
-	}
-	return tx.Commit()
+	return err
 }
 
 func doInitServerProfile(configFilePath string, a app.Application, debug bool, scheme string) error {
@@ -114,14 +445,5 @@ func doInitServerProfile(configFilePath string, a app.Application, debug bool, s
 	if err != nil {
 		return err
 	}
-	tx, err := db.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	err = users.SetServerPreferences(util.Context{context.Background()}, sp)
-	if err != nil {
-		return err
-	}
-	return tx.Commit()
+	return users.SetServerPreferences(util.Context{context.Background()}, sp)
 }
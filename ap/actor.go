@@ -37,7 +37,8 @@ func NewActor(c *config.Config,
 	po *services.Policies,
 	f *services.Followers,
 	u *services.Users,
-	tc *conn.Controller) (actor pub.Actor, err error) {
+	tc *conn.Controller,
+	keys *PublicKeys) (actor pub.Actor, err error) {
 
 	common := NewCommonBehavior(a, db, tc, o, pk)
 	ca, isC2S := a.(app.C2SApplication)
@@ -46,7 +47,7 @@ func NewActor(c *config.Config,
 		err = fmt.Errorf("the Application is neither a C2SApplication nor a S2SApplication")
 	} else if isC2S && isS2S {
 		c2s := NewSocialBehavior(ca, o)
-		s2s := NewFederatingBehavior(c, sa, db, po, pk, f, u, tc)
+		s2s := NewFederatingBehavior(c, sa, db, po, pk, f, u, tc, keys)
 		actor = pub.NewActor(
 			common,
 			c2s,
@@ -61,7 +62,7 @@ func NewActor(c *config.Config,
 			apdb,
 			clock)
 	} else {
-		s2s := NewFederatingBehavior(c, sa, db, po, pk, f, u, tc)
+		s2s := NewFederatingBehavior(c, sa, db, po, pk, f, u, tc, keys)
 		actor = pub.NewFederatingActor(
 			common,
 			s2s,
@@ -30,9 +30,10 @@ func NewActorMap(c *config.Config,
 	apdb *APDB,
 	pk *services.PrivateKeys,
 	f *services.Followers,
-	tc *conn.Controller) (actorMap map[paths.Actor]pub.Actor) {
+	tc *conn.Controller,
+	keys *PublicKeys) (actorMap map[paths.Actor]pub.Actor) {
 	actorMap = make(map[paths.Actor]pub.Actor, 1)
-	actorMap[paths.InstanceActor] = newInstanceActor(c, clock, db, apdb, pk, f, tc)
+	actorMap[paths.InstanceActor] = newInstanceActor(c, clock, db, apdb, pk, f, tc, keys)
 	return
 }
 
@@ -42,9 +43,10 @@ func newInstanceActor(c *config.Config,
 	apdb *APDB,
 	pk *services.PrivateKeys,
 	f *services.Followers,
-	tc *conn.Controller) (actor pub.Actor) {
+	tc *conn.Controller,
+	keys *PublicKeys) (actor pub.Actor) {
 	common := newInstanceActorCommonBehavior(db, tc, pk)
-	s2s := newInstanceActorFederatingBehavior(c, db, pk, f, tc)
+	s2s := newInstanceActorFederatingBehavior(c, db, pk, f, tc, keys)
 	actor = pub.NewFederatingActor(common, s2s, apdb, clock)
 	return
 }
@@ -0,0 +1,73 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ap
+
+import (
+	"net/http"
+
+	"github.com/allinbits/apcore/framework/conn"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+// FetchAuthorizer determines whether a federated peer may fetch ActivityPub
+// data when authorized fetch, also known as secure mode, is enabled.
+type FetchAuthorizer struct {
+	db   *Database
+	pk   *services.PrivateKeys
+	po   *services.Policies
+	tc   *conn.Controller
+	keys *PublicKeys
+}
+
+func NewFetchAuthorizer(db *Database,
+	pk *services.PrivateKeys,
+	po *services.Policies,
+	tc *conn.Controller,
+	keys *PublicKeys) *FetchAuthorizer {
+	return &FetchAuthorizer{
+		db:   db,
+		pk:   pk,
+		po:   po,
+		tc:   tc,
+		keys: keys,
+	}
+}
+
+// AuthorizeFetch permits a GET request that carries a valid HTTP Signature
+// from an actor whose domain is not blocked by a federated_block policy.
+//
+// A signature that cannot be verified only denies the request, so that a
+// peer sending malformed signatures cannot cause server errors.
+func (f *FetchAuthorizer) AuthorizeFetch(c util.Context, r *http.Request) (authorized bool, err error) {
+	if !hasHttpSignature(r) {
+		return
+	}
+	owner, authenticated, verr := verifyHttpSignatures(c, r, f.db, f.pk, f.tc, f.keys)
+	if verr != nil {
+		util.InfoLogger.Infof("Denying fetch of %s with an unverifiable HTTP Signature: %s", r.URL, verr)
+		return
+	} else if !authenticated {
+		return
+	}
+	var blocked bool
+	if blocked, err = f.po.BlocksFetch(c, owner); err != nil {
+		return
+	}
+	authorized = !blocked
+	return
+}
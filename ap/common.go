@@ -18,6 +18,7 @@ package ap
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"net/http"
 	"net/url"
@@ -95,7 +96,13 @@ func (a *CommonBehavior) NewTransport(c context.Context, actorBoxIRI *url.URL, g
 	if err != nil {
 		return
 	}
-	return a.tc.Get(privKey, pubKeyURL.String())
+	var proofKey ed25519.PrivateKey
+	var proofKeyURL *url.URL
+	proofKey, proofKeyURL, err = a.pk.GetUserEd25519Key(ctx, userUUID)
+	if err != nil {
+		return
+	}
+	return a.tc.GetWithProofs(privKey, pubKeyURL.String(), keyOwner(proofKeyURL), proofKey, proofKeyURL)
 }
 
 func (a *CommonBehavior) authenticateGetRequest(c util.Context, w http.ResponseWriter, r *http.Request) (newCtx context.Context, authenticated bool, err error) {
@@ -112,6 +112,10 @@ func (d *Database) SetInbox(c context.Context, inbox vocab.ActivityStreamsOrdere
 	if err != nil {
 		return err
 	}
+	if (util.Context{c}).IsQuarantined() {
+		util.InfoLogger.Infof("Not adding quarantined activity %s to inbox %s", id, inboxIRI)
+		return nil
+	}
 	return d.inboxes.PrependItem(util.Context{c}, paths.Normalize(inboxIRI), id)
 }
 
@@ -18,6 +18,7 @@ package ap
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"net/http"
 	"net/url"
@@ -79,5 +80,11 @@ func (a *instanceActorCommonBehavior) NewTransport(c context.Context, actorBoxIR
 	if err != nil {
 		return
 	}
-	return a.tc.Get(privKey, pubKeyURL.String())
+	var proofKey ed25519.PrivateKey
+	var proofKeyURL *url.URL
+	proofKey, proofKeyURL, err = a.pk.GetEd25519KeyForInstanceActor(util.Context{c})
+	if err != nil {
+		return
+	}
+	return a.tc.GetWithProofs(privKey, pubKeyURL.String(), keyOwner(proofKeyURL), proofKey, proofKeyURL)
 }
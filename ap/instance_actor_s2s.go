@@ -38,13 +38,15 @@ type instanceActorFederatingBehavior struct {
 	pk                      *services.PrivateKeys
 	f                       *services.Followers
 	tc                      *conn.Controller
+	keys                    *PublicKeys
 }
 
 func newInstanceActorFederatingBehavior(c *config.Config,
 	db *Database,
 	pk *services.PrivateKeys,
 	f *services.Followers,
-	tc *conn.Controller) *instanceActorFederatingBehavior {
+	tc *conn.Controller,
+	keys *PublicKeys) *instanceActorFederatingBehavior {
 	return &instanceActorFederatingBehavior{
 		maxInboxForwardingDepth: c.ActivityPubConfig.MaxInboxForwardingRecursionDepth,
 		maxDeliveryDepth:        c.ActivityPubConfig.MaxDeliveryRecursionDepth,
@@ -52,6 +54,7 @@ func newInstanceActorFederatingBehavior(c *config.Config,
 		pk:                      pk,
 		f:                       f,
 		tc:                      tc,
+		keys:                    keys,
 	}
 }
 
@@ -63,7 +66,7 @@ func (f *instanceActorFederatingBehavior) PostInboxRequestBodyHook(c context.Con
 }
 
 func (f *instanceActorFederatingBehavior) AuthenticatePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (out context.Context, authenticated bool, err error) {
-	authenticated, err = verifyHttpSignatures(c, r, f.db, f.pk, f.tc)
+	_, authenticated, err = verifyHttpSignatures(c, r, f.db, f.pk, f.tc, f.keys)
 	out = c
 	return
 }
@@ -0,0 +1,97 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ap
+
+import (
+	"github.com/allinbits/apcore/services"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams/vocab"
+)
+
+type attachmentHaver interface {
+	GetActivityStreamsAttachment() vocab.ActivityStreamsAttachmentProperty
+	SetActivityStreamsAttachment(i vocab.ActivityStreamsAttachmentProperty)
+}
+
+type tagHaver interface {
+	GetActivityStreamsTag() vocab.ActivityStreamsTagProperty
+	SetActivityStreamsTag(i vocab.ActivityStreamsTagProperty)
+}
+
+type unknownPropertiesHaver interface {
+	GetUnknownProperties() map[string]interface{}
+}
+
+// rewriteForPolicies applies the rewriting effects of matched policies to an
+// activity and the objects embedded in it.
+func rewriteForPolicies(o services.PolicyOutcome, a pub.Activity) {
+	types := []vocab.Type{a}
+	if op := a.GetActivityStreamsObject(); op != nil {
+		for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+			if t := iter.GetType(); t != nil {
+				types = append(types, t)
+			}
+		}
+	}
+	for _, t := range types {
+		if o.StripMedia {
+			stripMedia(t)
+		}
+		if o.DropMentions {
+			dropMentions(t)
+		}
+		if o.ForceSensitive {
+			forceSensitive(t)
+		}
+	}
+}
+
+// stripMedia removes all attachments.
+func stripMedia(t vocab.Type) {
+	if ah, ok := t.(attachmentHaver); ok && ah.GetActivityStreamsAttachment() != nil {
+		ah.SetActivityStreamsAttachment(nil)
+	}
+}
+
+// dropMentions removes all Mention tags, keeping any other tags.
+//
+// Tags are removed in place so that values not understood by go-fed are kept.
+func dropMentions(t vocab.Type) {
+	th, ok := t.(tagHaver)
+	if !ok || th.GetActivityStreamsTag() == nil {
+		return
+	}
+	tags := th.GetActivityStreamsTag()
+	for i := tags.Len() - 1; i >= 0; i-- {
+		if tags.At(i).IsActivityStreamsMention() {
+			tags.Remove(i)
+		}
+	}
+	if tags.Len() == 0 {
+		th.SetActivityStreamsTag(nil)
+	}
+}
+
+// forceSensitive marks the type as sensitive.
+//
+// The "sensitive" property is a widely adopted extension that is unknown to
+// the go-fed vocabulary, so it can only be set as an unknown property.
+func forceSensitive(t vocab.Type) {
+	if uh, ok := t.(unknownPropertiesHaver); ok && uh.GetUnknownProperties() != nil {
+		uh.GetUnknownProperties()["sensitive"] = true
+	}
+}
@@ -0,0 +1,96 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ap
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/allinbits/apcore/framework/conn"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+// verifyProof verifies the Data Integrity proof of the activity in a request's
+// body, which must have been created with a key of the activity's actor. This
+// allows an activity forwarded by another server to be attributed to its
+// actor.
+//
+// The body is left unread for handling the request.
+func verifyProof(c util.Context,
+	r *http.Request,
+	pk *services.PrivateKeys,
+	tc *conn.Controller,
+	keys *PublicKeys) (status models.ProofStatus, err error) {
+	var b []byte
+	if b, err = ioutil.ReadAll(r.Body); err != nil {
+		return
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	var a struct {
+		Actor interface{} `json:"actor"`
+	}
+	if err = json.Unmarshal(b, &a); err != nil {
+		return
+	}
+	actor, _ := a.Actor.(string)
+	if m, ok := a.Actor.(map[string]interface{}); ok {
+		actor, _ = m["id"].(string)
+	}
+	// Try the cached public key first, and if it does not verify then
+	// fetch the key again in case it has been rotated.
+	var usedCache bool
+	keyFn := func(fetch bool) func(*url.URL) (crypto.PublicKey, error) {
+		return func(keyID *url.URL) (pKey crypto.PublicKey, err error) {
+			var owner *url.URL
+			var ok bool
+			if !fetch {
+				pKey, owner, ok = keys.get(c, keyID)
+				usedCache = ok
+			}
+			if !ok {
+				if pKey, owner, err = fetchPublicKey(c, pk, tc, keys, keyID); err != nil {
+					return
+				}
+			}
+			if owner.String() != actor {
+				return nil, fmt.Errorf("proof key %s is owned by %s, not the actor %q", keyID, owner, actor)
+			}
+			return
+		}
+	}
+	verr := conn.VerifyProof(b, keyFn(false))
+	if verr != nil && verr != conn.ErrNoProof && usedCache {
+		verr = conn.VerifyProof(b, keyFn(true))
+	}
+	if verr == conn.ErrNoProof {
+		status = models.ProofAbsent
+	} else if verr != nil {
+		util.InfoLogger.Infof("Proof of activity by %q does not verify: %s", actor, verr)
+		status = models.ProofInvalid
+	} else {
+		status = models.ProofValid
+	}
+	return
+}
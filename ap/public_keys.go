@@ -0,0 +1,126 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ap
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/activity/pub"
+)
+
+// PublicKeys caches the public keys of federated peers by their id, so that
+// verifying the HTTP Signature of an incoming request does not require
+// fetching its sender's key every time.
+type PublicKeys struct {
+	// Immutable
+	clock   pub.Clock
+	maxAge  time.Duration
+	rpk     *services.RemotePublicKeys
+	pruneFn *util.SafeStartStop
+}
+
+func NewPublicKeys(c *config.Config, clock pub.Clock, rpk *services.RemotePublicKeys) *PublicKeys {
+	p := &PublicKeys{
+		clock:  clock,
+		maxAge: time.Duration(c.ActivityPubConfig.RemotePublicKeyCacheSeconds) * time.Second,
+		rpk:    rpk,
+	}
+	if p.maxAge > 0 {
+		p.pruneFn = util.NewSafeStartStop(p.prune, p.maxAge)
+	}
+	return p
+}
+
+func (p *PublicKeys) Start() {
+	if p.pruneFn == nil {
+		util.InfoLogger.Info("Caching of federated peers' public keys is disabled")
+		return
+	}
+	p.pruneFn.Start()
+}
+
+func (p *PublicKeys) Stop() {
+	if p.pruneFn == nil {
+		return
+	}
+	p.pruneFn.Stop()
+}
+
+// get returns the cached public key with the id and its owner, if it has not
+// expired.
+//
+// A cached key that cannot be read or parsed is logged, and the key is fetched
+// from its peer again instead.
+func (p *PublicKeys) get(c util.Context, keyID *url.URL) (k crypto.PublicKey, owner *url.URL, ok bool) {
+	if p.maxAge <= 0 {
+		return
+	}
+	rk, found, err := p.rpk.Get(c, keyID)
+	if err != nil {
+		util.ErrorLogger.Errorf("Failed to read cached public key %s: %s", keyID, err)
+		return
+	} else if !found || !p.clock.Now().Before(rk.Expires) {
+		return
+	}
+	k, err = parsePublicKeyPem(rk.PublicKeyPEM)
+	if err != nil {
+		util.ErrorLogger.Errorf("Failed to parse cached public key %s: %s", keyID, err)
+		return nil, nil, false
+	}
+	return k, rk.Owner.URL, true
+}
+
+// put caches a freshly fetched public key along with its owner.
+func (p *PublicKeys) put(c util.Context, keyID *url.URL, k crypto.PublicKey, owner *url.URL) {
+	if p.maxAge <= 0 {
+		return
+	}
+	b, err := x509.MarshalPKIXPublicKey(k)
+	if err != nil {
+		util.ErrorLogger.Errorf("Failed to marshal public key %s for caching: %s", keyID, err)
+		return
+	}
+	rk := models.RemotePublicKey{
+		PublicKeyPEM: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: b,
+		})),
+		Owner:   models.URL{owner},
+		Expires: p.clock.Now().Add(p.maxAge),
+	}
+	if err = p.rpk.Put(c, keyID, rk); err != nil {
+		util.ErrorLogger.Errorf("Failed to cache public key %s: %s", keyID, err)
+	}
+}
+
+func (p *PublicKeys) prune(ctx context.Context) {
+	n, err := p.rpk.Prune(util.Context{ctx}, p.clock.Now())
+	if err != nil {
+		util.ErrorLogger.Errorf("Error pruning cached public keys: %s", err)
+		return
+	}
+	util.InfoLogger.Infof("Pruned %d expired public keys of federated peers", n)
+}
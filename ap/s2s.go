@@ -24,6 +24,7 @@ import (
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/config"
 	"github.com/allinbits/apcore/framework/conn"
+	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/paths"
 	"github.com/allinbits/apcore/services"
 	"github.com/allinbits/apcore/util"
@@ -43,6 +44,7 @@ type FederatingBehavior struct {
 	f                       *services.Followers
 	u                       *services.Users
 	tc                      *conn.Controller
+	keys                    *PublicKeys
 }
 
 func NewFederatingBehavior(c *config.Config,
@@ -52,7 +54,8 @@ func NewFederatingBehavior(c *config.Config,
 	pk *services.PrivateKeys,
 	f *services.Followers,
 	u *services.Users,
-	tc *conn.Controller) *FederatingBehavior {
+	tc *conn.Controller,
+	keys *PublicKeys) *FederatingBehavior {
 	return &FederatingBehavior{
 		maxInboxForwardingDepth: c.ActivityPubConfig.MaxInboxForwardingRecursionDepth,
 		maxDeliveryDepth:        c.ActivityPubConfig.MaxDeliveryRecursionDepth,
@@ -63,19 +66,31 @@ func NewFederatingBehavior(c *config.Config,
 		f:                       f,
 		u:                       u,
 		tc:                      tc,
+		keys:                    keys,
 	}
 }
 
 func (f *FederatingBehavior) PostInboxRequestBodyHook(c context.Context, r *http.Request, activity pub.Activity) (out context.Context, err error) {
 	ctx := &util.Context{c}
 	ctx.WithActivity(activity)
+	ctx.WithQuarantineFlag()
 	out = ctx.Context
 	return
 }
 
 func (f *FederatingBehavior) AuthenticatePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (out context.Context, authenticated bool, err error) {
-	authenticated, err = verifyHttpSignatures(c, r, f.db, f.pk, f.tc)
+	_, authenticated, err = verifyHttpSignatures(c, r, f.db, f.pk, f.tc, f.keys)
 	out = c
+	if err != nil || !authenticated {
+		return
+	}
+	var status models.ProofStatus
+	if status, err = verifyProof(util.Context{c}, r, f.pk, f.tc, f.keys); err != nil {
+		return
+	}
+	ctx := &util.Context{c}
+	ctx.WithProofStatus(string(status))
+	out = ctx.Context
 	return
 }
 
@@ -89,7 +104,27 @@ func (f *FederatingBehavior) Blocked(c context.Context, actorIRIs []*url.URL) (b
 	if actorID, err = ctx.ActorIRI(); err != nil {
 		return
 	}
-	blocked, err = f.po.IsBlocked(ctx, actorID, activity)
+	var proof string
+	if proof, err = ctx.ProofStatus(); err != nil {
+		return
+	}
+	var o services.PolicyOutcome
+	if o, err = f.po.Evaluate(ctx, actorID, actorIRIs, activity, models.ProofStatus(proof)); err != nil {
+		return
+	} else if o.Blocked {
+		blocked = true
+		return
+	}
+	if o.Quarantine {
+		if err = ctx.MarkQuarantined(); err != nil {
+			return
+		}
+	}
+	// The activity is modified in place, as it is the same value that is
+	// later processed and stored.
+	if o.Rewrites() {
+		rewriteForPolicies(o, activity)
+	}
 	return
 }
 
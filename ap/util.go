@@ -19,6 +19,7 @@ package ap
 import (
 	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
@@ -26,9 +27,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/allinbits/apcore/framework/conn"
-	"github.com/allinbits/apcore/paths"
 	"github.com/allinbits/apcore/services"
 	"github.com/allinbits/apcore/util"
 	"github.com/go-fed/activity/pub"
@@ -41,12 +43,23 @@ type publicKeyer interface {
 	GetW3IDSecurityV1PublicKey() vocab.W3IDSecurityV1PublicKeyProperty
 }
 
-func getPublicKeyFromResponse(c context.Context, b []byte, keyId *url.URL) (p crypto.PublicKey, err error) {
+// getPublicKeyFromResponse finds the public key with the id in a fetched
+// document, along with the actor owning it. The owner is the one claimed by the
+// key, or else the actor whose document embeds it, and must be on the same
+// host as the key.
+func getPublicKeyFromResponse(c context.Context, b []byte, keyId *url.URL) (p crypto.PublicKey, owner *url.URL, err error) {
 	m := make(map[string]interface{}, 0)
 	err = json.Unmarshal(b, &m)
 	if err != nil {
 		return
 	}
+	var found bool
+	if p, owner, found, err = getKeyFromJSON(m, keyId); found {
+		if err == nil {
+			err = checkKeyOwner(keyId, owner)
+		}
+		return
+	}
 	var t vocab.Type
 	t, err = streams.ToType(c, m)
 	if err != nil {
@@ -88,7 +101,86 @@ func getPublicKeyFromResponse(c context.Context, b []byte, keyId *url.URL) (p cr
 		err = fmt.Errorf("publicKeyPem property is not provided or it is not embedded as a value")
 		return
 	}
-	pubKeyPem := pkPemProp.Get()
+	if op := pkpFound.GetW3IDSecurityV1Owner(); op != nil && op.IsIRI() {
+		owner = op.GetIRI()
+	} else if op != nil && op.IsXMLSchemaAnyURI() {
+		owner = op.Get()
+	} else if owner, err = pub.GetId(t); err != nil {
+		return
+	}
+	if err = checkKeyOwner(keyId, owner); err != nil {
+		return
+	}
+	p, err = parsePublicKeyPem(pkPemProp.Get())
+	return
+}
+
+// getKeyFromJSON finds keys that are not understood by the go-fed vocabulary:
+// a Multikey in the assertionMethod of an actor, or a document that is itself
+// the key.
+func getKeyFromJSON(m map[string]interface{}, keyId *url.URL) (p crypto.PublicKey, owner *url.URL, found bool, err error) {
+	candidates := []interface{}{m}
+	switch am := m["assertionMethod"].(type) {
+	case []interface{}:
+		candidates = append(candidates, am...)
+	case map[string]interface{}:
+		candidates = append(candidates, am)
+	}
+	for i, candidate := range candidates {
+		km, ok := candidate.(map[string]interface{})
+		if !ok || km["id"] != keyId.String() {
+			continue
+		}
+		mb, isMultikey := km["publicKeyMultibase"].(string)
+		keyPem, isPem := km["publicKeyPem"].(string)
+		if !isMultikey && !isPem {
+			continue
+		}
+		found = true
+		// A key in an actor's assertionMethod is the actor's unless it
+		// names another controller.
+		ownerID, ok := km["controller"].(string)
+		if !ok {
+			ownerID, ok = km["owner"].(string)
+		}
+		if !ok && i > 0 {
+			ownerID, ok = m["id"].(string)
+		}
+		if !ok {
+			err = fmt.Errorf("public key %s has no controller nor owner", keyId)
+			return
+		} else if owner, err = url.Parse(ownerID); err != nil {
+			return
+		}
+		if isMultikey {
+			p, err = util.DecodeMultikey(mb)
+		} else {
+			p, err = parsePublicKeyPem(keyPem)
+		}
+		return
+	}
+	return
+}
+
+// checkKeyOwner ensures that a public key is owned by an actor on its own
+// host, so that a server cannot claim keys for actors on other servers.
+func checkKeyOwner(keyID, owner *url.URL) error {
+	if owner.Scheme != keyID.Scheme || owner.Host != keyID.Host {
+		return fmt.Errorf("public key %s is owned by %s on another host", keyID, owner)
+	}
+	return nil
+}
+
+// verifyAlgorithm is the draft-cavage HTTP Signatures algorithm to verify
+// with the public key.
+func verifyAlgorithm(pKey crypto.PublicKey, tc *conn.Controller) httpsig.Algorithm {
+	if _, ok := pKey.(ed25519.PublicKey); ok {
+		return httpsig.ED25519
+	}
+	return tc.GetFirstAlgorithm()
+}
+
+func parsePublicKeyPem(pubKeyPem string) (p crypto.PublicKey, err error) {
 	var block *pem.Block
 	block, _ = pem.Decode([]byte(pubKeyPem))
 	if block == nil || block.Type != "PUBLIC KEY" {
@@ -103,11 +195,21 @@ func verifyHttpSignatures(c context.Context,
 	r *http.Request,
 	db *Database,
 	pk *services.PrivateKeys,
-	tc *conn.Controller) (authenticated bool, err error) {
+	tc *conn.Controller,
+	keys *PublicKeys) (owner *url.URL, authenticated bool, err error) {
 	// 1. Figure out what key we need to verify
 	ctx := util.Context{c}
 	var v httpsig.Verifier
-	v, err = httpsig.NewVerifier(r)
+	if conn.IsMessageSignature(r) {
+		var target *url.URL
+		target, err = ctx.CompleteRequestURL()
+		if err != nil {
+			return
+		}
+		v, err = conn.NewMessageVerifier(r, target, time.Now())
+	} else {
+		v, err = httpsig.NewVerifier(r)
+	}
 	if err != nil {
 		return
 	}
@@ -117,35 +219,70 @@ func verifyHttpSignatures(c context.Context,
 	if err != nil {
 		return
 	}
-	// 2. Get our user's credentials
-	var userUUID paths.UUID
-	userUUID, err = ctx.UserPathUUID()
+	// 2. Try the cached public key of the other actor, which is fetched
+	// again below if it fails to verify in case the key has been rotated
+	if pKey, pOwner, ok := keys.get(ctx, kIdIRI); ok && v.Verify(pKey, verifyAlgorithm(pKey, tc)) == nil {
+		owner = pOwner
+		authenticated = true
+		return
+	}
+	// 3. Fetch the public key of the other actor
+	pKey, owner, err := fetchPublicKey(ctx, pk, tc, keys, kIdIRI)
 	if err != nil {
 		return
 	}
+	// 4. Verify the other actor's key
+	authenticated = nil == v.Verify(pKey, verifyAlgorithm(pKey, tc))
+	return
+}
+
+// fetchPublicKey fetches the public key of another actor and the actor owning
+// it, bypassing any cached copy of the document holding it, and caches them.
+//
+// The fetch is signed by the instance actor, as it may not be on behalf of
+// any user and the other actor's server may require fetches to be signed.
+func fetchPublicKey(c util.Context,
+	pk *services.PrivateKeys,
+	tc *conn.Controller,
+	keys *PublicKeys,
+	keyID *url.URL) (pKey crypto.PublicKey, owner *url.URL, err error) {
 	var privKey *rsa.PrivateKey
 	var pubKeyURL *url.URL
-	privKey, pubKeyURL, err = pk.GetUserHTTPSignatureKey(ctx, userUUID)
+	privKey, pubKeyURL, err = pk.GetUserHTTPSignatureKeyForInstanceActor(c)
 	if err != nil {
 		return
 	}
-	pubKeyId := pubKeyURL.String()
-	// 3. Fetch the public key of the other actor using our credentials
-	tp, err := tc.Get(privKey, pubKeyId)
+	tp, err := tc.Get(privKey, pubKeyURL.String())
 	if err != nil {
 		return
 	}
+	tc.ForgetDereferenced(c, keyID)
 	var b []byte
-	b, err = tp.Dereference(c, kIdIRI)
+	b, err = tp.Dereference(c, keyID)
 	if err != nil {
 		return
 	}
-	pKey, err := getPublicKeyFromResponse(c, b, kIdIRI)
+	pKey, owner, err = getPublicKeyFromResponse(c, b, keyID)
 	if err != nil {
 		return
 	}
-	// 4. Verify the other actor's key
-	algo := tc.GetFirstAlgorithm()
-	authenticated = nil == v.Verify(pKey, algo)
+	keys.put(c, keyID, pKey, owner)
 	return
 }
+
+// hasHttpSignature determines whether the request carries an RFC 9421 HTTP
+// Message Signature or a draft-cavage HTTP Signature.
+func hasHttpSignature(r *http.Request) bool {
+	return conn.IsMessageSignature(r) ||
+		r.Header.Get("Signature") != "" ||
+		strings.HasPrefix(r.Header.Get("Authorization"), "Signature ")
+}
+
+// keyOwner approximates the actor owning a public key by its id, which is
+// conventionally the actor's id with a fragment.
+func keyOwner(keyID *url.URL) *url.URL {
+	u := *keyID // Copy
+	u.Fragment = ""
+	u.RawFragment = ""
+	return &u
+}
@@ -19,6 +19,8 @@ package app
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/go-fed/activity/pub"
 	"github.com/go-fed/activity/streams/vocab"
@@ -360,6 +362,58 @@ type S2SApplication interface {
 	ApplyFederatingCallbacks(fwc *pub.FederatingWrappedCallbacks) (others []interface{})
 }
 
+// MigratingApplication is an Application whose database tables change between
+// versions of the software. Implementing it allows the "migrate" family of
+// command line actions to upgrade and downgrade the application's tables
+// alongside apcore's own.
+//
+// The tables created in CreateTables must always reflect the schema after the
+// last migration, as a newly initialized database is recorded as having
+// applied all of them.
+type MigratingApplication interface {
+	Application
+	// Migrations returns the ordered migrations of the application's
+	// tables. Versions must be positive and strictly increasing, and a
+	// released migration must never be changed.
+	Migrations(apc APCoreConfig) []Migration
+}
+
+// RetryingApplication is an Application that decides how long to wait before
+// re-attempting a delivery that failed. It is only used when the "custom"
+// retry backoff strategy is set in the config.
+type RetryingApplication interface {
+	Application
+	// RetryBackoff returns how long to wait before attempting a delivery
+	// to the inbox again, after it has failed nFailures times in a row.
+	RetryBackoff(to *url.URL, nFailures int) time.Duration
+}
+
+// TwoFactorApplication is an Application that lets users log in with a second
+// factor in addition to their password: a time-based one-time password, a
+// WebAuthn credential, or a recovery code. Users enroll their second factors
+// through the Framework.
+type TwoFactorApplication interface {
+	Application
+	// Web handler for a GET call to the page of the second login step,
+	// which is shown after a user with two-factor authentication enters
+	// their password.
+	//
+	// It should render a form that POSTs to the "/login/2fa" endpoint with
+	// one of the "totp_code", "recovery_code", or "webauthn_credential"
+	// values, while keeping the query parameters of the page. The
+	// "webauthn_credential" is the JSON serialization of the credential
+	// returned by navigator.credentials.get, when passed the options
+	// obtained by a POST to "/login/webauthn".
+	//
+	// If the URL contains a query parameter "login_error" with a value of
+	// "true", then it should convey to the user that the second factor
+	// previously entered was incorrect.
+	//
+	// The OAuth2 authorization page may accept the same values alongside
+	// the email and password.
+	GetSecondFactorWebHandlerFunc(Framework) http.HandlerFunc
+}
+
 // APCoreConfig allows the application to reuse common fields set in apcore's config.
 type APCoreConfig interface {
 	// Hostname of the application set in the config
@@ -368,4 +422,6 @@ type APCoreConfig interface {
 	ClockTimezone() string
 	// Schema name of the database (ex: for Postgres)
 	Schema() string
+	// Kind of database, either "postgres" or "sqlite"
+	DatabaseKind() string
 }
@@ -35,3 +35,16 @@ type TxBuilder interface {
 type SingleRow interface {
 	Scan(dest ...interface{}) error
 }
+
+// Migration is a single versioned change to the tables an application creates
+// in CreateTables.
+//
+// Up and Down queue the statements that apply and revert the change. They are
+// executed in a transaction along with recording the applied version, so they
+// must not call Do themselves.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx TxBuilder)
+	Down        func(tx TxBuilder)
+}
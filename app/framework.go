@@ -20,6 +20,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/allinbits/apcore/paths"
 	"github.com/go-fed/activity/streams/vocab"
@@ -53,9 +54,15 @@ type Framework interface {
 	// If an error is returned, both the token and authentication values
 	// should be ignored.
 	//
-	// TODO: Scopes
+	// Use RequireScopes when building routes to also check the scopes the
+	// request is authorized for.
 	Validate(w http.ResponseWriter, r *http.Request) (userID paths.UUID, authenticated bool, err error)
 
+	// RequestedScopes returns the scopes a client is requesting in an
+	// OAuth2 authorization request, so that the authorization page can
+	// describe them to the user.
+	RequestedScopes(r *http.Request) ([]Scope, error)
+
 	// Send will send an Activity or Object on behalf of the user.
 	//
 	// Note that a new ID is not needed on the activity and/or objects that
@@ -76,6 +83,16 @@ type Framework interface {
 	// error.
 	SendRejectFollow(c context.Context, userID paths.UUID, followIRI *url.URL) error
 
+	// RotateKeys replaces the key the user signs HTTP Signatures with, and
+	// sends an Update of the user's actor to their followers if federation
+	// is enabled. The old key stays published on the actor for the
+	// configured grace period, so that peers can still verify what was
+	// signed with it. The Update is given a new ID by the application's
+	// NewIDPath.
+	RotateKeys(c context.Context, userID paths.UUID) error
+	// RotateInstanceActorKeys is like RotateKeys, for the instance actor.
+	RotateInstanceActorKeys(c context.Context) error
+
 	Session(r *http.Request) (Session, error)
 
 	// TODO: Determine if we need this.
@@ -92,6 +109,188 @@ type Framework interface {
 	// SetPrivileges sets the given application privileges and admin status
 	// for the given user.
 	SetPrivileges(c context.Context, userID paths.UUID, admin bool, appPrivileges interface{}) error
+
+	// CreateInstancePolicy creates a policy that is applied to every
+	// federated activity received by the instance, before any domain or
+	// user policies. The policy is provided in its JSON representation.
+	//
+	// The purpose is one of "federated_block", "quarantine", "strip_media",
+	// "drop_mentions", or "force_sensitive".
+	//
+	// Policies are resolved against the activity with an added
+	// "apcoreProofStatus" property, which is "valid", "invalid", or
+	// "absent" depending on the activity's Data Integrity proof.
+	CreateInstancePolicy(c context.Context, purpose string, policy []byte) (policyID string, err error)
+	// CreateDomainPolicy creates a policy that is applied to every
+	// federated activity sent by actors on the domain or its subdomains,
+	// before any user policies. The policy is provided in its JSON
+	// representation.
+	//
+	// The purposes are the same as for CreateInstancePolicy.
+	CreateDomainPolicy(c context.Context, domain, purpose string, policy []byte) (policyID string, err error)
+	// ScopedPolicies lists all instance and domain policies.
+	ScopedPolicies(c context.Context) ([]ScopedPolicy, error)
+	// DeleteScopedPolicy deletes an instance or domain policy.
+	DeleteScopedPolicy(c context.Context, policyID string) error
+
+	// ResolutionsForPolicy returns a page of the recorded results of
+	// applying a policy to federated data, newest first.
+	ResolutionsForPolicy(c context.Context, policyID string, offset, limit int) ([]PolicyResolution, error)
+	// ResolutionsForIRI returns a page of the recorded results of applying
+	// policies to the federated data with the given IRI, newest first.
+	ResolutionsForIRI(c context.Context, dataIRI *url.URL, offset, limit int) ([]PolicyResolution, error)
+	// ResolutionsInTimeRange returns a page of the recorded results of
+	// applying policies at or after start and before end, newest first.
+	ResolutionsInTimeRange(c context.Context, start, end time.Time, offset, limit int) ([]PolicyResolution, error)
+	// ResolutionsByMatched returns a page of the recorded results of
+	// applying policies that did or did not match, newest first.
+	ResolutionsByMatched(c context.Context, matched bool, offset, limit int) ([]PolicyResolution, error)
+
+	// RegisteredClients lists the third party OAuth2 clients registered
+	// through the dynamic client registration endpoint, newest first.
+	RegisteredClients(c context.Context) ([]RegisteredClient, error)
+	// RevokeClient deletes a registered OAuth2 client and all tokens
+	// issued to it. First party clients cannot be revoked.
+	RevokeClient(c context.Context, clientID string) error
+
+	// UserSessions lists the OAuth2 tokens and first party credentials that
+	// the user is signed in with, newest first, so that they can review
+	// them on a settings page.
+	UserSessions(c context.Context, userID paths.UUID) ([]UserSession, error)
+	// RevokeUserSession signs the user out of one of their sessions,
+	// deleting its OAuth2 token or first party credential.
+	RevokeUserSession(c context.Context, userID paths.UUID, sessionID string) error
+
+	// TwoFactorStatus describes the second factors that the user logs in
+	// with, in addition to their password.
+	//
+	// Two-factor authentication is only available to applications that
+	// implement TwoFactorApplication; the enrollment methods below return
+	// an error otherwise.
+	TwoFactorStatus(c context.Context, userID paths.UUID) (TwoFactorStatus, error)
+	// BeginTOTPEnrollment creates a time-based one-time password secret
+	// for the user to add to their authenticator app. It is not required
+	// to log in until it is confirmed.
+	BeginTOTPEnrollment(c context.Context, userID paths.UUID) (TOTPEnrollment, error)
+	// ConfirmTOTPEnrollment requires the secret to log in, once the user
+	// enters a code from their authenticator app. If this enables
+	// two-factor authentication for the user, their recovery codes are
+	// returned to be shown to them once.
+	//
+	// If an error is returned, it can be checked using
+	// IsInvalidSecondFactor to ask the user for another code.
+	ConfirmTOTPEnrollment(c context.Context, userID paths.UUID, code string) (recoveryCodes []string, err error)
+	// DisableTOTP removes the user's time-based one-time password secret.
+	DisableTOTP(c context.Context, userID paths.UUID) error
+	// RegenerateRecoveryCodes replaces all of the user's recovery codes,
+	// returning the new ones to be shown to them once.
+	RegenerateRecoveryCodes(c context.Context, userID paths.UUID) ([]string, error)
+	// BeginWebAuthnRegistration returns the JSON options to pass to
+	// PublicKeyCredential.parseCreationOptionsFromJSON, so that the
+	// browser creates a credential for the user. The challenge is kept in
+	// the user's session.
+	BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request, userID paths.UUID) (options []byte, err error)
+	// FinishWebAuthnRegistration stores the credential created by the
+	// browser, given as the JSON serialization of the PublicKeyCredential,
+	// under the name the user gave it. If this enables two-factor
+	// authentication for the user, their recovery codes are returned to
+	// be shown to them once.
+	//
+	// If an error is returned, it can be checked using
+	// IsInvalidSecondFactor to ask the user to try again.
+	FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request, userID paths.UUID, name string, credential []byte) (recoveryCodes []string, err error)
+	// RemoveWebAuthnCredential deletes one of the user's WebAuthn
+	// credentials.
+	RemoveWebAuthnCredential(c context.Context, userID paths.UUID, credentialID string) error
+	// IsInvalidSecondFactor returns true if the error returned from
+	// ConfirmTOTPEnrollment or FinishWebAuthnRegistration is due to the
+	// code or credential not being valid.
+	IsInvalidSecondFactor(error) bool
+}
+
+// ScopedPolicy is a policy that applies to the whole instance or to a domain,
+// instead of to a single user.
+type ScopedPolicy struct {
+	ID string
+	// Scope is either "instance" or "domain".
+	Scope string
+	// Domain is only set for the "domain" scope.
+	Domain  string
+	Purpose string
+	// Policy is the JSON representation of the policy.
+	Policy []byte
+}
+
+// PolicyResolution is the recorded result of applying a policy to federated
+// data.
+type PolicyResolution struct {
+	ID       string
+	PolicyID string
+	DataIRI  *url.URL
+	Time     time.Time
+	Matched  bool
+	// MatchLog explains how the policy was applied.
+	MatchLog []string
+}
+
+// RegisteredClient is a third party OAuth2 client registered through the
+// dynamic client registration endpoint.
+type RegisteredClient struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	// ClientURI is the client's home page, if it provided one.
+	ClientURI       string
+	Contacts        []string
+	SoftwareID      string
+	SoftwareVersion string
+	RegisteredAt    time.Time
+}
+
+// UserSession is an OAuth2 token or first party credential that a user is
+// signed in with.
+type UserSession struct {
+	ID       string
+	ClientID string
+	// ClientName is the name of a registered third party client, if it
+	// provided one.
+	ClientName string
+	// FirstParty is true for credentials of signing in to this server's
+	// web pages.
+	FirstParty bool
+	Scopes     []string
+	CreatedAt  time.Time
+}
+
+// TwoFactorStatus describes the second factors that a user logs in with.
+type TwoFactorStatus struct {
+	// Enabled is true when the user must provide a second factor to log
+	// in.
+	Enabled bool
+	TOTP    bool
+	// RecoveryCodesLeft is how many unused recovery codes the user has.
+	RecoveryCodesLeft   int
+	WebAuthnCredentials []WebAuthnCredential
+}
+
+// WebAuthnCredential is a security key or other authenticator that a user
+// logs in with.
+type WebAuthnCredential struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+	// LastUsedAt is the zero time if the credential was never used to log
+	// in.
+	LastUsedAt time.Time
+}
+
+// TOTPEnrollment is a time-based one-time password secret for a user to add to
+// their authenticator app.
+type TOTPEnrollment struct {
+	// Secret is the base32 encoded secret, for entering manually.
+	Secret string
+	// URI is the otpauth key URI of the secret, for showing as a QR code.
+	URI string
 }
 
 type Session interface {
@@ -27,13 +27,15 @@ package app
 //
 // A zero-value struct is valid and uses apcore defaults.
 type Paths struct {
-	GetLogin            string
-	PostLogin           string
-	GetLogout           string
-	GetOAuth2Authorize  string
-	PostOAuth2Authorize string
-	RedirectToHomepage  func(string) string
-	RedirectToLogin     func(string) string
+	GetLogin              string
+	PostLogin             string
+	GetLoginSecondFactor  string
+	PostLoginSecondFactor string
+	GetLogout             string
+	GetOAuth2Authorize    string
+	PostOAuth2Authorize   string
+	RedirectToHomepage    func(string) string
+	RedirectToLogin       func(string) string
 }
 
 func (p Paths) getOrDefault(s, d string) string {
@@ -51,6 +53,14 @@ func (p Paths) PostLoginPath() string {
 	return p.getOrDefault(p.PostLogin, "/login")
 }
 
+func (p Paths) GetLoginSecondFactorPath() string {
+	return p.getOrDefault(p.GetLoginSecondFactor, "/login/2fa")
+}
+
+func (p Paths) PostLoginSecondFactorPath() string {
+	return p.getOrDefault(p.PostLoginSecondFactor, "/login/2fa")
+}
+
 func (p Paths) GetLogoutPath() string {
 	return p.getOrDefault(p.GetLogout, "/logout")
 }
@@ -27,6 +27,10 @@ type Router interface {
 	ActivityPubAndWebHandleFunc(path string, authFn AuthorizeFunc, f func(http.ResponseWriter, *http.Request)) Route
 	HandleAuthorizationRequest(path string) Route
 	HandleAccessTokenRequest(path string) Route
+	// RequireScopes creates a route that is only served to requests whose
+	// OAuth2 access token grants all of the scopes. Requests signed in
+	// with first party credentials are granted every scope.
+	RequireScopes(scopes ...string) Route
 	Get(name string) Route
 	WebOnlyHandle(path string, handler http.Handler) Route
 	WebOnlyHandleFunc(path string, f func(http.ResponseWriter, *http.Request)) Route
@@ -50,6 +54,11 @@ type Route interface {
 	ActivityPubAndWebHandleFunc(path string, authFn AuthorizeFunc, f func(http.ResponseWriter, *http.Request)) Route
 	HandleAuthorizationRequest(path string) Route
 	HandleAccessTokenRequest(path string) Route
+	// RequireScopes only serves the route to requests whose OAuth2 access
+	// token grants all of the scopes, responding to others with a 401 or
+	// 403 status. Requests signed in with first party credentials are
+	// granted every scope.
+	RequireScopes(scopes ...string) Route
 	WebOnlyHandler(path string, handler http.Handler) Route
 	WebOnlyHandlerFunc(path string, f func(http.ResponseWriter, *http.Request)) Route
 	Handler(handler http.Handler) Route
@@ -0,0 +1,175 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The OAuth2 scopes provided by apcore.
+const (
+	// ReadScope permits reading the user's private data, such as their
+	// inbox and the private activities in their outbox.
+	ReadScope = "read"
+	// WriteScope permits posting activities on the user's behalf.
+	WriteScope = "write"
+	// FollowScope permits following, unfollowing, and blocking actors on
+	// the user's behalf.
+	FollowScope = "follow"
+	// AdminScope permits administering the server, if the user is an
+	// administrator.
+	AdminScope = "admin"
+)
+
+// DefaultScope is granted when an OAuth2 authorization request does not
+// request any scope.
+const DefaultScope = ReadScope
+
+// Scope is an OAuth2 scope, which limits what a client may do on behalf of a
+// user.
+type Scope struct {
+	Name string
+	// Description is shown to users when a client requests the scope, and
+	// should read well after "This application will be able to:".
+	Description string
+}
+
+// ScopingApplication is an Application with OAuth2 scopes of its own, in
+// addition to the ones provided by apcore.
+type ScopingApplication interface {
+	Application
+	// Scopes returns the application's OAuth2 scopes. Their names must not
+	// collide with apcore's scopes, nor with each other.
+	Scopes() []Scope
+}
+
+// ScopeRegistry is the set of OAuth2 scopes that clients may request, made of
+// apcore's scopes and the application's.
+type ScopeRegistry struct {
+	scopes []Scope
+	byName map[string]Scope
+}
+
+// NewScopeRegistry creates a registry of apcore's scopes, and the
+// application's if it is a ScopingApplication.
+func NewScopeRegistry(a Application) (*ScopeRegistry, error) {
+	s := &ScopeRegistry{
+		byName: make(map[string]Scope),
+	}
+	scopes := []Scope{
+		{ReadScope, "Read your inbox and your private posts"},
+		{WriteScope, "Post on your behalf"},
+		{FollowScope, "Follow, unfollow, and block accounts on your behalf"},
+		{AdminScope, "Administer this server, if you are an administrator"},
+	}
+	if sa, ok := a.(ScopingApplication); ok {
+		scopes = append(scopes, sa.Scopes()...)
+	}
+	for _, sc := range scopes {
+		if err := s.register(sc); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *ScopeRegistry) register(sc Scope) error {
+	if !isScopeToken(sc.Name) {
+		return fmt.Errorf("invalid oauth2 scope name: %q", sc.Name)
+	} else if _, ok := s.byName[sc.Name]; ok {
+		return fmt.Errorf("oauth2 scope registered more than once: %q", sc.Name)
+	}
+	s.scopes = append(s.scopes, sc)
+	s.byName[sc.Name] = sc
+	return nil
+}
+
+// All returns every registered scope, apcore's first.
+func (s *ScopeRegistry) All() []Scope {
+	return append([]Scope(nil), s.scopes...)
+}
+
+// Get returns the registered scope with the name.
+func (s *ScopeRegistry) Get(name string) (sc Scope, ok bool) {
+	sc, ok = s.byName[name]
+	return
+}
+
+// Parse returns the registered scopes in a space-delimited OAuth2 scope
+// parameter, in registration order. It is an error if any are not registered.
+func (s *ScopeRegistry) Parse(scope string) ([]Scope, error) {
+	names := strings.Fields(scope)
+	for _, n := range names {
+		if _, ok := s.byName[n]; !ok {
+			return nil, fmt.Errorf("unknown oauth2 scope: %q", n)
+		}
+	}
+	var sc []Scope
+	for _, r := range s.scopes {
+		for _, n := range names {
+			if r.Name == n {
+				sc = append(sc, r)
+				break
+			}
+		}
+	}
+	return sc, nil
+}
+
+// String returns the space-delimited OAuth2 scope parameter granting every
+// registered scope.
+func (s *ScopeRegistry) String() string {
+	names := make([]string, len(s.scopes))
+	for i, sc := range s.scopes {
+		names[i] = sc.Name
+	}
+	return strings.Join(names, " ")
+}
+
+// GrantsScopes determines whether a space-delimited OAuth2 scope parameter
+// includes all of the required scopes.
+func GrantsScopes(scope string, required ...string) bool {
+	granted := strings.Fields(scope)
+	for _, r := range required {
+		found := false
+		for _, g := range granted {
+			if g == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isScopeToken determines whether the name is a valid scope token, as
+// described by RFC 6749 Section 3.3.
+func isScopeToken(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if c < 0x21 || c == 0x22 || c == 0x5c || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
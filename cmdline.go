@@ -32,11 +32,22 @@ import (
 
 var (
 	// Flags for apcore
-	devFlag          = flag.Bool("dev", false, "Enable the development server on localhost & other developer quality of life features")
-	systemLogFlag    = flag.Bool("syslog", false, "Also logs to system (stdout and stderr) if logging to a file")
-	infoLogFileFlag  = flag.String("info_log_file", "", "Log file for info, defaults to stdout")
-	errorLogFileFlag = flag.String("error_log_file", "", "Log file for errors, defaults to stderr")
-	configFlag       = flag.String("config", "config.ini", "Path to the configuration file")
+	devFlag               = flag.Bool("dev", false, "Enable the development server on localhost & other developer quality of life features")
+	systemLogFlag         = flag.Bool("syslog", false, "Also logs to system (stdout and stderr) if logging to a file")
+	infoLogFileFlag       = flag.String("info_log_file", "", "Log file for info, defaults to stdout")
+	errorLogFileFlag      = flag.String("error_log_file", "", "Log file for errors, defaults to stderr")
+	configFlag            = flag.String("config", "config.ini", "Path to the configuration file")
+	policyFileFlag        = flag.String("policy_file", "", "Path to the JSON policy evaluated by the policy-dry-run action")
+	policyInputFlag       = flag.String("policy_input", "", "Path to a JSON activity, or array of activities, for the policy-dry-run action to evaluate instead of stored federated data")
+	policyLimitFlag       = flag.Int("policy_limit", 1000, "Number of the most recently stored federated activities evaluated by the policy-dry-run action")
+	deliveryStateFlag     = flag.String("delivery_state", "", "Only the deliveries in this state (new, failed, success, or abandoned) are affected by the deliveries actions")
+	deliveryHostFlag      = flag.String("delivery_host", "", "Only the deliveries to this host are affected by the deliveries actions")
+	deliveryUserFlag      = flag.String("delivery_user", "", "Only the deliveries sent by the user with this username are affected by the deliveries actions")
+	deliveryOlderThanFlag = flag.Duration("delivery_older_than", 0, "Only the deliveries created at least this long ago are affected by the deliveries actions, such as 72h")
+	deliveryLimitFlag     = flag.Int("delivery_limit", 100, "Number of the most recently created deliveries listed by the deliveries-list action")
+	goneActorFlag         = flag.String("gone_actor", "", "The IRI of the actor forgotten as gone by the deliveries-gone-clear action, which forgets every gone actor if unset")
+	rotateUserFlag        = flag.String("rotate_user", "", "The username of the user whose keys are rotated by the rotate-keys action, which rotates the instance actor's keys if unset")
+	clientIDFlag          = flag.String("client_id", "", "The ID of the registered OAuth2 client revoked by the clients-revoke action")
 )
 
 // Usage is overridable so client applications can add custom additional
@@ -114,9 +125,69 @@ var (
 	}
 	initDb cmdAction = cmdAction{
 		Name:        "init-db",
-		Description: "Initializes a new, empty database with the required tables if no existing database tables are detected. Requires a configuration.",
+		Description: "Initializes a new, empty database with the required tables if no existing database tables are detected, and records all schema migrations as applied. Requires a configuration.",
 		Action:      initDbFn,
 	}
+	migrate cmdAction = cmdAction{
+		Name:        "migrate",
+		Description: "Applies all pending database schema migrations for apcore and the application. Requires a database.",
+		Action:      migrateFn,
+	}
+	migrateStatus cmdAction = cmdAction{
+		Name:        "migrate-status",
+		Description: "Lists the database schema migrations and whether each has been applied. Requires a database.",
+		Action:      migrateStatusFn,
+	}
+	migrateDown cmdAction = cmdAction{
+		Name:        "migrate-down",
+		Description: "Reverts the most recently applied database schema migration, which may destroy data. Requires a database.",
+		Action:      migrateDownFn,
+	}
+	policyDryRun cmdAction = cmdAction{
+		Name:        "policy-dry-run",
+		Description: "Evaluates the policy in the policy_file flag against stored federated data, or the policy_input flag's file, reporting what would match without saving anything. Requires a database.",
+		Action:      policyDryRunFn,
+	}
+	deliveriesList cmdAction = cmdAction{
+		Name:        "deliveries-list",
+		Description: "Lists the most recent deliveries to federated peers matching the delivery flags, such as those failing for a host. Requires a database.",
+		Action:      deliveriesListFn,
+	}
+	deliveriesRequeue cmdAction = cmdAction{
+		Name:        "deliveries-requeue",
+		Description: "Queues the abandoned deliveries matching the delivery flags to be delivered again, such as after a peer's outage ends. Failed deliveries can be requeued to be delivered immediately. Requires a database.",
+		Action:      deliveriesRequeueFn,
+	}
+	deliveriesPurge cmdAction = cmdAction{
+		Name:        "deliveries-purge",
+		Description: "Deletes the deliveries matching the delivery flags, which must include the delivery_state flag. Requires a database.",
+		Action:      deliveriesPurgeFn,
+	}
+	deliveriesGoneList cmdAction = cmdAction{
+		Name:        "deliveries-gone-list",
+		Description: "Lists the federated actors whose inbox was reported gone, to which no deliveries are queued until their record expires. Requires a database.",
+		Action:      deliveriesGoneListFn,
+	}
+	deliveriesGoneClear cmdAction = cmdAction{
+		Name:        "deliveries-gone-clear",
+		Description: "Forgets that the actor in the gone_actor flag, or every actor if it is unset, is gone so that deliveries to its inbox are queued again. Requires a database.",
+		Action:      deliveriesGoneClearFn,
+	}
+	rotateKeys cmdAction = cmdAction{
+		Name:        "rotate-keys",
+		Description: "Replaces the HTTP Signatures key of the user in the rotate_user flag, or of the instance actor if it is unset, and sends an Update of the actor to its followers. The old key stays valid for verifying signatures for the configured grace period. Requires a database.",
+		Action:      rotateKeysFn,
+	}
+	clientsList cmdAction = cmdAction{
+		Name:        "clients-list",
+		Description: "Lists the third party OAuth2 clients registered through dynamic client registration, newest first. Requires a database.",
+		Action:      clientsListFn,
+	}
+	clientsRevoke cmdAction = cmdAction{
+		Name:        "clients-revoke",
+		Description: "Deletes the registered OAuth2 client in the client_id flag, along with all tokens issued to it. Requires a database.",
+		Action:      clientsRevokeFn,
+	}
 	initAdmin cmdAction = cmdAction{
 		Name:        "init-admin",
 		Description: "Initializes a new administrator user account. Requires a database.",
@@ -145,6 +216,18 @@ func init() {
 		serve,
 		guideNew,
 		initDb,
+		migrate,
+		migrateStatus,
+		migrateDown,
+		policyDryRun,
+		deliveriesList,
+		deliveriesRequeue,
+		deliveriesPurge,
+		deliveriesGoneList,
+		deliveriesGoneClear,
+		rotateKeys,
+		clientsList,
+		clientsRevoke,
 		initAdmin,
 		configure,
 		version,
@@ -168,7 +251,7 @@ func allActionsUsage() string {
 
 // The 'serve' command line action.
 func serveFn(a app.Application) error {
-	s, err := newServer(*configFlag, a, *devFlag)
+	s, _, err := newServer(*configFlag, a, *devFlag)
 	if err != nil {
 		return err
 	}
@@ -245,6 +328,80 @@ organization running it, in order to complete the server profile.`))
 	return nil
 }
 
+// The 'migrate' command line action.
+func migrateFn(a app.Application) error {
+	fmt.Println(framework.ClarkeSays(`
+Moo~, let's bring the database up to date! Back up your database before
+migrating, just in case something goes udderly wrong.`))
+	err := doMigrate(*configFlag, a, *devFlag, schemeFromFlags())
+	if err != nil {
+		return err
+	}
+	fmt.Println(framework.ClarkeSays(`Migrations complete! Moo~`))
+	return nil
+}
+
+// The 'migrate-status' command line action.
+func migrateStatusFn(a app.Application) error {
+	return doMigrateStatus(*configFlag, a, *devFlag, schemeFromFlags())
+}
+
+// The 'migrate-down' command line action.
+func migrateDownFn(a app.Application) error {
+	fmt.Println(framework.ClarkeSays(`
+Careful! Reverting a migration may permanently delete data. I hope you made a
+backup, because even a cow never forgets to.`))
+	return doMigrateDown(*configFlag, a, *devFlag, schemeFromFlags())
+}
+
+// The 'policy-dry-run' command line action.
+func policyDryRunFn(a app.Application) error {
+	return doPolicyDryRun(*configFlag, a, *devFlag, schemeFromFlags(), *policyFileFlag, *policyInputFlag, *policyLimitFlag)
+}
+
+// The 'deliveries-list' command line action.
+func deliveriesListFn(a app.Application) error {
+	return doDeliveriesList(*configFlag, a, *devFlag, schemeFromFlags(), *deliveryStateFlag, *deliveryHostFlag, *deliveryUserFlag, *deliveryOlderThanFlag, *deliveryLimitFlag)
+}
+
+// The 'deliveries-requeue' command line action.
+func deliveriesRequeueFn(a app.Application) error {
+	return doDeliveriesRequeue(*configFlag, a, *devFlag, schemeFromFlags(), *deliveryStateFlag, *deliveryHostFlag, *deliveryUserFlag, *deliveryOlderThanFlag)
+}
+
+// The 'deliveries-purge' command line action.
+func deliveriesPurgeFn(a app.Application) error {
+	fmt.Println(framework.ClarkeSays(`
+Careful! Purged deliveries are gone for good, and any that were still queued
+will never be delivered. Moo~`))
+	return doDeliveriesPurge(*configFlag, a, *devFlag, schemeFromFlags(), *deliveryStateFlag, *deliveryHostFlag, *deliveryUserFlag, *deliveryOlderThanFlag)
+}
+
+// The 'deliveries-gone-list' command line action.
+func deliveriesGoneListFn(a app.Application) error {
+	return doDeliveriesGoneList(*configFlag, a, *devFlag, schemeFromFlags())
+}
+
+// The 'deliveries-gone-clear' command line action.
+func deliveriesGoneClearFn(a app.Application) error {
+	return doDeliveriesGoneClear(*configFlag, a, *devFlag, schemeFromFlags(), *goneActorFlag)
+}
+
+// The 'rotate-keys' command line action.
+func rotateKeysFn(a app.Application) error {
+	return doRotateKeys(*configFlag, a, *devFlag, schemeFromFlags(), *rotateUserFlag)
+}
+
+// The 'clients-list' command line action.
+func clientsListFn(a app.Application) error {
+	return doClientsList(*configFlag, a, *devFlag, schemeFromFlags())
+}
+
+// The 'clients-revoke' command line action.
+func clientsRevokeFn(a app.Application) error {
+	return doClientsRevoke(*configFlag, a, *devFlag, schemeFromFlags(), *clientIDFlag)
+}
+
 // The 'init-admin' command line action.
 func initAdminFn(a app.Application) error {
 	msg := `Moo~, let's create an administrative account!`
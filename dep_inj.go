@@ -17,6 +17,7 @@
 package apcore
 
 import (
+	"context"
 	"database/sql"
 	"math/rand"
 	"time"
@@ -28,14 +29,16 @@ import (
 	"github.com/allinbits/apcore/framework/conn"
 	"github.com/allinbits/apcore/framework/db"
 	"github.com/allinbits/apcore/framework/oauth2"
+	"github.com/allinbits/apcore/framework/twofactor"
 	"github.com/allinbits/apcore/framework/web"
 	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
 	"github.com/go-fed/activity/pub"
 	"github.com/gorilla/mux"
 )
 
-func newServer(configFileName string, appl app.Application, debug bool) (s *framework.Server, err error) {
+func newServer(configFileName string, appl app.Application, debug bool) (s *framework.Server, fw *framework.Framework, err error) {
 	// Load the configuration
 	c, err := framework.LoadConfigFile(configFileName, appl, debug)
 	if err != nil {
@@ -60,7 +63,7 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 	}
 
 	// Create the models & services for higher-level transformations
-	cryp, data, dAttempts, followers, following, inboxes, liked, oauthSrv, outboxes, policies, pkeys, users, nodeinfo, any, models := createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	cryp, data, dAttempts, followers, following, inboxes, liked, oauthSrv, outboxes, policies, pkeys, users, nodeinfo, any, dCache, rKeys, twoFactor, models := createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
 
 	// Ensure the SQL statements are prepared
 	err = prepare(models, sqldb, dialect)
@@ -68,13 +71,22 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		return
 	}
 
+	// Ensure users created before Ed25519 keys were introduced have one
+	var nKeys int
+	nKeys, err = users.AddMissingEd25519Keys(util.Context{context.Background()})
+	if err != nil {
+		return
+	} else if nKeys > 0 {
+		util.InfoLogger.Infof("Added Ed25519 keys for %d existing users", nKeys)
+	}
+
 	// ** Create Misc Helpers **
 
 	// Create placeholder framework.
 	//
 	// Creating a placeholder early allows us to inject it into the needed
 	// dependencies, even if *Framework is not yet ready for use.
-	fw := &framework.Framework{}
+	fw = &framework.Framework{}
 	internalErrorHandler := appl.InternalServerErrorHandler(fw)
 
 	// Prepare web sessions behavior
@@ -84,10 +96,17 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 	}
 
 	// Prepare OAuth2 server
-	oauth, err := oauth2.NewServer(c, scheme, internalErrorHandler, oauthSrv, cryp, sess)
+	scopes, err := app.NewScopeRegistry(appl)
 	if err != nil {
 		return
 	}
+	oauth, err := oauth2.NewServer(c, scheme, internalErrorHandler, oauthSrv, cryp, sess, scopes)
+	if err != nil {
+		return
+	}
+
+	// Prepare two-factor authentication
+	tf := twofactor.NewServer(scheme, host, twoFactor, users)
 
 	// Create an HTTP client for this server.
 	httpClient := framework.NewHTTPClient(c)
@@ -110,11 +129,14 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 	apdb := ap.NewAPDB(db, appl)
 
 	// Create a controller for outbound messaging.
-	tc, err := conn.NewController(c, appl, clock, httpClient, dAttempts, pkeys)
+	tc, err := conn.NewController(c, appl, clock, httpClient, dAttempts, pkeys, dCache)
 	if err != nil {
 		return
 	}
 
+	// Cache the public keys of peers for verifying their HTTP Signatures.
+	keys := ap.NewPublicKeys(c, clock, rKeys)
+
 	// Hook up ActivityPub Actor behavior for users.
 	actor, err := ap.NewActor(c,
 		appl,
@@ -126,7 +148,8 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		policies,
 		followers,
 		users,
-		tc)
+		tc,
+		keys)
 	if err != nil {
 		return
 	}
@@ -137,7 +160,8 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		apdb,
 		pkeys,
 		followers,
-		tc)
+		tc,
+		keys)
 
 	// ** Initialize the Web Server **
 
@@ -147,13 +171,18 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		c.ServerConfig.RSAKeySize,
 		c.ServerConfig.SaltSize,
 		c.ServerConfig.BCryptStrength,
+		time.Duration(c.ActivityPubConfig.KeyRotationGraceSeconds)*time.Second,
 		fw,
 		oauth,
 		sess,
+		tf,
 		data,
 		followers,
 		users,
+		policies,
 		actor,
+		actorMap,
+		clock,
 		appl)
 
 	// Obtain a normal router and fallback web handlers.
@@ -164,6 +193,13 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 	getAuthWebHandler := appl.GetAuthWebHandlerFunc(fw)
 	getLoginWebHandler := appl.GetLoginWebHandlerFunc(fw)
 
+	// Require signed fetches of ActivityPub data, if enabled.
+	var fetchAuth framework.FetchAuthorizer
+	if c.ActivityPubConfig.AuthorizedFetch {
+		util.InfoLogger.Info("Authorized fetch is enabled, requiring ActivityPub GET requests to be signed")
+		fetchAuth = ap.NewFetchAuthorizer(db, pkeys, policies, tc, keys)
+	}
+
 	// Build a specialized AP-aware router for managing and routing HTTP requests.
 	r := framework.NewRouter(
 		mr,
@@ -175,7 +211,8 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		host,
 		scheme,
 		internalErrorHandler,
-		badRequestHandler)
+		badRequestHandler,
+		fetchAuth)
 
 	// Build application routes for default web support
 	h, err := framework.BuildHandler(r,
@@ -195,9 +232,12 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 		following,
 		followers,
 		liked,
+		policies,
+		tc,
 		sqldb,
 		oauth,
 		sess,
+		tf,
 		fw,
 		clock,
 		appl.Software(), apCoreSoftware(),
@@ -207,7 +247,7 @@ func newServer(configFileName string, appl app.Application, debug bool) (s *fram
 	}
 
 	// Build list of StartStoppers
-	ss := []framework.StartStopper{tc, oauth}
+	ss := []framework.StartStopper{tc, keys, oauth, framework.NewResolutionPruner(c, clock, policies), framework.NewRetiredKeyPruner(clock, users)}
 
 	// Build web server to control server behavior
 	if debug {
@@ -239,7 +279,7 @@ func newModels(configFileName string, appl app.Application, debug bool, scheme s
 		return
 	}
 
-	_, _, _, _, _, _, _, _, _, _, _, _, _, _, m = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	_, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _, m = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
 	return
 }
 
@@ -266,11 +306,138 @@ func newUserService(configFileName string, appl app.Application, debug bool, sch
 	}
 
 	var ml []models.Model
-	_, _, _, _, _, _, _, _, _, _, _, users, _, _, ml = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	_, _, _, _, _, _, _, _, _, _, _, users, _, _, _, _, _, ml = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
 	err = prepare(ml, sqldb, dialect)
 	return
 }
 
+func newPoliciesService(configFileName string, appl app.Application, debug bool, scheme string) (sqldb *sql.DB, policies *services.Policies, c *config.Config, err error) {
+	// Load the configuration
+	c, err = framework.LoadConfigFile(configFileName, appl, debug)
+	if err != nil {
+		return
+	}
+	host := c.ServerConfig.Host
+
+	// Create a server clock, a pub.Clock
+	var clock pub.Clock
+	clock, err = ap.NewClock(c.ActivityPubConfig.ClockTimezone)
+	if err != nil {
+		return
+	}
+
+	// Create the SQL database
+	var dialect models.SqlDialect
+	sqldb, dialect, err = db.NewDB(c)
+	if err != nil {
+		return
+	}
+
+	var ml []models.Model
+	_, _, _, _, _, _, _, _, _, policies, _, _, _, _, _, _, _, ml = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	err = prepare(ml, sqldb, dialect)
+	return
+}
+
+func newOAuth2Service(configFileName string, appl app.Application, debug bool, scheme string) (sqldb *sql.DB, oauth *services.OAuth2, c *config.Config, err error) {
+	// Load the configuration
+	c, err = framework.LoadConfigFile(configFileName, appl, debug)
+	if err != nil {
+		return
+	}
+	host := c.ServerConfig.Host
+
+	// Create a server clock, a pub.Clock
+	var clock pub.Clock
+	clock, err = ap.NewClock(c.ActivityPubConfig.ClockTimezone)
+	if err != nil {
+		return
+	}
+
+	// Create the SQL database
+	var dialect models.SqlDialect
+	sqldb, dialect, err = db.NewDB(c)
+	if err != nil {
+		return
+	}
+
+	var ml []models.Model
+	_, _, _, _, _, _, _, oauth, _, _, _, _, _, _, _, _, _, ml = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	err = prepare(ml, sqldb, dialect)
+	return
+}
+
+func newDeliveryAttemptsService(configFileName string, appl app.Application, debug bool, scheme string) (sqldb *sql.DB, dAttempts *services.DeliveryAttempts, users *services.Users, c *config.Config, err error) {
+	// Load the configuration
+	c, err = framework.LoadConfigFile(configFileName, appl, debug)
+	if err != nil {
+		return
+	}
+	host := c.ServerConfig.Host
+
+	// Create a server clock, a pub.Clock
+	var clock pub.Clock
+	clock, err = ap.NewClock(c.ActivityPubConfig.ClockTimezone)
+	if err != nil {
+		return
+	}
+
+	// Create the SQL database
+	var dialect models.SqlDialect
+	sqldb, dialect, err = db.NewDB(c)
+	if err != nil {
+		return
+	}
+
+	var ml []models.Model
+	_, _, dAttempts, _, _, _, _, _, _, _, _, users, _, _, _, _, _, ml = createModelsAndServices(c, sqldb, dialect, appl, host, scheme, clock)
+	err = prepare(ml, sqldb, dialect)
+	return
+}
+
+func newMigrationService(configFileName string, appl app.Application, debug bool, scheme string) (sqldb *sql.DB, migrations *services.Migrations, c *config.Config, err error) {
+	// Load the configuration
+	c, err = framework.LoadConfigFile(configFileName, appl, debug)
+	if err != nil {
+		return
+	}
+
+	// Create the SQL database
+	var dialect models.SqlDialect
+	sqldb, dialect, err = db.NewDB(c)
+	if err != nil {
+		return
+	}
+
+	// The table recording applied migrations may not yet exist in a
+	// database created before migrations were supported, so ensure it
+	// exists before preparing any statements against it.
+	sm := &models.SchemaMigrations{}
+	var tx *sql.Tx
+	tx, err = sqldb.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if err = sm.CreateTable(tx, dialect); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	if err = prepare([]models.Model{sm}, sqldb, dialect); err != nil {
+		return
+	}
+	migrations = &services.Migrations{
+		DB:               sqldb,
+		Dialect:          dialect,
+		App:              appl,
+		APCoreConfig:     c,
+		SchemaMigrations: sm,
+	}
+	return
+}
+
 func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialect, appl app.Application, host, scheme string, clock pub.Clock) (cryp *services.Crypto,
 	data *services.Data,
 	dAttempts *services.DeliveryAttempts,
@@ -285,6 +452,9 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 	users *services.Users,
 	nodeinfo *services.NodeInfo,
 	any *services.Any,
+	dCache *services.DereferenceCache,
+	rKeys *services.RemotePublicKeys,
+	twoFactor *services.TwoFactor,
 	m []models.Model) {
 	us := &models.Users{}
 	fd := &models.FedData{}
@@ -301,6 +471,16 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 	li := &models.Liked{}
 	po := &models.Policies{}
 	rs := &models.Resolutions{}
+	ga := &models.GoneActors{}
+	dc := &models.DereferenceCache{}
+	rk := &models.RemotePublicKeys{}
+	rt := &models.RetiredKeys{}
+	cr := &models.ClientRegistrations{}
+	ts := &models.TOTPSecrets{}
+	rc := &models.RecoveryCodes{}
+	wa := &models.WebAuthnCredentials{}
+	sf := &models.SecondFactorFailures{}
+	wc := &models.WebAuthnChallenges{}
 	m = []models.Model{
 		us,
 		fd,
@@ -317,6 +497,16 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 		li,
 		po,
 		rs,
+		ga,
+		dc,
+		rk,
+		rt,
+		cr,
+		ts,
+		rc,
+		wa,
+		sf,
+		wc,
 	}
 	cryp = &services.Crypto{
 		DB:    sqldb,
@@ -325,6 +515,7 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 	dAttempts = &services.DeliveryAttempts{
 		DB:               sqldb,
 		DeliveryAttempts: da,
+		GoneActors:       ga,
 	}
 	followers = &services.Followers{
 		DB:        sqldb,
@@ -355,10 +546,12 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 		MaxCollectionPageSize: c.DatabaseConfig.MaxCollectionPageSize,
 	}
 	oauth = &services.OAuth2{
-		DB:     sqldb,
-		Client: ci,
-		Token:  ti,
-		Creds:  cd,
+		DB:            sqldb,
+		Client:        ci,
+		Token:         ti,
+		Creds:         cd,
+		Registrations: cr,
+		Users:         us,
 	}
 	outboxes = &services.Outboxes{
 		DB:       sqldb,
@@ -369,6 +562,7 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 		DB:          sqldb,
 		Policies:    po,
 		Resolutions: rs,
+		FedData:     fd,
 	}
 	pkeys = &services.PrivateKeys{
 		Scheme:      scheme,
@@ -381,6 +575,7 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 		DB:          sqldb,
 		Users:       us,
 		PrivateKeys: pk,
+		RetiredKeys: rt,
 		Inboxes:     in,
 		Outboxes:    ou,
 		Followers:   fr,
@@ -397,6 +592,22 @@ func createModelsAndServices(c *config.Config, sqldb *sql.DB, d models.SqlDialec
 	any = &services.Any{
 		DB: sqldb,
 	}
+	dCache = &services.DereferenceCache{
+		DB:               sqldb,
+		DereferenceCache: dc,
+	}
+	rKeys = &services.RemotePublicKeys{
+		DB:               sqldb,
+		RemotePublicKeys: rk,
+	}
+	twoFactor = &services.TwoFactor{
+		DB:                   sqldb,
+		TOTPSecrets:          ts,
+		RecoveryCodes:        rc,
+		WebAuthnCredentials:  wa,
+		SecondFactorFailures: sf,
+		WebAuthnChallenges:   wc,
+	}
 	return
 }
 
@@ -54,11 +54,14 @@ const (
 	noteTemplate             = "note.tmpl"
 	followersRequestTemplate = "followers_request.tmpl"
 	followingCreateTemplate  = "following_create.tmpl"
+	secondFactorTemplate     = "second_factor.tmpl"
+	twoFactorTemplate        = "two_factor.tmpl"
 )
 
 var _ app.Application = &App{}
 var _ app.S2SApplication = &App{}
 var _ app.C2SApplication = &App{}
+var _ app.TwoFactorApplication = &App{}
 
 var fm template.FuncMap = map[string]interface{}{
 	"seq": func(n int) []int {
@@ -204,11 +207,30 @@ func (a *App) GetLoginWebHandlerFunc(f app.Framework) http.HandlerFunc {
 	}
 }
 
+// GetSecondFactorWebHandlerFunc returns a handler that renders the second
+// login step for users with two-factor authentication.
+//
+// The form should POST to "/login/2fa", and if the query parameter
+// "login_error" is "true" then it should also render the "code incorrect" error
+// message.
+func (a *App) GetSecondFactorWebHandlerFunc(f app.Framework) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loginError := r.URL.Query().Get("login_error") == "true"
+		a.getSessionWriteTemplateHelper(w, r, f, http.StatusOK, secondFactorTemplate, loginError, "GetSecondFactorWebHandlerFunc")
+	}
+}
+
 // GetAuthWebHandlerFunc returns a handler that renders the authorization page
-// for the user to approve in the OAuth2 flow.
+// for the user to approve in the OAuth2 flow, describing the scopes that the
+// client is requesting.
 func (a *App) GetAuthWebHandlerFunc(f app.Framework) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		a.getSessionWriteTemplateHelper(w, r, f, http.StatusOK, authTemplate, nil, "GetAuthWebHandlerFunc")
+		scopes, err := f.RequestedScopes(r)
+		if err != nil {
+			a.BadRequestHandler(f).ServeHTTP(w, r)
+			return
+		}
+		a.getSessionWriteTemplateHelper(w, r, f, http.StatusOK, authTemplate, scopes, "GetAuthWebHandlerFunc")
 	}
 }
 
@@ -280,6 +302,8 @@ func (a *App) BuildRoutes(r app.Router, db app.Database, f app.Framework) error
 	// endpoints:
 	//
 	//     /login (GET & POST)
+	//     /login/2fa (GET & POST)
+	//     /login/webauthn (POST)
 	//     /logout (GET)
 	//     /authorize (GET & POST)
 	//     /token (GET)
@@ -409,7 +433,7 @@ func (a *App) BuildRoutes(r app.Router, db app.Database, f app.Framework) error
 			util.ErrorLogger.Errorf("Error serving create note template: %v", err)
 		}
 	})
-	r.NewRoute().Path("/notes/create").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.NewRoute().Path("/notes/create").Methods("POST").RequireScopes(app.WriteScope).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Ensure the user is logged in.
 		userID, authd, err := f.Validate(w, r)
 		if err != nil {
@@ -746,6 +770,163 @@ func (a *App) BuildRoutes(r app.Router, db app.Database, f app.Framework) error
 		http.Redirect(w, r, iri.String(), http.StatusFound)
 	})
 
+	/* Two-Factor Authentication */
+
+	// These are pages that require a user to be signed-in to manage the
+	// second factors they log in with.
+	type twoFactorData struct {
+		Status        app.TwoFactorStatus
+		TOTP          *app.TOTPEnrollment
+		RecoveryCodes []string
+		Error         string
+	}
+	// renderTwoFactor renders the settings page with the user's current
+	// second factors.
+	renderTwoFactor := func(w http.ResponseWriter, r *http.Request, userID paths.UUID, d twoFactorData) {
+		s, err := f.Session(r)
+		if err != nil {
+			util.ErrorLogger.Errorf("Error getting session: %v", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		d.Status, err = f.TwoFactorStatus(f.Context(r), userID)
+		if err != nil {
+			util.ErrorLogger.Errorf("Error getting two-factor status: %v", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		err = a.templates.ExecuteTemplate(w, twoFactorTemplate, a.getTemplateData(s, d))
+		if err != nil {
+			util.ErrorLogger.Errorf("Error serving two-factor template: %v", err)
+		}
+	}
+	// validateTwoFactor ensures the user is logged in before changing their
+	// second factors, returning false if the request was already handled.
+	validateTwoFactor := func(w http.ResponseWriter, r *http.Request) (paths.UUID, bool) {
+		userID, authd, err := f.Validate(w, r)
+		if err != nil {
+			util.ErrorLogger.Errorf("error validating oauth2 token in %s %s: %s", r.Method, r.URL.Path, err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return "", false
+		}
+		if !authd {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return "", false
+		}
+		if r.Method == http.MethodPost {
+			if err = r.ParseForm(); err != nil {
+				badRequestHandler.ServeHTTP(w, r)
+				return "", false
+			}
+		}
+		return userID, true
+	}
+	r.NewRoute().Path("/settings/2fa").Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		renderTwoFactor(w, r, userID, twoFactorData{})
+	})
+	r.NewRoute().Path("/settings/2fa/totp").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		e, err := f.BeginTOTPEnrollment(f.Context(r), userID)
+		if err != nil {
+			util.ErrorLogger.Errorf("error beginning totp enrollment: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		renderTwoFactor(w, r, userID, twoFactorData{TOTP: &e})
+	})
+	r.NewRoute().Path("/settings/2fa/totp/confirm").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		codes, err := f.ConfirmTOTPEnrollment(f.Context(r), userID, r.PostForm.Get("totp_code"))
+		if f.IsInvalidSecondFactor(err) {
+			renderTwoFactor(w, r, userID, twoFactorData{Error: "The code was incorrect, please set up the authenticator app again."})
+			return
+		} else if err != nil {
+			util.ErrorLogger.Errorf("error confirming totp enrollment: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		renderTwoFactor(w, r, userID, twoFactorData{RecoveryCodes: codes})
+	})
+	r.NewRoute().Path("/settings/2fa/totp/disable").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		if err := f.DisableTOTP(f.Context(r), userID); err != nil {
+			util.ErrorLogger.Errorf("error disabling totp: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+	})
+	r.NewRoute().Path("/settings/2fa/recovery").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		codes, err := f.RegenerateRecoveryCodes(f.Context(r), userID)
+		if err != nil {
+			util.ErrorLogger.Errorf("error regenerating recovery codes: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		renderTwoFactor(w, r, userID, twoFactorData{RecoveryCodes: codes})
+	})
+	r.NewRoute().Path("/settings/2fa/webauthn/options").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		b, err := f.BeginWebAuthnRegistration(w, r, userID)
+		if err != nil {
+			util.ErrorLogger.Errorf("error beginning webauthn registration: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err = w.Write(b); err != nil {
+			util.ErrorLogger.Errorf("error writing webauthn registration options: %s", err)
+		}
+	})
+	r.NewRoute().Path("/settings/2fa/webauthn").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		codes, err := f.FinishWebAuthnRegistration(w, r, userID, r.PostForm.Get("name"), []byte(r.PostForm.Get("webauthn_credential")))
+		if f.IsInvalidSecondFactor(err) {
+			renderTwoFactor(w, r, userID, twoFactorData{Error: "The security key could not be added, please try again."})
+			return
+		} else if err != nil {
+			util.ErrorLogger.Errorf("error finishing webauthn registration: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		renderTwoFactor(w, r, userID, twoFactorData{RecoveryCodes: codes})
+	})
+	r.NewRoute().Path("/settings/2fa/webauthn/remove").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := validateTwoFactor(w, r)
+		if !ok {
+			return
+		}
+		if err := f.RemoveWebAuthnCredential(f.Context(r), userID, r.PostForm.Get("id")); err != nil {
+			util.ErrorLogger.Errorf("error removing webauthn credential: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+	})
+
 	/*We could do something similar for "liked" as we did above for
 	"following" or "followers", but we made the choice not to expose the
 	"liked" collection at all, so we will just not introduce that concept.*/
@@ -775,6 +956,8 @@ func (a *App) NewIDPath(c context.Context, t vocab.Type) (path string, err error
 		fallthrough
 	case "Reject":
 		fallthrough
+	case "Update":
+		fallthrough
 	case "Follow":
 		// This path matches the route created above to serve the data.
 		path = fmt.Sprintf("/activities/%s", uuid.New().String())
@@ -828,25 +1011,21 @@ func (a *App) ApplySocialCallbacks(swc *pub.SocialWrappedCallbacks) (others []in
 	return
 }
 
-// ScopePermitsPostOutbox ensures the OAuth2 token scope is "loggedin", which
-// is the only permission. Other applications can have more granular
-// authorization systems.
+// ScopePermitsPostOutbox ensures the OAuth2 token grants the "write" scope.
 func (a *App) ScopePermitsPostOutbox(scope string) (permitted bool, err error) {
-	return scope == "postOutbox" || scope == "all", nil
+	return app.GrantsScopes(scope, app.WriteScope), nil
 }
 
-// ScopePermitsPrivateGetInbox ensures the OAuth2 token scope is "loggedin",
-// which is the only permission. Other applications can have more granular
-// authorization systems.
+// ScopePermitsPrivateGetInbox ensures the OAuth2 token grants the "read"
+// scope.
 func (a *App) ScopePermitsPrivateGetInbox(scope string) (permitted bool, err error) {
-	return scope == "getInbox" || scope == "all", nil
+	return app.GrantsScopes(scope, app.ReadScope), nil
 }
 
-// ScopePermitsPrivateGetOutbox ensures the OAuth2 token scope is "loggedin",
-// which is the only permission. Other applications can have more granular
-// authorization systems.
+// ScopePermitsPrivateGetOutbox ensures the OAuth2 token grants the "read"
+// scope.
 func (a *App) ScopePermitsPrivateGetOutbox(scope string) (permitted bool, err error) {
-	return scope == "getOutbox" || scope == "all", nil
+	return app.GrantsScopes(scope, app.ReadScope), nil
 }
 
 // Software describes the current running software, based on the code. This
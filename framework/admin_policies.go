@@ -0,0 +1,382 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package framework
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/allinbits/apcore/app"
+	"github.com/allinbits/apcore/framework/oauth2"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/paths"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+const (
+	adminPoliciesPath           = "/admin/policies"
+	adminPolicyPath             = "/admin/policies/{policy}"
+	adminPolicyResolutionsPath  = "/admin/policies/{policy}/resolutions"
+	adminPolicyUserScope        = "user"
+	adminPolicyVarName          = "policy"
+	adminPolicyUserQueryName    = "user"
+	adminPolicyOffsetQueryName  = "offset"
+	adminPolicyLimitQueryName   = "limit"
+	adminPolicyJSONContentType  = "application/json; charset=utf-8"
+	adminPolicyMaxRequestLength = 1 << 20
+)
+
+// adminPolicyRequest is the body of requests creating or updating a policy.
+type adminPolicyRequest struct {
+	// Scope is "instance", "domain", or "user", and is ignored on update.
+	Scope string `json:"scope,omitempty"`
+	// Domain is only used by the "domain" scope.
+	Domain string `json:"domain,omitempty"`
+	// UserID is only used by the "user" scope.
+	UserID  string        `json:"userID,omitempty"`
+	Purpose string        `json:"purpose"`
+	Policy  models.Policy `json:"policy"`
+}
+
+type adminPolicyResponse struct {
+	ID      string        `json:"id"`
+	Scope   string        `json:"scope"`
+	Domain  string        `json:"domain,omitempty"`
+	ActorID string        `json:"actorID,omitempty"`
+	Purpose string        `json:"purpose"`
+	Policy  models.Policy `json:"policy"`
+}
+
+type adminResolutionResponse struct {
+	ID       string   `json:"id"`
+	DataIRI  string   `json:"dataIRI"`
+	Time     string   `json:"time"`
+	Matched  bool     `json:"matched"`
+	MatchLog []string `json:"matchLog"`
+}
+
+type adminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// addAdminPolicyRoutes registers the built-in admin HTTP API for managing
+// policies, which only users with the Admin privilege may use, and only with
+// tokens granting the "admin" scope:
+//
+//	GET    /admin/policies                         Lists instance and domain policies, or a user's with ?user=<uuid>
+//	POST   /admin/policies                         Creates a policy
+//	GET    /admin/policies/{policy}                Gets a policy
+//	PUT    /admin/policies/{policy}                Replaces a policy's purpose and policy
+//	DELETE /admin/policies/{policy}                Deletes a policy and its resolutions
+//	GET    /admin/policies/{policy}/resolutions    Lists a policy's recent resolutions, with ?offset= and ?limit=
+func addAdminPolicyRoutes(r *Router,
+	scheme, host string,
+	defaultPageSize, maxPageSize int,
+	oauth *oauth2.Server,
+	users *services.Users,
+	policies *services.Policies) {
+	a := &adminPolicies{
+		scheme:          scheme,
+		host:            host,
+		defaultPageSize: defaultPageSize,
+		maxPageSize:     maxPageSize,
+		policies:        policies,
+	}
+	route := func(path, method string, fn http.HandlerFunc) {
+		r.NewRoute().
+			Path(path).
+			Methods(method).
+			RequireScopes(app.AdminScope).
+			HandlerFunc(adminOnly(oauth, users, fn))
+	}
+	route(adminPoliciesPath, http.MethodGet, a.list)
+	route(adminPoliciesPath, http.MethodPost, a.create)
+	route(adminPolicyPath, http.MethodGet, a.get)
+	route(adminPolicyPath, http.MethodPut, a.update)
+	route(adminPolicyPath, http.MethodDelete, a.delete)
+	route(adminPolicyResolutionsPath, http.MethodGet, a.resolutions)
+}
+
+// adminOnly only permits requests from authenticated administrators.
+func adminOnly(oauth *oauth2.Server, users *services.Users, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, authenticated, err := oauth.Validate(w, r)
+		if err != nil {
+			util.ErrorLogger.Errorf("error validating admin request: %s", err)
+			writeAdminError(w, http.StatusInternalServerError, "internal error")
+			return
+		} else if !authenticated {
+			writeAdminError(w, http.StatusUnauthorized, "not authenticated")
+			return
+		}
+		p, err := users.Privileges(util.Context{r.Context()}, userID, nil)
+		if err != nil {
+			util.ErrorLogger.Errorf("error fetching privileges for admin request: %s", err)
+			writeAdminError(w, http.StatusInternalServerError, "internal error")
+			return
+		} else if !p.Admin {
+			writeAdminError(w, http.StatusForbidden, "not an administrator")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type adminPolicies struct {
+	scheme          string
+	host            string
+	defaultPageSize int
+	maxPageSize     int
+	policies        *services.Policies
+}
+
+func (a *adminPolicies) list(w http.ResponseWriter, r *http.Request) {
+	ctx := util.Context{r.Context()}
+	resp := []adminPolicyResponse{}
+	if uid := r.URL.Query().Get(adminPolicyUserQueryName); len(uid) > 0 {
+		actorID := a.userIRI(uid)
+		po, err := a.policies.GetActorPolicies(ctx, actorID)
+		if err != nil {
+			a.internalError(w, "listing user policies", err)
+			return
+		}
+		for _, p := range po {
+			resp = append(resp, adminPolicyResponse{
+				ID:      p.ID,
+				Scope:   adminPolicyUserScope,
+				ActorID: actorID.String(),
+				Purpose: string(p.Purpose),
+				Policy:  p.Policy,
+			})
+		}
+	} else {
+		po, err := a.policies.GetScopedPolicies(ctx)
+		if err != nil {
+			a.internalError(w, "listing scoped policies", err)
+			return
+		}
+		for _, p := range po {
+			resp = append(resp, toAdminPolicyResponse(p))
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (a *adminPolicies) create(w http.ResponseWriter, r *http.Request) {
+	ctx := util.Context{r.Context()}
+	var req adminPolicyRequest
+	if err := readAdminJSON(r, &req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var policyID string
+	var err error
+	switch models.PolicyScope(req.Scope) {
+	case models.InstancePolicyScope:
+		policyID, err = a.policies.CreateInstancePolicy(ctx, models.Purpose(req.Purpose), req.Policy)
+	case models.DomainPolicyScope:
+		policyID, err = a.policies.CreateDomainPolicy(ctx, req.Domain, models.Purpose(req.Purpose), req.Policy)
+	case adminPolicyUserScope:
+		if len(req.UserID) == 0 {
+			writeAdminError(w, http.StatusBadRequest, "missing userID")
+			return
+		}
+		policyID, err = a.policies.CreateActorPolicy(ctx, a.userIRI(req.UserID), models.Purpose(req.Purpose), req.Policy)
+	default:
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope: %q", req.Scope))
+		return
+	}
+	if errors.Is(err, services.ErrInvalidPolicy) {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		a.internalError(w, "creating policy", err)
+		return
+	}
+	sp, err := a.policies.GetPolicy(ctx, policyID)
+	if err != nil {
+		a.internalError(w, "fetching created policy", err)
+		return
+	}
+	writeAdminJSON(w, http.StatusCreated, toAdminPolicyResponse(sp))
+}
+
+func (a *adminPolicies) get(w http.ResponseWriter, r *http.Request) {
+	sp, ok := a.fetch(w, r)
+	if !ok {
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, toAdminPolicyResponse(sp))
+}
+
+func (a *adminPolicies) update(w http.ResponseWriter, r *http.Request) {
+	ctx := util.Context{r.Context()}
+	sp, ok := a.fetch(w, r)
+	if !ok {
+		return
+	}
+	var req adminPolicyRequest
+	if err := readAdminJSON(r, &req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err := a.policies.UpdatePolicy(ctx, sp.ID, models.Purpose(req.Purpose), req.Policy)
+	if errors.Is(err, services.ErrInvalidPolicy) {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		a.internalError(w, "updating policy", err)
+		return
+	}
+	sp.Purpose = models.Purpose(req.Purpose)
+	sp.Policy = req.Policy
+	writeAdminJSON(w, http.StatusOK, toAdminPolicyResponse(sp))
+}
+
+func (a *adminPolicies) delete(w http.ResponseWriter, r *http.Request) {
+	sp, ok := a.fetch(w, r)
+	if !ok {
+		return
+	}
+	if err := a.policies.DeletePolicy(util.Context{r.Context()}, sp.ID); err != nil {
+		a.internalError(w, "deleting policy", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminPolicies) resolutions(w http.ResponseWriter, r *http.Request) {
+	sp, ok := a.fetch(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, err := a.page(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sr, err := a.policies.ResolutionsForPolicy(util.Context{r.Context()}, sp.ID, offset, limit)
+	if err != nil {
+		a.internalError(w, "listing policy resolutions", err)
+		return
+	}
+	resp := []adminResolutionResponse{}
+	for _, s := range sr {
+		resp = append(resp, adminResolutionResponse{
+			ID:       s.ID,
+			DataIRI:  s.IRI.String(),
+			Time:     s.CreateTime.UTC().Format(time.RFC3339),
+			Matched:  s.R.Matched,
+			MatchLog: s.R.MatchLog,
+		})
+	}
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+// fetch obtains the policy in the request path, writing an error response if
+// it cannot.
+func (a *adminPolicies) fetch(w http.ResponseWriter, r *http.Request) (sp models.ScopedPolicy, ok bool) {
+	var err error
+	sp, err = a.policies.GetPolicy(util.Context{r.Context()}, Vars(r)[adminPolicyVarName])
+	if err == services.ErrPolicyNotFound {
+		writeAdminError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		a.internalError(w, "fetching policy", err)
+		return
+	}
+	ok = true
+	return
+}
+
+func (a *adminPolicies) page(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	limit = a.defaultPageSize
+	if v := q.Get(adminPolicyOffsetQueryName); len(v) > 0 {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			err = fmt.Errorf("invalid offset: %q", v)
+			return
+		}
+	}
+	if v := q.Get(adminPolicyLimitQueryName); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			err = fmt.Errorf("invalid limit: %q", v)
+			return
+		}
+	}
+	if limit > a.maxPageSize {
+		limit = a.maxPageSize
+	}
+	return
+}
+
+func (a *adminPolicies) userIRI(userID string) *url.URL {
+	return paths.UUIDIRIFor(a.scheme, a.host, paths.UserPathKey, paths.UUID(userID))
+}
+
+func (a *adminPolicies) internalError(w http.ResponseWriter, doing string, err error) {
+	util.ErrorLogger.Errorf("error in admin policy API while %s: %s", doing, err)
+	writeAdminError(w, http.StatusInternalServerError, "internal error")
+}
+
+func toAdminPolicyResponse(sp models.ScopedPolicy) adminPolicyResponse {
+	scope := string(sp.Scope)
+	if sp.Scope == models.ActorPolicyScope {
+		scope = adminPolicyUserScope
+	}
+	return adminPolicyResponse{
+		ID:      sp.ID,
+		Scope:   scope,
+		Domain:  sp.Domain,
+		ActorID: sp.ActorID,
+		Purpose: string(sp.Purpose),
+		Policy:  sp.Policy,
+	}
+}
+
+func readAdminJSON(r *http.Request, v interface{}) error {
+	d := json.NewDecoder(http.MaxBytesReader(nil, r.Body, adminPolicyMaxRequestLength))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %s", err)
+	}
+	return nil
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, adminErrorResponse{Error: msg})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		util.ErrorLogger.Errorf("error marshalling admin API response: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", adminPolicyJSONContentType)
+	w.WriteHeader(status)
+	if _, err = w.Write(b); err != nil {
+		util.ErrorLogger.Errorf("error writing admin API response: %s", err)
+	}
+}
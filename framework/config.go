@@ -28,6 +28,7 @@ import (
 
 const (
 	postgresDB = "postgres"
+	sqliteDB   = "sqlite"
 )
 
 func defaultConfig(dbkind string) (c *config.Config, err error) {
@@ -74,11 +75,14 @@ func defaultDatabaseConfig(dbkind string) (d config.DatabaseConfig, err error) {
 		// This default is arbitrarily chosen
 		MaxCollectionPageSize: 200,
 	}
-	if dbkind != postgresDB {
+	switch dbkind {
+	case postgresDB:
+		d.PostgresConfig = defaultPostgresConfig()
+	case sqliteDB:
+		d.SqliteConfig = defaultSqliteConfig()
+	default:
 		err = fmt.Errorf("unsupported database kind: %s", dbkind)
-		return
 	}
-	d.PostgresConfig = defaultPostgresConfig()
 	return
 }
 
@@ -95,6 +99,20 @@ func defaultActivityPubConfig() config.ActivityPubConfig {
 		RetrySleepPeriod:                    300,
 		OutboundRateLimitPrunePeriodSeconds: 60,
 		OutboundRateLimitPruneAgeSeconds:    30,
+		RetryBackoff:                        "exponential_jitter",
+		CircuitBreakerFailures:              5,
+		CircuitBreakerPauseSeconds:          600,
+		DeliveryWorkers:                     8,
+		DeliveryHostConcurrency:             2,
+		DeliveryClaimSeconds:                600,
+		ResolutionRetentionSeconds:          2592000,
+		ResolutionPrunePeriodSeconds:        3600,
+		SharedInboxCacheSeconds:             3600,
+		GoneActorSeconds:                    2592000,
+		DereferenceCacheMaxAgeSeconds:       600,
+		DereferenceCacheRetentionSeconds:    86400,
+		RemotePublicKeyCacheSeconds:         86400,
+		KeyRotationGraceSeconds:             604800,
 	}
 }
 
@@ -104,6 +122,7 @@ func defaultHttpSignaturesConfig() config.HttpSignaturesConfig {
 		DigestAlgorithm: "SHA-256",
 		GetHeaders:      []string{"(request-target)", "Date"},
 		PostHeaders:     []string{"(request-target)", "Date", "Digest"},
+		Scheme:          "double_knock",
 	}
 }
 
@@ -111,6 +130,13 @@ func defaultPostgresConfig() config.PostgresConfig {
 	return config.PostgresConfig{}
 }
 
+func defaultSqliteConfig() config.SqliteConfig {
+	return config.SqliteConfig{
+		BusyTimeoutMs: 5000,
+		JournalMode:   "WAL",
+	}
+}
+
 func defaultNodeInfoConfig() config.NodeInfoConfig {
 	return config.NodeInfoConfig{
 		EnableNodeInfo:                         true,
@@ -44,37 +44,56 @@ type ServerConfig struct {
 	SaltSize                    int    `ini:"sr_salt_size" comment:"(default: 32) The size of salts to use with passwords when hashing, anything smaller than 16 will be treated as 16"`
 	BCryptStrength              int    `ini:"sr_bcrypt_strength" comment:"(default: 10) The hashing cost to use with the bcrypt hashing algorithm, between 4 and 31; the higher the cost, the slower the hash comparisons for passwords will take for attackers and regular users alike"`
 	RSAKeySize                  int    `ini:"sr_rsa_private_key_size" comment:"(default: 1024) The size of the RSA private key for a user; values less than 1024 are forbidden"`
+	EnableAdminPolicyAPI        bool   `ini:"sr_enable_admin_policy_api" comment:"(default: false) Whether to serve the built-in JSON API for managing federation policies and their resolutions under /admin/policies; only users with the admin privilege may use it"`
 }
 
 type OAuth2Config struct {
-	AccessTokenExpiry  int `ini:"oauth_access_token_expiry" comment:"(default: 3600 seconds) Duration in seconds until an access token expires; zero or negative values are invalid."`
-	RefreshTokenExpiry int `ini:"oauth_refresh_token_expiry" comment:"(default: 7200 seconds) Duration in seconds until a refresh token expires; zero or negative values are invalid."`
+	AccessTokenExpiry        int  `ini:"oauth_access_token_expiry" comment:"(default: 3600 seconds) Duration in seconds until an access token expires; zero or negative values are invalid."`
+	RefreshTokenExpiry       int  `ini:"oauth_refresh_token_expiry" comment:"(default: 7200 seconds) Duration in seconds until a refresh token expires; zero or negative values are invalid."`
+	EnableClientRegistration bool `ini:"oauth_enable_client_registration" comment:"(default: false) Whether third party clients, such as ActivityPub C2S apps, may register themselves at /oauth2/register using OAuth 2.0 Dynamic Client Registration (RFC 7591); administrators can list and revoke registered clients"`
 }
 
 // Configuration section specifically for the database.
 type DatabaseConfig struct {
-	DatabaseKind              string         `ini:"db_database_kind" comment:"(required) Only \"postgres\" supported"`
+	DatabaseKind              string         `ini:"db_database_kind" comment:"(required) Either \"postgres\" or \"sqlite\""`
 	ConnMaxLifetimeSeconds    int            `ini:"db_conn_max_lifetime_seconds" comment:"(default: indefinite) Maximum lifetime of a connection in seconds; a value of zero or unset value means indefinite"`
 	MaxOpenConns              int            `ini:"db_max_open_conns" comment:"(default: infinite) Maximum number of open connections to the database; a value of zero or unset value means infinite"`
 	MaxIdleConns              int            `ini:"db_max_idle_conns" comment:"(default: 2) Maximum number of idle connections in the connection pool to the database; a value of zero maintains no idle connections; a value greater than max_open_conns is reduced to be equal to max_open_conns"`
 	DefaultCollectionPageSize int            `ini:"db_default_collection_page_size" comment:"(default: 10) The default collection page size when fetching a page of an ActivityStreams collection"`
 	MaxCollectionPageSize     int            `ini:"db_max_collection_page_size" comment:"(default: 200) The maximum collection page size allowed when fetching a page of an ActivityStreams collection"`
 	PostgresConfig            PostgresConfig `ini:"db_postgres,omitempty" comment:"Only needed if database_kind is postgres, and values are based on the github.com/jackc/pgx driver"`
+	SqliteConfig              SqliteConfig   `ini:"db_sqlite,omitempty" comment:"Only needed if database_kind is sqlite, and values are based on the github.com/mattn/go-sqlite3 driver"`
 }
 
 // Configuration section specifically for ActivityPub.
 type ActivityPubConfig struct {
 	ClockTimezone                       string               `ini:"ap_clock_timezone" comment:"(default: UTC) Timezone for ActivityPub related operations: unset and \"UTC\" are UTC, \"Local\" is local server time, otherwise use IANA Time Zone database values"`
-	OutboundRateLimitQPS                float64              `ini:"ap_outbound_rate_limit_qps" comment:"(default: 2) Per-host outbound rate limit for delivery of federated messages under steady state conditions; a negative value or value of zero is invalid"`
-	OutboundRateLimitBurst              int                  `ini:"ap_outbound_rate_limit_burst" comment:"(default: 5) Per-host outbound burst tolerance for delivery of federated messages; a negative value or value of zero is invalid"`
+	OutboundRateLimitQPS                float64              `ini:"ap_outbound_rate_limit_qps" comment:"(default: 2) Per-host outbound rate limit for delivery of federated messages under steady state conditions, which is lowered for a peer that times out, responds with server errors, or asks to slow down, and is gradually raised back as requests to it succeed; a negative value or value of zero is invalid"`
+	OutboundRateLimitBurst              int                  `ini:"ap_outbound_rate_limit_burst" comment:"(default: 5) Per-host outbound burst tolerance for delivery of federated messages, which is lowered and raised in proportion to the rate limit; a negative value or value of zero is invalid"`
 	OutboundRateLimitPrunePeriodSeconds int                  `ini:"ap_outbound_rate_limit_prune_period_seconds" comment:"(default: 60) The time period to await before periodically removing cached per-host rate-limiters that are no longer in use, controlling how frequently pruning occurs; a negative value or value of zero is invalid"`
 	OutboundRateLimitPruneAgeSeconds    int                  `ini:"ap_outbound_rate_limit_prune_age_seconds" comment:"(default: 30) The age of an unused per-host rate-limiter must be to be pruned and removed from the cache when the pruning occurs, controlling how long cached rate-limiters are kept when unused; a negative value is invalid"`
+	OutboundRateLimitHosts              []string             `ini:"ap_outbound_rate_limit_hosts" comment:"(default: \"\") Comma-separated list of static per-host outbound rate limits in the form \"host=qps:burst\", such as \"example.com=10:20\", which are used instead of ap_outbound_rate_limit_qps and ap_outbound_rate_limit_burst for the named hosts and are never adapted; the host includes any non-default port; a negative value or value of zero for either limit is invalid"`
 	HttpSignaturesConfig                HttpSignaturesConfig `ini:"ap_http_signatures" comment:"HTTP Signatures configuration"`
 	MaxInboxForwardingRecursionDepth    int                  `ini:"ap_max_inbox_forwarding_recursion_depth" comment:"(default: 50) The maximum recursion depth to use when determining whether to do inbox forwarding, which if triggered ensures older thread participants are able to receive messages; zero means no limit (only used if the application has S2S enabled); a negative value is invalid"`
 	MaxDeliveryRecursionDepth           int                  `ini:"ap_max_delivery_recursion_depth" comment:"(default: 50) The maximum depth to search for peers to deliver due to inbox forwarding, which ensures messages received by this server are propagated to them and no \"ghost reply\" problems occur; zero means no limit (only used if the application has S2S enabled); a negative value is invalid"`
 	RetryPageSize                       int                  `ini:"ap_retry_page_size" comment:"(default: 25) The number of retryable deliveries to request from the database at a time; a negative value or zero value is invalid"`
 	RetryAbandonLimit                   int                  `ini:"ap_retry_abandon_limit" comment:"(default: 10) The maximum number of times the app will attempt to deliver an Activity to a federated peer and fail before permanently giving up and abandoning any further attempts to deliver it; a negative value or zero value is invalid"`
-	RetrySleepPeriod                    int                  `ini:"ap_retry_sleep_period_seconds" comment:"(default: 300) The time period to await between making periodic attempts to re-deliver Activities to federated peers that have never been successfully delivered; a 300-second retry sleep period with an abandon limit of 10 results in an exponential backoff of 10 delivery attempts across roughly 3 days; a negative value or zero value is invalid"`
+	RetrySleepPeriod                    int                  `ini:"ap_retry_sleep_period_seconds" comment:"(default: 300) The time period to await before the first attempt to re-deliver an Activity to a federated peer that failed to be delivered, doubling after each further failure, which is also how often queued deliveries are checked for being due; a 300-second retry sleep period with an abandon limit of 10 results in an exponential backoff of 10 delivery attempts across roughly 3 days; a negative value or zero value is invalid"`
+	RetryBackoff                        string               `ini:"ap_retry_backoff" comment:"(default: exponential_jitter) The strategy for how long to wait before re-delivering an Activity that failed to be delivered: \"exponential\" doubles ap_retry_sleep_period_seconds after each failure, \"exponential_jitter\" does the same but waits a random amount of up to half of that less so that retries to the same peer are spread out, \"fixed\" always waits ap_retry_sleep_period_seconds, and \"custom\" lets the application decide; unset is \"exponential\""`
+	CircuitBreakerFailures              int                  `ini:"ap_circuit_breaker_failures" comment:"(default: 5) The number of deliveries to a federated peer that must fail in a row to pause all deliveries to it; zero disables pausing deliveries; a negative value is invalid"`
+	CircuitBreakerPauseSeconds          int                  `ini:"ap_circuit_breaker_pause_seconds" comment:"(default: 600) How long deliveries to a federated peer are paused before a single delivery is made to probe whether the peer has recovered, which resumes all deliveries to it if successful; only used if ap_circuit_breaker_failures is positive, in which case a negative value or value of zero is invalid"`
+	DeliveryWorkers                     int                  `ini:"ap_delivery_workers" comment:"(default: 8) The number of workers concurrently delivering queued Activities to federated peers; zero uses a single worker; a negative value is invalid"`
+	DeliveryHostConcurrency             int                  `ini:"ap_delivery_host_concurrency" comment:"(default: 2) The maximum number of deliveries made to a single federated peer at the same time, so that a slow peer cannot occupy every delivery worker; zero means no limit; a negative value is invalid"`
+	DeliveryClaimSeconds                int                  `ini:"ap_delivery_claim_seconds" comment:"(default: 600) How long due deliveries taken from the database by this process are hidden from any other process sharing the database, so that several processes may deliver from the same queue; a delivery that is not attempted within this time, such as when this process exits without stopping, is taken again by whichever process next finds it due, so this should be longer than a page of ap_retry_page_size deliveries takes to make; zero uses 600 seconds; a negative value is invalid"`
+	ResolutionRetentionSeconds          int                  `ini:"ap_resolution_retention_seconds" comment:"(default: 2592000) The age in seconds after which the recorded results of applying policies to federated data are deleted; zero keeps them indefinitely; a negative value is invalid"`
+	ResolutionPrunePeriodSeconds        int                  `ini:"ap_resolution_prune_period_seconds" comment:"(default: 3600) The time period to await between periodically deleting recorded results of applying policies that are older than the retention period; only used if ap_resolution_retention_seconds is positive, in which case a negative value or zero value is invalid"`
+	SharedInboxCacheSeconds             int                  `ini:"ap_shared_inbox_cache_seconds" comment:"(default: 3600) How long the shared inbox of a federated peer is remembered after its actor was last fetched; recipients on the same host that share an inbox receive a single delivery to it instead of one delivery each; zero disables delivering to shared inboxes; a negative value is invalid"`
+	GoneActorSeconds                    int                  `ini:"ap_gone_actor_seconds" comment:"(default: 2592000) How long no deliveries are queued for the inbox of a federated actor after its peer responded that the inbox is gone; the actor owning an inbox is only known if it was fetched within ap_shared_inbox_cache_seconds, otherwise only the delivery that was refused is abandoned, as are deliveries to shared inboxes that are gone; zero only abandons the deliveries queued at the time; a negative value is invalid"`
+	DereferenceCacheMaxAgeSeconds       int                  `ini:"ap_dereference_cache_max_age_seconds" comment:"(default: 600) The longest time that federated objects and actors fetched from peers are used without fetching them again, which is shortened by peers that ask for a shorter time with the Cache-Control or Expires headers; afterwards they are revalidated with a conditional request if the peer supports it; this is also how often stale entries are pruned; zero disables caching fetched federated data; a negative value is invalid"`
+	DereferenceCacheRetentionSeconds    int                  `ini:"ap_dereference_cache_retention_seconds" comment:"(default: 86400) How long cached federated data is kept after it needs to be fetched again, so that it can be revalidated with a conditional request instead of being fetched in full; only used if ap_dereference_cache_max_age_seconds is positive; a negative value is invalid"`
+	RemotePublicKeyCacheSeconds         int                  `ini:"ap_remote_public_key_cache_seconds" comment:"(default: 86400) How long the public key of a federated peer is used to verify the HTTP Signatures of its requests before it is fetched again; a signature that fails to verify with a cached key causes the key to be fetched again once, so that a peer rotating its key is noticed; zero disables caching public keys, fetching the key for every request; a negative value is invalid"`
+	KeyRotationGraceSeconds             int                  `ini:"ap_key_rotation_grace_seconds" comment:"(default: 604800) How long the previous key of a user or the instance actor stays published after its keys are rotated, so that federated peers can still verify what was signed with it; the retired key is removed within an hour of this period ending; zero removes it at the next pruning; a negative value is invalid"`
+	AuthorizedFetch                     bool                 `ini:"ap_authorized_fetch" comment:"(default: false) Whether ActivityPub GET requests for actors, objects, and collections must carry a valid HTTP Signature or OAuth2 access token, also known as secure mode; the signing actor's domain is subject to the instance and domain federated_block policies; the instance actor can always be fetched so that peers can verify its signatures"`
 }
 
 // Configuration for HTTP Signatures.
@@ -83,6 +102,7 @@ type HttpSignaturesConfig struct {
 	DigestAlgorithm string   `ini:"http_sig_digest_algorithm" comment:"(default: \"SHA-256\") RFC 3230 algorithm for use in signing header Digests"`
 	GetHeaders      []string `ini:"http_sig_get_headers" comment:"(default: \"(request-target),Date\") Comma-separated list of HTTP headers to sign in GET requests; must contain \"(request-target)\" and \"Date\""`
 	PostHeaders     []string `ini:"http_sig_post_headers" comment:"(default: \"(request-target),Date,Digest\") Comma-separated list of HTTP headers to sign in POST requests; must contain \"(request-target)\", \"Date\", and \"Digest\""`
+	Scheme          string   `ini:"http_sig_scheme" comment:"(default: double_knock) How outgoing requests are signed: \"cavage\" always uses the draft-cavage HTTP Signatures configured above, \"rfc9421\" always uses RFC 9421 HTTP Message Signatures, and \"double_knock\" first tries RFC 9421 with each peer and falls back to draft-cavage if the peer responds 401 Unauthorized, remembering what each peer accepts and which peers reject both; incoming requests are verified with either scheme regardless; unset is \"cavage\""`
 }
 
 // Configuration section specifically for Postgres databases.
@@ -101,6 +121,13 @@ type PostgresConfig struct {
 	Schema                  string `ini:"pg_schema" comment:"Postgres schema prefix to use"`
 }
 
+// Configuration section specifically for SQLite databases.
+type SqliteConfig struct {
+	File          string `ini:"sqlite_file" comment:"(required) Path to the SQLite database file, which is created if it does not exist"`
+	BusyTimeoutMs int    `ini:"sqlite_busy_timeout_ms" comment:"(default: 5000) Milliseconds to wait for a lock held by another connection before failing; a negative value is invalid"`
+	JournalMode   string `ini:"sqlite_journal_mode" comment:"(default: WAL) SQLite journal mode (options are: \"DELETE\", \"TRUNCATE\", \"PERSIST\", \"MEMORY\", \"WAL\", \"OFF\")"`
+}
+
 // Configuration section specifically for NodeInfo.
 type NodeInfoConfig struct {
 	EnableNodeInfo                         bool `ini:"ni_enable_nodeinfo" comment:"(default: true) Whether to share basic server and software information at a somewhat-Fediverse-understood endpoint for public use; NodeInfo is upstream of the NodeInfo2 fork and in general admins will either wish to enable or disable both"`
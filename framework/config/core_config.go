@@ -16,6 +16,12 @@
 
 package config
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 func (c *Config) Host() string {
 	return c.ServerConfig.Host
 }
@@ -27,3 +33,46 @@ func (c *Config) ClockTimezone() string {
 func (c *Config) Schema() string {
 	return c.DatabaseConfig.PostgresConfig.Schema
 }
+
+func (c *Config) DatabaseKind() string {
+	return c.DatabaseConfig.DatabaseKind
+}
+
+// HostRateLimit is a static outbound rate limit for a single host.
+type HostRateLimit struct {
+	QPS   float64
+	Burst int
+}
+
+// HostRateLimits parses the static per-host outbound rate limits, keyed by
+// host.
+func (c *ActivityPubConfig) HostRateLimits() (m map[string]HostRateLimit, err error) {
+	m = make(map[string]HostRateLimit, len(c.OutboundRateLimitHosts))
+	for _, v := range c.OutboundRateLimitHosts {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		host, limits := splitPair(v, "=")
+		qps, burst := splitPair(limits, ":")
+		var l HostRateLimit
+		if len(host) == 0 || len(qps) == 0 || len(burst) == 0 {
+			return nil, fmt.Errorf("ap_outbound_rate_limit_hosts entry is not of the form \"host=qps:burst\": %q", v)
+		} else if l.QPS, err = strconv.ParseFloat(qps, 64); err != nil || l.QPS <= 0 {
+			return nil, fmt.Errorf("ap_outbound_rate_limit_hosts entry has a qps that is not positive: %q", v)
+		} else if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst <= 0 {
+			return nil, fmt.Errorf("ap_outbound_rate_limit_hosts entry has a burst that is not positive: %q", v)
+		} else if _, ok := m[host]; ok {
+			return nil, fmt.Errorf("ap_outbound_rate_limit_hosts has more than one entry for host: %q", host)
+		}
+		m[host] = l
+	}
+	return m, nil
+}
+
+func splitPair(s, sep string) (a, b string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(sep):])
+	}
+	return strings.TrimSpace(s), ""
+}
@@ -88,6 +88,11 @@ func (c *DatabaseConfig) Verify() error {
 			return err
 		}
 	}
+	if c.DatabaseKind == "sqlite" {
+		if err := c.SqliteConfig.Verify(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -104,6 +109,9 @@ func (c *ActivityPubConfig) Verify() error {
 	if c.OutboundRateLimitPruneAgeSeconds < 0 {
 		return fmt.Errorf("ap_outbound_rate_limit_prune_age_seconds is negative, which is forbidden: %d", c.OutboundRateLimitPruneAgeSeconds)
 	}
+	if _, err := c.HostRateLimits(); err != nil {
+		return err
+	}
 	if c.MaxInboxForwardingRecursionDepth < 0 {
 		return fmt.Errorf("ap_max_inbox_forwarding_recursion_depth is negative, which is forbidden: %d", c.MaxInboxForwardingRecursionDepth)
 	}
@@ -119,6 +127,50 @@ func (c *ActivityPubConfig) Verify() error {
 	if c.RetrySleepPeriod <= 0 {
 		return fmt.Errorf("ap_retry_sleep_period_seconds is zero or negative, which is forbidden: %d", c.RetrySleepPeriod)
 	}
+	switch c.RetryBackoff {
+	case "", "exponential", "exponential_jitter", "fixed", "custom":
+	default:
+		return fmt.Errorf("ap_retry_backoff is not one of \"exponential\", \"exponential_jitter\", \"fixed\", or \"custom\": %q", c.RetryBackoff)
+	}
+	if c.CircuitBreakerFailures < 0 {
+		return fmt.Errorf("ap_circuit_breaker_failures is negative, which is forbidden: %d", c.CircuitBreakerFailures)
+	}
+	if c.CircuitBreakerFailures > 0 && c.CircuitBreakerPauseSeconds <= 0 {
+		return fmt.Errorf("ap_circuit_breaker_pause_seconds is zero or negative, which is forbidden: %d", c.CircuitBreakerPauseSeconds)
+	}
+	if c.DeliveryWorkers < 0 {
+		return fmt.Errorf("ap_delivery_workers is negative, which is forbidden: %d", c.DeliveryWorkers)
+	}
+	if c.DeliveryHostConcurrency < 0 {
+		return fmt.Errorf("ap_delivery_host_concurrency is negative, which is forbidden: %d", c.DeliveryHostConcurrency)
+	}
+	if c.DeliveryClaimSeconds < 0 {
+		return fmt.Errorf("ap_delivery_claim_seconds is negative, which is forbidden: %d", c.DeliveryClaimSeconds)
+	}
+	if c.ResolutionRetentionSeconds < 0 {
+		return fmt.Errorf("ap_resolution_retention_seconds is negative, which is forbidden: %d", c.ResolutionRetentionSeconds)
+	}
+	if c.ResolutionRetentionSeconds > 0 && c.ResolutionPrunePeriodSeconds <= 0 {
+		return fmt.Errorf("ap_resolution_prune_period_seconds is zero or negative, which is forbidden: %d", c.ResolutionPrunePeriodSeconds)
+	}
+	if c.SharedInboxCacheSeconds < 0 {
+		return fmt.Errorf("ap_shared_inbox_cache_seconds is negative, which is forbidden: %d", c.SharedInboxCacheSeconds)
+	}
+	if c.GoneActorSeconds < 0 {
+		return fmt.Errorf("ap_gone_actor_seconds is negative, which is forbidden: %d", c.GoneActorSeconds)
+	}
+	if c.DereferenceCacheMaxAgeSeconds < 0 {
+		return fmt.Errorf("ap_dereference_cache_max_age_seconds is negative, which is forbidden: %d", c.DereferenceCacheMaxAgeSeconds)
+	}
+	if c.DereferenceCacheRetentionSeconds < 0 {
+		return fmt.Errorf("ap_dereference_cache_retention_seconds is negative, which is forbidden: %d", c.DereferenceCacheRetentionSeconds)
+	}
+	if c.RemotePublicKeyCacheSeconds < 0 {
+		return fmt.Errorf("ap_remote_public_key_cache_seconds is negative, which is forbidden: %d", c.RemotePublicKeyCacheSeconds)
+	}
+	if c.KeyRotationGraceSeconds < 0 {
+		return fmt.Errorf("ap_key_rotation_grace_seconds is negative, which is forbidden: %d", c.KeyRotationGraceSeconds)
+	}
 	if err := c.HttpSignaturesConfig.Verify(); err != nil {
 		return err
 	}
@@ -126,6 +178,11 @@ func (c *ActivityPubConfig) Verify() error {
 }
 
 func (c *HttpSignaturesConfig) Verify() error {
+	switch c.Scheme {
+	case "", "cavage", "rfc9421", "double_knock":
+	default:
+		return fmt.Errorf("http_sig_scheme is not one of \"cavage\", \"rfc9421\", or \"double_knock\": %q", c.Scheme)
+	}
 	return nil
 }
 
@@ -136,3 +193,13 @@ func (c *PostgresConfig) Verify() error {
 func (c *NodeInfoConfig) Verify() error {
 	return nil
 }
+
+func (c *SqliteConfig) Verify() error {
+	if len(c.File) == 0 {
+		return errors.New("sqlite_file is empty, but it is required")
+	}
+	if c.BusyTimeoutMs < 0 {
+		return fmt.Errorf("sqlite_busy_timeout_ms is negative, which is forbidden: %d", c.BusyTimeoutMs)
+	}
+	return nil
+}
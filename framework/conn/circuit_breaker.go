@@ -0,0 +1,143 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+)
+
+type breakerState int
+
+const (
+	// Deliveries are paused.
+	breakerOpen breakerState = iota
+	// A single delivery is probing whether deliveries can resume.
+	breakerProbing
+)
+
+type breakerEntry struct {
+	Failures int
+	Tripped  bool
+	State    breakerState
+	Until    time.Time
+}
+
+// circuitBreaker pauses deliveries to hosts that have failed too many of them
+// in a row, and then lets a single delivery through to probe whether the host
+// has recovered before resuming the rest.
+//
+// Only hosts with failures are tracked, as a successful delivery resets the
+// host.
+type circuitBreaker struct {
+	// Immutable
+	failures int
+	pause    time.Duration
+	// Mutable
+	m  map[string]*breakerEntry
+	mu sync.Mutex
+}
+
+func newCircuitBreaker(c *config.Config) *circuitBreaker {
+	return &circuitBreaker{
+		failures: c.ActivityPubConfig.CircuitBreakerFailures,
+		pause:    time.Duration(c.ActivityPubConfig.CircuitBreakerPauseSeconds) * time.Second,
+		m:        make(map[string]*breakerEntry),
+	}
+}
+
+// Allow determines whether a delivery to the host may be made now. Once a
+// pause is over, only the first caller is allowed as the probe.
+func (b *circuitBreaker) Allow(host string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.m[host]
+	if !ok || !e.Tripped {
+		return true
+	} else if e.State == breakerOpen && !now.Before(e.Until) {
+		e.State = breakerProbing
+		return true
+	}
+	return false
+}
+
+// PausedUntil returns the time that deliveries to the host are paused until,
+// if they are paused and not being probed.
+func (b *circuitBreaker) PausedUntil(host string, now time.Time) (until time.Time, paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.m[host]
+	if !ok || !e.Tripped || e.State != breakerOpen || !now.Before(e.Until) {
+		return
+	}
+	return e.Until, true
+}
+
+// Success records a successful delivery to the host, resuming deliveries to
+// it if they were paused.
+func (b *circuitBreaker) Success(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.m, host)
+}
+
+// Throttled records that the host asked for deliveries to be slowed down. If
+// the delivery was probing the host, deliveries to it are paused again until
+// the host asked to be retried, or for another pause if it did not say, and
+// that time is returned.
+func (b *circuitBreaker) Throttled(host string, now, retryAfter time.Time) (until time.Time, paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.m[host]
+	if !ok || !e.Tripped || e.State != breakerProbing {
+		return
+	}
+	e.State = breakerOpen
+	e.Until = retryAfter
+	if e.Until.IsZero() {
+		e.Until = now.Add(b.pause)
+	}
+	return e.Until, true
+}
+
+// Failure records a failed delivery to the host. If deliveries to the host
+// are now paused, the time they are paused until is returned.
+func (b *circuitBreaker) Failure(host string, now time.Time) (until time.Time, paused bool) {
+	if b.failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.m[host]
+	if !ok {
+		e = &breakerEntry{}
+		b.m[host] = e
+	}
+	e.Failures++
+	if e.Tripped && e.State == breakerOpen {
+		// Deliveries already in progress when pausing.
+		return e.Until, true
+	} else if e.Tripped || e.Failures >= b.failures {
+		e.Tripped = true
+		e.State = breakerOpen
+		e.Until = now.Add(b.pause)
+		return e.Until, true
+	}
+	return
+}
@@ -0,0 +1,224 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+const (
+	dataIntegrityProofType  = "DataIntegrityProof"
+	eddsaJCS2022Cryptosuite = "eddsa-jcs-2022"
+	assertionMethodPurpose  = "assertionMethod"
+	// dataIntegrityContext is the JSON-LD context defining the proof
+	// property and its terms.
+	dataIntegrityContext = "https://w3id.org/security/data-integrity/v1"
+)
+
+// ErrNoProof is returned when verifying an object without any proof.
+var ErrNoProof = errors.New("no proof")
+
+// AddProof secures a JSON serialized ActivityStreams object with an
+// eddsa-jcs-2022 Data Integrity proof created by the Ed25519 key, as described
+// in FEP-8b32. The Data Integrity JSON-LD context is added to the object if it
+// is missing.
+func AddProof(b []byte, key ed25519.PrivateKey, keyID *url.URL, created time.Time) ([]byte, error) {
+	m, err := decodeJSONObject(b)
+	if err != nil {
+		return nil, err
+	}
+	m["@context"] = withContext(m["@context"], dataIntegrityContext)
+	proof := map[string]interface{}{
+		"type":               dataIntegrityProofType,
+		"cryptosuite":        eddsaJCS2022Cryptosuite,
+		"verificationMethod": keyID.String(),
+		"proofPurpose":       assertionMethodPurpose,
+		"created":            created.UTC().Format(time.RFC3339),
+	}
+	hash, err := proofHash(m, proof)
+	if err != nil {
+		return nil, err
+	}
+	proof["proofValue"] = util.EncodeMultibase(ed25519.Sign(key, hash))
+	m["proof"] = proof
+	return json.Marshal(m)
+}
+
+// VerifyProof verifies the eddsa-jcs-2022 Data Integrity proof of a JSON
+// serialized ActivityStreams object. If the object has several proofs, only
+// one of them needs to verify.
+//
+// The keyFn obtains the public key of a proof's verification method, and is
+// where it must be determined whether the key may secure the object.
+func VerifyProof(b []byte, keyFn func(keyID *url.URL) (crypto.PublicKey, error)) error {
+	m, err := decodeJSONObject(b)
+	if err != nil {
+		return err
+	}
+	var proofs []interface{}
+	switch p := m["proof"].(type) {
+	case nil:
+		return ErrNoProof
+	case []interface{}:
+		proofs = p
+	default:
+		proofs = []interface{}{p}
+	}
+	delete(m, "proof")
+	err = ErrNoProof
+	for _, p := range proofs {
+		proof, ok := p.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("proof is not an object: %T", p)
+			continue
+		}
+		if err = verifyProof(m, proof, keyFn); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func verifyProof(m, proof map[string]interface{}, keyFn func(keyID *url.URL) (crypto.PublicKey, error)) error {
+	if proof["type"] != dataIntegrityProofType || proof["cryptosuite"] != eddsaJCS2022Cryptosuite {
+		return fmt.Errorf("unsupported proof type %v with cryptosuite %v", proof["type"], proof["cryptosuite"])
+	} else if proof["proofPurpose"] != assertionMethodPurpose {
+		return fmt.Errorf("unsupported proof purpose: %v", proof["proofPurpose"])
+	}
+	pv, ok := proof["proofValue"].(string)
+	if !ok {
+		return errors.New("proofValue is not a string")
+	}
+	sig, err := util.DecodeMultibase(pv)
+	if err != nil {
+		return fmt.Errorf("proofValue: %w", err)
+	}
+	vm, ok := proof["verificationMethod"].(string)
+	if !ok {
+		return errors.New("verificationMethod is not a string")
+	}
+	keyID, err := url.Parse(vm)
+	if err != nil {
+		return err
+	}
+	config := make(map[string]interface{}, len(proof))
+	for k, v := range proof {
+		if k != "proofValue" {
+			config[k] = v
+		}
+	}
+	if pc, ok := config["@context"]; ok && !startsWithContext(m["@context"], pc) {
+		return errors.New("proof @context is not at the start of the object's @context")
+	}
+	hash, err := proofHash(m, config)
+	if err != nil {
+		return err
+	}
+	pKey, err := keyFn(keyID)
+	if err != nil {
+		return err
+	}
+	edKey, ok := pKey.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("verificationMethod is not an Ed25519 key: %T", pKey)
+	} else if !ed25519.Verify(edKey, hash, sig) {
+		return errors.New("proof does not verify")
+	}
+	return nil
+}
+
+// proofHash is the data signed by an eddsa-jcs-2022 proof: the hashes of the
+// canonical proof configuration and of the canonical object.
+func proofHash(m, config map[string]interface{}) ([]byte, error) {
+	cc, err := canonicalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := canonicalJSON(m)
+	if err != nil {
+		return nil, err
+	}
+	hc := sha256.Sum256(cc)
+	hm := sha256.Sum256(cm)
+	return append(hc[:], hm[:]...), nil
+}
+
+// decodeJSONObject decodes a JSON object, keeping the literal form of its
+// numbers so that they are not changed if it is encoded again.
+func decodeJSONObject(b []byte) (m map[string]interface{}, err error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err = d.Decode(&m); err == nil && m == nil {
+		err = errors.New("JSON is not an object")
+	}
+	return
+}
+
+// withContext adds a JSON-LD context to the @context, if it is missing.
+func withContext(c interface{}, add string) interface{} {
+	switch t := c.(type) {
+	case nil:
+		return add
+	case string:
+		if t == add {
+			return t
+		}
+		return []interface{}{t, add}
+	case []interface{}:
+		for _, e := range t {
+			if e == add {
+				return t
+			}
+		}
+		return append(t, add)
+	default:
+		return []interface{}{t, add}
+	}
+}
+
+// startsWithContext determines whether the @context starts with all of the
+// contexts in prefix, in the same order.
+func startsWithContext(c, prefix interface{}) bool {
+	cs, ok := c.([]interface{})
+	if !ok {
+		cs = []interface{}{c}
+	}
+	ps, ok := prefix.([]interface{})
+	if !ok {
+		ps = []interface{}{prefix}
+	}
+	if len(ps) > len(cs) {
+		return false
+	}
+	for i := range ps {
+		if !reflect.DeepEqual(cs[i], ps[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,420 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/paths"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+// deliveryQueue delivers the attempts queued in the database with a pool of
+// workers.
+//
+// Due deliveries are grouped by host and handed out to the workers in turn,
+// with a limit on how many deliveries a single host may have in progress, so
+// that a slow peer cannot hold up the deliveries to every other peer. Hosts
+// paused by the circuit breaker have their deliveries postponed instead.
+//
+// Due deliveries are claimed in the database when fetched, so that several
+// processes sharing the database each make different deliveries. A delivery
+// remains queued in the database until it succeeds or is abandoned. When
+// stopping, the claims on deliveries not yet started are released, so they
+// are picked up again when next started. Should the process exit without
+// stopping, they are picked up once their claim expires.
+type deliveryQueue struct {
+	// Immutable
+	da              *services.DeliveryAttempts
+	pk              *services.PrivateKeys
+	tc              *Controller
+	rt              *retrier
+	cb              *circuitBreaker
+	workers         int
+	hostConcurrency int
+	pageSize        int
+	pollPeriod      time.Duration
+	goneFor         time.Duration
+	claimFor        time.Duration
+	wake            chan struct{}
+	wg              sync.WaitGroup
+	// Mutable
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[string][]services.DueDelivery
+	npending int
+	hosts    []string
+	active   map[string]int
+	queued   map[string]bool
+	more     bool
+	stopped  bool
+}
+
+// defaultDeliveryClaim is how long fetched deliveries are claimed for when
+// not configured.
+const defaultDeliveryClaim = 10 * time.Minute
+
+func newDeliveryQueue(da *services.DeliveryAttempts, pk *services.PrivateKeys, tc *Controller, rt *retrier, cb *circuitBreaker, c *config.Config) *deliveryQueue {
+	workers := c.ActivityPubConfig.DeliveryWorkers
+	if workers == 0 {
+		workers = 1
+	}
+	claimFor := time.Duration(c.ActivityPubConfig.DeliveryClaimSeconds) * time.Second
+	if claimFor == 0 {
+		claimFor = defaultDeliveryClaim
+	}
+	q := &deliveryQueue{
+		da:              da,
+		pk:              pk,
+		tc:              tc,
+		rt:              rt,
+		cb:              cb,
+		workers:         workers,
+		hostConcurrency: c.ActivityPubConfig.DeliveryHostConcurrency,
+		pageSize:        c.ActivityPubConfig.RetryPageSize,
+		pollPeriod:      time.Duration(c.ActivityPubConfig.RetrySleepPeriod) * time.Second,
+		goneFor:         time.Duration(c.ActivityPubConfig.GoneActorSeconds) * time.Second,
+		claimFor:        claimFor,
+		wake:            make(chan struct{}, 1),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *deliveryQueue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancel != nil {
+		return
+	}
+	q.pending = make(map[string][]services.DueDelivery)
+	q.npending = 0
+	q.hosts = nil
+	q.active = make(map[string]int)
+	q.queued = make(map[string]bool)
+	q.more = false
+	q.stopped = false
+	var ctx context.Context
+	ctx, q.cancel = context.WithCancel(context.Background())
+	q.wg.Add(1 + q.workers)
+	go q.dispatch(ctx)
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	q.Wake()
+}
+
+// Stop waits for the deliveries in progress to finish, leaving all others
+// queued in the database. The claims on pending deliveries are released, so
+// that they are due again right away when next started.
+func (q *deliveryQueue) Stop() {
+	q.mu.Lock()
+	if q.cancel == nil {
+		q.mu.Unlock()
+		return
+	}
+	q.cancel()
+	q.cancel = nil
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	util.InfoLogger.Info("Waiting for deliveries in progress to finish")
+	q.wg.Wait()
+	q.mu.Lock()
+	var dd []services.DueDelivery
+	for _, pending := range q.pending {
+		dd = append(dd, pending...)
+	}
+	q.pending = nil
+	q.npending = 0
+	q.hosts = nil
+	q.queued = nil
+	q.mu.Unlock()
+	if len(dd) == 0 {
+		return
+	}
+	if err := q.da.ReleaseDeliveries(util.Context{context.Background()}, dd); err != nil {
+		util.ErrorLogger.Errorf("delivery queue failed to release %d pending deliveries: %s", len(dd), err)
+	}
+}
+
+// Wake prompts the queue to look for due deliveries, such as when a new one
+// has been queued.
+func (q *deliveryQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *deliveryQueue) dispatch(ctx context.Context) {
+	defer q.wg.Done()
+	t := time.NewTicker(q.pollPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-t.C:
+		}
+		q.fill(ctx)
+	}
+}
+
+// fill fetches and claims due deliveries from the database that are not
+// already pending or in progress.
+func (q *deliveryQueue) fill(ctx context.Context) {
+	q.mu.Lock()
+	if q.npending >= q.pageSize {
+		q.mu.Unlock()
+		return
+	}
+	// Deliveries pending or in progress are claimed, so are not due again
+	// unless their claim expired.
+	limit := q.pageSize - q.npending
+	q.mu.Unlock()
+	now := time.Now()
+	dd, err := q.da.DueDeliveries(util.Context{ctx}, now, now.Add(q.claimFor), limit)
+	if err != nil {
+		util.ErrorLogger.Errorf("delivery queue failed to obtain due deliveries: %s", err)
+		return
+	}
+	paused := make(map[string]*url.URL)
+	defer func() {
+		for _, to := range paused {
+			if until, ok := q.cb.PausedUntil(to.Host, now); ok {
+				q.postpone(util.Context{ctx}, to, until)
+			}
+		}
+	}()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.more = len(dd) == limit
+	for _, d := range dd {
+		if q.queued[d.ID] {
+			continue
+		}
+		host := d.DeliverTo.Host
+		if _, ok := q.cb.PausedUntil(host, now); ok {
+			paused[host] = d.DeliverTo
+			continue
+		}
+		if len(q.pending[host]) == 0 {
+			q.hosts = append(q.hosts, host)
+		}
+		q.pending[host] = append(q.pending[host], d)
+		q.npending++
+		q.queued[d.ID] = true
+	}
+	q.cond.Broadcast()
+}
+
+// next blocks until there is a delivery for a host that is below its limit of
+// deliveries in progress, or until stopped.
+func (q *deliveryQueue) next() (d services.DueDelivery, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.stopped {
+		now := time.Now()
+		for i, host := range q.hosts {
+			if q.hostConcurrency > 0 && q.active[host] >= q.hostConcurrency {
+				continue
+			} else if !q.cb.Allow(host, now) {
+				continue
+			}
+			d = q.pending[host][0]
+			q.pending[host] = q.pending[host][1:]
+			q.npending--
+			q.active[host]++
+			// Move the host to the back so the other hosts take their
+			// turn first.
+			q.hosts = append(q.hosts[:i:i], q.hosts[i+1:]...)
+			if len(q.pending[host]) > 0 {
+				q.hosts = append(q.hosts, host)
+			} else {
+				delete(q.pending, host)
+			}
+			return d, true
+		}
+		q.cond.Wait()
+	}
+	return
+}
+
+// done records that a delivery is no longer in progress.
+func (q *deliveryQueue) done(d services.DueDelivery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	host := d.DeliverTo.Host
+	if q.active[host]--; q.active[host] <= 0 {
+		delete(q.active, host)
+	}
+	delete(q.queued, d.ID)
+	q.cond.Broadcast()
+	if q.more && q.npending < q.pageSize {
+		q.Wake()
+	}
+}
+
+func (q *deliveryQueue) work() {
+	defer q.wg.Done()
+	for {
+		d, ok := q.next()
+		if !ok {
+			return
+		}
+		q.deliver(d)
+		q.done(d)
+	}
+}
+
+// deliver attempts a single delivery and updates its record.
+func (q *deliveryQueue) deliver(d services.DueDelivery) {
+	c := util.Context{context.Background()}
+	err := q.attempt(c, d)
+	if err == nil {
+		q.cb.Success(d.DeliverTo.Host)
+		if err = q.da.MarkSuccessfulAttempt(c, d.ID); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as successful: %s", err)
+		}
+		return
+	}
+	util.ErrorLogger.Errorf("delivery queue failed in an attempt to deliver: %s", err)
+	now := time.Now()
+	if de, ok := err.(*deliveryError); ok && de.Gone() {
+		// The host is responding, even though the inbox is gone.
+		q.cb.Success(d.DeliverTo.Host)
+		// A shared inbox, or one whose owner is not known, may still
+		// receive deliveries for other actors.
+		if owner := q.tc.si.Owner(d.DeliverTo); owner != nil {
+			util.InfoLogger.Infof("Actor %s is gone, abandoning all deliveries to its inbox %s", owner, d.DeliverTo)
+			if err = q.da.MarkGoneAttempt(c, d.ID, owner, d.DeliverTo, now.Add(q.goneFor)); err != nil {
+				util.ErrorLogger.Errorf("delivery queue failed to mark attempt as gone: %s", err)
+			}
+			return
+		}
+		if err = q.da.MarkAbandonedAttempt(c, d.ID); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as abandoned: %s", err)
+		}
+		return
+	} else if ok && de.Permanent() {
+		q.cb.Success(d.DeliverTo.Host)
+		if err = q.da.MarkAbandonedAttempt(c, d.ID); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as abandoned: %s", err)
+		}
+		return
+	} else if ok && de.Throttled() {
+		q.throttle(c, d, de.RetryAfter, now)
+		return
+	}
+	until, paused := q.cb.Failure(d.DeliverTo.Host, now)
+	if abandon, at := q.rt.next(d.DeliverTo, d.NAttempts, now); abandon {
+		if err = q.da.MarkAbandonedAttempt(c, d.ID); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as abandoned: %s", err)
+		}
+	} else {
+		if paused && at.Before(until) {
+			at = until
+		}
+		if err = q.da.MarkRetryFailureAttempt(c, d.ID, at); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as failed: %s", err)
+		}
+	}
+	if paused {
+		q.pause(c, d.DeliverTo, until)
+	}
+}
+
+// throttle reschedules a delivery to a host that is limiting the deliveries
+// it accepts, without counting against the host in the circuit breaker. If
+// the host asked for deliveries to be attempted again at a later time, all of
+// its deliveries are paused until then. A throttled probe of a paused host
+// pauses its deliveries again, so that the probe is not left unresolved.
+func (q *deliveryQueue) throttle(c util.Context, d services.DueDelivery, retryAfter, now time.Time) {
+	until, paused := q.cb.Throttled(d.DeliverTo.Host, now, retryAfter)
+	if !paused && !retryAfter.IsZero() {
+		until, paused = retryAfter, true
+	}
+	if abandon, at := q.rt.next(d.DeliverTo, d.NAttempts, now); abandon {
+		if err := q.da.MarkAbandonedAttempt(c, d.ID); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as abandoned: %s", err)
+		}
+	} else {
+		if paused {
+			at = until
+		}
+		if err := q.da.MarkRetryFailureAttempt(c, d.ID, at); err != nil {
+			util.ErrorLogger.Errorf("delivery queue failed to mark attempt as failed: %s", err)
+		}
+	}
+	if paused {
+		q.pause(c, d.DeliverTo, until)
+	}
+}
+
+// pause drops the pending deliveries to a host whose deliveries are paused,
+// and postpones them in the database.
+func (q *deliveryQueue) pause(c util.Context, to *url.URL, until time.Time) {
+	q.mu.Lock()
+	host := to.Host
+	for _, d := range q.pending[host] {
+		delete(q.queued, d.ID)
+		q.npending--
+	}
+	delete(q.pending, host)
+	for i, h := range q.hosts {
+		if h == host {
+			q.hosts = append(q.hosts[:i:i], q.hosts[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	q.postpone(c, to, until)
+}
+
+// postpone delays the queued deliveries to the host of an IRI.
+func (q *deliveryQueue) postpone(c util.Context, to *url.URL, until time.Time) {
+	prefix := (&url.URL{Scheme: to.Scheme, Host: to.Host, Path: "/"}).String()
+	n, err := q.da.PostponeDeliveriesTo(c, prefix, until)
+	if err != nil {
+		util.ErrorLogger.Errorf("delivery queue failed to postpone deliveries to %s: %s", to.Host, err)
+		return
+	}
+	if n > 0 {
+		util.InfoLogger.Infof("Paused %d deliveries to %s until %s", n, to.Host, until)
+	}
+}
+
+func (q *deliveryQueue) attempt(c util.Context, d services.DueDelivery) error {
+	privKey, pubKeyID, err := q.pk.GetUserHTTPSignatureKey(c, paths.UUID(d.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to obtain user's HTTP Signature key: %s", err)
+	}
+	t, err := q.tc.get(privKey, pubKeyID.String())
+	if err != nil {
+		return fmt.Errorf("failed to obtain a transport for delivery: %s", err)
+	}
+	return t.post(c, d.Payload, d.DeliverTo)
+}
@@ -0,0 +1,264 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+// dereferenceCache keeps the federated objects and actors fetched from peers,
+// so that they are only fetched again once their freshness expires, and are
+// then revalidated with conditional requests where the peer supports them.
+//
+// The cache is shared by every local user, so responses a peer marks as
+// private, or that vary on who signed the request, are never stored.
+type dereferenceCache struct {
+	// Immutable
+	maxAge    time.Duration
+	retention time.Duration
+	dc        *services.DereferenceCache
+	pruneFn   *util.SafeStartStop
+}
+
+func newDereferenceCache(c *config.Config, dc *services.DereferenceCache) *dereferenceCache {
+	d := &dereferenceCache{
+		maxAge:    time.Duration(c.ActivityPubConfig.DereferenceCacheMaxAgeSeconds) * time.Second,
+		retention: time.Duration(c.ActivityPubConfig.DereferenceCacheRetentionSeconds) * time.Second,
+		dc:        dc,
+	}
+	if d.maxAge > 0 {
+		d.pruneFn = util.NewSafeStartStop(d.prune, d.maxAge)
+	}
+	return d
+}
+
+func (d *dereferenceCache) Start() {
+	if d.pruneFn == nil {
+		util.InfoLogger.Info("Caching of dereferenced federated data is disabled")
+		return
+	}
+	d.pruneFn.Start()
+}
+
+func (d *dereferenceCache) Stop() {
+	if d.pruneFn == nil {
+		return
+	}
+	d.pruneFn.Stop()
+}
+
+// Enabled determines whether fetched federated data is cached.
+func (d *dereferenceCache) Enabled() bool {
+	return d.maxAge > 0
+}
+
+// Get returns the cached object for the IRI, whether or not it is fresh.
+//
+// Failing to read the cache is not fatal to dereferencing, so it is logged
+// and treated as a miss.
+func (d *dereferenceCache) Get(c util.Context, iri *url.URL) (o models.CachedObject, found bool) {
+	if !d.Enabled() {
+		return
+	}
+	var err error
+	o, found, err = d.dc.Get(c, cacheKey(iri))
+	if err != nil {
+		util.ErrorLogger.Errorf("Failed to read dereference cache for %s: %s", iri, err)
+		return models.CachedObject{}, false
+	}
+	return
+}
+
+// Store caches a successfully fetched response, if the peer allows it.
+func (d *dereferenceCache) Store(c util.Context, iri *url.URL, h http.Header, b []byte, now time.Time) {
+	if !d.Enabled() {
+		return
+	}
+	o := models.CachedObject{
+		Body:         b,
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+	}
+	var ok bool
+	o.Expires, ok = d.expiry(h, now)
+	if !ok {
+		// A response that may not be kept must also not leave an older
+		// version of it behind.
+		d.Forget(c, iri)
+		return
+	} else if !o.Expires.After(now) && o.ETag == "" && o.LastModified == "" {
+		// Neither fresh nor revalidatable, so it would never be used.
+		return
+	}
+	if err := d.dc.Put(c, cacheKey(iri), o); err != nil {
+		util.ErrorLogger.Errorf("Failed to write dereference cache for %s: %s", iri, err)
+	}
+}
+
+// Revalidated extends the freshness of a cached object that the peer has
+// reported as not modified.
+func (d *dereferenceCache) Revalidated(c util.Context, iri *url.URL, h http.Header, now time.Time) {
+	expires, ok := d.expiry(h, now)
+	if !ok {
+		d.Forget(c, iri)
+		return
+	}
+	if err := d.dc.Revalidate(c, cacheKey(iri), expires); err != nil {
+		util.ErrorLogger.Errorf("Failed to revalidate dereference cache for %s: %s", iri, err)
+	}
+}
+
+// Forget removes any cached object for the IRI.
+func (d *dereferenceCache) Forget(c util.Context, iri *url.URL) {
+	if !d.Enabled() {
+		return
+	}
+	if err := d.dc.Delete(c, cacheKey(iri)); err != nil {
+		util.ErrorLogger.Errorf("Failed to delete from dereference cache for %s: %s", iri, err)
+	}
+}
+
+// expiry determines until when a response may be used without revalidating
+// it, from its Cache-Control and Expires headers, and never later than the
+// configured maximum age. Responses without any freshness information are
+// kept for the maximum age. It returns false if the response may not be
+// stored at all.
+func (d *dereferenceCache) expiry(h http.Header, now time.Time) (expires time.Time, ok bool) {
+	if !sharedVary(h) {
+		return
+	}
+	var maxAge, sMaxAge time.Duration = -1, -1
+	var noCache bool
+	for _, v := range h.Values("Cache-Control") {
+		for _, dir := range strings.Split(v, ",") {
+			name, value := splitDirective(dir)
+			switch name {
+			case "no-store", "private":
+				return
+			case "no-cache":
+				noCache = true
+			case "max-age":
+				maxAge = parseDeltaSeconds(value)
+			case "s-maxage":
+				sMaxAge = parseDeltaSeconds(value)
+			}
+		}
+	}
+	age := d.maxAge
+	if noCache {
+		age = 0
+	} else if sMaxAge >= 0 {
+		age = sMaxAge
+	} else if maxAge >= 0 {
+		age = maxAge
+	} else if v := h.Get("Expires"); v != "" {
+		// An invalid Expires means the response is already expired.
+		age = 0
+		if at, err := http.ParseTime(v); err == nil && at.After(now) {
+			age = at.Sub(now)
+		}
+	}
+	if age > d.maxAge {
+		age = d.maxAge
+	}
+	// Databases store timestamps with differing precision, which must not
+	// round the expiry up past the time it is due.
+	return now.Add(age).Truncate(time.Second), true
+}
+
+func (d *dereferenceCache) prune(ctx context.Context) {
+	n, err := d.dc.Prune(util.Context{ctx}, time.Now().Add(-d.retention))
+	if err != nil {
+		util.ErrorLogger.Errorf("Error pruning dereference cache: %s", err)
+		return
+	}
+	util.InfoLogger.Infof("Pruned %d stale entries from the dereference cache", n)
+}
+
+// cacheKey is the IRI that an object is cached under, which excludes any
+// fragment since it is never sent to the peer.
+func cacheKey(iri *url.URL) *url.URL {
+	if iri.Fragment == "" && iri.RawFragment == "" {
+		return iri
+	}
+	k := *iri
+	k.Fragment = ""
+	k.RawFragment = ""
+	return &k
+}
+
+// sharedVaryHeaders are the request headers sent alike in every fetch, no
+// matter which local actor signs it. Origin is never sent, which is just as
+// alike, and peers such as Mastodon vary on it.
+var sharedVaryHeaders = map[string]bool{
+	"accept":          true,
+	"accept-charset":  true,
+	"accept-encoding": true,
+	"origin":          true,
+	"user-agent":      true,
+}
+
+// sharedVary determines whether a response only varies on request headers
+// that are the same for every local actor. Responses varying on the
+// Signature or Authorization of the request may have been tailored to the
+// actor that signed it.
+func sharedVary(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !sharedVaryHeaders[name] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitDirective splits a Cache-Control directive into its lowercased name
+// and its unquoted value, if any.
+func splitDirective(dir string) (name, value string) {
+	dir = strings.TrimSpace(dir)
+	if i := strings.IndexByte(dir, '='); i >= 0 {
+		name, value = dir[:i], strings.Trim(strings.TrimSpace(dir[i+1:]), `"`)
+	} else {
+		name = dir
+	}
+	return strings.ToLower(strings.TrimSpace(name)), value
+}
+
+// parseDeltaSeconds parses a Cache-Control delta-seconds value, returning a
+// negative duration if it is invalid.
+func parseDeltaSeconds(v string) time.Duration {
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || s < 0 {
+		return -1
+	} else if s > int64(math.MaxInt64/time.Second) {
+		s = int64(math.MaxInt64 / time.Second)
+	}
+	return time.Duration(s) * time.Second
+}
@@ -18,22 +18,61 @@ package conn
 
 import (
 	"context"
+	"net"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/util"
 	"golang.org/x/time/rate"
 )
 
+const (
+	// maxSlowdown is how many times slower than the configured rate a host
+	// may be limited to.
+	maxSlowdown = 16
+	// raiseSteps is how many successful requests it takes to raise a host
+	// back from its slowest rate to the configured rate.
+	raiseSteps = 30
+	// adaptedPruneAge is how long the limiter of a host that has yet to be
+	// raised back to the configured rate is kept when unused.
+	adaptedPruneAge = time.Hour * 24
+)
+
 type entry struct {
 	L        *rate.Limiter
 	LastUsed time.Time
+	// Static is true for hosts whose limits are set by the administrator,
+	// which are never adapted.
+	Static bool
+}
+
+// HostLimit is the current outbound rate limit of a single host.
+type HostLimit struct {
+	Host     string    `json:"host"`
+	QPS      float64   `json:"qps"`
+	Burst    int       `json:"burst"`
+	Static   bool      `json:"static"`
+	LastUsed time.Time `json:"lastUsed"`
 }
 
+// hostLimiter rate limits outbound requests to each host.
+//
+// The limit of a host is adapted to how it responds: it is halved whenever the
+// host times out, responds with a server error, or asks to slow down, and is
+// raised back towards the configured limit in small steps as requests to it
+// succeed. Hosts with static limits in the configuration are not adapted.
+//
+// Unused limiters are pruned, though those of hosts that have yet to be raised
+// back to the configured limit are kept for longer, so that what was learned
+// about them is not forgotten as soon as they go quiet.
 type hostLimiter struct {
 	// Immutable
 	limit       rate.Limit
 	burst       int
+	static      map[string]config.HostRateLimit
 	prunePeriod time.Duration
 	pruneAge    time.Duration
 	wg          sync.WaitGroup
@@ -46,14 +85,19 @@ type hostLimiter struct {
 	mu          sync.Mutex
 }
 
-func newHostLimiter(c *config.Config) *hostLimiter {
+func newHostLimiter(c *config.Config) (*hostLimiter, error) {
+	static, err := c.ActivityPubConfig.HostRateLimits()
+	if err != nil {
+		return nil, err
+	}
 	return &hostLimiter{
 		limit:       rate.Limit(c.ActivityPubConfig.OutboundRateLimitQPS),
 		burst:       c.ActivityPubConfig.OutboundRateLimitBurst,
+		static:      static,
 		prunePeriod: time.Duration(c.ActivityPubConfig.OutboundRateLimitPrunePeriodSeconds) * time.Second,
 		pruneAge:    time.Duration(c.ActivityPubConfig.OutboundRateLimitPruneAgeSeconds) * time.Second,
 		m:           make(map[string]entry),
-	}
+	}, nil
 }
 
 func (h *hostLimiter) Start() {
@@ -68,19 +112,110 @@ func (h *hostLimiter) Stop() {
 func (h *hostLimiter) Get(host string) *rate.Limiter {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	return h.get(host).L
+}
+
+// get obtains the entry of a host, creating it if needed. It must be called
+// with the lock held.
+func (h *hostLimiter) get(host string) entry {
 	e, ok := h.m[host]
 	if ok {
 		e.LastUsed = time.Now()
-		h.m[host] = e
-		return e.L
+	} else if l, ok := h.static[host]; ok {
+		e = entry{
+			L:        rate.NewLimiter(rate.Limit(l.QPS), l.Burst),
+			LastUsed: time.Now(),
+			Static:   true,
+		}
 	} else {
 		e = entry{
 			L:        rate.NewLimiter(h.limit, h.burst),
 			LastUsed: time.Now(),
 		}
-		h.m[host] = e
-		return e.L
 	}
+	h.m[host] = e
+	return e
+}
+
+// Adapt adjusts the limit of a host to the outcome of a request made to it,
+// which is either a response or an error.
+func (h *hostLimiter) Adapt(host string, r *http.Response, err error) {
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			h.Lower(host)
+		}
+	} else if r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500 {
+		h.Lower(host)
+	} else if r.StatusCode >= 200 && r.StatusCode < 300 {
+		h.Raise(host)
+	}
+}
+
+// Lower halves the rate of requests to the host, down to maxSlowdown times
+// slower than the configured rate.
+func (h *hostLimiter) Lower(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.get(host)
+	if e.Static {
+		return
+	}
+	l := e.L.Limit() / 2
+	if l < h.limit/maxSlowdown {
+		l = h.limit / maxSlowdown
+	}
+	if l != e.L.Limit() {
+		util.InfoLogger.Infof("Lowering the outbound rate limit of %s to %g per second", host, l)
+		h.set(e, l)
+	}
+}
+
+// Raise raises the rate of requests to the host by a step, up to the
+// configured rate.
+func (h *hostLimiter) Raise(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.get(host)
+	if e.Static || e.L.Limit() >= h.limit {
+		return
+	}
+	l := e.L.Limit() + h.limit/raiseSteps
+	if l > h.limit {
+		l = h.limit
+	}
+	h.set(e, l)
+}
+
+// set changes the rate of an entry, and its burst in proportion. It must be
+// called with the lock held.
+func (h *hostLimiter) set(e entry, l rate.Limit) {
+	b := int(float64(h.burst) * float64(l/h.limit))
+	if b < 1 {
+		b = 1
+	}
+	e.L.SetLimit(l)
+	e.L.SetBurst(b)
+}
+
+// Limits obtains the current limits of all hosts that have been requested
+// recently or whose limits are adapted, ordered by host.
+func (h *hostLimiter) Limits() (hl []HostLimit) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hl = make([]HostLimit, 0, len(h.m))
+	for k, v := range h.m {
+		hl = append(hl, HostLimit{
+			Host:     k,
+			QPS:      float64(v.L.Limit()),
+			Burst:    v.L.Burst(),
+			Static:   v.Static,
+			LastUsed: v.LastUsed,
+		})
+	}
+	sort.Slice(hl, func(i, j int) bool {
+		return hl[i].Host < hl[j].Host
+	})
+	return
 }
 
 func (h *hostLimiter) resetMap() {
@@ -135,7 +270,11 @@ func (h *hostLimiter) prune() {
 	defer h.mu.Unlock()
 	now := time.Now()
 	for k, v := range h.m {
-		if v.LastUsed.Sub(now) > h.pruneAge {
+		age := h.pruneAge
+		if !v.Static && v.L.Limit() < h.limit {
+			age = adaptedPruneAge
+		}
+		if now.Sub(v.LastUsed) > age {
 			delete(h.m, k)
 		}
 	}
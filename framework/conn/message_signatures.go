@@ -0,0 +1,350 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-fed/httpsig"
+)
+
+const (
+	// messageSignatureLabel is the label of the RFC 9421 HTTP Message
+	// Signatures added to outgoing requests.
+	messageSignatureLabel = "sig1"
+	// maxMessageSignatureAge is how long after its creation an incoming
+	// HTTP Message Signature is accepted.
+	maxMessageSignatureAge = time.Hour
+	// maxMessageSignatureSkew is how far in the future the creation of an
+	// incoming HTTP Message Signature may be, to allow for clock skew.
+	maxMessageSignatureSkew = time.Minute * 5
+)
+
+// RFC 9421 HTTP Message Signature algorithms.
+const (
+	rsaV15SHA256     = "rsa-v1_5-sha256"
+	rsaPSSSHA512     = "rsa-pss-sha512"
+	ecdsaP256SHA256  = "ecdsa-p256-sha256"
+	ed25519Algorithm = "ed25519"
+)
+
+// IsMessageSignature determines whether a request is signed with an RFC 9421
+// HTTP Message Signature rather than a draft-cavage HTTP Signature.
+func IsMessageSignature(r *http.Request) bool {
+	return r.Header.Get("Signature-Input") != ""
+}
+
+var _ httpsig.Verifier = &MessageVerifier{}
+
+// MessageVerifier verifies an RFC 9421 HTTP Message Signature of an incoming
+// request.
+//
+// It implements httpsig.Verifier so that it can be used in place of verifying
+// a draft-cavage HTTP Signature, except that the algorithm is determined by
+// the signature and the public key instead of the one passed to Verify.
+type MessageVerifier struct {
+	keyID string
+	alg   string
+	base  []byte
+	sig   []byte
+}
+
+// NewMessageVerifier prepares to verify the HTTP Message Signature of a
+// request, whose complete URL is target.
+//
+// Of the signatures on the request, the first one that covers the method and
+// target of the request, and the Content-Digest of its body if it has one, is
+// used. The Content-Digest is checked against the body, which is left
+// unconsumed.
+func NewMessageVerifier(r *http.Request, target *url.URL, now time.Time) (*MessageVerifier, error) {
+	inputs, err := parseDictionary(r.Header.Get("Signature-Input"))
+	if err != nil {
+		return nil, fmt.Errorf("malformed Signature-Input: %s", err)
+	}
+	sigs, err := parseDictionary(r.Header.Get("Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("malformed Signature: %s", err)
+	}
+	hasBody := r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+	for _, in := range inputs {
+		sig, ok := sigs.get(in.key)
+		if !ok || in.components == nil || sig.value == nil {
+			continue
+		}
+		b, ok := sig.value.([]byte)
+		if !ok {
+			continue
+		}
+		covered := make(map[string]bool, len(in.components))
+		for _, c := range in.components {
+			covered[c] = true
+		}
+		if !covered["@method"] ||
+			!(covered["@target-uri"] || (covered["@authority"] && (covered["@path"] || covered["@request-target"]))) ||
+			(hasBody && !covered["content-digest"]) {
+			continue
+		}
+		m := &MessageVerifier{sig: b}
+		if m.keyID, ok = in.params["keyid"].(string); !ok || m.keyID == "" {
+			return nil, errors.New("HTTP Message Signature has no keyid")
+		}
+		if v, ok := in.params["alg"]; ok {
+			if m.alg, ok = v.(string); !ok {
+				return nil, errors.New("HTTP Message Signature alg is not a string")
+			}
+		}
+		created, ok := in.params["created"].(int64)
+		if !ok {
+			return nil, errors.New("HTTP Message Signature has no created time")
+		} else if t := time.Unix(created, 0); t.After(now.Add(maxMessageSignatureSkew)) {
+			return nil, fmt.Errorf("HTTP Message Signature is created in the future: %s", t)
+		} else if t.Before(now.Add(-maxMessageSignatureAge)) {
+			return nil, fmt.Errorf("HTTP Message Signature is too old: %s", t)
+		}
+		if expires, ok := in.params["expires"].(int64); ok && !now.Before(time.Unix(expires, 0)) {
+			return nil, fmt.Errorf("HTTP Message Signature expired: %s", time.Unix(expires, 0))
+		}
+		if covered["content-digest"] {
+			if err = checkContentDigest(r); err != nil {
+				return nil, err
+			}
+		}
+		if m.base, err = signatureBase(r, target, in.components, in.raw); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, errors.New("no HTTP Message Signature covers the method, target, and content of the request")
+}
+
+// KeyId is the id of the public key the signature is made with.
+func (m *MessageVerifier) KeyId() string {
+	return m.keyID
+}
+
+// Verify verifies the signature with the public key. The algorithm is
+// ignored in favor of the one named by the signature, or the one implied by
+// the public key.
+func (m *MessageVerifier) Verify(pKey crypto.PublicKey, _ httpsig.Algorithm) error {
+	alg := m.alg
+	if alg == "" {
+		alg = messageSignatureAlgorithm(pKey)
+	}
+	switch k := pKey.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case rsaV15SHA256:
+			h := sha256.Sum256(m.base)
+			return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], m.sig)
+		case rsaPSSSHA512:
+			h := sha512.Sum512(m.base)
+			return rsa.VerifyPSS(k, crypto.SHA512, h[:], m.sig, &rsa.PSSOptions{SaltLength: 64})
+		}
+	case ed25519.PublicKey:
+		if alg == ed25519Algorithm {
+			if !ed25519.Verify(k, m.base, m.sig) {
+				return errors.New("ed25519 verification failed")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == ecdsaP256SHA256 && k.Curve == elliptic.P256() && len(m.sig) == 64 {
+			h := sha256.Sum256(m.base)
+			r := new(big.Int).SetBytes(m.sig[:32])
+			s := new(big.Int).SetBytes(m.sig[32:])
+			if !ecdsa.Verify(k, h[:], r, s) {
+				return errors.New("ecdsa verification failed")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported HTTP Message Signature algorithm %q for key of type %T", alg, pKey)
+}
+
+// messageSignatureAlgorithm is the algorithm used with a key when a signature
+// does not name one.
+func messageSignatureAlgorithm(k interface{}) string {
+	switch k.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return rsaV15SHA256
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return ed25519Algorithm
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return ecdsaP256SHA256
+	}
+	return ""
+}
+
+// signMessage adds an RFC 9421 HTTP Message Signature to an outgoing request,
+// covering its method and target, and its content if it has a body.
+func signMessage(r *http.Request, body []byte, k crypto.PrivateKey, keyID string, now time.Time) error {
+	components := []string{"@method", "@target-uri"}
+	if body != nil {
+		d := sha256.Sum256(body)
+		r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(d[:])+":")
+		components = append(components, "content-type", "content-digest")
+	}
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for i, c := range components {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(strconv.Quote(c))
+	}
+	sb.WriteString(");created=")
+	sb.WriteString(strconv.FormatInt(now.Unix(), 10))
+	sb.WriteString(";keyid=")
+	sb.WriteString(serializeString(keyID))
+	params := sb.String()
+	base, err := signatureBase(r, r.URL, components, params)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	switch pk := k.(type) {
+	case *rsa.PrivateKey:
+		h := sha256.Sum256(base)
+		sig, err = rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, h[:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(pk, base)
+	default:
+		err = fmt.Errorf("unsupported private key type for HTTP Message Signatures: %T", k)
+	}
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Signature-Input", messageSignatureLabel+"="+params)
+	r.Header.Set("Signature", messageSignatureLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+// signatureBase creates the signature base of a request for the covered
+// components and the serialized signature parameters.
+func signatureBase(r *http.Request, target *url.URL, components []string, params string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, c := range components {
+		v, err := componentValue(r, target, c)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(strconv.Quote(c))
+		b.WriteString(": ")
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(params)
+	return b.Bytes(), nil
+}
+
+func componentValue(r *http.Request, target *url.URL, c string) (string, error) {
+	switch c {
+	case "@method":
+		return strings.ToUpper(r.Method), nil
+	case "@target-uri":
+		return target.String(), nil
+	case "@authority":
+		return strings.ToLower(target.Host), nil
+	case "@scheme":
+		return strings.ToLower(target.Scheme), nil
+	case "@request-target":
+		return target.RequestURI(), nil
+	case "@path":
+		if p := target.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + target.RawQuery, nil
+	}
+	if strings.HasPrefix(c, "@") {
+		return "", fmt.Errorf("unsupported HTTP Message Signature component: %s", c)
+	}
+	vs := r.Header.Values(c)
+	if len(vs) == 0 {
+		return "", fmt.Errorf("HTTP Message Signature covers missing header: %s", c)
+	}
+	for i := range vs {
+		vs[i] = strings.TrimSpace(vs[i])
+	}
+	return strings.Join(vs, ", "), nil
+}
+
+// checkContentDigest ensures the request body matches the supported digests
+// in its Content-Digest, of which there must be at least one. The body is
+// read and replaced so that it can be read again.
+func checkContentDigest(r *http.Request) error {
+	digests, err := parseDictionary(r.Header.Get("Content-Digest"))
+	if err != nil {
+		return fmt.Errorf("malformed Content-Digest: %s", err)
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	var checked bool
+	for _, d := range digests {
+		want, ok := d.value.([]byte)
+		if !ok {
+			continue
+		}
+		var got []byte
+		switch d.key {
+		case "sha-256":
+			h := sha256.Sum256(body)
+			got = h[:]
+		case "sha-512":
+			h := sha512.Sum512(body)
+			got = h[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return fmt.Errorf("Content-Digest %s does not match the body", d.key)
+		}
+		checked = true
+	}
+	if !checked {
+		return errors.New("Content-Digest has no supported digest")
+	}
+	return nil
+}
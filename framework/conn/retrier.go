@@ -17,110 +17,78 @@
 package conn
 
 import (
-	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
 	"time"
 
+	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/config"
-	"github.com/allinbits/apcore/paths"
-	"github.com/allinbits/apcore/services"
-	"github.com/allinbits/apcore/util"
 )
 
+// retrier decides when a failed delivery is attempted again, and when to
+// give up on it entirely.
 type retrier struct {
 	// Immutable
-	da               *services.DeliveryAttempts
-	pk               *services.PrivateKeys
-	tc               *Controller
-	pageSize         int
 	abandonLimit     int
-	reattemptBackoff func(n int) time.Duration
-	retrierFn        *util.SafeStartStop
+	reattemptBackoff func(to *url.URL, n int) time.Duration
 }
 
-func newRetrier(da *services.DeliveryAttempts, pk *services.PrivateKeys, tc *Controller, c *config.Config) *retrier {
+func newRetrier(c *config.Config, a app.Application) (*retrier, error) {
 	r := &retrier{
-		da:           da,
-		pk:           pk,
-		tc:           tc,
-		pageSize:     c.ActivityPubConfig.RetryPageSize,
 		abandonLimit: c.ActivityPubConfig.RetryAbandonLimit,
-		reattemptBackoff: func(n int) time.Duration {
-			z := time.Duration(c.ActivityPubConfig.RetrySleepPeriod) * time.Second
-			// Exponential backoff
-			for i := 0; i < n; i++ {
-				z += z
-			}
-			// If larger than a day, cap at one attempt per day
-			if z > time.Hour*24 {
-				z = time.Hour * 24
-			}
-			return z
-		},
 	}
-	r.retrierFn = util.NewSafeStartStop(r.retry, time.Duration(c.ActivityPubConfig.RetrySleepPeriod)*time.Second)
-	return r
-}
-
-func (r *retrier) Start() {
-	r.retrierFn.Start()
+	period := time.Duration(c.ActivityPubConfig.RetrySleepPeriod) * time.Second
+	switch c.ActivityPubConfig.RetryBackoff {
+	case "", "exponential":
+		r.reattemptBackoff = func(to *url.URL, n int) time.Duration {
+			return exponentialBackoff(period, n)
+		}
+	case "exponential_jitter":
+		r.reattemptBackoff = func(to *url.URL, n int) time.Duration {
+			z := exponentialBackoff(period, n)
+			// Wait anywhere from half to all of the backoff, so
+			// deliveries that failed together are retried apart.
+			return z - time.Duration(rand.Int63n(int64(z/2)+1))
+		}
+	case "fixed":
+		r.reattemptBackoff = func(to *url.URL, n int) time.Duration {
+			return period
+		}
+	case "custom":
+		ra, ok := a.(app.RetryingApplication)
+		if !ok {
+			return nil, fmt.Errorf("custom retry backoff requires the application to implement RetryingApplication")
+		}
+		r.reattemptBackoff = func(to *url.URL, n int) time.Duration {
+			return ra.RetryBackoff(to, n+1)
+		}
+	default:
+		return nil, fmt.Errorf("unknown retry backoff: %q", c.ActivityPubConfig.RetryBackoff)
+	}
+	return r, nil
 }
 
-func (r *retrier) Stop() {
-	r.retrierFn.Stop()
+// exponentialBackoff doubles the period for each of n previous failures.
+func exponentialBackoff(period time.Duration, n int) time.Duration {
+	z := period
+	for i := 0; i < n; i++ {
+		z += z
+		// If larger than a day, cap at one attempt per day
+		if z > time.Hour*24 {
+			z = time.Hour * 24
+			break
+		}
+	}
+	return z
 }
 
-func (r *retrier) retry(ctx context.Context) {
-	c := util.Context{ctx}
-	now := time.Now()
-	failures, err := r.da.FirstPageRetryableFailures(c, r.pageSize)
-	if err != nil {
-		util.ErrorLogger.Errorf("retrier failed to obtain first page: %s", err)
-		return
-	}
-	for len(failures) > 0 {
-		for _, failure := range failures {
-			// Skip this if the retry attempt would be too soon;
-			// this applies a backoff function.
-			if failure.LastAttempt.Sub(now) < r.reattemptBackoff(failure.NAttempts) {
-				continue
-			}
-			privKey, pubKeyID, err := r.pk.GetUserHTTPSignatureKey(c, paths.UUID(failure.UserID))
-			if err != nil {
-				util.ErrorLogger.Errorf("retrier failed to obtain user's HTTP Signature key: %s", err)
-				continue
-			}
-			tp, err := r.tc.Get(privKey, pubKeyID.String())
-			if err != nil {
-				util.ErrorLogger.Errorf("retrier failed to obtain a transport for delivery: %s", err)
-				continue
-			}
-			// Attempt delivery and update its associated record.
-			err = tp.Deliver(ctx, failure.Payload, failure.DeliverTo)
-			if err != nil {
-				util.ErrorLogger.Errorf("retrier failed in an attempt to retry delivery: %s", err)
-				if failure.NAttempts >= r.abandonLimit {
-					err = r.da.MarkAbandonedAttempt(c, failure.ID)
-					if err != nil {
-						util.ErrorLogger.Errorf("retrier failed to mark attempt as abandoned: %s", err)
-					}
-				} else {
-					err = r.da.MarkRetryFailureAttempt(c, failure.ID)
-					if err != nil {
-						util.ErrorLogger.Errorf("retrier failed to mark attempt as failed: %s", err)
-					}
-				}
-			} else {
-				err = r.da.MarkSuccessfulAttempt(c, failure.ID)
-				if err != nil {
-					util.ErrorLogger.Errorf("retrier failed to mark attempt as successful: %s", err)
-				}
-			}
-		}
-		last := failures[len(failures)-1]
-		failures, err = r.da.NextPageRetryableFailures(c, last.ID, last.FetchTime, r.pageSize)
-		if err != nil {
-			util.ErrorLogger.Errorf("retrier failed to obtain the next page of retriable failures: %s", err)
-			return
-		}
+// next determines whether a delivery that has just failed, after having
+// previously been attempted nAttempts times, is abandoned or otherwise when it
+// is next attempted.
+func (r *retrier) next(to *url.URL, nAttempts int, now time.Time) (abandon bool, at time.Time) {
+	if nAttempts+1 >= r.abandonLimit {
+		return true, now
 	}
+	return false, now.Add(r.reattemptBackoff(to, nAttempts))
 }
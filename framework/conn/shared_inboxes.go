@@ -0,0 +1,187 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/util"
+	"github.com/tidwall/gjson"
+)
+
+type sharedInboxEntry struct {
+	Actor *url.URL
+	// SharedInbox is nil if the actor has none.
+	SharedInbox *url.URL
+	LastSeen    time.Time
+}
+
+// sharedInboxes remembers the actor owning each personal inbox of federated
+// actors, and their shared inbox, as their actor documents are fetched.
+//
+// Fetching the actors of the recipients always happens before delivering to
+// their inboxes, so the entries are kept fresh by the deliveries that use
+// them.
+type sharedInboxes struct {
+	// Immutable
+	maxAge  time.Duration
+	pruneFn *util.SafeStartStop
+	// Mutable
+	m  map[string]sharedInboxEntry
+	mu sync.Mutex
+}
+
+func newSharedInboxes(c *config.Config) *sharedInboxes {
+	s := &sharedInboxes{
+		maxAge: time.Duration(c.ActivityPubConfig.SharedInboxCacheSeconds) * time.Second,
+		m:      make(map[string]sharedInboxEntry),
+	}
+	if s.maxAge > 0 {
+		s.pruneFn = util.NewSafeStartStop(s.prune, s.maxAge)
+	}
+	return s
+}
+
+func (s *sharedInboxes) Start() {
+	if s.pruneFn == nil {
+		util.InfoLogger.Info("Delivery to shared inboxes is disabled")
+		return
+	}
+	s.pruneFn.Start()
+}
+
+func (s *sharedInboxes) Stop() {
+	if s.pruneFn == nil {
+		return
+	}
+	s.pruneFn.Stop()
+}
+
+// Enabled determines whether deliveries may be collapsed into shared inboxes.
+func (s *sharedInboxes) Enabled() bool {
+	return s.maxAge > 0
+}
+
+// Observe records the personal and shared inboxes of a document fetched from
+// an IRI, if it is an actor.
+//
+// The actor and its inboxes must all be on the host that the document was
+// fetched from, so that a peer cannot redirect deliveries meant for actors on
+// other hosts, nor an actor those meant for its neighbors.
+func (s *sharedInboxes) Observe(fetched *url.URL, b []byte) {
+	if !s.Enabled() {
+		return
+	}
+	onHost := func(v gjson.Result) *url.URL {
+		if v.Type != gjson.String {
+			return nil
+		}
+		iri, err := url.Parse(v.String())
+		if err != nil || iri.Host != fetched.Host || iri.Scheme != fetched.Scheme {
+			return nil
+		}
+		return iri
+	}
+	actorIRI := onHost(gjson.GetBytes(b, "id"))
+	inboxIRI := onHost(gjson.GetBytes(b, "inbox"))
+	if actorIRI == nil || inboxIRI == nil {
+		return
+	}
+	var sharedIRI *url.URL
+	if shared := gjson.GetBytes(b, "endpoints.sharedInbox"); shared.Exists() {
+		if sharedIRI = onHost(shared); sharedIRI == nil {
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[inboxIRI.String()] = sharedInboxEntry{
+		Actor:       actorIRI,
+		SharedInbox: sharedIRI,
+		LastSeen:    time.Now(),
+	}
+}
+
+// Get returns the shared inbox known for an inbox, or nil if there is none.
+func (s *sharedInboxes) Get(inbox *url.URL) *url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.m[inbox.String()]
+	if !ok || time.Since(e.LastSeen) > s.maxAge {
+		return nil
+	}
+	return e.SharedInbox
+}
+
+// Owner returns the actor whose personal inbox it is, or nil if it is not
+// known or is also the actor's shared inbox.
+func (s *sharedInboxes) Owner(inbox *url.URL) *url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.m[inbox.String()]
+	if !ok || time.Since(e.LastSeen) > s.maxAge {
+		return nil
+	} else if e.SharedInbox != nil && e.SharedInbox.String() == inbox.String() {
+		return nil
+	}
+	return e.Actor
+}
+
+// Collapse groups recipients by their shared inbox. Any shared inbox that
+// would receive the delivery on behalf of more than one recipient replaces
+// them, while every other recipient is kept as-is.
+func (s *sharedInboxes) Collapse(recipients []*url.URL) (targets []*url.URL) {
+	if !s.Enabled() {
+		return recipients
+	}
+	var order []string
+	groups := make(map[string][]*url.URL)
+	shared := make(map[string]*url.URL)
+	for _, r := range recipients {
+		key := r.String()
+		if si := s.Get(r); si != nil {
+			key = si.String()
+			shared[key] = si
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], r)
+	}
+	for _, key := range order {
+		if si, ok := shared[key]; ok && len(groups[key]) > 1 {
+			targets = append(targets, si)
+		} else {
+			targets = append(targets, groups[key]...)
+		}
+	}
+	return
+}
+
+func (s *sharedInboxes) prune(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.m {
+		if time.Since(v.LastSeen) > s.maxAge {
+			delete(s.m, k)
+		}
+	}
+}
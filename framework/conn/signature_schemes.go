@@ -0,0 +1,170 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/util"
+)
+
+// signatureSchemeMemory is how long the signature scheme accepted by a peer is
+// remembered after it was last accepted.
+const signatureSchemeMemory = time.Hour * 24
+
+// signatureScheme is a way of signing outgoing requests.
+type signatureScheme int
+
+const (
+	// draftCavage signs with draft-cavage HTTP Signatures.
+	draftCavage signatureScheme = iota
+	// messageSignatures signs with RFC 9421 HTTP Message Signatures.
+	messageSignatures
+)
+
+func (s signatureScheme) String() string {
+	if s == messageSignatures {
+		return "rfc9421"
+	}
+	return "cavage"
+}
+
+type signatureSchemeEntry struct {
+	Scheme signatureScheme
+	// Rejected is whether the peer rejected every scheme.
+	Rejected bool
+	LastSeen time.Time
+}
+
+// signatureSchemes decides which signature schemes requests to a peer are
+// signed with.
+//
+// When double knocking, a peer is first sent a request signed with RFC 9421
+// HTTP Message Signatures and, if it rejects it, the same request signed with
+// draft-cavage HTTP Signatures. The scheme the peer accepted is remembered and
+// tried first afterwards. A peer that rejected both is only sent requests
+// signed with draft-cavage HTTP Signatures until it is forgotten, as it is
+// likely refusing them for another reason than their signature.
+type signatureSchemes struct {
+	// Immutable
+	doubleKnock bool
+	scheme      signatureScheme
+	pruneFn     *util.SafeStartStop
+	// Mutable
+	m  map[string]signatureSchemeEntry
+	mu sync.Mutex
+}
+
+func newSignatureSchemes(c *config.Config) (*signatureSchemes, error) {
+	s := &signatureSchemes{
+		m: make(map[string]signatureSchemeEntry),
+	}
+	switch c.ActivityPubConfig.HttpSignaturesConfig.Scheme {
+	case "", "cavage":
+		s.scheme = draftCavage
+	case "rfc9421":
+		s.scheme = messageSignatures
+	case "double_knock":
+		s.doubleKnock = true
+		s.pruneFn = util.NewSafeStartStop(s.prune, signatureSchemeMemory)
+	default:
+		return nil, fmt.Errorf("unknown http signature scheme: %q", c.ActivityPubConfig.HttpSignaturesConfig.Scheme)
+	}
+	return s, nil
+}
+
+func (s *signatureSchemes) Start() {
+	if s.pruneFn == nil {
+		util.InfoLogger.Infof("Signing outgoing requests only with %s HTTP signatures", s.scheme)
+		return
+	}
+	s.pruneFn.Start()
+}
+
+func (s *signatureSchemes) Stop() {
+	if s.pruneFn == nil {
+		return
+	}
+	s.pruneFn.Stop()
+}
+
+// Attempts returns the schemes to sign a request to the host with, in the
+// order they are to be tried.
+func (s *signatureSchemes) Attempts(host string) []signatureScheme {
+	if !s.doubleKnock {
+		return []signatureScheme{s.scheme}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.m[host]; ok && time.Since(e.LastSeen) <= signatureSchemeMemory {
+		if e.Rejected {
+			return []signatureScheme{draftCavage}
+		} else if e.Scheme == draftCavage {
+			return []signatureScheme{draftCavage, messageSignatures}
+		}
+	}
+	return []signatureScheme{messageSignatures, draftCavage}
+}
+
+// Accepted records that the host accepted a request signed with the scheme.
+func (s *signatureSchemes) Accepted(host string, scheme signatureScheme) {
+	if !s.doubleKnock {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[host] = signatureSchemeEntry{
+		Scheme:   scheme,
+		LastSeen: time.Now(),
+	}
+}
+
+// Rejected records that the host rejected requests signed with every scheme.
+func (s *signatureSchemes) Rejected(host string) {
+	if !s.doubleKnock {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[host] = signatureSchemeEntry{
+		Rejected: true,
+		LastSeen: time.Now(),
+	}
+}
+
+func (s *signatureSchemes) prune(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.m {
+		if time.Since(v.LastSeen) > signatureSchemeMemory {
+			delete(s.m, k)
+		}
+	}
+}
+
+// rejectsSignature determines whether a response status may be due to the
+// peer not accepting how the request was signed. Other client errors, such as
+// a peer forbidding requests from this server, would not be any different
+// with another signature.
+func rejectsSignature(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,302 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sfToken is a Token in an RFC 8941 Structured Field.
+type sfToken string
+
+// sfMember is a member of an RFC 8941 Structured Field Dictionary.
+//
+// Only what is needed for HTTP Message Signatures is kept: either a bare item
+// value, or the strings of an inner list whose items have no parameters.
+type sfMember struct {
+	key string
+	// value is an int64, float64, string, sfToken, []byte or bool bare
+	// item, or nil for an inner list.
+	value interface{}
+	// components are the strings of an inner list. It is nil if the
+	// value is not an inner list, or if the inner list has items that
+	// are not strings or have parameters.
+	components []string
+	params     map[string]interface{}
+	// raw is the member's value and parameters as serialized in the field.
+	raw string
+}
+
+type sfDictionary []sfMember
+
+// get returns the member with the key. Later members override earlier ones.
+func (d sfDictionary) get(key string) (sfMember, bool) {
+	for i := len(d) - 1; i >= 0; i-- {
+		if d[i].key == key {
+			return d[i], true
+		}
+	}
+	return sfMember{}, false
+}
+
+// parseDictionary parses an RFC 8941 Structured Field Dictionary.
+func parseDictionary(s string) (d sfDictionary, err error) {
+	p := &sfParser{s: strings.TrimSpace(s)}
+	for !p.done() {
+		var m sfMember
+		if m.key, err = p.key(); err != nil {
+			return
+		}
+		start := p.i
+		if p.peek() == '=' {
+			p.i++
+			start = p.i
+			if p.peek() == '(' {
+				if m.components, err = p.innerList(); err != nil {
+					return
+				}
+			} else if m.value, err = p.bareItem(); err != nil {
+				return
+			}
+		} else {
+			m.value = true
+		}
+		if m.params, err = p.params(); err != nil {
+			return
+		}
+		m.raw = p.s[start:p.i]
+		d = append(d, m)
+		p.skipOWS()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("expected ',' at %d", p.i)
+		}
+		p.i++
+		p.skipOWS()
+		if p.done() {
+			return nil, fmt.Errorf("trailing ','")
+		}
+	}
+	return
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.i++
+	}
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.i
+	if c := p.peek(); !(c == '*' || (c >= 'a' && c <= 'z')) {
+		return "", fmt.Errorf("invalid key at %d", p.i)
+	}
+	for !p.done() {
+		c := p.peek()
+		if !(c == '*' || c == '_' || c == '-' || c == '.' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+// innerList parses an inner list, returning its strings if all of its items
+// are strings without parameters.
+func (p *sfParser) innerList() (strs []string, err error) {
+	p.i++
+	strs = []string{}
+	for {
+		p.skipSP()
+		if p.done() {
+			return nil, fmt.Errorf("unterminated inner list")
+		}
+		if p.peek() == ')' {
+			p.i++
+			return
+		}
+		var v interface{}
+		if v, err = p.bareItem(); err != nil {
+			return nil, err
+		}
+		var params map[string]interface{}
+		if params, err = p.params(); err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok && len(params) == 0 && strs != nil {
+			strs = append(strs, s)
+		} else {
+			strs = nil
+		}
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, fmt.Errorf("expected ' ' or ')' at %d", p.i)
+		}
+	}
+}
+
+func (p *sfParser) params() (params map[string]interface{}, err error) {
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		var k string
+		if k, err = p.key(); err != nil {
+			return
+		}
+		var v interface{} = true
+		if p.peek() == '=' {
+			p.i++
+			if v, err = p.bareItem(); err != nil {
+				return
+			}
+		}
+		if params == nil {
+			params = make(map[string]interface{})
+		}
+		params[k] = v
+	}
+	return
+}
+
+func (p *sfParser) bareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		p.i++
+		switch p.peek() {
+		case '1':
+			p.i++
+			return true, nil
+		case '0':
+			p.i++
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean at %d", p.i)
+	case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return p.token(), nil
+	}
+	return nil, fmt.Errorf("invalid item at %d", p.i)
+}
+
+func (p *sfParser) number() (interface{}, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	decimal := false
+	for !p.done() {
+		c := p.peek()
+		if c == '.' && !decimal {
+			decimal = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		p.i++
+	}
+	if decimal {
+		return strconv.ParseFloat(p.s[start:p.i], 64)
+	}
+	return strconv.ParseInt(p.s[start:p.i], 10, 64)
+}
+
+func (p *sfParser) string() (interface{}, error) {
+	p.i++
+	var sb strings.Builder
+	for !p.done() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if n := p.peek(); n == '"' || n == '\\' {
+				sb.WriteByte(n)
+				p.i++
+				continue
+			}
+			return nil, fmt.Errorf("invalid escape at %d", p.i)
+		case c == '"':
+			return sb.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return nil, fmt.Errorf("invalid string character at %d", p.i-1)
+		}
+		sb.WriteByte(c)
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+func (p *sfParser) byteSequence() (interface{}, error) {
+	p.i++
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated byte sequence")
+	}
+	enc := p.s[p.i : p.i+end]
+	p.i += end + 1
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(enc)
+	}
+	return b, err
+}
+
+func (p *sfParser) token() sfToken {
+	start := p.i
+	for !p.done() {
+		c := p.peek()
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),;<=>?@[\\]{}", c) >= 0 {
+			break
+		}
+		p.i++
+	}
+	return sfToken(p.s[start:p.i])
+}
+
+// serializeString serializes an RFC 8941 String.
+func serializeString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -20,11 +20,15 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/config"
@@ -38,6 +42,9 @@ import (
 
 const (
 	activityStreamsContentType = "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\""
+	// maxRetryAfter caps how far in the future a peer may ask for a
+	// delivery to be attempted again.
+	maxRetryAfter = time.Hour * 24
 )
 
 func containsRequiredHttpHeaders(method string, headers []string) error {
@@ -66,7 +73,10 @@ type Controller struct {
 	getHeaders  []string
 	postHeaders []string
 	hl          *hostLimiter
-	rt          *retrier
+	si          *sharedInboxes
+	dc          *dereferenceCache
+	ss          *signatureSchemes
+	dq          *deliveryQueue
 	da          *services.DeliveryAttempts
 }
 
@@ -76,7 +86,8 @@ func NewController(
 	clock pub.Clock,
 	client *http.Client,
 	da *services.DeliveryAttempts,
-	pk *services.PrivateKeys) (tc *Controller, err error) {
+	pk *services.PrivateKeys,
+	dc *services.DereferenceCache) (tc *Controller, err error) {
 	if c.ActivityPubConfig.OutboundRateLimitQPS <= 0 {
 		err = fmt.Errorf("outbound rate limit qps is <= 0")
 		return
@@ -107,26 +118,75 @@ func NewController(
 		digestAlg:   httpsig.DigestAlgorithm(c.ActivityPubConfig.HttpSignaturesConfig.DigestAlgorithm),
 		getHeaders:  c.ActivityPubConfig.HttpSignaturesConfig.GetHeaders,
 		postHeaders: c.ActivityPubConfig.HttpSignaturesConfig.PostHeaders,
-		hl:          newHostLimiter(c),
+		si:          newSharedInboxes(c),
+		dc:          newDereferenceCache(c, dc),
 		da:          da,
 	}
-	ct.rt = newRetrier(da, pk, ct, c)
+	if ct.hl, err = newHostLimiter(c); err != nil {
+		return
+	}
+	if ct.ss, err = newSignatureSchemes(c); err != nil {
+		return
+	}
+	var rt *retrier
+	if rt, err = newRetrier(c, a); err != nil {
+		return
+	}
+	ct.dq = newDeliveryQueue(da, pk, ct, rt, newCircuitBreaker(c), c)
 	return ct, err
 }
 
 func (tc *Controller) Start() {
 	tc.hl.Start()
-	tc.rt.Start()
+	tc.si.Start()
+	tc.dc.Start()
+	tc.ss.Start()
+	tc.dq.Start()
 }
 
 func (tc *Controller) Stop() {
-	tc.rt.Stop()
+	tc.dq.Stop()
+	tc.ss.Stop()
+	tc.dc.Stop()
+	tc.si.Stop()
 	tc.hl.Stop()
 }
 
+// ForgetDereferenced removes any cached copy of the federated data with the
+// IRI, so that the next time it is dereferenced it is fetched from the peer.
+func (tc *Controller) ForgetDereferenced(c util.Context, iri *url.URL) {
+	tc.dc.Forget(c, iri)
+}
+
 func (tc *Controller) Get(
 	privKey crypto.PrivateKey,
 	pubKeyId string) (t pub.Transport, err error) {
+	return tc.get(privKey, pubKeyId)
+}
+
+// GetWithProofs is like Get, but the Transport also secures the activities of
+// the actor that it delivers with a Data Integrity proof created by the
+// actor's Ed25519 key.
+func (tc *Controller) GetWithProofs(
+	privKey crypto.PrivateKey,
+	pubKeyId string,
+	actorID *url.URL,
+	proofKey ed25519.PrivateKey,
+	proofKeyID *url.URL) (t pub.Transport, err error) {
+	var tp *transport
+	tp, err = tc.get(privKey, pubKeyId)
+	if err != nil {
+		return
+	}
+	tp.proofActor = actorID.String()
+	tp.proofKey = proofKey
+	tp.proofKeyID = proofKeyID
+	return tp, nil
+}
+
+func (tc *Controller) get(
+	privKey crypto.PrivateKey,
+	pubKeyId string) (t *transport, err error) {
 	var getSigner, postSigner httpsig.Signer
 	// TODO: Use config for expiration in seconds
 	getSigner, _, err = httpsig.NewSigner(tc.algs, tc.digestAlg, tc.getHeaders, httpsig.Signature, 60)
@@ -152,22 +212,25 @@ func (tc *Controller) GetFirstAlgorithm() httpsig.Algorithm {
 	return tc.algs[0]
 }
 
-func (tc *Controller) wait(c context.Context, host string) error {
-	return tc.hl.Get(host).Wait(c)
+// HostLimits obtains the current outbound rate limits of hosts, for
+// debugging.
+func (tc *Controller) HostLimits() []HostLimit {
+	return tc.hl.Limits()
 }
 
-func (tc *Controller) insertAttempt(c util.Context, payload []byte, to *url.URL, fromUUID paths.UUID) (id string, err error) {
-	id, err = tc.da.InsertAttempt(c, fromUUID, to, payload)
-	return
-}
-
-func (tc *Controller) markSuccess(c util.Context, id string) (err error) {
-	err = tc.da.MarkSuccessfulAttempt(c, id)
-	return
+func (tc *Controller) wait(c context.Context, host string) error {
+	return tc.hl.Get(host).Wait(c)
 }
 
-func (tc *Controller) markFailure(c util.Context, id string) (err error) {
-	err = tc.da.MarkRetryFailureAttempt(c, id)
+// enqueue queues a delivery to be made by the delivery queue's workers.
+func (tc *Controller) enqueue(c util.Context, payload []byte, to *url.URL, fromUUID paths.UUID) (err error) {
+	if _, err = tc.da.InsertAttempt(c, fromUUID, to, payload); err == services.ErrInboxGone {
+		util.InfoLogger.Infof("Not delivering to %s: %s", to, err)
+		return nil
+	} else if err != nil {
+		return
+	}
+	tc.dq.Wake()
 	return
 }
 
@@ -182,6 +245,10 @@ type transport struct {
 	privKey                   crypto.PrivateKey
 	pubKeyId                  string
 	tc                        *Controller
+	// Optional, for securing delivered activities with proofs
+	proofActor string
+	proofKey   ed25519.PrivateKey
+	proofKeyID *url.URL
 }
 
 func newTransport(a app.Application,
@@ -206,40 +273,69 @@ func newTransport(a app.Application,
 }
 
 func (t *transport) Dereference(c context.Context, iri *url.URL) (b []byte, err error) {
-	var req *http.Request
-	req, err = http.NewRequest(http.MethodGet, iri.String(), nil)
-	if err != nil {
-		return
-	}
-	req.WithContext(c)
-	req.Header.Add("Accept", activityStreamsContentType)
-	req.Header.Add("Accept-Charset", "utf-8")
-	req.Header.Add("Date", t.date())
-	req.Header.Add("User-Agent", t.userAgent())
-	t.getSignerMu.Lock()
-	err = t.getSigner.SignRequest(t.privKey, t.pubKeyId, req, nil)
-	t.getSignerMu.Unlock()
-	if err != nil {
-		return
-	}
-	if err = t.tc.wait(c, req.URL.Host); err != nil {
+	uc := util.Context{c}
+	now := t.clock.Now()
+	cached, found := t.tc.dc.Get(uc, iri)
+	if found && now.Before(cached.Expires) {
+		b = cached.Body
+		t.tc.si.Observe(iri, b)
 		return
 	}
 	var resp *http.Response
-	resp, err = t.client.Do(req)
+	resp, err = t.send(c, iri, nil, func() (req *http.Request, err error) {
+		req, err = http.NewRequest(http.MethodGet, iri.String(), nil)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(c)
+		req.Header.Add("Accept", activityStreamsContentType)
+		req.Header.Add("Accept-Charset", "utf-8")
+		req.Header.Add("Date", t.date())
+		req.Header.Add("User-Agent", t.userAgent())
+		if found && cached.ETag != "" {
+			req.Header.Add("If-None-Match", cached.ETag)
+		}
+		if found && cached.LastModified != "" {
+			req.Header.Add("If-Modified-Since", cached.LastModified)
+		}
+		return
+	})
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
+	if found && resp.StatusCode == http.StatusNotModified {
+		t.tc.dc.Revalidated(uc, iri, resp.Header, now)
+		b = cached.Body
+		t.tc.si.Observe(iri, b)
+		return
+	}
 	if err = t.handleDereferenceResponse(resp, iri); err != nil {
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			t.tc.dc.Forget(uc, iri)
+		}
 		return
 	}
 	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	t.tc.dc.Store(uc, iri, resp.Header, b, now)
+	t.tc.si.Observe(iri, b)
 	return
 }
 
+// Deliver queues the payload for delivery to the recipient's inbox, returning
+// once it is durably queued rather than once it is delivered.
 func (t *transport) Deliver(c context.Context, b []byte, to *url.URL) (err error) {
+	if b, err = t.withProof(b); err != nil {
+		return
+	}
+	return t.deliver(c, b, to)
+}
+
+func (t *transport) deliver(c context.Context, b []byte, to *url.URL) (err error) {
 	uc := util.Context{c}
 	var fromUUID paths.UUID
 	fromUUID, err = uc.UserPathUUID()
@@ -247,71 +343,133 @@ func (t *transport) Deliver(c context.Context, b []byte, to *url.URL) (err error
 		err = fmt.Errorf("failed to determine user to deliver on behalf of: %s", err)
 		return
 	}
-	var attemptId string
-	if attemptId, err = t.tc.insertAttempt(uc, b, to, fromUUID); err != nil {
-		err = fmt.Errorf("failed to create delivery attempt: %s", err)
+	if err = t.tc.enqueue(uc, b, to, fromUUID); err != nil {
+		err = fmt.Errorf("failed to queue delivery: %s", err)
 		return
 	}
+	return
+}
 
-	byteCopy := make([]byte, len(b))
-	copy(byteCopy, b)
-	buf := bytes.NewBuffer(byteCopy)
-	var req *http.Request
-	req, err = http.NewRequest(http.MethodPost, to.String(), buf)
-	if err != nil {
-		return
-	}
-	req.WithContext(c)
-	req.Header.Add("Content-Type", activityStreamsContentType)
-	req.Header.Add("Accept-Charset", "utf-8")
-	req.Header.Add("Date", t.date())
-	req.Header.Add("User-Agent", t.userAgent())
-	t.postSignerMu.Lock()
-	err = t.postSigner.SignRequest(t.privKey, t.pubKeyId, req, b)
-	t.postSignerMu.Unlock()
-	if err != nil {
-		return
-	}
-	if err = t.tc.wait(c, req.URL.Host); err != nil {
-		return
-	}
+// post sends the payload to the recipient's inbox.
+func (t *transport) post(c context.Context, b []byte, to *url.URL) (err error) {
 	var resp *http.Response
-	resp, err = t.client.Do(req)
+	resp, err = t.send(c, to, b, func() (req *http.Request, err error) {
+		byteCopy := make([]byte, len(b))
+		copy(byteCopy, b)
+		buf := bytes.NewBuffer(byteCopy)
+		req, err = http.NewRequest(http.MethodPost, to.String(), buf)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(c)
+		req.Header.Add("Content-Type", activityStreamsContentType)
+		req.Header.Add("Accept-Charset", "utf-8")
+		req.Header.Add("Date", t.date())
+		req.Header.Add("User-Agent", t.userAgent())
+		return
+	})
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
+	return t.handleDeliverResponse(resp, to)
+}
 
-	if err = t.handleDeliverResponse(resp, to); err != nil {
-		err2 := t.tc.markFailure(uc, attemptId)
-		if err2 != nil {
-			err = fmt.Errorf("failed delivery and failed to mark as failure (%s): [%s, %s]", attemptId, err, err2)
+// send signs and sends a request created by newReq, whose body is b.
+//
+// If the peer may not accept the signature scheme used, it rejecting the
+// request as unauthorized causes a new request to be signed with the next
+// scheme and sent. A peer rejecting every scheme is remembered, so that it is
+// not sent each request more than once.
+func (t *transport) send(c context.Context, to *url.URL, b []byte, newReq func() (*http.Request, error)) (resp *http.Response, err error) {
+	attempts := t.tc.ss.Attempts(to.Host)
+	for i, scheme := range attempts {
+		var req *http.Request
+		if req, err = newReq(); err != nil {
+			return
+		}
+		if err = t.sign(req, b, scheme); err != nil {
+			return
+		}
+		if err = t.tc.wait(c, req.URL.Host); err != nil {
+			return
+		}
+		resp, err = t.client.Do(req)
+		t.tc.hl.Adapt(req.URL.Host, resp, err)
+		if err != nil {
+			return
+		}
+		if !rejectsSignature(resp.StatusCode) {
+			t.tc.ss.Accepted(to.Host, scheme)
+			return
+		} else if i < len(attempts)-1 {
+			resp.Body.Close()
 		}
-		return
 	}
-	if err = t.tc.markSuccess(uc, attemptId); err != nil {
-		err = fmt.Errorf("failed to mark delivery as successful (%s): %s", attemptId, err)
-		return
+	if len(attempts) > 1 {
+		t.tc.ss.Rejected(to.Host)
 	}
 	return
 }
 
+// sign signs a request, whose body is b, with the signature scheme.
+func (t *transport) sign(req *http.Request, b []byte, scheme signatureScheme) (err error) {
+	if scheme == messageSignatures {
+		return signMessage(req, b, t.privKey, t.pubKeyId, t.clock.Now())
+	} else if req.Method == http.MethodGet {
+		t.getSignerMu.Lock()
+		defer t.getSignerMu.Unlock()
+		return t.getSigner.SignRequest(t.privKey, t.pubKeyId, req, b)
+	}
+	t.postSignerMu.Lock()
+	defer t.postSignerMu.Unlock()
+	return t.postSigner.SignRequest(t.privKey, t.pubKeyId, req, b)
+}
+
+// BatchDeliver queues the payload for delivery to each of the recipients'
+// inboxes.
+//
+// Recipients on the same host that are known to share an inbox are sent a
+// single delivery to that shared inbox instead.
 func (t *transport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) (err error) {
-	var wg sync.WaitGroup
+	if b, err = t.withProof(b); err != nil {
+		return
+	}
+	recipients = t.tc.si.Collapse(recipients)
 	for i, r := range recipients {
-		wg.Add(1)
-		go func(i int, r *url.URL) {
-			defer wg.Done()
-			err := t.Deliver(c, b, r)
-			if err != nil {
-				util.ErrorLogger.Errorf("BatchDeliver (%d of %d): %s", i, len(recipients), err)
-			}
-		}(i, r)
-	}
-	wg.Wait()
+		err := t.deliver(c, b, r)
+		if err != nil {
+			util.ErrorLogger.Errorf("BatchDeliver (%d of %d): %s", i, len(recipients), err)
+		}
+	}
 	return
 }
 
+// withProof secures the payload with a Data Integrity proof, if the transport
+// has a key for proofs and the payload is an activity of its actor that is not
+// already secured. Other actors' activities, such as those being forwarded,
+// are delivered unchanged.
+func (t *transport) withProof(b []byte) ([]byte, error) {
+	if t.proofKey == nil {
+		return b, nil
+	}
+	var a struct {
+		Actor interface{} `json:"actor"`
+		Proof interface{} `json:"proof"`
+	}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, err
+	}
+	actor := a.Actor
+	if m, ok := a.Actor.(map[string]interface{}); ok {
+		actor = m["id"]
+	}
+	if actor != t.proofActor || a.Proof != nil {
+		return b, nil
+	}
+	return AddProof(b, t.proofKey, t.proofKeyID, t.clock.Now())
+}
+
 func (t *transport) handleDereferenceResponse(r *http.Response, iri *url.URL) (err error) {
 	ok := r.StatusCode == http.StatusOK
 	if !ok {
@@ -325,7 +483,12 @@ func (t *transport) handleDeliverResponse(r *http.Response, iri *url.URL) (err e
 		r.StatusCode == http.StatusCreated ||
 		r.StatusCode == http.StatusAccepted
 	if !ok {
-		err = fmt.Errorf("delivery [%s] failed with status (%d): %s", iri, r.StatusCode, r.Status)
+		err = &deliveryError{
+			IRI:        iri,
+			StatusCode: r.StatusCode,
+			Status:     r.Status,
+			RetryAfter: parseRetryAfter(r.Header.Get("Retry-After"), t.clock.Now()),
+		}
 	}
 	return
 }
@@ -337,3 +500,63 @@ func (t *transport) userAgent() string {
 func (t *transport) date() string {
 	return fmt.Sprintf("%s GMT", t.clock.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05"))
 }
+
+// deliveryError is a delivery refused by the peer.
+type deliveryError struct {
+	IRI        *url.URL
+	StatusCode int
+	Status     string
+	// RetryAfter is when the peer asked for the delivery to be attempted
+	// again, or the zero time if it did not.
+	RetryAfter time.Time
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("delivery [%s] failed with status (%d): %s", e.IRI, e.StatusCode, e.Status)
+}
+
+// Gone determines whether the inbox no longer exists, and never will again.
+func (e *deliveryError) Gone() bool {
+	return e.StatusCode == http.StatusGone
+}
+
+// Throttled determines whether the peer is limiting the deliveries it
+// accepts, either due to their rate or while it is unavailable.
+func (e *deliveryError) Throttled() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		(e.StatusCode == http.StatusServiceUnavailable && !e.RetryAfter.IsZero())
+}
+
+// Permanent determines whether the peer refused the delivery due to a client
+// error, which attempting again will not fix.
+func (e *deliveryError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date. The zero time is returned if it is absent or
+// invalid, and it is capped at maxRetryAfter from now.
+func parseRetryAfter(v string, now time.Time) (at time.Time) {
+	if len(v) == 0 {
+		return
+	} else if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if s < 0 {
+			return
+		} else if s > int64(maxRetryAfter/time.Second) {
+			s = int64(maxRetryAfter / time.Second)
+		}
+		return now.Add(time.Duration(s) * time.Second)
+	} else if at, err = http.ParseTime(v); err != nil {
+		return time.Time{}
+	}
+	if at.Before(now) {
+		return now
+	} else if at.After(now.Add(maxRetryAfter)) {
+		return now.Add(maxRetryAfter)
+	}
+	return
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/util"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func NewDB(c *config.Config) (sqldb *sql.DB, d models.SqlDialect, err error) {
@@ -36,6 +37,10 @@ func NewDB(c *config.Config) (sqldb *sql.DB, d models.SqlDialect, err error) {
 		conn, err = postgresConn(c.DatabaseConfig.PostgresConfig)
 		d = NewPgV0(c.DatabaseConfig.PostgresConfig.Schema)
 		driver = "pgx"
+	case "sqlite":
+		conn, err = sqliteConn(c.DatabaseConfig.SqliteConfig)
+		d = NewSqliteV0()
+		driver = "sqlite3"
 	default:
 		err = fmt.Errorf("unhandled database_kind in config: %s", kind)
 	}
@@ -119,3 +124,22 @@ func postgresConn(pg config.PostgresConfig) (s string, err error) {
 	}
 	return
 }
+
+func sqliteConn(sc config.SqliteConfig) (s string, err error) {
+	util.InfoLogger.Info("SQLite database configuration")
+	if len(sc.File) == 0 {
+		err = fmt.Errorf("sqlite config missing sqlite_file")
+		return
+	}
+	// Foreign keys are required for the ON DELETE CASCADE behaviors, and
+	// immediate transactions avoid lock upgrade deadlocks between
+	// concurrent writers.
+	s = fmt.Sprintf("file:%s?_foreign_keys=on&_txlock=immediate", sc.File)
+	if sc.BusyTimeoutMs > 0 {
+		s = fmt.Sprintf("%s&_busy_timeout=%d", s, sc.BusyTimeoutMs)
+	}
+	if len(sc.JournalMode) > 0 {
+		s = fmt.Sprintf("%s&_journal_mode=%s", s, sc.JournalMode)
+	}
+	return
+}
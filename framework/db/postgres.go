@@ -174,6 +174,10 @@ func (p *pgV0) FedDelete() string {
 	return `DELETE FROM ` + p.schema + `fed_data WHERE payload->>'id' = $1`
 }
 
+func (p *pgV0) FedGetRecent() string {
+	return `SELECT payload FROM ` + p.schema + `fed_data ORDER BY create_time DESC LIMIT $1`
+}
+
 func (p *pgV0) CreateLocalDataTable() string {
 	return `
 CREATE TABLE IF NOT EXISTS ` + p.schema + `local_data
@@ -779,6 +783,23 @@ WHERE actor->'inbox' ? $1`
 
 func (p *pgV0) CreateDeliveryAttemptsTable() string {
 	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `delivery_attempts
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  create_time timestamp with time zone DEFAULT current_timestamp,
+  from_id uuid REFERENCES ` + p.schema + `users (id) ON DELETE CASCADE NOT NULL,
+  deliver_to text NOT NULL,
+  payload bytea NOT NULL,
+  state text NOT NULL,
+  n_attempts bigint NOT NULL,
+  last_attempt timestamp with time zone DEFAULT current_timestamp,
+  next_attempt timestamp with time zone NOT NULL DEFAULT current_timestamp
+);`
+}
+
+// createDeliveryAttemptsTableV1 is the delivery attempts table as created by
+// the initial schema migration.
+func (p *pgV0) createDeliveryAttemptsTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `delivery_attempts
 (
   id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
   create_time timestamp with time zone DEFAULT current_timestamp,
@@ -791,6 +812,10 @@ func (p *pgV0) CreateDeliveryAttemptsTable() string {
 );`
 }
 
+func (p *pgV0) CreateIndexNextAttemptDeliveryAttemptsTable() string {
+	return `CREATE INDEX IF NOT EXISTS delivery_attempts_next_attempt_index ON ` + p.schema + `delivery_attempts (state, next_attempt)`
+}
+
 func (p *pgV0) InsertAttempt() string {
 	return `INSERT INTO ` + p.schema + `delivery_attempts (from_id, deliver_to, payload, state, n_attempts) VALUES ($1, $2, $3, $4, 0) RETURNING id`
 }
@@ -809,7 +834,8 @@ func (p *pgV0) MarkFailedAttempt() string {
 SET
   state = $2,
   n_attempts = n_attempts + 1,
-  last_attempt = current_timestamp
+  last_attempt = current_timestamp,
+  next_attempt = $3
 WHERE id = $1`
 }
 
@@ -822,25 +848,221 @@ SET
 WHERE id = $1`
 }
 
-func (p *pgV0) FirstPageRetryableFailures() string {
-	return `SELECT id, from_id, deliver_to, payload, n_attempts, last_attempt
+func (p *pgV0) GetDueAttempts() string {
+	return `SELECT id, from_id, deliver_to, payload, n_attempts, last_attempt, next_attempt
 FROM ` + p.schema + `delivery_attempts
-WHERE state = $1 AND create_time < $2
-ORDER BY id DESC
-LIMIT $3`
+WHERE state IN ($1, $2) AND next_attempt <= $3
+ORDER BY next_attempt ASC, id ASC
+LIMIT $4`
+}
+
+func (p *pgV0) ClaimAttempt() string {
+	return `UPDATE ` + p.schema + `delivery_attempts
+SET next_attempt = $5
+WHERE id = $1 AND state IN ($2, $3) AND next_attempt <= $4`
+}
+
+func (p *pgV0) ReleaseAttempt() string {
+	return `UPDATE ` + p.schema + `delivery_attempts
+SET next_attempt = $4
+WHERE id = $1 AND state IN ($2, $3)`
+}
+
+func (p *pgV0) PostponeAttempts() string {
+	return `UPDATE ` + p.schema + `delivery_attempts
+SET next_attempt = $4
+WHERE state IN ($1, $2) AND deliver_to LIKE $3 ESCAPE '\' AND next_attempt < $4`
+}
+
+func (p *pgV0) AbandonAttemptsTo() string {
+	return `UPDATE ` + p.schema + `delivery_attempts
+SET state = $4
+WHERE state IN ($1, $2) AND deliver_to = $3`
 }
 
-func (p *pgV0) NextPageRetryableFailures() string {
-	return `SELECT id, from_id, deliver_to, payload, n_attempts, last_attempt
+func (p *pgV0) GetAttempts() string {
+	return `SELECT id, from_id, deliver_to, state, n_attempts, create_time, last_attempt, next_attempt
 FROM ` + p.schema + `delivery_attempts
-WHERE state = $1 AND create_time < $2 AND id < $4
-ORDER BY id DESC
-LIMIT $3`
+WHERE ` + p.filterAttempts() + `
+ORDER BY create_time DESC, id DESC
+LIMIT $5`
+}
+
+func (p *pgV0) RequeueAttempts() string {
+	return `UPDATE ` + p.schema + `delivery_attempts
+SET
+  state = $5,
+  n_attempts = 0,
+  next_attempt = current_timestamp
+WHERE ` + p.filterAttempts()
+}
+
+func (p *pgV0) DeleteAttempts() string {
+	return `DELETE FROM ` + p.schema + `delivery_attempts
+WHERE ` + p.filterAttempts()
+}
+
+// filterAttempts matches delivery attempts by the state, destination pattern,
+// sender, and creation time in the first four parameters.
+func (p *pgV0) filterAttempts() string {
+	return `($1 = '' OR state = $1)
+  AND ($2 = '' OR deliver_to LIKE $2 ESCAPE '\')
+  AND ($3 = '' OR from_id::text = $3)
+  AND create_time < $4`
+}
+
+func (p *pgV0) createGoneInboxesTableV5() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `gone_inboxes
+(
+  inbox text PRIMARY KEY,
+  gone_at timestamp with time zone NOT NULL DEFAULT current_timestamp
+);`
+}
+
+func (p *pgV0) CreateGoneActorsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `gone_actors
+(
+  actor_iri text PRIMARY KEY,
+  inbox text NOT NULL,
+  gone_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateIndexInboxGoneActorsTable() string {
+	return `CREATE INDEX IF NOT EXISTS gone_actors_inbox_index ON ` + p.schema + `gone_actors (inbox)`
+}
+
+func (p *pgV0) GoneActorInboxExists() string {
+	return `SELECT EXISTS (SELECT 1 FROM ` + p.schema + `gone_actors WHERE inbox = $1 AND expires > $2)`
+}
+
+func (p *pgV0) UpsertGoneActor() string {
+	return `INSERT INTO ` + p.schema + `gone_actors (actor_iri, inbox, expires) VALUES ($1, $2, $3)
+ON CONFLICT (actor_iri) DO UPDATE SET inbox = $2, gone_at = current_timestamp, expires = $3`
+}
+
+func (p *pgV0) GetGoneActors() string {
+	return `SELECT actor_iri, inbox, gone_at, expires FROM ` + p.schema + `gone_actors
+ORDER BY gone_at DESC, actor_iri`
+}
+
+func (p *pgV0) DeleteGoneActor() string {
+	return `DELETE FROM ` + p.schema + `gone_actors WHERE actor_iri = $1`
+}
+
+func (p *pgV0) DeleteGoneActors() string {
+	return `DELETE FROM ` + p.schema + `gone_actors`
+}
+
+func (p *pgV0) CreateDereferenceCacheTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `dereference_cache
+(
+  iri text PRIMARY KEY,
+  body bytea NOT NULL,
+  etag text NOT NULL,
+  last_modified text NOT NULL,
+  fetch_time timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateIndexExpiresDereferenceCacheTable() string {
+	return `CREATE INDEX IF NOT EXISTS dereference_cache_expires_index ON ` + p.schema + `dereference_cache (expires)`
+}
+
+func (p *pgV0) GetDereferenceCache() string {
+	return `SELECT body, etag, last_modified, expires FROM ` + p.schema + `dereference_cache WHERE iri = $1`
+}
+
+func (p *pgV0) UpsertDereferenceCache() string {
+	return `INSERT INTO ` + p.schema + `dereference_cache (iri, body, etag, last_modified, expires)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (iri) DO UPDATE SET
+  body = excluded.body,
+  etag = excluded.etag,
+  last_modified = excluded.last_modified,
+  fetch_time = current_timestamp,
+  expires = excluded.expires`
+}
+
+func (p *pgV0) RevalidateDereferenceCache() string {
+	return `UPDATE ` + p.schema + `dereference_cache SET expires = $2 WHERE iri = $1`
+}
+
+func (p *pgV0) DeleteDereferenceCache() string {
+	return `DELETE FROM ` + p.schema + `dereference_cache WHERE iri = $1`
+}
+
+func (p *pgV0) PruneDereferenceCache() string {
+	return `DELETE FROM ` + p.schema + `dereference_cache WHERE expires < $1`
+}
+
+func (p *pgV0) createRemotePublicKeysTableV7() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `remote_public_keys
+(
+  key_id text PRIMARY KEY,
+  public_key_pem text NOT NULL,
+  fetch_time timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateRemotePublicKeysTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `remote_public_keys
+(
+  key_id text PRIMARY KEY,
+  public_key_pem text NOT NULL,
+  owner text NOT NULL,
+  fetch_time timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateIndexExpiresRemotePublicKeysTable() string {
+	return `CREATE INDEX IF NOT EXISTS remote_public_keys_expires_index ON ` + p.schema + `remote_public_keys (expires)`
+}
+
+func (p *pgV0) GetRemotePublicKey() string {
+	return `SELECT public_key_pem, owner, expires FROM ` + p.schema + `remote_public_keys WHERE key_id = $1`
+}
+
+func (p *pgV0) UpsertRemotePublicKey() string {
+	return `INSERT INTO ` + p.schema + `remote_public_keys (key_id, public_key_pem, owner, expires)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key_id) DO UPDATE SET
+  public_key_pem = excluded.public_key_pem,
+  owner = excluded.owner,
+  fetch_time = current_timestamp,
+  expires = excluded.expires`
+}
+
+func (p *pgV0) PruneRemotePublicKeys() string {
+	return `DELETE FROM ` + p.schema + `remote_public_keys WHERE expires < $1`
 }
 
 func (p *pgV0) CreatePrivateKeysTable() string {
 	return `
 CREATE TABLE IF NOT EXISTS ` + p.schema + `private_keys
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE NOT NULL,
+  purpose text NOT NULL,
+  priv_key bytea NOT NULL,
+  key_id text
+);`
+}
+
+// createPrivateKeysTableV1 is the private keys table as created by the initial
+// schema migration.
+func (p *pgV0) createPrivateKeysTableV1() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `private_keys
 (
   id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
   user_id uuid REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE NOT NULL,
@@ -854,18 +1076,55 @@ func (p *pgV0) CreatePrivateKey() string {
 }
 
 func (p *pgV0) GetPrivateKeyByUserID() string {
-	return `SELECT priv_key FROM ` + p.schema + `private_keys WHERE user_id = $1 AND purpose = $2`
+	return `SELECT priv_key, key_id FROM ` + p.schema + `private_keys WHERE user_id = $1 AND purpose = $2`
 }
 
 func (p *pgV0) GetPrivateKeyForInstanceActor() string {
 	return `SELECT
-  pk.priv_key
+  pk.priv_key,
+  pk.key_id
 FROM ` + p.schema + `private_keys AS pk
 LEFT JOIN ` + p.schema + `users AS u
 ON u.id = pk.user_id
 WHERE u.privileges->>'InstanceActor' = 'true' AND purpose = $1`
 }
 
+func (p *pgV0) RotatePrivateKey() string {
+	return `UPDATE ` + p.schema + `private_keys SET priv_key = $3, key_id = $4 WHERE user_id = $1 AND purpose = $2`
+}
+
+func (p *pgV0) GetUsersWithoutPrivateKey() string {
+	return `SELECT u.id FROM ` + p.schema + `users AS u
+WHERE NOT EXISTS (SELECT 1 FROM ` + p.schema + `private_keys AS pk WHERE pk.user_id = u.id AND pk.purpose = $1)`
+}
+
+func (p *pgV0) CreateRetiredKeysTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `retired_keys
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE NOT NULL,
+  key_id text NOT NULL,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateIndexExpiresRetiredKeysTable() string {
+	return `CREATE INDEX IF NOT EXISTS retired_keys_expires_index ON ` + p.schema + `retired_keys (expires)`
+}
+
+func (p *pgV0) CreateRetiredKey() string {
+	return `INSERT INTO ` + p.schema + `retired_keys (user_id, key_id, expires) VALUES ($1, $2, $3)`
+}
+
+func (p *pgV0) GetExpiredRetiredKeys() string {
+	return `SELECT id, user_id, key_id, expires FROM ` + p.schema + `retired_keys WHERE expires < $1`
+}
+
+func (p *pgV0) DeleteRetiredKey() string {
+	return `DELETE FROM ` + p.schema + `retired_keys WHERE id = $1`
+}
+
 func (p *pgV0) CreateClientInfosTable() string {
 	return `
 CREATE TABLE IF NOT EXISTS ` + p.schema + `oauth_clients
@@ -885,6 +1144,33 @@ func (p *pgV0) GetClientInfoByID() string {
 	return `SELECT id, secret, domain, user_id FROM ` + p.schema + `oauth_clients WHERE id = $1`
 }
 
+func (p *pgV0) DeleteClientInfo() string {
+	return `DELETE FROM ` + p.schema + `oauth_clients WHERE id = $1`
+}
+
+func (p *pgV0) CreateClientRegistrationsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `oauth_client_registrations
+(
+  client_id text PRIMARY KEY REFERENCES ` + p.schema + `oauth_clients(id) ON DELETE CASCADE,
+  metadata jsonb NOT NULL,
+  create_time timestamp with time zone NOT NULL DEFAULT current_timestamp
+);`
+}
+
+func (p *pgV0) CreateClientRegistration() string {
+	return `INSERT INTO ` + p.schema + `oauth_client_registrations (client_id, metadata) VALUES ($1, $2)`
+}
+
+func (p *pgV0) GetClientRegistration() string {
+	return `SELECT client_id, metadata, create_time FROM ` + p.schema + `oauth_client_registrations WHERE client_id = $1`
+}
+
+func (p *pgV0) GetClientRegistrations() string {
+	return `SELECT client_id, metadata, create_time FROM ` + p.schema + `oauth_client_registrations
+ORDER BY create_time DESC, client_id`
+}
+
 func (p *pgV0) CreateTokenInfosTable() string {
 	return `
 CREATE TABLE IF NOT EXISTS ` + p.schema + `oauth_tokens
@@ -1018,6 +1304,38 @@ func (p *pgV0) GetTokenInfoByRefresh() string {
 FROM ` + p.schema + "oauth_tokens WHERE refresh = $1"
 }
 
+func (p *pgV0) GetTokenInfosForUser() string {
+	return `SELECT
+  ti.id,
+  ti.client_id,
+  ti.user_id,
+  ti.redirect_uri,
+  ti.scope,
+  ti.code,
+  ti.code_create_at,
+  ti.code_expires_in,
+  ti.code_challenge,
+  ti.code_challenge_method,
+  ti.access,
+  ti.access_create_at,
+  ti.access_expires_in,
+  ti.refresh,
+  ti.refresh_create_at,
+  ti.refresh_expires_in,
+  fpc.create_time,
+  cr.metadata->>'client_name'
+FROM ` + p.schema + `oauth_tokens AS ti
+LEFT JOIN ` + p.schema + `first_party_creds AS fpc
+ON fpc.token_id = ti.id
+LEFT JOIN ` + p.schema + `oauth_client_registrations AS cr
+ON cr.client_id = ti.client_id
+WHERE ti.user_id = $1 AND ti.access IS NOT NULL AND ti.access <> ''`
+}
+
+func (p *pgV0) RemoveTokenInfoForUser() string {
+	return `DELETE FROM ` + p.schema + `oauth_tokens WHERE id = $1 AND user_id = $2`
+}
+
 /* Collection prototype queries */
 
 func (p *pgV0) createCollectionTable(name string) string {
@@ -1297,6 +1615,20 @@ func (p *pgV0) GetAllLikedForActor() string {
 
 func (p *pgV0) CreatePoliciesTable() string {
 	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `policies
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  actor_id text NOT NULL,
+  purpose text NOT NULL,
+  policy jsonb NOT NULL,
+  scope text NOT NULL DEFAULT 'actor',
+  domain text NOT NULL DEFAULT ''
+)`
+}
+
+// createPoliciesTableV1 is the policies table as created by the initial
+// schema migration, before policies were scoped.
+func (p *pgV0) createPoliciesTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `policies
 (
   id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
   actor_id text NOT NULL,
@@ -1305,20 +1637,69 @@ func (p *pgV0) CreatePoliciesTable() string {
 )`
 }
 
+func (p *pgV0) CreateIndexScopePoliciesTable() string {
+	return `CREATE INDEX IF NOT EXISTS policies_scope_index ON ` + p.schema + `policies (scope, domain)`
+}
+
 func (p *pgV0) CreatePolicy() string {
 	return `INSERT INTO ` + p.schema + `policies (actor_id, purpose, policy) VALUES ($1, $2, $3) RETURNING id`
 }
 
 func (p *pgV0) GetPoliciesForActor() string {
-	return `SELECT id, purpose, policy FROM ` + p.schema + `policies WHERE actor_id = $1`
+	return `SELECT id, purpose, policy FROM ` + p.schema + `policies WHERE actor_id = $1 AND scope = 'actor'`
 }
 
 func (p *pgV0) GetPoliciesForActorAndPurpose() string {
-	return `SELECT id, policy FROM ` + p.schema + `policies WHERE actor_id = $1 AND purpose = $2`
+	return `SELECT id, policy FROM ` + p.schema + `policies WHERE actor_id = $1 AND purpose = $2 AND scope = 'actor'`
+}
+
+func (p *pgV0) CreateScopedPolicy() string {
+	return `INSERT INTO ` + p.schema + `policies (actor_id, scope, domain, purpose, policy) VALUES ('', $1, $2, $3, $4) RETURNING id`
+}
+
+func (p *pgV0) GetInstancePolicies() string {
+	return `SELECT id, purpose, policy FROM ` + p.schema + `policies WHERE scope = 'instance'`
+}
+
+func (p *pgV0) GetPoliciesForDomain() string {
+	return `SELECT id, purpose, policy FROM ` + p.schema + `policies WHERE scope = 'domain' AND (domain = $1::text OR right($1::text, length(domain) + 1) = '.' || domain)`
+}
+
+func (p *pgV0) GetScopedPolicies() string {
+	return `SELECT id, scope, domain, purpose, policy FROM ` + p.schema + `policies WHERE scope <> 'actor' ORDER BY scope, domain`
+}
+
+func (p *pgV0) DeleteScopedPolicy() string {
+	return `DELETE FROM ` + p.schema + `policies WHERE id = $1 AND scope <> 'actor'`
+}
+
+func (p *pgV0) GetPolicy() string {
+	return `SELECT id, actor_id, scope, domain, purpose, policy FROM ` + p.schema + `policies WHERE id = $1`
+}
+
+func (p *pgV0) UpdatePolicy() string {
+	return `UPDATE ` + p.schema + `policies SET purpose = $2, policy = $3 WHERE id = $1`
+}
+
+func (p *pgV0) DeletePolicy() string {
+	return `DELETE FROM ` + p.schema + `policies WHERE id = $1`
 }
 
 func (p *pgV0) CreateResolutionsTable() string {
 	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `resolutions
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  policy_id uuid REFERENCES ` + p.schema + `policies(id) ON DELETE CASCADE NOT NULL,
+  data_iri text NOT NULL,
+  resolution jsonb NOT NULL,
+  create_time timestamp with time zone NOT NULL DEFAULT current_timestamp
+)`
+}
+
+// createResolutionsTableV1 is the resolutions table as created by the initial
+// schema migration, before resolutions recorded their creation time.
+func (p *pgV0) createResolutionsTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `resolutions
 (
   id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
   policy_id uuid REFERENCES ` + p.schema + `policies(id) ON DELETE CASCADE NOT NULL,
@@ -1327,10 +1708,54 @@ func (p *pgV0) CreateResolutionsTable() string {
 )`
 }
 
+func (p *pgV0) CreateIndexPolicyIDResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_policy_id_index ON ` + p.schema + `resolutions (policy_id)`
+}
+
+func (p *pgV0) CreateIndexDataIRIResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_data_iri_index ON ` + p.schema + `resolutions (data_iri)`
+}
+
+func (p *pgV0) CreateIndexCreateTimeResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_create_time_index ON ` + p.schema + `resolutions (create_time)`
+}
+
 func (p *pgV0) CreateResolution() string {
 	return `INSERT INTO ` + p.schema + `resolutions (policy_id, data_iri, resolution) VALUES ($1, $2, $3)`
 }
 
+func (p *pgV0) GetResolutionsForPolicy() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM ` + p.schema + `resolutions
+WHERE policy_id = $1
+ORDER BY create_time DESC, id
+OFFSET $2 LIMIT $3`
+}
+
+func (p *pgV0) GetResolutionsForDataIRI() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM ` + p.schema + `resolutions
+WHERE data_iri = $1
+ORDER BY create_time DESC, id
+OFFSET $2 LIMIT $3`
+}
+
+func (p *pgV0) GetResolutionsInTimeRange() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM ` + p.schema + `resolutions
+WHERE create_time >= $1 AND create_time < $2
+ORDER BY create_time DESC, id
+OFFSET $3 LIMIT $4`
+}
+
+func (p *pgV0) GetResolutionsByMatched() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM ` + p.schema + `resolutions
+WHERE (resolution->>'matched')::boolean = $1
+ORDER BY create_time DESC, id
+OFFSET $2 LIMIT $3`
+}
+
+func (p *pgV0) DeleteResolutionsBefore() string {
+	return `DELETE FROM ` + p.schema + `resolutions WHERE create_time < $1`
+}
+
 func (p *pgV0) CreateFirstPartyCredentialsTable() string {
 	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `first_party_creds
 (
@@ -1414,6 +1839,142 @@ ON fpc.token_id = ti.id
 WHERE fpc.id = $1`
 }
 
+func (p *pgV0) CreateTOTPSecretsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `totp_secrets
+(
+  user_id uuid PRIMARY KEY REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE,
+  secret bytea NOT NULL,
+  confirmed boolean NOT NULL DEFAULT false,
+  last_step bigint NOT NULL DEFAULT 0,
+  create_time timestamp with time zone NOT NULL DEFAULT current_timestamp
+);`
+}
+
+func (p *pgV0) CreateTOTPSecret() string {
+	return `INSERT INTO ` + p.schema + `totp_secrets (user_id, secret) VALUES ($1, $2)`
+}
+
+func (p *pgV0) GetTOTPSecret() string {
+	return `SELECT secret, confirmed, last_step FROM ` + p.schema + `totp_secrets WHERE user_id = $1`
+}
+
+func (p *pgV0) ConfirmTOTPSecret() string {
+	return `UPDATE ` + p.schema + `totp_secrets SET confirmed = true WHERE user_id = $1`
+}
+
+func (p *pgV0) UpdateTOTPLastStep() string {
+	return `UPDATE ` + p.schema + `totp_secrets SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+}
+
+func (p *pgV0) DeleteTOTPSecret() string {
+	return `DELETE FROM ` + p.schema + `totp_secrets WHERE user_id = $1`
+}
+
+func (p *pgV0) CreateRecoveryCodesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `recovery_codes
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE NOT NULL,
+  hashed_code bytea NOT NULL,
+  UNIQUE (user_id, hashed_code)
+);`
+}
+
+func (p *pgV0) CreateRecoveryCode() string {
+	return `INSERT INTO ` + p.schema + `recovery_codes (user_id, hashed_code) VALUES ($1, $2)`
+}
+
+func (p *pgV0) CountRecoveryCodes() string {
+	return `SELECT count(*) FROM ` + p.schema + `recovery_codes WHERE user_id = $1`
+}
+
+func (p *pgV0) UseRecoveryCode() string {
+	return `DELETE FROM ` + p.schema + `recovery_codes WHERE user_id = $1 AND hashed_code = $2`
+}
+
+func (p *pgV0) DeleteRecoveryCodes() string {
+	return `DELETE FROM ` + p.schema + `recovery_codes WHERE user_id = $1`
+}
+
+func (p *pgV0) CreateWebAuthnCredentialsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `webauthn_credentials
+(
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE NOT NULL,
+  credential_id bytea NOT NULL UNIQUE,
+  public_key bytea NOT NULL,
+  sign_count bigint NOT NULL,
+  name text NOT NULL,
+  create_time timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  last_used_time timestamp with time zone
+);`
+}
+
+func (p *pgV0) CreateWebAuthnCredential() string {
+	return `INSERT INTO ` + p.schema + `webauthn_credentials (user_id, credential_id, public_key, sign_count, name) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+}
+
+func (p *pgV0) GetWebAuthnCredentialsForUser() string {
+	return `SELECT id, credential_id, public_key, sign_count, name, create_time, last_used_time FROM ` + p.schema + `webauthn_credentials
+WHERE user_id = $1
+ORDER BY create_time, id`
+}
+
+func (p *pgV0) UpdateWebAuthnCredentialSignCount() string {
+	return `UPDATE ` + p.schema + `webauthn_credentials SET sign_count = $2, last_used_time = current_timestamp WHERE id = $1`
+}
+
+func (p *pgV0) DeleteWebAuthnCredential() string {
+	return `DELETE FROM ` + p.schema + `webauthn_credentials WHERE id = $1 AND user_id = $2`
+}
+
+func (p *pgV0) CreateWebAuthnChallengesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `webauthn_challenges
+(
+  challenge text PRIMARY KEY,
+  expires timestamp with time zone NOT NULL
+);`
+}
+
+func (p *pgV0) CreateWebAuthnChallenge() string {
+	return `INSERT INTO ` + p.schema + `webauthn_challenges (challenge, expires) VALUES ($1, $2)`
+}
+
+func (p *pgV0) UseWebAuthnChallenge() string {
+	return `DELETE FROM ` + p.schema + `webauthn_challenges WHERE challenge = $1 AND expires > $2`
+}
+
+func (p *pgV0) DeleteExpiredWebAuthnChallenges() string {
+	return `DELETE FROM ` + p.schema + `webauthn_challenges WHERE expires <= $1`
+}
+
+func (p *pgV0) CreateSecondFactorFailuresTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + p.schema + `second_factor_failures
+(
+  user_id uuid PRIMARY KEY REFERENCES ` + p.schema + `users(id) ON DELETE CASCADE,
+  n_failures integer NOT NULL,
+  locked_until timestamp with time zone
+);`
+}
+
+func (p *pgV0) GetSecondFactorFailures() string {
+	return `SELECT n_failures, locked_until FROM ` + p.schema + `second_factor_failures WHERE user_id = $1`
+}
+
+func (p *pgV0) UpsertSecondFactorFailures() string {
+	return `INSERT INTO ` + p.schema + `second_factor_failures (user_id, n_failures, locked_until) VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE SET n_failures = $2, locked_until = $3`
+}
+
+func (p *pgV0) DeleteSecondFactorFailures() string {
+	return `DELETE FROM ` + p.schema + `second_factor_failures WHERE user_id = $1`
+}
+
 func (p *pgV0) GetOpenFollowRequests() string {
 	return `WITH follows_received AS (
   SELECT payload
@@ -1447,3 +2008,233 @@ LEFT JOIN accept_reject_follows AS arf
 ON arf.ap_id = fr.payload->>'id'
 WHERE arf IS NULL`
 }
+
+func (p *pgV0) CreateSchemaMigrationsTable() string {
+	return `CREATE TABLE IF NOT EXISTS ` + p.schema + `schema_migrations
+(
+  component text NOT NULL,
+  version integer NOT NULL,
+  description text NOT NULL,
+  applied_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+  PRIMARY KEY (component, version)
+)`
+}
+
+func (p *pgV0) GetSchemaMigrations() string {
+	return `SELECT version, description, applied_at FROM ` + p.schema + `schema_migrations WHERE component = $1 ORDER BY version ASC`
+}
+
+func (p *pgV0) InsertSchemaMigration() string {
+	return `INSERT INTO ` + p.schema + `schema_migrations (component, version, description) VALUES ($1, $2, $3)`
+}
+
+func (p *pgV0) DeleteSchemaMigration() string {
+	return `DELETE FROM ` + p.schema + `schema_migrations WHERE component = $1 AND version = $2`
+}
+
+/* Migrations */
+
+func (p *pgV0) Migrations() []models.Migration {
+	return []models.Migration{
+		{
+			Version:     1,
+			Description: "initial schema",
+			Up: []string{
+				p.CreateUsersTable(),
+				p.CreateFedDataTable(),
+				p.CreateIndexIDFedDataTable(),
+				p.CreateLocalDataTable(),
+				p.CreateIndexIDLocalDataTable(),
+				p.CreateInboxesTable(),
+				p.CreateIndexIDInboxesTable(),
+				p.CreateOutboxesTable(),
+				p.CreateIndexIDOutboxesTable(),
+				p.createDeliveryAttemptsTableV1(),
+				p.createPrivateKeysTableV1(),
+				p.CreateClientInfosTable(),
+				p.CreateTokenInfosTable(),
+				p.CreateFirstPartyCredentialsTable(),
+				p.CreateFollowingTable(),
+				p.CreateIndexIDFollowingTable(),
+				p.CreateFollowersTable(),
+				p.CreateIndexIDFollowersTable(),
+				p.CreateLikedTable(),
+				p.CreateIndexIDLikedTable(),
+				p.createPoliciesTableV1(),
+				p.createResolutionsTableV1(),
+			},
+			Down: p.dropTables(
+				"resolutions",
+				"policies",
+				v0Liked,
+				v0Followers,
+				v0Following,
+				"first_party_creds",
+				"oauth_tokens",
+				"oauth_clients",
+				"private_keys",
+				"delivery_attempts",
+				"outboxes",
+				"inboxes",
+				"local_data",
+				"fed_data",
+				"users"),
+		},
+		{
+			Version:     2,
+			Description: "instance and domain scoped policies",
+			Up: []string{
+				`ALTER TABLE ` + p.schema + `policies ADD COLUMN scope text NOT NULL DEFAULT 'actor'`,
+				`ALTER TABLE ` + p.schema + `policies ADD COLUMN domain text NOT NULL DEFAULT ''`,
+				p.CreateIndexScopePoliciesTable(),
+			},
+			Down: []string{
+				`DELETE FROM ` + p.schema + `policies WHERE scope <> 'actor'`,
+				`DROP INDEX IF EXISTS ` + p.schema + `policies_scope_index`,
+				`ALTER TABLE ` + p.schema + `policies DROP COLUMN domain`,
+				`ALTER TABLE ` + p.schema + `policies DROP COLUMN scope`,
+			},
+		},
+		{
+			Version:     3,
+			Description: "resolution creation time",
+			Up: []string{
+				`ALTER TABLE ` + p.schema + `resolutions ADD COLUMN create_time timestamp with time zone NOT NULL DEFAULT current_timestamp`,
+				`UPDATE ` + p.schema + `resolutions SET create_time = (resolution->>'time')::timestamp with time zone WHERE resolution ? 'time'`,
+				p.CreateIndexPolicyIDResolutionsTable(),
+				p.CreateIndexDataIRIResolutionsTable(),
+				p.CreateIndexCreateTimeResolutionsTable(),
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS ` + p.schema + `resolutions_create_time_index`,
+				`DROP INDEX IF EXISTS ` + p.schema + `resolutions_data_iri_index`,
+				`DROP INDEX IF EXISTS ` + p.schema + `resolutions_policy_id_index`,
+				`ALTER TABLE ` + p.schema + `resolutions DROP COLUMN create_time`,
+			},
+		},
+		{
+			Version:     4,
+			Description: "delivery queue scheduling",
+			Up: []string{
+				`ALTER TABLE ` + p.schema + `delivery_attempts ADD COLUMN next_attempt timestamp with time zone NOT NULL DEFAULT current_timestamp`,
+				`UPDATE ` + p.schema + `delivery_attempts SET next_attempt = last_attempt WHERE last_attempt IS NOT NULL`,
+				p.CreateIndexNextAttemptDeliveryAttemptsTable(),
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS ` + p.schema + `delivery_attempts_next_attempt_index`,
+				`ALTER TABLE ` + p.schema + `delivery_attempts DROP COLUMN next_attempt`,
+			},
+		},
+		{
+			Version:     5,
+			Description: "gone inboxes",
+			Up: []string{
+				p.createGoneInboxesTableV5(),
+			},
+			Down: p.dropTables("gone_inboxes"),
+		},
+		{
+			Version:     6,
+			Description: "dereference cache",
+			Up: []string{
+				p.CreateDereferenceCacheTable(),
+				p.CreateIndexExpiresDereferenceCacheTable(),
+			},
+			Down: p.dropTables("dereference_cache"),
+		},
+		{
+			Version:     7,
+			Description: "remote public keys",
+			Up: []string{
+				p.createRemotePublicKeysTableV7(),
+				p.CreateIndexExpiresRemotePublicKeysTable(),
+			},
+			Down: p.dropTables("remote_public_keys"),
+		},
+		{
+			Version:     8,
+			Description: "key rotation",
+			Up: []string{
+				`ALTER TABLE ` + p.schema + `private_keys ADD COLUMN key_id text`,
+				p.CreateRetiredKeysTable(),
+				p.CreateIndexExpiresRetiredKeysTable(),
+			},
+			Down: []string{
+				`DROP TABLE IF EXISTS ` + p.schema + `retired_keys`,
+				`ALTER TABLE ` + p.schema + `private_keys DROP COLUMN key_id`,
+			},
+		},
+		{
+			Version:     9,
+			Description: "oauth2 client registration",
+			Up: []string{
+				p.CreateClientRegistrationsTable(),
+			},
+			Down: []string{
+				`DELETE FROM ` + p.schema + `oauth_clients WHERE id IN (SELECT client_id FROM ` + p.schema + `oauth_client_registrations)`,
+				`DROP TABLE IF EXISTS ` + p.schema + `oauth_client_registrations`,
+			},
+		},
+		{
+			Version:     10,
+			Description: "two-factor authentication",
+			Up: []string{
+				p.CreateTOTPSecretsTable(),
+				p.CreateRecoveryCodesTable(),
+				p.CreateWebAuthnCredentialsTable(),
+			},
+			Down: p.dropTables(
+				"webauthn_credentials",
+				"recovery_codes",
+				"totp_secrets"),
+		},
+		{
+			Version:     11,
+			Description: "gone actors",
+			// Gone inboxes were not known to belong to a single actor,
+			// so they are forgotten rather than carried over.
+			Up: append([]string{
+				p.CreateGoneActorsTable(),
+				p.CreateIndexInboxGoneActorsTable(),
+			}, p.dropTables("gone_inboxes")...),
+			Down: append(
+				p.dropTables("gone_actors"),
+				p.createGoneInboxesTableV5()),
+		},
+		{
+			Version:     12,
+			Description: "second factor failures",
+			Up: []string{
+				p.CreateSecondFactorFailuresTable(),
+			},
+			Down: p.dropTables("second_factor_failures"),
+		},
+		{
+			Version:     13,
+			Description: "webauthn challenges",
+			Up: []string{
+				p.CreateWebAuthnChallengesTable(),
+			},
+			Down: p.dropTables("webauthn_challenges"),
+		},
+		{
+			Version:     14,
+			Description: "remote public key owners",
+			// The owners of cached keys are not known, so the keys
+			// are fetched again rather than carried over.
+			Up: append(p.dropTables("remote_public_keys"),
+				p.CreateRemotePublicKeysTable(),
+				p.CreateIndexExpiresRemotePublicKeysTable()),
+			Down: append(p.dropTables("remote_public_keys"),
+				p.createRemotePublicKeysTableV7(),
+				p.CreateIndexExpiresRemotePublicKeysTable()),
+		},
+	}
+}
+
+func (p *pgV0) dropTables(names ...string) (s []string) {
+	for _, n := range names {
+		s = append(s, `DROP TABLE IF EXISTS `+p.schema+n)
+	}
+	return
+}
@@ -0,0 +1,1890 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2019 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"github.com/allinbits/apcore/models"
+)
+
+var _ models.SqlDialect = &sqliteV0{}
+
+// sqliteV0 is the SQLite dialect. It relies on the JSON functions and
+// aggregate ORDER BY clauses available in SQLite 3.44 and later.
+//
+// JSON payloads are stored as TEXT, so JSON parameters are explicitly cast to
+// TEXT when inserted, since drivers bind []byte values as BLOBs.
+type sqliteV0 struct{}
+
+func NewSqliteV0() *sqliteV0 {
+	return &sqliteV0{}
+}
+
+const (
+	// sqliteUUID generates a random (version 4) UUID in its canonical text
+	// form, as SQLite has no native UUID type.
+	sqliteUUID = `(lower(
+  hex(randomblob(4)) || '-' ||
+  hex(randomblob(2)) || '-4' ||
+  substr(hex(randomblob(2)), 2) || '-' ||
+  substr('89ab', 1 + (abs(random()) % 4), 1) ||
+  substr(hex(randomblob(2)), 2) || '-' ||
+  hex(randomblob(6))))`
+	// sqliteNow is the current time in a format that both SQLite date
+	// functions and the driver's timestamp parsing understand.
+	sqliteNow = `(strftime('%Y-%m-%d %H:%M:%f', 'now'))`
+	// sqlitePublic is the ActivityStreams Public collection IRI.
+	sqlitePublic = `'https://www.w3.org/ns/activitystreams#Public'`
+)
+
+// jsonHas mirrors the Postgres jsonb `?` operator: it is true if the JSON
+// value at path is the string val, or is an array containing the string val.
+func (p *sqliteV0) jsonHas(doc, path, val string) string {
+	return `EXISTS (SELECT 1 FROM json_each(` + doc + `, '` + path + `') WHERE value = ` + val + `)`
+}
+
+// isPublic determines whether the stored payload addresses the Public
+// collection.
+func (p *sqliteV0) isPublic(payload string) string {
+	return `(` + p.jsonHas(payload, `$.to`, sqlitePublic) + ` OR ` + p.jsonHas(payload, `$.cc`, sqlitePublic) + `)`
+}
+
+// TODO
+func (p *sqliteV0) indexTokenCode() string {
+	return `CREATE INDEX IF NOT EXISTS oauth_tokens_code_index ON oauth_tokens (code);`
+}
+
+// TODO
+func (p *sqliteV0) indexTokenAccess() string {
+	return `CREATE INDEX IF NOT EXISTS oauth_tokens_access_index ON oauth_tokens (access);`
+}
+
+// TODO
+func (p *sqliteV0) indexTokenRefresh() string {
+	return `CREATE INDEX IF NOT EXISTS oauth_tokens_refresh_index ON oauth_tokens (refresh);`
+}
+
+/* SqlDialect */
+
+func (p *sqliteV0) CreateUsersTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS users
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  last_seen timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  email text NOT NULL,
+  hashpass blob NOT NULL,
+  salt blob NOT NULL,
+  actor text NOT NULL,
+  privileges text NOT NULL,
+  preferences text NOT NULL
+);`
+}
+
+func (p *sqliteV0) InsertUser() string {
+	return `INSERT INTO users (email, hashpass, salt, actor, privileges, preferences) VALUES (?1, ?2, ?3, CAST(?4 AS TEXT), CAST(?5 AS TEXT), CAST(?6 AS TEXT)) RETURNING id`
+}
+
+func (p *sqliteV0) UpdateUserActor() string {
+	return `UPDATE users SET actor = CAST(?2 AS TEXT) WHERE id = ?1`
+}
+
+func (p *sqliteV0) SensitiveUserByEmail() string {
+	return "SELECT id, hashpass, salt FROM users WHERE email = ?1"
+}
+
+func (p *sqliteV0) UserByID() string {
+	return "SELECT id, email, actor, privileges, preferences FROM users WHERE id = ?1"
+}
+
+func (p *sqliteV0) UserByPreferredUsername() string {
+	return "SELECT id, email, actor, privileges, preferences FROM users WHERE " + p.jsonHas("actor", "$.preferredUsername", "?1")
+}
+
+func (p *sqliteV0) ActorIDForOutbox() string {
+	return `SELECT json_extract(actor, '$.id') FROM users
+WHERE json_extract(actor, '$.outbox') = ?1`
+}
+
+func (p *sqliteV0) ActorIDForInbox() string {
+	return `SELECT json_extract(actor, '$.id') FROM users
+WHERE json_extract(actor, '$.inbox') = ?1`
+}
+
+func (p *sqliteV0) UpdateUserPreferences() string {
+	return `UPDATE users SET preferences = CAST(?2 AS TEXT) WHERE id = ?1`
+}
+
+func (p *sqliteV0) UpdateUserPrivileges() string {
+	return `UPDATE users SET privileges = CAST(?2 AS TEXT) WHERE id = ?1`
+}
+
+func (p *sqliteV0) InstanceUser() string {
+	return "SELECT id, email, actor, privileges, preferences FROM users WHERE json_extract(privileges, '$.InstanceActor') = 1"
+}
+
+func (p *sqliteV0) GetInstanceActorPreferences() string {
+	return `SELECT preferences
+FROM users
+WHERE json_extract(privileges, '$.InstanceActor') = 1`
+}
+
+func (p *sqliteV0) SetInstanceActorPreferences() string {
+	return `UPDATE users
+SET preferences = CAST(?1 AS TEXT)
+WHERE json_extract(privileges, '$.InstanceActor') = 1`
+}
+
+func (p *sqliteV0) GetUserActivityStats() string {
+	return `SELECT
+  COUNT(*),
+  COUNT(*) FILTER (WHERE julianday('now') - julianday(last_seen) < 180),
+  COUNT(*) FILTER (WHERE julianday('now') - julianday(last_seen) < 30),
+  COUNT(*) FILTER (WHERE julianday('now') - julianday(last_seen) < 7)
+FROM users`
+}
+
+func (p *sqliteV0) CreateFedDataTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS fed_data
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  create_time timestamp DEFAULT ` + sqliteNow + `,
+  payload text NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexIDFedDataTable() string {
+	return `CREATE INDEX IF NOT EXISTS fed_data_id_index ON fed_data (json_extract(payload, '$.id'));`
+}
+
+func (p *sqliteV0) FedExists() string {
+	return `SELECT EXISTS (
+  SELECT 1
+  FROM fed_data
+  WHERE json_extract(payload, '$.id') = ?1
+  LIMIT 1
+)`
+}
+
+func (p *sqliteV0) FedGet() string {
+	return `SELECT payload
+FROM fed_data
+WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) FedCreate() string {
+	return `INSERT INTO fed_data (payload) VALUES (CAST(?1 AS TEXT))`
+}
+
+func (p *sqliteV0) FedUpdate() string {
+	return `UPDATE fed_data SET payload = CAST(?2 AS TEXT) WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) FedDelete() string {
+	return `DELETE FROM fed_data WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) FedGetRecent() string {
+	return `SELECT payload FROM fed_data ORDER BY create_time DESC LIMIT ?1`
+}
+
+func (p *sqliteV0) CreateLocalDataTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS local_data
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  payload text NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexIDLocalDataTable() string {
+	return `CREATE INDEX IF NOT EXISTS local_data_id_index ON local_data (json_extract(payload, '$.id'));`
+}
+
+func (p *sqliteV0) LocalExists() string {
+	return `SELECT EXISTS (
+  SELECT 1
+  FROM local_data
+  WHERE json_extract(payload, '$.id') = ?1
+  LIMIT 1
+)`
+}
+
+func (p *sqliteV0) LocalGet() string {
+	return `SELECT payload
+FROM local_data
+WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) LocalCreate() string {
+	return `INSERT INTO local_data (payload) VALUES (CAST(?1 AS TEXT))`
+}
+
+func (p *sqliteV0) LocalUpdate() string {
+	return `UPDATE local_data SET payload = CAST(?2 AS TEXT) WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) LocalDelete() string {
+	return `DELETE FROM local_data WHERE json_extract(payload, '$.id') = ?1`
+}
+
+func (p *sqliteV0) LocalStats() string {
+	return `SELECT
+  COUNT(*) FILTER (WHERE json_type(payload, '$.inReplyTo') IS NULL),
+  COUNT(*) FILTER (WHERE json_type(payload, '$.inReplyTo') IS NOT NULL)
+FROM local_data`
+}
+
+func (p *sqliteV0) CreateInboxesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS inboxes
+(
+  id integer PRIMARY KEY AUTOINCREMENT,
+  actor_id text NOT NULL,
+  inbox text NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexIDInboxesTable() string {
+	return `CREATE INDEX IF NOT EXISTS inboxes_id_index ON inboxes (json_extract(inbox, '$.id'));`
+}
+
+func (p *sqliteV0) CreateOutboxesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS outboxes
+(
+  id integer PRIMARY KEY AUTOINCREMENT,
+  actor_id text NOT NULL,
+  outbox text NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexIDOutboxesTable() string {
+	return `CREATE INDEX IF NOT EXISTS outboxes_id_index ON outboxes (json_extract(outbox, '$.id'));`
+}
+
+func (p *sqliteV0) InsertInbox() string {
+	return `INSERT INTO inboxes (actor_id, inbox) VALUES (?1, CAST(?2 AS TEXT))`
+}
+
+func (p *sqliteV0) InsertOutbox() string {
+	return `INSERT INTO outboxes (actor_id, outbox) VALUES (?1, CAST(?2 AS TEXT))`
+}
+
+func (p *sqliteV0) InboxContainsForActor() string {
+	return p.orderedContainsForActor("inboxes", "inbox")
+}
+
+func (p *sqliteV0) InboxContains() string {
+	return p.orderedContains("inboxes", "inbox")
+}
+
+func (p *sqliteV0) OutboxContainsForActor() string {
+	return p.orderedContainsForActor("outboxes", "outbox")
+}
+
+func (p *sqliteV0) OutboxContains() string {
+	return p.orderedContains("outboxes", "outbox")
+}
+
+func (p *sqliteV0) GetInbox() string {
+	return p.getPage("inboxes", "inbox", "orderedItems", "OrderedCollectionPage")
+}
+
+func (p *sqliteV0) GetOutbox() string {
+	return p.getPage("outboxes", "outbox", "orderedItems", "OrderedCollectionPage")
+}
+
+func (p *sqliteV0) GetPublicInbox() string {
+	return p.getPublicPage("inboxes", "inbox")
+}
+
+func (p *sqliteV0) GetPublicOutbox() string {
+	return p.getPublicPage("outboxes", "outbox")
+}
+
+func (p *sqliteV0) GetInboxLastPage() string {
+	return p.getLastPage("inboxes", "inbox", "orderedItems", "OrderedCollectionPage")
+}
+
+func (p *sqliteV0) GetOutboxLastPage() string {
+	return p.getLastPage("outboxes", "outbox", "orderedItems", "OrderedCollectionPage")
+}
+
+func (p *sqliteV0) GetPublicInboxLastPage() string {
+	return p.getPublicLastPage("inboxes", "inbox")
+}
+
+func (p *sqliteV0) GetPublicOutboxLastPage() string {
+	return p.getPublicLastPage("outboxes", "outbox")
+}
+
+func (p *sqliteV0) PrependInboxItem() string {
+	return p.prependItem("inboxes", "inbox", "orderedItems")
+}
+
+func (p *sqliteV0) PrependOutboxItem() string {
+	return p.prependItem("outboxes", "outbox", "orderedItems")
+}
+
+func (p *sqliteV0) DeleteInboxItem() string {
+	return p.deleteItem("inboxes", "inbox", "orderedItems")
+}
+
+func (p *sqliteV0) DeleteOutboxItem() string {
+	return p.deleteItem("outboxes", "outbox", "orderedItems")
+}
+
+func (p *sqliteV0) OutboxForInbox() string {
+	return `SELECT json_extract(actor, '$.outbox') FROM users
+WHERE json_extract(actor, '$.inbox') = ?1`
+}
+
+func (p *sqliteV0) CreateDeliveryAttemptsTable() string {
+	return p.createDeliveryAttemptsTable("delivery_attempts")
+}
+
+// createDeliveryAttemptsTable creates the latest delivery attempts table with
+// the given name, so that migrations are able to rebuild it.
+func (p *sqliteV0) createDeliveryAttemptsTable(name string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + name + `
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  create_time timestamp DEFAULT ` + sqliteNow + `,
+  from_id text REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+  deliver_to text NOT NULL,
+  payload blob NOT NULL,
+  state text NOT NULL,
+  n_attempts integer NOT NULL,
+  last_attempt timestamp DEFAULT ` + sqliteNow + `,
+  next_attempt timestamp NOT NULL DEFAULT ` + sqliteNow + `
+);`
+}
+
+// createDeliveryAttemptsTableV1 is the delivery attempts table as created by
+// the initial schema migration.
+func (p *sqliteV0) createDeliveryAttemptsTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS delivery_attempts
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  create_time timestamp DEFAULT ` + sqliteNow + `,
+  from_id text REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+  deliver_to text NOT NULL,
+  payload blob NOT NULL,
+  state text NOT NULL,
+  n_attempts integer NOT NULL,
+  last_attempt timestamp DEFAULT ` + sqliteNow + `
+);`
+}
+
+func (p *sqliteV0) CreateIndexNextAttemptDeliveryAttemptsTable() string {
+	return `CREATE INDEX IF NOT EXISTS delivery_attempts_next_attempt_index ON delivery_attempts (state, julianday(next_attempt))`
+}
+
+func (p *sqliteV0) InsertAttempt() string {
+	return `INSERT INTO delivery_attempts (from_id, deliver_to, payload, state, n_attempts) VALUES (?1, ?2, ?3, ?4, 0) RETURNING id`
+}
+
+func (p *sqliteV0) MarkSuccessfulAttempt() string {
+	return p.markAttempt()
+}
+
+func (p *sqliteV0) MarkFailedAttempt() string {
+	return `UPDATE delivery_attempts
+SET
+  state = ?2,
+  n_attempts = n_attempts + 1,
+  last_attempt = ` + sqliteNow + `,
+  next_attempt = strftime('%Y-%m-%d %H:%M:%f', ?3)
+WHERE id = ?1`
+}
+
+func (p *sqliteV0) MarkAbandonedAttempt() string {
+	return p.markAttempt()
+}
+
+func (p *sqliteV0) markAttempt() string {
+	return `UPDATE delivery_attempts
+SET
+  state = ?2,
+  n_attempts = n_attempts + 1,
+  last_attempt = ` + sqliteNow + `
+WHERE id = ?1`
+}
+
+func (p *sqliteV0) GetDueAttempts() string {
+	return `SELECT id, from_id, deliver_to, payload, n_attempts, last_attempt, next_attempt
+FROM delivery_attempts
+WHERE state IN (?1, ?2) AND julianday(next_attempt) <= julianday(?3)
+ORDER BY julianday(next_attempt) ASC, id ASC
+LIMIT ?4`
+}
+
+func (p *sqliteV0) ClaimAttempt() string {
+	return `UPDATE delivery_attempts
+SET next_attempt = strftime('%Y-%m-%d %H:%M:%f', ?5)
+WHERE id = ?1 AND state IN (?2, ?3) AND julianday(next_attempt) <= julianday(?4)`
+}
+
+func (p *sqliteV0) ReleaseAttempt() string {
+	return `UPDATE delivery_attempts
+SET next_attempt = strftime('%Y-%m-%d %H:%M:%f', ?4)
+WHERE id = ?1 AND state IN (?2, ?3)`
+}
+
+func (p *sqliteV0) PostponeAttempts() string {
+	return `UPDATE delivery_attempts
+SET next_attempt = strftime('%Y-%m-%d %H:%M:%f', ?4)
+WHERE state IN (?1, ?2) AND deliver_to LIKE ?3 ESCAPE '\' AND julianday(next_attempt) < julianday(?4)`
+}
+
+func (p *sqliteV0) AbandonAttemptsTo() string {
+	return `UPDATE delivery_attempts
+SET state = ?4
+WHERE state IN (?1, ?2) AND deliver_to = ?3`
+}
+
+func (p *sqliteV0) GetAttempts() string {
+	return `SELECT id, from_id, deliver_to, state, n_attempts, create_time, last_attempt, next_attempt
+FROM delivery_attempts
+WHERE ` + p.filterAttempts() + `
+ORDER BY julianday(create_time) DESC, id DESC
+LIMIT ?5`
+}
+
+func (p *sqliteV0) RequeueAttempts() string {
+	return `UPDATE delivery_attempts
+SET
+  state = ?5,
+  n_attempts = 0,
+  next_attempt = ` + sqliteNow + `
+WHERE ` + p.filterAttempts()
+}
+
+func (p *sqliteV0) DeleteAttempts() string {
+	return `DELETE FROM delivery_attempts
+WHERE ` + p.filterAttempts()
+}
+
+// filterAttempts matches delivery attempts by the state, destination pattern,
+// sender, and creation time in the first four parameters.
+func (p *sqliteV0) filterAttempts() string {
+	return `(?1 = '' OR state = ?1)
+  AND (?2 = '' OR deliver_to LIKE ?2 ESCAPE '\')
+  AND (?3 = '' OR from_id = ?3)
+  AND julianday(create_time) < julianday(?4)`
+}
+
+func (p *sqliteV0) createGoneInboxesTableV5() string {
+	return `
+CREATE TABLE IF NOT EXISTS gone_inboxes
+(
+  inbox text PRIMARY KEY,
+  gone_at timestamp NOT NULL DEFAULT ` + sqliteNow + `
+);`
+}
+
+func (p *sqliteV0) CreateGoneActorsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS gone_actors
+(
+  actor_iri text PRIMARY KEY,
+  inbox text NOT NULL,
+  gone_at timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexInboxGoneActorsTable() string {
+	return `CREATE INDEX IF NOT EXISTS gone_actors_inbox_index ON gone_actors (inbox)`
+}
+
+func (p *sqliteV0) GoneActorInboxExists() string {
+	return `SELECT EXISTS (SELECT 1 FROM gone_actors WHERE inbox = ?1 AND julianday(expires) > julianday(?2))`
+}
+
+func (p *sqliteV0) UpsertGoneActor() string {
+	return `INSERT INTO gone_actors (actor_iri, inbox, expires) VALUES (?1, ?2, ?3)
+ON CONFLICT (actor_iri) DO UPDATE SET inbox = ?2, gone_at = ` + sqliteNow + `, expires = ?3`
+}
+
+func (p *sqliteV0) GetGoneActors() string {
+	return `SELECT actor_iri, inbox, gone_at, expires FROM gone_actors
+ORDER BY julianday(gone_at) DESC, actor_iri`
+}
+
+func (p *sqliteV0) DeleteGoneActor() string {
+	return `DELETE FROM gone_actors WHERE actor_iri = ?1`
+}
+
+func (p *sqliteV0) DeleteGoneActors() string {
+	return `DELETE FROM gone_actors`
+}
+
+func (p *sqliteV0) CreateDereferenceCacheTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS dereference_cache
+(
+  iri text PRIMARY KEY,
+  body blob NOT NULL,
+  etag text NOT NULL,
+  last_modified text NOT NULL,
+  fetch_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexExpiresDereferenceCacheTable() string {
+	return `CREATE INDEX IF NOT EXISTS dereference_cache_expires_index ON dereference_cache (julianday(expires))`
+}
+
+func (p *sqliteV0) GetDereferenceCache() string {
+	return `SELECT body, etag, last_modified, expires FROM dereference_cache WHERE iri = ?1`
+}
+
+func (p *sqliteV0) UpsertDereferenceCache() string {
+	return `INSERT INTO dereference_cache (iri, body, etag, last_modified, expires)
+VALUES (?1, ?2, ?3, ?4, strftime('%Y-%m-%d %H:%M:%f', ?5))
+ON CONFLICT (iri) DO UPDATE SET
+  body = excluded.body,
+  etag = excluded.etag,
+  last_modified = excluded.last_modified,
+  fetch_time = ` + sqliteNow + `,
+  expires = excluded.expires`
+}
+
+func (p *sqliteV0) RevalidateDereferenceCache() string {
+	return `UPDATE dereference_cache SET expires = strftime('%Y-%m-%d %H:%M:%f', ?2) WHERE iri = ?1`
+}
+
+func (p *sqliteV0) DeleteDereferenceCache() string {
+	return `DELETE FROM dereference_cache WHERE iri = ?1`
+}
+
+func (p *sqliteV0) PruneDereferenceCache() string {
+	return `DELETE FROM dereference_cache WHERE julianday(expires) < julianday(?1)`
+}
+
+func (p *sqliteV0) createRemotePublicKeysTableV7() string {
+	return `
+CREATE TABLE IF NOT EXISTS remote_public_keys
+(
+  key_id text PRIMARY KEY,
+  public_key_pem text NOT NULL,
+  fetch_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateRemotePublicKeysTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS remote_public_keys
+(
+  key_id text PRIMARY KEY,
+  public_key_pem text NOT NULL,
+  owner text NOT NULL,
+  fetch_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexExpiresRemotePublicKeysTable() string {
+	return `CREATE INDEX IF NOT EXISTS remote_public_keys_expires_index ON remote_public_keys (julianday(expires))`
+}
+
+func (p *sqliteV0) GetRemotePublicKey() string {
+	return `SELECT public_key_pem, owner, expires FROM remote_public_keys WHERE key_id = ?1`
+}
+
+func (p *sqliteV0) UpsertRemotePublicKey() string {
+	return `INSERT INTO remote_public_keys (key_id, public_key_pem, owner, expires)
+VALUES (?1, ?2, ?3, strftime('%Y-%m-%d %H:%M:%f', ?4))
+ON CONFLICT (key_id) DO UPDATE SET
+  public_key_pem = excluded.public_key_pem,
+  owner = excluded.owner,
+  fetch_time = ` + sqliteNow + `,
+  expires = excluded.expires`
+}
+
+func (p *sqliteV0) PruneRemotePublicKeys() string {
+	return `DELETE FROM remote_public_keys WHERE julianday(expires) < julianday(?1)`
+}
+
+func (p *sqliteV0) CreatePrivateKeysTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS private_keys
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  purpose text NOT NULL,
+  priv_key blob NOT NULL,
+  key_id text
+);`
+}
+
+// createPrivateKeysTableV1 is the private keys table as created by the initial
+// schema migration.
+func (p *sqliteV0) createPrivateKeysTableV1() string {
+	return `
+CREATE TABLE IF NOT EXISTS private_keys
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  purpose text NOT NULL,
+  priv_key blob NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreatePrivateKey() string {
+	return `INSERT INTO private_keys (user_id, purpose, priv_key) VALUES (?1, ?2, ?3)`
+}
+
+func (p *sqliteV0) GetPrivateKeyByUserID() string {
+	return `SELECT priv_key, key_id FROM private_keys WHERE user_id = ?1 AND purpose = ?2`
+}
+
+func (p *sqliteV0) GetPrivateKeyForInstanceActor() string {
+	return `SELECT
+  pk.priv_key,
+  pk.key_id
+FROM private_keys AS pk
+LEFT JOIN users AS u
+ON u.id = pk.user_id
+WHERE json_extract(u.privileges, '$.InstanceActor') = 1 AND purpose = ?1`
+}
+
+func (p *sqliteV0) RotatePrivateKey() string {
+	return `UPDATE private_keys SET priv_key = ?3, key_id = ?4 WHERE user_id = ?1 AND purpose = ?2`
+}
+
+func (p *sqliteV0) GetUsersWithoutPrivateKey() string {
+	return `SELECT u.id FROM users AS u
+WHERE NOT EXISTS (SELECT 1 FROM private_keys AS pk WHERE pk.user_id = u.id AND pk.purpose = ?1)`
+}
+
+func (p *sqliteV0) CreateRetiredKeysTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS retired_keys
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  key_id text NOT NULL,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateIndexExpiresRetiredKeysTable() string {
+	return `CREATE INDEX IF NOT EXISTS retired_keys_expires_index ON retired_keys (julianday(expires))`
+}
+
+func (p *sqliteV0) CreateRetiredKey() string {
+	return `INSERT INTO retired_keys (user_id, key_id, expires) VALUES (?1, ?2, strftime('%Y-%m-%d %H:%M:%f', ?3))`
+}
+
+func (p *sqliteV0) GetExpiredRetiredKeys() string {
+	return `SELECT id, user_id, key_id, expires FROM retired_keys WHERE julianday(expires) < julianday(?1)`
+}
+
+func (p *sqliteV0) DeleteRetiredKey() string {
+	return `DELETE FROM retired_keys WHERE id = ?1`
+}
+
+func (p *sqliteV0) CreateClientInfosTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS oauth_clients
+(
+  id text PRIMARY KEY,
+  secret text,
+  domain text NOT NULL,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateClientInfo() string {
+	return `INSERT INTO oauth_clients (id, secret, domain, user_id) VALUES (?1, ?2, ?3, ?4) RETURNING id`
+}
+
+func (p *sqliteV0) GetClientInfoByID() string {
+	return `SELECT id, secret, domain, user_id FROM oauth_clients WHERE id = ?1`
+}
+
+func (p *sqliteV0) DeleteClientInfo() string {
+	return `DELETE FROM oauth_clients WHERE id = ?1`
+}
+
+func (p *sqliteV0) CreateClientRegistrationsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS oauth_client_registrations
+(
+  client_id text PRIMARY KEY REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  metadata text NOT NULL,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `
+);`
+}
+
+func (p *sqliteV0) CreateClientRegistration() string {
+	return `INSERT INTO oauth_client_registrations (client_id, metadata) VALUES (?1, ?2)`
+}
+
+func (p *sqliteV0) GetClientRegistration() string {
+	return `SELECT client_id, metadata, create_time FROM oauth_client_registrations WHERE client_id = ?1`
+}
+
+func (p *sqliteV0) GetClientRegistrations() string {
+	return `SELECT client_id, metadata, create_time FROM oauth_client_registrations
+ORDER BY julianday(create_time) DESC, client_id`
+}
+
+func (p *sqliteV0) CreateTokenInfosTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS oauth_tokens
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  client_id text REFERENCES oauth_clients(id) ON DELETE CASCADE NOT NULL,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  redirect_uri text NOT NULL,
+  scope text NOT NULL,
+  code text,
+  code_create_at timestamp,
+  code_expires_in integer,
+  code_challenge text,
+  code_challenge_method text,
+  access text,
+  access_create_at timestamp,
+  access_expires_in integer,
+  refresh text,
+  refresh_create_at timestamp,
+  refresh_expires_in integer
+)`
+}
+
+func (p *sqliteV0) CreateTokenInfo() string {
+	return `INSERT INTO oauth_tokens
+(
+  client_id,
+  user_id,
+  redirect_uri,
+  scope,
+  code,
+  code_create_at,
+  code_expires_in,
+  code_challenge,
+  code_challenge_method,
+  access,
+  access_create_at,
+  access_expires_in,
+  refresh,
+  refresh_create_at,
+  refresh_expires_in
+) VALUES
+(
+  ?1,
+  ?2,
+  ?3,
+  ?4,
+  ?5,
+  ?6,
+  ?7,
+  ?8,
+  ?9,
+  ?10,
+  ?11,
+  ?12,
+  ?13,
+  ?14,
+  ?15
+) RETURNING id`
+}
+
+func (p *sqliteV0) RemoveTokenInfoByCode() string {
+	return `DELETE FROM oauth_tokens WHERE code = ?1`
+}
+
+func (p *sqliteV0) RemoveTokenInfoByAccess() string {
+	return `DELETE FROM oauth_tokens WHERE access = ?1`
+}
+
+func (p *sqliteV0) RemoveTokenInfoByRefresh() string {
+	return `DELETE FROM oauth_tokens WHERE refresh = ?1`
+}
+
+func (p *sqliteV0) GetTokenInfoByCode() string {
+	return p.getTokenInfoWhere("code = ?1")
+}
+
+func (p *sqliteV0) GetTokenInfoByAccess() string {
+	return p.getTokenInfoWhere("access = ?1")
+}
+
+func (p *sqliteV0) GetTokenInfoByRefresh() string {
+	return p.getTokenInfoWhere("refresh = ?1")
+}
+
+func (p *sqliteV0) GetTokenInfosForUser() string {
+	return `SELECT
+  ti.id,
+  ti.client_id,
+  ti.user_id,
+  ti.redirect_uri,
+  ti.scope,
+  ti.code,
+  ti.code_create_at,
+  ti.code_expires_in,
+  ti.code_challenge,
+  ti.code_challenge_method,
+  ti.access,
+  ti.access_create_at,
+  ti.access_expires_in,
+  ti.refresh,
+  ti.refresh_create_at,
+  ti.refresh_expires_in,
+  fpc.create_time,
+  json_extract(cr.metadata, '$.client_name')
+FROM oauth_tokens AS ti
+LEFT JOIN first_party_creds AS fpc
+ON fpc.token_id = ti.id
+LEFT JOIN oauth_client_registrations AS cr
+ON cr.client_id = ti.client_id
+WHERE ti.user_id = ?1 AND ti.access IS NOT NULL AND ti.access <> ''`
+}
+
+func (p *sqliteV0) RemoveTokenInfoForUser() string {
+	return `DELETE FROM oauth_tokens WHERE id = ?1 AND user_id = ?2`
+}
+
+func (p *sqliteV0) getTokenInfoWhere(where string) string {
+	return `SELECT
+  client_id,
+  user_id,
+  redirect_uri,
+  scope,
+  code,
+  code_create_at,
+  code_expires_in,
+  code_challenge,
+  code_challenge_method,
+  access,
+  access_create_at,
+  access_expires_in,
+  refresh,
+  refresh_create_at,
+  refresh_expires_in
+FROM oauth_tokens WHERE ` + where
+}
+
+/* Collection prototype queries */
+
+func (p *sqliteV0) createCollectionTable(name string) string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + name + `
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  actor_id text NOT NULL,
+  ` + name + ` text NOT NULL
+)`
+}
+
+func (p *sqliteV0) createCollectionIDIndex(name string) string {
+	return `CREATE INDEX IF NOT EXISTS ` + name + `_id_index ON ` + name + ` (json_extract(` + name + `, '$.id'));`
+}
+
+func (p *sqliteV0) insertCollection(name string) string {
+	return `INSERT INTO ` + name + ` (actor_id, ` + name + `) VALUES (?1, CAST(?2 AS TEXT))`
+}
+
+func (p *sqliteV0) collectionContainsForActor(name string) string {
+	return p.containsForActor(name, name, "items")
+}
+
+func (p *sqliteV0) collectionContains(name string) string {
+	return p.contains(name, name, "items")
+}
+
+func (p *sqliteV0) getCollection(name string) string {
+	return p.getPage(name, name, "items", "CollectionPage")
+}
+
+func (p *sqliteV0) getCollectionLastPage(name string) string {
+	return p.getLastPage(name, name, "items", "CollectionPage")
+}
+
+func (p *sqliteV0) prependCollectionItem(name string) string {
+	return p.prependItem(name, name, "items")
+}
+
+func (p *sqliteV0) deleteCollectionItem(name string) string {
+	return p.deleteItem(name, name, "items")
+}
+
+func (p *sqliteV0) getAllCollectionForActor(name string) string {
+	return `SELECT ` + name + `
+FROM ` + name + `
+WHERE actor_id = ?1`
+}
+
+/* Shared collection and ordered collection queries */
+
+func (p *sqliteV0) orderedContainsForActor(table, col string) string {
+	return p.containsForActor(table, col, "orderedItems")
+}
+
+func (p *sqliteV0) orderedContains(table, col string) string {
+	return p.contains(table, col, "orderedItems")
+}
+
+func (p *sqliteV0) containsForActor(table, col, items string) string {
+	return `SELECT EXISTS (
+  SELECT 1
+  FROM ` + table + `
+  WHERE actor_id = ?1 AND ` + p.jsonHas(col, "$."+items, "?2") + `
+  LIMIT 1
+)`
+}
+
+func (p *sqliteV0) contains(table, col, items string) string {
+	return `SELECT EXISTS (
+  SELECT 1
+  FROM ` + table + `
+  WHERE json_extract(` + col + `, '$.id') = ?1 AND ` + p.jsonHas(col, "$."+items, "?2") + `
+  LIMIT 1
+)`
+}
+
+// getPage returns the items with indices between ?2 and ?3 inclusive, as well
+// as whether the page is the last one.
+func (p *sqliteV0) getPage(table, col, items, pageType string) string {
+	return `WITH c AS (
+  SELECT ` + col + ` AS doc
+  FROM ` + table + `
+  WHERE json_extract(` + col + `, '$.id') = ?1
+),
+page AS (
+  SELECT
+    json_group_array(e.value ORDER BY e.key) AS page
+  FROM c, json_each(c.doc, '$.` + items + `') AS e
+  WHERE e.key BETWEEN ?2 AND ?3
+)
+SELECT
+  json_set(
+    c.doc,
+    '$.` + items + `',
+    json(page.page),
+    '$.totalItems',
+    json_array_length(page.page),
+    '$.type',
+    '` + pageType + `'),
+  ?3 + 1 >= COALESCE(json_array_length(c.doc, '$.` + items + `'), 0)
+FROM c, page`
+}
+
+// getLastPage returns the last ?2 items, as well as the index of the first
+// item returned.
+func (p *sqliteV0) getLastPage(table, col, items, pageType string) string {
+	return `WITH c AS (
+  SELECT
+    ` + col + ` AS doc,
+    MAX(0, COALESCE(json_array_length(` + col + `, '$.` + items + `'), 0) - ?2) AS startIndex
+  FROM ` + table + `
+  WHERE json_extract(` + col + `, '$.id') = ?1
+),
+page AS (
+  SELECT
+    json_group_array(e.value ORDER BY e.key) AS page
+  FROM c, json_each(c.doc, '$.` + items + `') AS e
+  WHERE e.key >= c.startIndex
+)
+SELECT
+  json_set(
+    c.doc,
+    '$.` + items + `',
+    json(page.page),
+    '$.totalItems',
+    json_array_length(page.page),
+    '$.type',
+    '` + pageType + `'),
+  c.startIndex
+FROM c, page`
+}
+
+// publicItems is a common table expression listing the items of the ordered
+// collection `c` that are addressed to the Public collection, re-indexed from
+// zero.
+func (p *sqliteV0) publicItems() string {
+	return `public_items AS (
+  SELECT
+    e.value AS item,
+    ROW_NUMBER() OVER (ORDER BY e.key) - 1 AS idx
+  FROM c, json_each(c.doc, '$.orderedItems') AS e
+  WHERE EXISTS (
+    SELECT 1
+    FROM fed_data AS fd
+    WHERE json_extract(fd.payload, '$.id') = e.value
+      AND ` + p.isPublic("fd.payload") + `)
+  OR EXISTS (
+    SELECT 1
+    FROM local_data AS ld
+    WHERE json_extract(ld.payload, '$.id') = e.value
+      AND ` + p.isPublic("ld.payload") + `)
+)`
+}
+
+func (p *sqliteV0) getPublicPage(table, col string) string {
+	return `WITH c AS (
+  SELECT ` + col + ` AS doc
+  FROM ` + table + `
+  WHERE json_extract(` + col + `, '$.id') = ?1
+),
+` + p.publicItems() + `,
+page AS (
+  SELECT
+    json_group_array(item ORDER BY idx) AS page
+  FROM public_items
+  WHERE idx BETWEEN ?2 AND ?3
+)
+SELECT
+  json_set(
+    c.doc,
+    '$.orderedItems',
+    json(page.page),
+    '$.totalItems',
+    json_array_length(page.page),
+    '$.type',
+    'OrderedCollectionPage'),
+  ?3 + 1 >= (SELECT COUNT(*) FROM public_items)
+FROM c, page`
+}
+
+func (p *sqliteV0) getPublicLastPage(table, col string) string {
+	return `WITH c AS (
+  SELECT ` + col + ` AS doc
+  FROM ` + table + `
+  WHERE json_extract(` + col + `, '$.id') = ?1
+),
+` + p.publicItems() + `,
+stats AS (
+  SELECT MAX(0, COUNT(*) - ?2) AS startIndex
+  FROM public_items
+),
+page AS (
+  SELECT
+    json_group_array(item ORDER BY idx) AS page
+  FROM public_items, stats
+  WHERE idx >= stats.startIndex
+)
+SELECT
+  json_set(
+    c.doc,
+    '$.orderedItems',
+    json(page.page),
+    '$.totalItems',
+    json_array_length(page.page),
+    '$.type',
+    'OrderedCollectionPage'),
+  stats.startIndex
+FROM c, page, stats`
+}
+
+func (p *sqliteV0) prependItem(table, col, items string) string {
+	return `UPDATE ` + table + `
+SET ` + col + ` = json_set(
+  ` + col + `,
+  '$.` + items + `',
+  json((
+    SELECT json_group_array(i.value ORDER BY i.k)
+    FROM (
+      SELECT -1 AS k, ?2 AS value
+      UNION ALL
+      SELECT key AS k, value FROM json_each(` + col + `, '$.` + items + `')) AS i)),
+  '$.totalItems',
+  COALESCE(json_extract(` + col + `, '$.totalItems'), 0) + 1)
+WHERE json_extract(` + col + `, '$.id') = ?1`
+}
+
+func (p *sqliteV0) deleteItem(table, col, items string) string {
+	return `UPDATE ` + table + `
+SET ` + col + ` = json_set(
+  ` + col + `,
+  '$.` + items + `',
+  json((
+    SELECT json_group_array(value ORDER BY key)
+    FROM json_each(` + col + `, '$.` + items + `')
+    WHERE value IS NOT ?2)),
+  '$.totalItems',
+  COALESCE(json_extract(` + col + `, '$.totalItems'), 0) - 1)
+WHERE json_extract(` + col + `, '$.id') = ?1`
+}
+
+/* Collections */
+
+func (p *sqliteV0) CreateFollowersTable() string {
+	return p.createCollectionTable(v0Followers)
+}
+
+func (p *sqliteV0) CreateIndexIDFollowersTable() string {
+	return p.createCollectionIDIndex(v0Followers)
+}
+
+func (p *sqliteV0) InsertFollowers() string {
+	return p.insertCollection(v0Followers)
+}
+
+func (p *sqliteV0) FollowersContainsForActor() string {
+	return p.collectionContainsForActor(v0Followers)
+}
+
+func (p *sqliteV0) FollowersContains() string {
+	return p.collectionContains(v0Followers)
+}
+
+func (p *sqliteV0) GetFollowers() string {
+	return p.getCollection(v0Followers)
+}
+
+func (p *sqliteV0) GetFollowersLastPage() string {
+	return p.getCollectionLastPage(v0Followers)
+}
+
+func (p *sqliteV0) PrependFollowersItem() string {
+	return p.prependCollectionItem(v0Followers)
+}
+
+func (p *sqliteV0) DeleteFollowersItem() string {
+	return p.deleteCollectionItem(v0Followers)
+}
+
+func (p *sqliteV0) GetAllFollowersForActor() string {
+	return p.getAllCollectionForActor(v0Followers)
+}
+
+func (p *sqliteV0) CreateFollowingTable() string {
+	return p.createCollectionTable(v0Following)
+}
+
+func (p *sqliteV0) CreateIndexIDFollowingTable() string {
+	return p.createCollectionIDIndex(v0Following)
+}
+
+func (p *sqliteV0) InsertFollowing() string {
+	return p.insertCollection(v0Following)
+}
+
+func (p *sqliteV0) FollowingContainsForActor() string {
+	return p.collectionContainsForActor(v0Following)
+}
+
+func (p *sqliteV0) FollowingContains() string {
+	return p.collectionContains(v0Following)
+}
+
+func (p *sqliteV0) GetFollowing() string {
+	return p.getCollection(v0Following)
+}
+
+func (p *sqliteV0) GetFollowingLastPage() string {
+	return p.getCollectionLastPage(v0Following)
+}
+
+func (p *sqliteV0) PrependFollowingItem() string {
+	return p.prependCollectionItem(v0Following)
+}
+
+func (p *sqliteV0) DeleteFollowingItem() string {
+	return p.deleteCollectionItem(v0Following)
+}
+
+func (p *sqliteV0) GetAllFollowingForActor() string {
+	return p.getAllCollectionForActor(v0Following)
+}
+
+func (p *sqliteV0) CreateLikedTable() string {
+	return p.createCollectionTable(v0Liked)
+}
+
+func (p *sqliteV0) CreateIndexIDLikedTable() string {
+	return p.createCollectionIDIndex(v0Liked)
+}
+
+func (p *sqliteV0) InsertLiked() string {
+	return p.insertCollection(v0Liked)
+}
+
+func (p *sqliteV0) LikedContainsForActor() string {
+	return p.collectionContainsForActor(v0Liked)
+}
+
+func (p *sqliteV0) LikedContains() string {
+	return p.collectionContains(v0Liked)
+}
+
+func (p *sqliteV0) GetLiked() string {
+	return p.getCollection(v0Liked)
+}
+
+func (p *sqliteV0) GetLikedLastPage() string {
+	return p.getCollectionLastPage(v0Liked)
+}
+
+func (p *sqliteV0) PrependLikedItem() string {
+	return p.prependCollectionItem(v0Liked)
+}
+
+func (p *sqliteV0) DeleteLikedItem() string {
+	return p.deleteCollectionItem(v0Liked)
+}
+
+func (p *sqliteV0) GetAllLikedForActor() string {
+	return p.getAllCollectionForActor(v0Liked)
+}
+
+func (p *sqliteV0) CreatePoliciesTable() string {
+	return `CREATE TABLE IF NOT EXISTS policies
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  actor_id text NOT NULL,
+  purpose text NOT NULL,
+  policy text NOT NULL,
+  scope text NOT NULL DEFAULT 'actor',
+  domain text NOT NULL DEFAULT ''
+)`
+}
+
+// createPoliciesTableV1 is the policies table as created by the initial
+// schema migration, before policies were scoped.
+func (p *sqliteV0) createPoliciesTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS policies
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  actor_id text NOT NULL,
+  purpose text NOT NULL,
+  policy text NOT NULL
+)`
+}
+
+func (p *sqliteV0) CreateIndexScopePoliciesTable() string {
+	return `CREATE INDEX IF NOT EXISTS policies_scope_index ON policies (scope, domain)`
+}
+
+func (p *sqliteV0) CreatePolicy() string {
+	return `INSERT INTO policies (actor_id, purpose, policy) VALUES (?1, ?2, CAST(?3 AS TEXT)) RETURNING id`
+}
+
+func (p *sqliteV0) GetPoliciesForActor() string {
+	return `SELECT id, purpose, policy FROM policies WHERE actor_id = ?1 AND scope = 'actor'`
+}
+
+func (p *sqliteV0) GetPoliciesForActorAndPurpose() string {
+	return `SELECT id, policy FROM policies WHERE actor_id = ?1 AND purpose = ?2 AND scope = 'actor'`
+}
+
+func (p *sqliteV0) CreateScopedPolicy() string {
+	return `INSERT INTO policies (actor_id, scope, domain, purpose, policy) VALUES ('', ?1, ?2, ?3, CAST(?4 AS TEXT)) RETURNING id`
+}
+
+func (p *sqliteV0) GetInstancePolicies() string {
+	return `SELECT id, purpose, policy FROM policies WHERE scope = 'instance'`
+}
+
+func (p *sqliteV0) GetPoliciesForDomain() string {
+	return `SELECT id, purpose, policy FROM policies WHERE scope = 'domain' AND (domain = ?1 OR substr(?1, -length(domain) - 1) = '.' || domain)`
+}
+
+func (p *sqliteV0) GetScopedPolicies() string {
+	return `SELECT id, scope, domain, purpose, policy FROM policies WHERE scope <> 'actor' ORDER BY scope, domain`
+}
+
+func (p *sqliteV0) DeleteScopedPolicy() string {
+	return `DELETE FROM policies WHERE id = ?1 AND scope <> 'actor'`
+}
+
+func (p *sqliteV0) GetPolicy() string {
+	return `SELECT id, actor_id, scope, domain, purpose, policy FROM policies WHERE id = ?1`
+}
+
+func (p *sqliteV0) UpdatePolicy() string {
+	return `UPDATE policies SET purpose = ?2, policy = CAST(?3 AS TEXT) WHERE id = ?1`
+}
+
+func (p *sqliteV0) DeletePolicy() string {
+	return `DELETE FROM policies WHERE id = ?1`
+}
+
+func (p *sqliteV0) CreateResolutionsTable() string {
+	return p.createResolutionsTable("resolutions")
+}
+
+// createResolutionsTable creates the latest resolutions table with the given
+// name, so that migrations can rebuild it.
+func (p *sqliteV0) createResolutionsTable(name string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + name + `
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  policy_id text REFERENCES policies(id) ON DELETE CASCADE NOT NULL,
+  data_iri text NOT NULL,
+  resolution text NOT NULL,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `
+)`
+}
+
+// createResolutionsTableV1 is the resolutions table as created by the initial
+// schema migration, before resolutions recorded their creation time.
+func (p *sqliteV0) createResolutionsTableV1() string {
+	return `CREATE TABLE IF NOT EXISTS resolutions
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  policy_id text REFERENCES policies(id) ON DELETE CASCADE NOT NULL,
+  data_iri text NOT NULL,
+  resolution text NOT NULL
+)`
+}
+
+func (p *sqliteV0) CreateIndexPolicyIDResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_policy_id_index ON resolutions (policy_id)`
+}
+
+func (p *sqliteV0) CreateIndexDataIRIResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_data_iri_index ON resolutions (data_iri)`
+}
+
+func (p *sqliteV0) CreateIndexCreateTimeResolutionsTable() string {
+	return `CREATE INDEX IF NOT EXISTS resolutions_create_time_index ON resolutions (julianday(create_time))`
+}
+
+func (p *sqliteV0) CreateResolution() string {
+	return `INSERT INTO resolutions (policy_id, data_iri, resolution) VALUES (?1, ?2, CAST(?3 AS TEXT))`
+}
+
+func (p *sqliteV0) GetResolutionsForPolicy() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM resolutions
+WHERE policy_id = ?1
+ORDER BY julianday(create_time) DESC, id
+LIMIT ?3 OFFSET ?2`
+}
+
+func (p *sqliteV0) GetResolutionsForDataIRI() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM resolutions
+WHERE data_iri = ?1
+ORDER BY julianday(create_time) DESC, id
+LIMIT ?3 OFFSET ?2`
+}
+
+func (p *sqliteV0) GetResolutionsInTimeRange() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM resolutions
+WHERE julianday(create_time) >= julianday(?1) AND julianday(create_time) < julianday(?2)
+ORDER BY julianday(create_time) DESC, id
+LIMIT ?4 OFFSET ?3`
+}
+
+func (p *sqliteV0) GetResolutionsByMatched() string {
+	return `SELECT id, policy_id, data_iri, resolution, create_time FROM resolutions
+WHERE json_extract(resolution, '$.matched') = ?1
+ORDER BY julianday(create_time) DESC, id
+LIMIT ?3 OFFSET ?2`
+}
+
+func (p *sqliteV0) DeleteResolutionsBefore() string {
+	return `DELETE FROM resolutions WHERE julianday(create_time) < julianday(?1)`
+}
+
+func (p *sqliteV0) CreateFirstPartyCredentialsTable() string {
+	return `CREATE TABLE IF NOT EXISTS first_party_creds
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  token_id text REFERENCES oauth_tokens(id) ON DELETE CASCADE NOT NULL,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  expiration_time timestamp NOT NULL
+)`
+}
+
+func (p *sqliteV0) CreateFirstPartyCredential() string {
+	return `INSERT INTO first_party_creds (user_id, token_id, expiration_time) VALUES (?1, ?2, ?3) RETURNING id`
+}
+
+func (p *sqliteV0) UpdateFirstPartyCredential() string {
+	return `UPDATE oauth_tokens
+SET
+  client_id = ?2,
+  user_id = ?3,
+  redirect_uri = ?4,
+  scope = ?5,
+  code = ?6,
+  code_create_at = ?7,
+  code_expires_in = ?8,
+  code_challenge = ?9,
+  code_challenge_method = ?10,
+  access = ?11,
+  access_create_at = ?12,
+  access_expires_in = ?13,
+  refresh = ?14,
+  refresh_create_at = ?15,
+  refresh_expires_in = ?16
+WHERE id = (
+  SELECT token_id
+  FROM first_party_creds
+  WHERE id = ?1
+)`
+}
+
+func (p *sqliteV0) UpdateFirstPartyCredentialExpires() string {
+	return `UPDATE first_party_creds SET expiration_time = ?2 WHERE id = ?1`
+}
+
+func (p *sqliteV0) RemoveFirstPartyCredential() string {
+	return `DELETE FROM oauth_tokens
+WHERE id IN (SELECT token_id FROM first_party_creds WHERE id = ?1)`
+}
+
+func (p *sqliteV0) RemoveExpiredFirstPartyCredentials() string {
+	return `DELETE FROM oauth_tokens
+WHERE id IN (
+  SELECT token_id
+  FROM first_party_creds
+  WHERE julianday(expiration_time) < julianday('now')
+)`
+}
+
+func (p *sqliteV0) GetTokenInfoForCredentialID() string {
+	return `SELECT
+  ti.client_id,
+  ti.user_id,
+  ti.redirect_uri,
+  ti.scope,
+  ti.code,
+  ti.code_create_at,
+  ti.code_expires_in,
+  ti.code_challenge,
+  ti.code_challenge_method,
+  ti.access,
+  ti.access_create_at,
+  ti.access_expires_in,
+  ti.refresh,
+  ti.refresh_create_at,
+  ti.refresh_expires_in
+FROM first_party_creds AS fpc
+INNER JOIN oauth_tokens AS ti
+ON fpc.token_id = ti.id
+WHERE fpc.id = ?1`
+}
+
+func (p *sqliteV0) CreateTOTPSecretsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS totp_secrets
+(
+  user_id text PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  secret blob NOT NULL,
+  confirmed boolean NOT NULL DEFAULT false,
+  last_step integer NOT NULL DEFAULT 0,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `
+);`
+}
+
+func (p *sqliteV0) CreateTOTPSecret() string {
+	return `INSERT INTO totp_secrets (user_id, secret) VALUES (?1, ?2)`
+}
+
+func (p *sqliteV0) GetTOTPSecret() string {
+	return `SELECT secret, confirmed, last_step FROM totp_secrets WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) ConfirmTOTPSecret() string {
+	return `UPDATE totp_secrets SET confirmed = true WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) UpdateTOTPLastStep() string {
+	return `UPDATE totp_secrets SET last_step = ?2 WHERE user_id = ?1 AND last_step < ?2`
+}
+
+func (p *sqliteV0) DeleteTOTPSecret() string {
+	return `DELETE FROM totp_secrets WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) CreateRecoveryCodesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  hashed_code blob NOT NULL,
+  UNIQUE (user_id, hashed_code)
+);`
+}
+
+func (p *sqliteV0) CreateRecoveryCode() string {
+	return `INSERT INTO recovery_codes (user_id, hashed_code) VALUES (?1, ?2)`
+}
+
+func (p *sqliteV0) CountRecoveryCodes() string {
+	return `SELECT count(*) FROM recovery_codes WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) UseRecoveryCode() string {
+	return `DELETE FROM recovery_codes WHERE user_id = ?1 AND hashed_code = ?2`
+}
+
+func (p *sqliteV0) DeleteRecoveryCodes() string {
+	return `DELETE FROM recovery_codes WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) CreateWebAuthnCredentialsTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+  id text PRIMARY KEY DEFAULT ` + sqliteUUID + `,
+  user_id text REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  credential_id blob NOT NULL UNIQUE,
+  public_key blob NOT NULL,
+  sign_count integer NOT NULL,
+  name text NOT NULL,
+  create_time timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  last_used_time timestamp
+);`
+}
+
+func (p *sqliteV0) CreateWebAuthnCredential() string {
+	return `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name) VALUES (?1, ?2, ?3, ?4, ?5) RETURNING id`
+}
+
+func (p *sqliteV0) GetWebAuthnCredentialsForUser() string {
+	return `SELECT id, credential_id, public_key, sign_count, name, create_time, last_used_time FROM webauthn_credentials
+WHERE user_id = ?1
+ORDER BY julianday(create_time), id`
+}
+
+func (p *sqliteV0) UpdateWebAuthnCredentialSignCount() string {
+	return `UPDATE webauthn_credentials SET sign_count = ?2, last_used_time = ` + sqliteNow + ` WHERE id = ?1`
+}
+
+func (p *sqliteV0) DeleteWebAuthnCredential() string {
+	return `DELETE FROM webauthn_credentials WHERE id = ?1 AND user_id = ?2`
+}
+
+func (p *sqliteV0) CreateWebAuthnChallengesTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+  challenge text PRIMARY KEY,
+  expires timestamp NOT NULL
+);`
+}
+
+func (p *sqliteV0) CreateWebAuthnChallenge() string {
+	return `INSERT INTO webauthn_challenges (challenge, expires) VALUES (?1, ?2)`
+}
+
+func (p *sqliteV0) UseWebAuthnChallenge() string {
+	return `DELETE FROM webauthn_challenges WHERE challenge = ?1 AND julianday(expires) > julianday(?2)`
+}
+
+func (p *sqliteV0) DeleteExpiredWebAuthnChallenges() string {
+	return `DELETE FROM webauthn_challenges WHERE julianday(expires) <= julianday(?1)`
+}
+
+func (p *sqliteV0) CreateSecondFactorFailuresTable() string {
+	return `
+CREATE TABLE IF NOT EXISTS second_factor_failures
+(
+  user_id text PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  n_failures integer NOT NULL,
+  locked_until timestamp
+);`
+}
+
+func (p *sqliteV0) GetSecondFactorFailures() string {
+	return `SELECT n_failures, locked_until FROM second_factor_failures WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) UpsertSecondFactorFailures() string {
+	return `INSERT INTO second_factor_failures (user_id, n_failures, locked_until) VALUES (?1, ?2, ?3)
+ON CONFLICT (user_id) DO UPDATE SET n_failures = ?2, locked_until = ?3`
+}
+
+func (p *sqliteV0) DeleteSecondFactorFailures() string {
+	return `DELETE FROM second_factor_failures WHERE user_id = ?1`
+}
+
+func (p *sqliteV0) GetOpenFollowRequests() string {
+	return `WITH follows_received AS (
+  SELECT payload
+  FROM local_data
+  WHERE ` + p.jsonHas("payload", "$.type", "'Follow'") + `
+    AND json_extract(payload, '$.object') = ?1
+  UNION
+  SELECT payload
+  FROM fed_data
+  WHERE ` + p.jsonHas("payload", "$.type", "'Follow'") + `
+    AND json_extract(payload, '$.object') = ?1
+),
+accept_reject_follows AS (
+  SELECT
+    COALESCE(json_extract(payload, '$.object.id'), json_extract(payload, '$.object')) AS ap_id
+  FROM local_data
+  WHERE (` + p.jsonHas("payload", "$.type", "'Accept'") + `
+    OR ` + p.jsonHas("payload", "$.type", "'Reject'") + `)
+    AND json_extract(payload, '$.actor') = ?1
+)
+SELECT fr.payload
+FROM follows_received AS fr
+WHERE NOT EXISTS (
+  SELECT 1
+  FROM accept_reject_follows AS arf
+  WHERE arf.ap_id = json_extract(fr.payload, '$.id')
+)`
+}
+
+func (p *sqliteV0) CreateSchemaMigrationsTable() string {
+	return `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+  component text NOT NULL,
+  version integer NOT NULL,
+  description text NOT NULL,
+  applied_at timestamp NOT NULL DEFAULT ` + sqliteNow + `,
+  PRIMARY KEY (component, version)
+)`
+}
+
+func (p *sqliteV0) GetSchemaMigrations() string {
+	return `SELECT version, description, applied_at FROM schema_migrations WHERE component = ?1 ORDER BY version ASC`
+}
+
+func (p *sqliteV0) InsertSchemaMigration() string {
+	return `INSERT INTO schema_migrations (component, version, description) VALUES (?1, ?2, ?3)`
+}
+
+func (p *sqliteV0) DeleteSchemaMigration() string {
+	return `DELETE FROM schema_migrations WHERE component = ?1 AND version = ?2`
+}
+
+/* Migrations */
+
+func (p *sqliteV0) Migrations() []models.Migration {
+	return []models.Migration{
+		{
+			Version:     1,
+			Description: "initial schema",
+			Up: []string{
+				p.CreateUsersTable(),
+				p.CreateFedDataTable(),
+				p.CreateIndexIDFedDataTable(),
+				p.CreateLocalDataTable(),
+				p.CreateIndexIDLocalDataTable(),
+				p.CreateInboxesTable(),
+				p.CreateIndexIDInboxesTable(),
+				p.CreateOutboxesTable(),
+				p.CreateIndexIDOutboxesTable(),
+				p.createDeliveryAttemptsTableV1(),
+				p.createPrivateKeysTableV1(),
+				p.CreateClientInfosTable(),
+				p.CreateTokenInfosTable(),
+				p.CreateFirstPartyCredentialsTable(),
+				p.CreateFollowingTable(),
+				p.CreateIndexIDFollowingTable(),
+				p.CreateFollowersTable(),
+				p.CreateIndexIDFollowersTable(),
+				p.CreateLikedTable(),
+				p.CreateIndexIDLikedTable(),
+				p.createPoliciesTableV1(),
+				p.createResolutionsTableV1(),
+			},
+			Down: p.dropTables(
+				"resolutions",
+				"policies",
+				v0Liked,
+				v0Followers,
+				v0Following,
+				"first_party_creds",
+				"oauth_tokens",
+				"oauth_clients",
+				"private_keys",
+				"delivery_attempts",
+				"outboxes",
+				"inboxes",
+				"local_data",
+				"fed_data",
+				"users"),
+		},
+		{
+			Version:     2,
+			Description: "instance and domain scoped policies",
+			Up: []string{
+				`ALTER TABLE policies ADD COLUMN scope text NOT NULL DEFAULT 'actor'`,
+				`ALTER TABLE policies ADD COLUMN domain text NOT NULL DEFAULT ''`,
+				p.CreateIndexScopePoliciesTable(),
+			},
+			Down: []string{
+				`DELETE FROM policies WHERE scope <> 'actor'`,
+				`DROP INDEX IF EXISTS policies_scope_index`,
+				`ALTER TABLE policies DROP COLUMN domain`,
+				`ALTER TABLE policies DROP COLUMN scope`,
+			},
+		},
+		{
+			Version:     3,
+			Description: "resolution creation time",
+			// SQLite cannot add a column with a non-constant default, so
+			// the table is rebuilt instead.
+			Up: []string{
+				p.createResolutionsTable("resolutions_v3"),
+				`INSERT INTO resolutions_v3 (id, policy_id, data_iri, resolution, create_time)
+SELECT id, policy_id, data_iri, resolution, COALESCE(strftime('%Y-%m-%d %H:%M:%f', json_extract(resolution, '$.time')), ` + sqliteNow + `)
+FROM resolutions`,
+				`DROP TABLE resolutions`,
+				`ALTER TABLE resolutions_v3 RENAME TO resolutions`,
+				p.CreateIndexPolicyIDResolutionsTable(),
+				p.CreateIndexDataIRIResolutionsTable(),
+				p.CreateIndexCreateTimeResolutionsTable(),
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS resolutions_create_time_index`,
+				`DROP INDEX IF EXISTS resolutions_data_iri_index`,
+				`DROP INDEX IF EXISTS resolutions_policy_id_index`,
+				`ALTER TABLE resolutions DROP COLUMN create_time`,
+			},
+		},
+		{
+			Version:     4,
+			Description: "delivery queue scheduling",
+			// SQLite cannot add a column with a non-constant default, so
+			// the table is rebuilt instead.
+			Up: []string{
+				p.createDeliveryAttemptsTable("delivery_attempts_v4"),
+				`INSERT INTO delivery_attempts_v4 (id, create_time, from_id, deliver_to, payload, state, n_attempts, last_attempt, next_attempt)
+SELECT id, create_time, from_id, deliver_to, payload, state, n_attempts, last_attempt, COALESCE(last_attempt, ` + sqliteNow + `)
+FROM delivery_attempts`,
+				`DROP TABLE delivery_attempts`,
+				`ALTER TABLE delivery_attempts_v4 RENAME TO delivery_attempts`,
+				p.CreateIndexNextAttemptDeliveryAttemptsTable(),
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS delivery_attempts_next_attempt_index`,
+				`ALTER TABLE delivery_attempts DROP COLUMN next_attempt`,
+			},
+		},
+		{
+			Version:     5,
+			Description: "gone inboxes",
+			Up: []string{
+				p.createGoneInboxesTableV5(),
+			},
+			Down: p.dropTables("gone_inboxes"),
+		},
+		{
+			Version:     6,
+			Description: "dereference cache",
+			Up: []string{
+				p.CreateDereferenceCacheTable(),
+				p.CreateIndexExpiresDereferenceCacheTable(),
+			},
+			Down: p.dropTables("dereference_cache"),
+		},
+		{
+			Version:     7,
+			Description: "remote public keys",
+			Up: []string{
+				p.createRemotePublicKeysTableV7(),
+				p.CreateIndexExpiresRemotePublicKeysTable(),
+			},
+			Down: p.dropTables("remote_public_keys"),
+		},
+		{
+			Version:     8,
+			Description: "key rotation",
+			Up: []string{
+				`ALTER TABLE private_keys ADD COLUMN key_id text`,
+				p.CreateRetiredKeysTable(),
+				p.CreateIndexExpiresRetiredKeysTable(),
+			},
+			Down: []string{
+				`DROP TABLE IF EXISTS retired_keys`,
+				`ALTER TABLE private_keys DROP COLUMN key_id`,
+			},
+		},
+		{
+			Version:     9,
+			Description: "oauth2 client registration",
+			Up: []string{
+				p.CreateClientRegistrationsTable(),
+			},
+			Down: []string{
+				`DELETE FROM oauth_clients WHERE id IN (SELECT client_id FROM oauth_client_registrations)`,
+				`DROP TABLE IF EXISTS oauth_client_registrations`,
+			},
+		},
+		{
+			Version:     10,
+			Description: "two-factor authentication",
+			Up: []string{
+				p.CreateTOTPSecretsTable(),
+				p.CreateRecoveryCodesTable(),
+				p.CreateWebAuthnCredentialsTable(),
+			},
+			Down: p.dropTables(
+				"webauthn_credentials",
+				"recovery_codes",
+				"totp_secrets"),
+		},
+		{
+			Version:     11,
+			Description: "gone actors",
+			// Gone inboxes were not known to belong to a single actor,
+			// so they are forgotten rather than carried over.
+			Up: append([]string{
+				p.CreateGoneActorsTable(),
+				p.CreateIndexInboxGoneActorsTable(),
+			}, p.dropTables("gone_inboxes")...),
+			Down: append(
+				p.dropTables("gone_actors"),
+				p.createGoneInboxesTableV5()),
+		},
+		{
+			Version:     12,
+			Description: "second factor failures",
+			Up: []string{
+				p.CreateSecondFactorFailuresTable(),
+			},
+			Down: p.dropTables("second_factor_failures"),
+		},
+		{
+			Version:     13,
+			Description: "webauthn challenges",
+			Up: []string{
+				p.CreateWebAuthnChallengesTable(),
+			},
+			Down: p.dropTables("webauthn_challenges"),
+		},
+		{
+			Version:     14,
+			Description: "remote public key owners",
+			// The owners of cached keys are not known, so the keys
+			// are fetched again rather than carried over.
+			Up: append(p.dropTables("remote_public_keys"),
+				p.CreateRemotePublicKeysTable(),
+				p.CreateIndexExpiresRemotePublicKeysTable()),
+			Down: append(p.dropTables("remote_public_keys"),
+				p.createRemotePublicKeysTableV7(),
+				p.CreateIndexExpiresRemotePublicKeysTable()),
+		},
+	}
+}
+
+func (p *sqliteV0) dropTables(names ...string) (s []string) {
+	for _, n := range names {
+		s = append(s, `DROP TABLE IF EXISTS `+n)
+	}
+	return
+}
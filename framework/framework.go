@@ -18,13 +18,19 @@ package framework
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/oauth2"
+	"github.com/allinbits/apcore/framework/twofactor"
 	"github.com/allinbits/apcore/framework/web"
+	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/paths"
 	"github.com/allinbits/apcore/services"
 	"github.com/allinbits/apcore/util"
@@ -43,10 +49,15 @@ type Framework struct {
 	bCryptStrength    int
 	o                 *oauth2.Server
 	s                 *web.Sessions
+	tf                *twofactor.Server
 	data              *services.Data
 	followers         *services.Followers
 	users             *services.Users
+	policies          *services.Policies
 	actor             pub.Actor
+	instanceActor     pub.Actor
+	clock             pub.Clock
+	keyRotationGrace  time.Duration
 	federationEnabled bool
 }
 
@@ -55,13 +66,18 @@ func BuildFramework(scheme string,
 	rsaKeySize int,
 	saltSize int,
 	bCryptStrength int,
+	keyRotationGrace time.Duration,
 	fw *Framework,
 	o *oauth2.Server,
 	s *web.Sessions,
+	tf *twofactor.Server,
 	data *services.Data,
 	followers *services.Followers,
 	users *services.Users,
+	policies *services.Policies,
 	actor pub.Actor,
+	actorMap map[paths.Actor]pub.Actor,
+	clock pub.Clock,
 	a app.Application) *Framework {
 	_, isS2S := a.(app.S2SApplication)
 	fw.scheme = scheme
@@ -69,13 +85,20 @@ func BuildFramework(scheme string,
 	fw.rsaKeySize = rsaKeySize
 	fw.saltSize = saltSize
 	fw.bCryptStrength = bCryptStrength
+	fw.keyRotationGrace = keyRotationGrace
 	fw.o = o
 	fw.s = s
+	if _, ok := a.(app.TwoFactorApplication); ok {
+		fw.tf = tf
+	}
 	fw.data = data
 	fw.actor = actor
+	fw.instanceActor = actorMap[paths.InstanceActor]
+	fw.clock = clock
 	fw.federationEnabled = isS2S
 	fw.followers = followers
 	fw.users = users
+	fw.policies = policies
 	return fw
 }
 
@@ -118,6 +141,10 @@ func (f *Framework) Validate(w http.ResponseWriter, r *http.Request) (userID pat
 	return
 }
 
+func (f *Framework) RequestedScopes(r *http.Request) ([]app.Scope, error) {
+	return f.o.RequestedScopes(r)
+}
+
 func (f *Framework) Send(c context.Context, userID paths.UUID, t vocab.Type) error {
 	ctx := util.Context{c}
 	ctx.WithUserPathUUID(userID)
@@ -151,6 +178,229 @@ func (f *Framework) SetPrivileges(c context.Context, userID paths.UUID, admin bo
 	return f.users.UpdatePrivileges(util.Context{c}, string(userID), p)
 }
 
+func (f *Framework) CreateInstancePolicy(c context.Context, purpose string, policy []byte) (policyID string, err error) {
+	var p models.Policy
+	if err = json.Unmarshal(policy, &p); err != nil {
+		return
+	}
+	return f.policies.CreateInstancePolicy(util.Context{c}, models.Purpose(purpose), p)
+}
+
+func (f *Framework) CreateDomainPolicy(c context.Context, domain, purpose string, policy []byte) (policyID string, err error) {
+	var p models.Policy
+	if err = json.Unmarshal(policy, &p); err != nil {
+		return
+	}
+	return f.policies.CreateDomainPolicy(util.Context{c}, domain, models.Purpose(purpose), p)
+}
+
+func (f *Framework) ScopedPolicies(c context.Context) (sp []app.ScopedPolicy, err error) {
+	var po []models.ScopedPolicy
+	if po, err = f.policies.GetScopedPolicies(util.Context{c}); err != nil {
+		return
+	}
+	for _, p := range po {
+		var b []byte
+		if b, err = json.Marshal(p.Policy); err != nil {
+			return
+		}
+		sp = append(sp, app.ScopedPolicy{
+			ID:      p.ID,
+			Scope:   string(p.Scope),
+			Domain:  p.Domain,
+			Purpose: string(p.Purpose),
+			Policy:  b,
+		})
+	}
+	return
+}
+
+func (f *Framework) DeleteScopedPolicy(c context.Context, policyID string) error {
+	return f.policies.DeleteScopedPolicy(util.Context{c}, policyID)
+}
+
+func (f *Framework) ResolutionsForPolicy(c context.Context, policyID string, offset, limit int) ([]app.PolicyResolution, error) {
+	sr, err := f.policies.ResolutionsForPolicy(util.Context{c}, policyID, offset, limit)
+	return toPolicyResolutions(sr), err
+}
+
+func (f *Framework) ResolutionsForIRI(c context.Context, dataIRI *url.URL, offset, limit int) ([]app.PolicyResolution, error) {
+	sr, err := f.policies.ResolutionsForDataIRI(util.Context{c}, dataIRI, offset, limit)
+	return toPolicyResolutions(sr), err
+}
+
+func (f *Framework) ResolutionsInTimeRange(c context.Context, start, end time.Time, offset, limit int) ([]app.PolicyResolution, error) {
+	sr, err := f.policies.ResolutionsInTimeRange(util.Context{c}, start, end, offset, limit)
+	return toPolicyResolutions(sr), err
+}
+
+func (f *Framework) ResolutionsByMatched(c context.Context, matched bool, offset, limit int) ([]app.PolicyResolution, error) {
+	sr, err := f.policies.ResolutionsByMatched(util.Context{c}, matched, offset, limit)
+	return toPolicyResolutions(sr), err
+}
+
+func toPolicyResolutions(sr []models.StoredResolution) (pr []app.PolicyResolution) {
+	for _, s := range sr {
+		pr = append(pr, app.PolicyResolution{
+			ID:       s.ID,
+			PolicyID: s.PolicyID,
+			DataIRI:  s.IRI,
+			Time:     s.CreateTime,
+			Matched:  s.R.Matched,
+			MatchLog: s.R.MatchLog,
+		})
+	}
+	return
+}
+
+func (f *Framework) RegisteredClients(c context.Context) (rc []app.RegisteredClient, err error) {
+	var cr []models.ClientRegistration
+	if cr, err = f.o.RegisteredClients(util.Context{c}); err != nil {
+		return
+	}
+	for _, r := range cr {
+		rc = append(rc, app.RegisteredClient{
+			ID:              r.ClientID,
+			Name:            r.Metadata.ClientName,
+			RedirectURIs:    r.Metadata.RedirectURIs,
+			ClientURI:       r.Metadata.ClientURI,
+			Contacts:        r.Metadata.Contacts,
+			SoftwareID:      r.Metadata.SoftwareID,
+			SoftwareVersion: r.Metadata.SoftwareVersion,
+			RegisteredAt:    r.CreateTime,
+		})
+	}
+	return
+}
+
+func (f *Framework) RevokeClient(c context.Context, clientID string) error {
+	return f.o.RevokeClient(util.Context{c}, clientID)
+}
+
+func (f *Framework) UserSessions(c context.Context, userID paths.UUID) (us []app.UserSession, err error) {
+	var ut []models.UserToken
+	if ut, err = f.o.UserTokens(util.Context{c}, string(userID)); err != nil {
+		return
+	}
+	for _, t := range ut {
+		us = append(us, app.UserSession{
+			ID:         t.ID,
+			ClientID:   t.ClientID,
+			ClientName: t.ClientName.String,
+			FirstParty: t.CredCreated.Valid,
+			Scopes:     strings.Fields(t.Scope),
+			CreatedAt:  t.CreateTime(),
+		})
+	}
+	return
+}
+
+func (f *Framework) RevokeUserSession(c context.Context, userID paths.UUID, sessionID string) error {
+	return f.o.RevokeUserToken(util.Context{c}, string(userID), sessionID)
+}
+
+func (f *Framework) TwoFactorStatus(c context.Context, userID paths.UUID) (ts app.TwoFactorStatus, err error) {
+	if f.tf == nil {
+		return
+	}
+	var st services.TwoFactorStatus
+	if st, err = f.tf.Status(util.Context{c}, string(userID)); err != nil {
+		return
+	}
+	ts = app.TwoFactorStatus{
+		Enabled:           st.Enabled(),
+		TOTP:              st.TOTP,
+		RecoveryCodesLeft: st.RecoveryCodes,
+	}
+	for _, wc := range st.WebAuthnCredentials {
+		ts.WebAuthnCredentials = append(ts.WebAuthnCredentials, app.WebAuthnCredential{
+			ID:         wc.ID,
+			Name:       wc.Name,
+			CreatedAt:  wc.CreateTime,
+			LastUsedAt: wc.LastUsedTime.Time,
+		})
+	}
+	return
+}
+
+func (f *Framework) BeginTOTPEnrollment(c context.Context, userID paths.UUID) (app.TOTPEnrollment, error) {
+	if err := f.checkTwoFactor(); err != nil {
+		return app.TOTPEnrollment{}, err
+	}
+	return f.tf.BeginTOTP(util.Context{c}, string(userID))
+}
+
+func (f *Framework) ConfirmTOTPEnrollment(c context.Context, userID paths.UUID, code string) ([]string, error) {
+	if err := f.checkTwoFactor(); err != nil {
+		return nil, err
+	}
+	return f.tf.ConfirmTOTP(util.Context{c}, string(userID), code)
+}
+
+func (f *Framework) DisableTOTP(c context.Context, userID paths.UUID) error {
+	if err := f.checkTwoFactor(); err != nil {
+		return err
+	}
+	return f.tf.DisableTOTP(util.Context{c}, string(userID))
+}
+
+func (f *Framework) RegenerateRecoveryCodes(c context.Context, userID paths.UUID) ([]string, error) {
+	if err := f.checkTwoFactor(); err != nil {
+		return nil, err
+	}
+	return f.tf.RegenerateRecoveryCodes(util.Context{c}, string(userID))
+}
+
+func (f *Framework) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request, userID paths.UUID) (options []byte, err error) {
+	if err = f.checkTwoFactor(); err != nil {
+		return
+	}
+	var s *web.Session
+	if s, err = f.s.Get(r); err != nil {
+		return
+	} else if options, err = f.tf.BeginWebAuthnRegistration(util.Context{r.Context()}, s, string(userID)); err != nil {
+		return
+	}
+	err = s.Save(r, w)
+	return
+}
+
+func (f *Framework) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request, userID paths.UUID, name string, credential []byte) (recoveryCodes []string, err error) {
+	if err = f.checkTwoFactor(); err != nil {
+		return
+	}
+	var s *web.Session
+	if s, err = f.s.Get(r); err != nil {
+		return
+	}
+	recoveryCodes, err = f.tf.FinishWebAuthnRegistration(util.Context{r.Context()}, s, string(userID), name, credential)
+	// The challenge is used up even if the credential is not valid.
+	if serr := s.Save(r, w); err == nil {
+		err = serr
+	}
+	return
+}
+
+func (f *Framework) RemoveWebAuthnCredential(c context.Context, userID paths.UUID, credentialID string) error {
+	if err := f.checkTwoFactor(); err != nil {
+		return err
+	}
+	return f.tf.RemoveWebAuthnCredential(util.Context{c}, string(userID), credentialID)
+}
+
+func (f *Framework) IsInvalidSecondFactor(err error) bool {
+	return errors.Is(err, twofactor.ErrInvalidSecondFactor)
+}
+
+// checkTwoFactor returns an error if two-factor authentication is not
+// supported by the application.
+func (f *Framework) checkTwoFactor() error {
+	if f.tf == nil {
+		return fmt.Errorf("two-factor authentication requires the application to implement app.TwoFactorApplication")
+	}
+	return nil
+}
+
 func (f *Framework) Session(r *http.Request) (app.Session, error) {
 	return f.s.Get(r)
 }
@@ -248,6 +498,95 @@ func (f *Framework) SendRejectFollow(ctx context.Context, userID paths.UUID, fol
 	return nil
 }
 
+func (f *Framework) RotateKeys(ctx context.Context, userID paths.UUID) error {
+	now := f.clock.Now()
+	actor, err := f.users.RotateHTTPSignatureKey(util.Context{ctx}, userID, f.rsaKeySize, now, now.Add(f.keyRotationGrace))
+	if err != nil {
+		return err
+	}
+	if !f.federationEnabled {
+		return nil
+	}
+	update, err := f.actorUpdate(ctx, actor)
+	if err != nil {
+		return err
+	} else if update == nil {
+		return nil
+	}
+	// Deliver the Update
+	return f.Send(ctx, userID, update)
+}
+
+func (f *Framework) RotateInstanceActorKeys(ctx context.Context) error {
+	now := f.clock.Now()
+	userID, actor, err := f.users.RotateInstanceActorHTTPSignatureKey(util.Context{ctx}, f.rsaKeySize, now, now.Add(f.keyRotationGrace))
+	if err != nil {
+		return err
+	}
+	if !f.federationEnabled {
+		return nil
+	}
+	update, err := f.actorUpdate(ctx, actor)
+	if err != nil {
+		return err
+	} else if update == nil {
+		return nil
+	}
+	// Deliver the Update on behalf of the instance actor, which is not
+	// handled by the pub.Actor of users.
+	fa, ok := f.instanceActor.(pub.FederatingActor)
+	if !ok {
+		return fmt.Errorf("cannot Send: instance actor is not a pub.FederatingActor with federation enabled")
+	}
+	c := util.Context{ctx}
+	c.WithUserPathUUID(userID)
+	outboxIRI := paths.ActorIRIFor(f.scheme, f.host, paths.OutboxPathKey, paths.InstanceActor)
+	_, err = fa.Send(c.Context, outboxIRI, update)
+	return err
+}
+
+// actorUpdate builds an Update of the actor addressed to its followers, which
+// is nil if the actor has no followers.
+//
+// The followers are addressed individually rather than by their collection,
+// since the collection of the instance actor is not served.
+func (f *Framework) actorUpdate(ctx context.Context, actor vocab.Type) (vocab.ActivityStreamsUpdate, error) {
+	actorIRI, err := pub.GetId(actor)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := f.followers.GetAllForActor(util.Context{ctx}, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	items := followers.GetActivityStreamsItems()
+	if items == nil || items.Len() == 0 {
+		return nil, nil
+	}
+	update := streams.NewActivityStreamsUpdate()
+
+	me := streams.NewActivityStreamsActorProperty()
+	me.AppendIRI(actorIRI)
+	update.SetActivityStreamsActor(me)
+
+	op := streams.NewActivityStreamsObjectProperty()
+	if err := op.AppendType(actor); err != nil {
+		return nil, err
+	}
+	update.SetActivityStreamsObject(op)
+
+	to := streams.NewActivityStreamsToProperty()
+	for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+		id, err := pub.ToId(iter)
+		if err != nil {
+			return nil, err
+		}
+		to.AppendIRI(id)
+	}
+	update.SetActivityStreamsTo(to)
+	return update, nil
+}
+
 func (f *Framework) getValidFollow(ctx context.Context, userIRI *url.URL, followIRI *url.URL) (vocab.ActivityStreamsFollow, error) {
 	// Fetch the Follow from our database
 	tFollow, err := f.GetByIRI(ctx, followIRI)
@@ -22,13 +22,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/framework/conn"
 	"github.com/allinbits/apcore/framework/nodeinfo"
 	"github.com/allinbits/apcore/framework/oauth2"
+	"github.com/allinbits/apcore/framework/twofactor"
 	"github.com/allinbits/apcore/framework/web"
 	"github.com/allinbits/apcore/framework/webfinger"
 	"github.com/allinbits/apcore/paths"
@@ -40,8 +43,24 @@ import (
 )
 
 const (
-	LoginFormEmailKey    = "email"
-	LoginFormPasswordKey = "password"
+	LoginFormEmailKey              = "email"
+	LoginFormPasswordKey           = "password"
+	LoginFormTOTPCodeKey           = "totp_code"
+	LoginFormRecoveryCodeKey       = "recovery_code"
+	LoginFormWebAuthnCredentialKey = "webauthn_credential"
+)
+
+const (
+	// loginWebAuthnPath is where the second login step obtains the options
+	// for logging in with a WebAuthn credential.
+	loginWebAuthnPath = "/login/webauthn"
+	// secondFactorTimeout is how long a user has to provide a second
+	// factor after entering their password.
+	secondFactorTimeout = 5 * time.Minute
+)
+
+const (
+	debugHostLimitsPath = "/debug/host-limits"
 )
 
 func BuildHandler(r *Router,
@@ -61,9 +80,12 @@ func BuildHandler(r *Router,
 	following *services.Following,
 	followers *services.Followers,
 	liked *services.Liked,
+	policies *services.Policies,
+	tc *conn.Controller,
 	sqldb *sql.DB,
 	oauth *oauth2.Server,
 	sl *web.Sessions,
+	tf *twofactor.Server,
 	fw *Framework,
 	clock pub.Clock,
 	sw, apcore app.Software,
@@ -178,6 +200,13 @@ func BuildHandler(r *Router,
 	// Obtain the application's paths.
 	pt := a.Paths()
 
+	// Two-factor authentication is only used if the application renders
+	// the second login step.
+	tfa, isTwoFactor := a.(app.TwoFactorApplication)
+	if !isTwoFactor {
+		tf = nil
+	}
+
 	// POST/GET login, logout, and OAuth2 routes
 	r.NewRoute().
 		Path(pt.GetLoginPath()).
@@ -188,7 +217,24 @@ func BuildHandler(r *Router,
 		Path(pt.PostLoginPath()).
 		Methods("POST").
 		HandlerFunc(
-			postLoginFn(oauth, sl, db, badRequestHandler, internalErrorHandler, cy, pt))
+			postLoginFn(oauth, tf, sl, db, badRequestHandler, internalErrorHandler, cy, pt))
+	if isTwoFactor {
+		r.NewRoute().
+			Path(pt.GetLoginSecondFactorPath()).
+			Methods("GET").
+			HandlerFunc(
+				getLoginSecondFactorFn(sl, pt, internalErrorHandler, tfa.GetSecondFactorWebHandlerFunc(fr)))
+		r.NewRoute().
+			Path(pt.PostLoginSecondFactorPath()).
+			Methods("POST").
+			HandlerFunc(
+				postLoginSecondFactorFn(oauth, tf, sl, badRequestHandler, internalErrorHandler, pt))
+		r.NewRoute().
+			Path(loginWebAuthnPath).
+			Methods("POST").
+			HandlerFunc(
+				postLoginWebAuthnFn(tf, sl, internalErrorHandler))
+	}
 	r.NewRoute().
 		Path(pt.GetLogoutPath()).
 		Methods("GET").
@@ -203,14 +249,50 @@ func BuildHandler(r *Router,
 		Path(pt.PostOAuth2AuthorizePath()).
 		Methods("POST").
 		HandlerFunc(
-			postAuthFn(oauth, sl, db, badRequestHandler, internalErrorHandler, cy))
+			postAuthFn(oauth, tf, sl, db, badRequestHandler, internalErrorHandler, cy))
 	r.NewRoute().
 		Path("/oauth2/token").
-		Methods("GET").
+		Methods("POST").
 		HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				oauth.HandleAccessTokenRequest(w, r)
 			})
+	r.NewRoute().
+		Path("/oauth2/introspect").
+		Methods("POST").
+		HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				oauth.HandleIntrospectionRequest(w, r)
+			})
+	r.NewRoute().
+		Path("/oauth2/revoke").
+		Methods("POST").
+		HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				oauth.HandleRevocationRequest(w, r)
+			})
+	if c.OAuthConfig.EnableClientRegistration {
+		util.InfoLogger.Info("OAuth2 dynamic client registration enabled")
+		r.NewRoute().
+			Path("/oauth2/register").
+			Methods("POST").
+			HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					oauth.HandleClientRegistrationRequest(w, r)
+				})
+	}
+
+	// Optional built-in admin routes
+	if c.ServerConfig.EnableAdminPolicyAPI {
+		addAdminPolicyRoutes(r,
+			scheme,
+			c.ServerConfig.Host,
+			defaultCollectionSize,
+			maxCollectionPageSize,
+			oauth,
+			users,
+			policies)
+	}
 
 	// Application-specific routes
 	err = a.BuildRoutes(r, db, fw)
@@ -226,6 +308,11 @@ func BuildHandler(r *Router,
 		r.Use(requestLogger)
 		util.InfoLogger.Info("Adding request timing middleware for debugging")
 		r.Use(timingLogger)
+		util.InfoLogger.Infof("Serving outbound rate limits at %s for debugging", debugHostLimitsPath)
+		r.NewRoute().
+			Path(debugHostLimitsPath).
+			Methods("GET").
+			HandlerFunc(hostLimitsHandler(tc))
 		util.InfoLogger.Info("Printing all registered routes for debugging")
 		err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 			pathTemplate, err := route.GetPathTemplate()
@@ -281,6 +368,16 @@ func timingLogger(next http.Handler) http.Handler {
 	})
 }
 
+// hostLimitsHandler lists the current outbound rate limit of each host.
+func hostLimitsHandler(tc *conn.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(tc.HostLimits()); err != nil {
+			util.ErrorLogger.Errorf("error writing host limits: %s", err)
+		}
+	}
+}
+
 func getFirstPartyCredRefreshFn(o *oauth2.Server, s *web.Sessions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -386,7 +483,7 @@ func getLoginFn(oauth *oauth2.Server, sl *web.Sessions, pt app.Paths, getLoginWe
 	}
 }
 
-func postLoginFn(oauth *oauth2.Server, sl *web.Sessions, db pub.Database, badRequestHandler, internalErrorHandler http.Handler, cy *services.Crypto, pt app.Paths) func(w http.ResponseWriter, r *http.Request) {
+func postLoginFn(oauth *oauth2.Server, tf *twofactor.Server, sl *web.Sessions, db pub.Database, badRequestHandler, internalErrorHandler http.Handler, cy *services.Crypto, pt app.Paths) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s, err := sl.Get(r)
 		if err != nil {
@@ -427,7 +524,8 @@ func postLoginFn(oauth *oauth2.Server, sl *web.Sessions, db pub.Database, badReq
 			return
 		}
 		pass := passV[0]
-		u, valid, err := cy.Valid(util.Context{r.Context()}, email, pass)
+		ctx := util.Context{r.Context()}
+		u, valid, err := cy.Valid(ctx, email, pass)
 		if err != nil {
 			util.ErrorLogger.Errorf("error determining password validity in POST login: %s", err)
 			internalErrorHandler.ServeHTTP(w, r)
@@ -436,27 +534,166 @@ func postLoginFn(oauth *oauth2.Server, sl *web.Sessions, db pub.Database, badReq
 			http.Redirect(w, r, oauth2.AddLoginError(r.URL).String(), http.StatusFound)
 			return
 		}
-		s.SetUserID(u)
-		// Proxy the first-party login
-		id, err := oauth.CreateProxyCredentials(util.Context{r.Context()}, u)
+		if tf != nil {
+			enabled, err := tf.Enabled(ctx, u)
+			if err != nil {
+				util.ErrorLogger.Errorf("error determining two-factor authentication in POST login: %s", err)
+				internalErrorHandler.ServeHTTP(w, r)
+				return
+			} else if enabled {
+				// Continue to the second login step
+				s.SetSecondFactorUserID(u, time.Now().Add(secondFactorTimeout))
+				if err = s.Save(r, w); err != nil {
+					util.ErrorLogger.Errorf("error saving session in POST login: %s", err)
+					internalErrorHandler.ServeHTTP(w, r)
+					return
+				}
+				http.Redirect(w, r, loginPathWithQuery(pt.GetLoginSecondFactorPath(), r.URL), http.StatusFound)
+				return
+			}
+		}
+		finishLogin(w, r, s, u, oauth, internalErrorHandler, pt, "POST login")
+	}
+}
+
+func getLoginSecondFactorFn(sl *web.Sessions, pt app.Paths, internalErrorHandler, getSecondFactorWebHandler http.Handler) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := sl.Get(r)
 		if err != nil {
-			util.ErrorLogger.Errorf("error creating proxy credentials in POST login: %s", err)
+			util.ErrorLogger.Errorf("error getting session for GET login second factor: %s", err)
 			internalErrorHandler.ServeHTTP(w, r)
 			return
 		}
-		s.SetFirstPartyCredentialID(id)
-		err = s.Save(r, w)
+		if _, err := s.SecondFactorUserID(time.Now()); err != nil {
+			// The password was not entered, or too long ago.
+			http.Redirect(w, r, loginPathWithQuery(pt.RedirectToLoginPath(r.URL.Path), r.URL), http.StatusFound)
+			return
+		}
+		getSecondFactorWebHandler.ServeHTTP(w, r)
+	}
+}
+
+func postLoginSecondFactorFn(oauth *oauth2.Server, tf *twofactor.Server, sl *web.Sessions, badRequestHandler, internalErrorHandler http.Handler, pt app.Paths) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := sl.Get(r)
 		if err != nil {
-			util.ErrorLogger.Errorf("error saving session in POST login: %s", err)
+			util.ErrorLogger.Errorf("error getting session for POST login second factor: %s", err)
 			internalErrorHandler.ServeHTTP(w, r)
 			return
 		}
-		p, err := oauth2.FirstPartyOAuth2LoginRedirPath(r.URL)
+		u, err := s.SecondFactorUserID(time.Now())
 		if err != nil {
-			util.ErrorLogger.Errorf("error determining first party OAuth2 proxy redirection: %s", err)
-			p = pt.RedirectToHomepagePath(r.URL.Path) // Go to homepage instead of failing request
+			// The password was not entered, or too long ago.
+			http.Redirect(w, r, loginPathWithQuery(pt.RedirectToLoginPath(r.URL.Path), r.URL), http.StatusFound)
+			return
 		}
-		http.Redirect(w, r, p, http.StatusFound)
+		if r.Form == nil {
+			err = r.ParseForm()
+			if err != nil {
+				badRequestHandler.ServeHTTP(w, r)
+				return
+			}
+		}
+		valid, err := tf.Verify(util.Context{r.Context()}, s, u, secondFactorFromForm(r))
+		if err != nil {
+			util.ErrorLogger.Errorf("error verifying second factor in POST login second factor: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		} else if !valid {
+			// Save the session, as a WebAuthn challenge is used up.
+			if err = s.Save(r, w); err != nil {
+				util.ErrorLogger.Errorf("error saving session in POST login second factor: %s", err)
+				internalErrorHandler.ServeHTTP(w, r)
+				return
+			}
+			p := &url.URL{Path: pt.GetLoginSecondFactorPath(), RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, oauth2.AddLoginError(p).String(), http.StatusFound)
+			return
+		}
+		s.DeleteSecondFactorUserID()
+		finishLogin(w, r, s, u, oauth, internalErrorHandler, pt, "POST login second factor")
+	}
+}
+
+func postLoginWebAuthnFn(tf *twofactor.Server, sl *web.Sessions, internalErrorHandler http.Handler) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := sl.Get(r)
+		if err != nil {
+			util.ErrorLogger.Errorf("error getting session for POST login webauthn: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		// On the OAuth2 authorization page, the user is not known until
+		// the form is submitted.
+		u, err := s.SecondFactorUserID(time.Now())
+		if err != nil {
+			u = ""
+		}
+		b, err := tf.LoginOptions(util.Context{r.Context()}, s, u)
+		if err != nil {
+			util.ErrorLogger.Errorf("error creating webauthn options in POST login webauthn: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		} else if err = s.Save(r, w); err != nil {
+			util.ErrorLogger.Errorf("error saving session in POST login webauthn: %s", err)
+			internalErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(b); err != nil {
+			util.ErrorLogger.Errorf("error writing webauthn options in POST login webauthn: %s", err)
+		}
+	}
+}
+
+// finishLogin logs the user in once they are authenticated, and redirects them
+// to where they were going.
+func finishLogin(w http.ResponseWriter, r *http.Request, s *web.Session, userID string, oauth *oauth2.Server, internalErrorHandler http.Handler, pt app.Paths, debug string) {
+	s.SetUserID(userID)
+	// Proxy the first-party login
+	id, err := oauth.CreateProxyCredentials(util.Context{r.Context()}, userID)
+	if err != nil {
+		util.ErrorLogger.Errorf("error creating proxy credentials in %s: %s", debug, err)
+		internalErrorHandler.ServeHTTP(w, r)
+		return
+	}
+	s.SetFirstPartyCredentialID(id)
+	err = s.Save(r, w)
+	if err != nil {
+		util.ErrorLogger.Errorf("error saving session in %s: %s", debug, err)
+		internalErrorHandler.ServeHTTP(w, r)
+		return
+	}
+	p, err := oauth2.FirstPartyOAuth2LoginRedirPath(r.URL)
+	if err != nil {
+		util.ErrorLogger.Errorf("error determining first party OAuth2 proxy redirection: %s", err)
+		p = pt.RedirectToHomepagePath(r.URL.Path) // Go to homepage instead of failing request
+	} else if len(p) == 0 {
+		// Not going anywhere in particular; relative to the second
+		// login step, an empty path would not be the homepage.
+		p = pt.RedirectToHomepagePath(r.URL.Path)
+	}
+	http.Redirect(w, r, p, http.StatusFound)
+}
+
+// loginPathWithQuery keeps the query of a login step when moving to another,
+// so that the user is still redirected to where they were going, but without
+// any error from the previous step.
+func loginPathWithQuery(path string, u *url.URL) string {
+	n := url.URL{
+		Path:     path,
+		RawQuery: oauth2.RemoveLoginError(u).RawQuery,
+	}
+	return n.String()
+}
+
+// secondFactorFromForm obtains the second factor entered in a login form.
+func secondFactorFromForm(r *http.Request) twofactor.SecondFactor {
+	return twofactor.SecondFactor{
+		TOTPCode:           r.Form.Get(LoginFormTOTPCodeKey),
+		RecoveryCode:       r.Form.Get(LoginFormRecoveryCodeKey),
+		WebAuthnCredential: []byte(r.Form.Get(LoginFormWebAuthnCredentialKey)),
 	}
 }
 
@@ -482,7 +719,7 @@ func getAuthFn(getAuthWebHandler http.Handler) func(w http.ResponseWriter, r *ht
 	}
 }
 
-func postAuthFn(oauth *oauth2.Server, sl *web.Sessions, db pub.Database, badRequestHandler, internalErrorHandler http.Handler, cy *services.Crypto) func(w http.ResponseWriter, r *http.Request) {
+func postAuthFn(oauth *oauth2.Server, tf *twofactor.Server, sl *web.Sessions, db pub.Database, badRequestHandler, internalErrorHandler http.Handler, cy *services.Crypto) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s, err := sl.Get(r)
 		if err != nil {
@@ -518,6 +755,33 @@ func postAuthFn(oauth *oauth2.Server, sl *web.Sessions, db pub.Database, badRequ
 			http.Redirect(w, r, oauth2.AddAuthError(r.URL).String(), http.StatusFound)
 			return
 		}
+		if tf != nil {
+			ctx := util.Context{r.Context()}
+			enabled, err := tf.Enabled(ctx, u)
+			if err != nil {
+				util.ErrorLogger.Errorf("error determining two-factor authentication in POST auth: %s", err)
+				internalErrorHandler.ServeHTTP(w, r)
+				return
+			} else if enabled {
+				// The second factor is entered alongside the password.
+				valid, err = tf.Verify(ctx, s, u, secondFactorFromForm(r))
+				if err != nil {
+					util.ErrorLogger.Errorf("error verifying second factor in POST auth: %s", err)
+					internalErrorHandler.ServeHTTP(w, r)
+					return
+				} else if !valid {
+					// Save the session, as a WebAuthn challenge is
+					// used up.
+					if err = s.Save(r, w); err != nil {
+						util.ErrorLogger.Errorf("error saving session in POST auth: %s", err)
+						internalErrorHandler.ServeHTTP(w, r)
+						return
+					}
+					http.Redirect(w, r, oauth2.AddAuthError(r.URL).String(), http.StatusFound)
+					return
+				}
+			}
+		}
 		s.SetUserID(u)
 		err = s.Save(r, w)
 		if err != nil {
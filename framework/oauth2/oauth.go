@@ -26,6 +26,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/config"
 	"github.com/allinbits/apcore/framework/web"
 	"github.com/allinbits/apcore/services"
@@ -48,6 +49,8 @@ type Server struct {
 	k *web.Sessions
 	m *manage.Manager
 	s *oaserver.Server
+	// Scopes that clients may request.
+	scopes *app.ScopeRegistry
 	// First-party support:
 	clientIDBase                string
 	host                        string
@@ -60,7 +63,7 @@ type Server struct {
 	cleanupFn                   *util.SafeStartStop
 }
 
-func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handler, d *services.OAuth2, y *services.Crypto, k *web.Sessions) (s *Server, err error) {
+func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handler, d *services.OAuth2, y *services.Crypto, k *web.Sessions, scopes *app.ScopeRegistry) (s *Server, err error) {
 	m := manage.NewDefaultManager()
 	// Configure Access token and Refresh token refresh.
 	if c.OAuthConfig.AccessTokenExpiry <= 0 {
@@ -95,6 +98,14 @@ func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handle
 		AllowGetAccessRequest: false,
 		// Support only the non-implicit flow.
 		AllowedResponseTypes: []oauth2.ResponseType{oauth2.Code},
+		// PKCE is verified with S256. The library treats requests
+		// without a code challenge as using the plain method, so it
+		// must be allowed here, and checkCodeChallenge rejects plain
+		// code challenges instead.
+		AllowedCodeChallengeMethods: []oauth2.CodeChallengeMethod{
+			oauth2.CodeChallengeS256,
+			oauth2.CodeChallengePlain,
+		},
 		// Allow:
 		// - Authorization Code (for first & third parties)
 		// - Refreshing Tokens
@@ -107,6 +118,11 @@ func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handle
 			oauth2.Refreshing,
 		},
 	}, m)
+	srv.SetClientInfoHandler(clientInfoHandler)
+	// Refreshing a token may narrow its scope, but not widen it.
+	srv.SetRefreshingScopeHandler(func(newScope, oldScope string) (allowed bool, err error) {
+		return app.GrantsScopes(oldScope, strings.Fields(newScope)...), nil
+	})
 	// Determines the user to use when granting an authorization token. If
 	// no user is present, then they have not yet logged in and need to do
 	// so. Note that an empty string userID plus no error will magically
@@ -128,6 +144,18 @@ func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handle
 		return
 	})
 	srv.SetInternalErrorHandler(func(err error) (re *oaerrors.Response) {
+		// A token request that fails PKCE verification is an invalid
+		// grant, as described by RFC 7636 Section 4.6.
+		if err == oaerrors.ErrMissingCodeVerifier || err == oaerrors.ErrInvalidCodeChallenge {
+			re = &oaerrors.Response{
+				Error:       oaerrors.ErrInvalidGrant,
+				ErrorCode:   oaerrors.StatusCodes[oaerrors.ErrInvalidGrant],
+				Description: oaerrors.Descriptions[oaerrors.ErrInvalidGrant],
+				StatusCode:  oaerrors.StatusCodes[oaerrors.ErrInvalidGrant],
+			}
+			return
+		}
+		util.ErrorLogger.Errorf("oauth2 internal error: %s", err)
 		re = &oaerrors.Response{
 			Error:       oaerrors.ErrServerError,
 			ErrorCode:   http.StatusInternalServerError,
@@ -152,6 +180,7 @@ func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handle
 		k:                           k,
 		m:                           m,
 		s:                           srv,
+		scopes:                      scopes,
 		clientIDBase:                fmt.Sprintf("%s.%s", b64ClientPart, c.ServerConfig.Host),
 		host:                        c.ServerConfig.Host,
 		scheme:                      scheme,
@@ -165,9 +194,27 @@ func NewServer(c *config.Config, scheme string, internalErrorHandler http.Handle
 	return
 }
 
-// TODO: Scopes
-
 func (o *Server) HandleAuthorizationRequest(w http.ResponseWriter, r *http.Request) {
+	cr, err := o.d.GetClientRegistration(r.Context(), r.FormValue("client_id"))
+	registered := err == nil
+	if err != nil && err != services.ErrClientNotFound {
+		util.ErrorLogger.Errorf("oauth2 error fetching client registration: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	} else if registered && !hasRegisteredRedirectURI(cr, r.FormValue("redirect_uri")) {
+		http.Error(w, oaerrors.ErrInvalidRedirectURI.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkCodeChallenge(r, registered && isPublicClient(cr.Metadata)); err != nil {
+		o.redirectAuthorizationError(w, r, err)
+		return
+	}
+	scope, err := o.requestedScope(r, cr)
+	if err != nil {
+		o.redirectAuthorizationError(w, r, err)
+		return
+	}
+	r.Form.Set("scope", scope)
 	if err := o.s.HandleAuthorizeRequest(w, r); err != nil {
 		// oauth2 library would already have written headers by now.
 		util.ErrorLogger.Errorf("oauth2 HandleAuthorizeRequest error: %s", err)
@@ -196,6 +243,14 @@ func (o *Server) RemoveByAccess(ctx util.Context, t oauth2.TokenInfo) error {
 }
 
 func (o *Server) Validate(w http.ResponseWriter, r *http.Request) (userID string, auth bool, err error) {
+	userID, _, auth, err = o.ValidateScope(w, r)
+	return
+}
+
+// ValidateScope is like Validate, and also obtains the space-delimited scopes
+// that the request is authorized for. First party credentials are authorized
+// for every registered scope.
+func (o *Server) ValidateScope(w http.ResponseWriter, r *http.Request) (userID, scope string, auth bool, err error) {
 	var sn *web.Session
 	sn, err = o.k.Get(r)
 	if err != nil {
@@ -205,6 +260,7 @@ func (o *Server) Validate(w http.ResponseWriter, r *http.Request) (userID string
 	_, uid, auth, err = o.ValidateFirstPartyProxyAccessToken(util.Context{r.Context()}, sn)
 	if err == nil && auth {
 		userID = uid
+		scope = o.scopes.String()
 		return
 	} else if err != nil {
 		sn.Clear()
@@ -217,6 +273,7 @@ func (o *Server) Validate(w http.ResponseWriter, r *http.Request) (userID string
 	ti, auth, err = o.ValidateOAuth2AccessToken(w, r)
 	if err == nil && auth {
 		userID = ti.GetUserID()
+		scope = ti.GetScope()
 	} else {
 		sn.Clear()
 		if err2 := sn.Save(r, w); err2 != nil {
@@ -237,7 +294,7 @@ func (o *Server) CreateProxyCredentials(ctx util.Context, userID string) (id str
 		ClientID:            clientID,
 		UserID:              userID,
 		RedirectURI:         (&url.URL{Scheme: o.scheme, Host: o.host, Path: "/"}).String(),
-		Scope:               "all", // TODO: Hardcoded scope here
+		Scope:               o.scopes.String(),
 		Code:                "",
 		CodeCreateAt:        now,
 		CodeExpiresIn:       0,
@@ -320,6 +377,10 @@ func (o *Server) ValidateFirstPartyProxyAccessToken(ctx util.Context, sn *web.Se
 		} else if ti == nil {
 			err = fmt.Errorf("invalid first party credential token")
 			return
+		} else if len(ti.GetAccess()) == 0 {
+			// The credential no longer exists, such as when the
+			// user has revoked it, so they are signed out.
+			return
 		} else if ti.GetRefresh() != "" && ti.GetRefreshExpiresIn() != 0 &&
 			ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn()).Before(now) {
 			err = fmt.Errorf("refresh token is expired")
@@ -0,0 +1,98 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"github.com/go-fed/oauth2"
+	oaerrors "github.com/go-fed/oauth2/errors"
+	"github.com/go-fed/oauth2/manage"
+	oaserver "github.com/go-fed/oauth2/server"
+)
+
+// checkCodeChallenge ensures that an authorization request's PKCE code
+// challenge, if any, uses the S256 method. Public clients must send one.
+//
+// The oauth2 library compares the challenge to the padded base64url encoding
+// of the verifier's digest instead of the unpadded encoding of RFC 7636, so
+// the challenge in the request is replaced by its padded encoding before the
+// library stores it.
+func checkCodeChallenge(r *http.Request, public bool) error {
+	cc := r.FormValue("code_challenge")
+	if len(cc) == 0 {
+		if public {
+			return oaerrors.ErrCodeChallengeRquired
+		}
+		return nil
+	}
+	if oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method")) != oauth2.CodeChallengeS256 {
+		return oaerrors.ErrUnsupportedCodeChallengeMethod
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cc)
+	if err != nil || len(b) != sha256.Size {
+		return oaerrors.ErrInvalidRequest
+	}
+	r.Form.Set("code_challenge", base64.URLEncoding.EncodeToString(b))
+	return nil
+}
+
+// redirectAuthorizationError sends the client back to its redirect URI with
+// the error, as described by RFC 6749 Section 4.1.2.1. The error is shown
+// instead if the redirect URI is not valid for the client.
+func (o *Server) redirectAuthorizationError(w http.ResponseWriter, r *http.Request, oaErr error) {
+	cli, err := o.m.GetClient(r.Context(), r.FormValue("client_id"))
+	if err != nil {
+		http.Error(w, oaErr.Error(), http.StatusBadRequest)
+		return
+	}
+	redir := r.FormValue("redirect_uri")
+	if len(redir) == 0 {
+		redir = cli.GetDomain()
+	} else if err = manage.DefaultValidateURI(cli.GetDomain(), redir); err != nil {
+		http.Error(w, oaErr.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(redir)
+	if err != nil {
+		http.Error(w, oaErr.Error(), http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set("error", oaErr.Error())
+	if d, ok := oaerrors.Descriptions[oaErr]; ok {
+		q.Set("error_description", d)
+	}
+	if state := r.FormValue("state"); len(state) > 0 {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// clientInfoHandler obtains the client of a token request from its HTTP Basic
+// authentication, or else from the request body, where public clients only
+// send their client_id.
+func clientInfoHandler(r *http.Request) (clientID, secret string, err error) {
+	if _, _, ok := r.BasicAuth(); ok {
+		return oaserver.ClientBasicHandler(r)
+	}
+	return oaserver.ClientFormHandler(r)
+}
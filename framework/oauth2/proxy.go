@@ -67,6 +67,17 @@ func AddAuthError(u *url.URL) *url.URL {
 	return addKV(u, authErrorQueryKey, "true")
 }
 
+// RemoveLoginError removes the error of a previous login attempt.
+func RemoveLoginError(u *url.URL) *url.URL {
+	v := u.Query()
+	v.Del(loginErrorQueryKey)
+	out := &url.URL{
+		Path:     u.Path,
+		RawQuery: v.Encode(),
+	}
+	return out
+}
+
 func addKV(u *url.URL, key, value string) *url.URL {
 	v := u.Query()
 	v.Add(key, value)
@@ -0,0 +1,291 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oauth2
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/oauth2"
+)
+
+const (
+	registrationMaxRequestLength = 1 << 16
+	jsonContentType              = "application/json; charset=utf-8"
+	registeredCredentialLength   = 32
+	// Error codes of RFC 7591 Section 3.2.2.
+	invalidRedirectURI    = "invalid_redirect_uri"
+	invalidClientMetadata = "invalid_client_metadata"
+	// Token endpoint authentication methods of RFC 7591 Section 2.
+	clientSecretBasicAuthn = "client_secret_basic"
+	clientSecretPostAuthn  = "client_secret_post"
+	// Public clients cannot keep a secret, so they authenticate with
+	// PKCE instead.
+	noneAuthn = "none"
+)
+
+// errorResponse is an error response of the client registration, token
+// introspection, and token revocation endpoints.
+type errorResponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (r *errorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", r.Code, r.Description)
+}
+
+func redirectURIError(format string, a ...interface{}) error {
+	return &errorResponse{Code: invalidRedirectURI, Description: fmt.Sprintf(format, a...)}
+}
+
+func clientMetadataError(format string, a ...interface{}) error {
+	return &errorResponse{Code: invalidClientMetadata, Description: fmt.Sprintf(format, a...)}
+}
+
+// registrationResponse is the client information response of the client
+// registration endpoint.
+type registrationResponse struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt int64  `json:"client_secret_expires_at"`
+	models.ClientMetadata
+}
+
+// HandleClientRegistrationRequest registers a third party client with the
+// client metadata in the request, as described by RFC 7591.
+func (o *Server) HandleClientRegistrationRequest(w http.ResponseWriter, r *http.Request) {
+	var md models.ClientMetadata
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, registrationMaxRequestLength))
+	if err := d.Decode(&md); err != nil {
+		writeJSON(w, http.StatusBadRequest, clientMetadataError("cannot parse client metadata: %s", err))
+		return
+	}
+	id, secret, err := o.RegisterClient(util.Context{r.Context()}, &md)
+	if re, ok := err.(*errorResponse); ok {
+		writeJSON(w, http.StatusBadRequest, re)
+		return
+	} else if err != nil {
+		util.ErrorLogger.Errorf("oauth2 client registration error: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	util.InfoLogger.Infof("Registered OAuth2 client %q named %q", id, md.ClientName)
+	writeJSON(w, http.StatusCreated, registrationResponse{
+		ClientID:         id,
+		ClientSecret:     secret,
+		ClientIDIssuedAt: time.Now().Unix(),
+		ClientMetadata:   md,
+	})
+}
+
+// RegisterClient validates the client metadata, filling in defaults for
+// missing values, and registers a client with it. Public clients are not
+// issued a secret.
+func (o *Server) RegisterClient(ctx util.Context, md *models.ClientMetadata) (id, secret string, err error) {
+	if err = o.validateClientMetadata(md); err != nil {
+		return
+	}
+	if id, err = generateRegisteredCredential(); err != nil {
+		return
+	} else if !isPublicClient(*md) {
+		if secret, err = generateRegisteredCredential(); err != nil {
+			return
+		}
+	}
+	err = o.d.RegisterClient(ctx, id, secret, *md)
+	return
+}
+
+// RegisteredClients lists the clients registered with the client
+// registration endpoint, newest first.
+func (o *Server) RegisteredClients(ctx util.Context) ([]models.ClientRegistration, error) {
+	return o.d.ClientRegistrations(ctx)
+}
+
+// RevokeClient deletes a registered client and the tokens issued to it.
+func (o *Server) RevokeClient(ctx util.Context, clientID string) error {
+	return o.d.RevokeClient(ctx, clientID)
+}
+
+func (o *Server) validateClientMetadata(md *models.ClientMetadata) error {
+	if len(md.RedirectURIs) == 0 {
+		return redirectURIError("at least one redirect URI is required")
+	}
+	var host string
+	for i, r := range md.RedirectURIs {
+		u, err := o.validateRedirectURI(r)
+		if err != nil {
+			return err
+		}
+		// The oauth2 library checks redirect URIs against the host of
+		// the first one.
+		if i == 0 {
+			host = u.Host
+		} else if u.Host != host {
+			return redirectURIError("redirect URIs must all have the same host")
+		}
+	}
+	switch md.TokenEndpointAuthMethod {
+	case "":
+		md.TokenEndpointAuthMethod = clientSecretBasicAuthn
+	case clientSecretBasicAuthn, clientSecretPostAuthn, noneAuthn:
+	default:
+		return clientMetadataError("unsupported token endpoint authentication method: %q", md.TokenEndpointAuthMethod)
+	}
+	if len(md.GrantTypes) == 0 {
+		md.GrantTypes = []string{oauth2.AuthorizationCode.String()}
+	}
+	hasAuthCode := false
+	for _, g := range md.GrantTypes {
+		switch oauth2.GrantType(g) {
+		case oauth2.AuthorizationCode:
+			hasAuthCode = true
+		case oauth2.Refreshing:
+		default:
+			return clientMetadataError("unsupported grant type: %q", g)
+		}
+	}
+	if !hasAuthCode {
+		return clientMetadataError("the %q grant type is required", oauth2.AuthorizationCode)
+	}
+	if len(md.ResponseTypes) == 0 {
+		md.ResponseTypes = []string{oauth2.Code.String()}
+	}
+	for _, rt := range md.ResponseTypes {
+		if oauth2.ResponseType(rt) != oauth2.Code {
+			return clientMetadataError("unsupported response type: %q", rt)
+		}
+	}
+	if _, err := o.scopes.Parse(md.Scope); err != nil {
+		return clientMetadataError("%s", err)
+	}
+	for name, v := range map[string]string{
+		"client_uri": md.ClientURI,
+		"logo_uri":   md.LogoURI,
+		"tos_uri":    md.TOSURI,
+		"policy_uri": md.PolicyURI,
+	} {
+		if len(v) == 0 {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+			return clientMetadataError("%s is not an absolute web URL: %q", name, v)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI permits absolute redirect URIs without a fragment that
+// are https, http on the loopback interface, or a private-use scheme of a
+// native app as described by RFC 8252. Any http redirect URI is permitted
+// when the server itself is served over http for development.
+func (o *Server) validateRedirectURI(r string) (*url.URL, error) {
+	u, err := url.Parse(r)
+	if err != nil {
+		return nil, redirectURIError("cannot parse redirect URI %q: %s", r, err)
+	} else if !u.IsAbs() {
+		return nil, redirectURIError("redirect URI is not absolute: %q", r)
+	} else if len(u.Fragment) > 0 || strings.Contains(r, "#") {
+		return nil, redirectURIError("redirect URI has a fragment: %q", r)
+	}
+	switch u.Scheme {
+	case "https":
+		if len(u.Host) == 0 {
+			return nil, redirectURIError("redirect URI has no host: %q", r)
+		}
+	case "http":
+		if len(u.Host) == 0 {
+			return nil, redirectURIError("redirect URI has no host: %q", r)
+		} else if !isLoopback(u) && o.scheme != "http" {
+			return nil, redirectURIError("http redirect URI is not on the loopback interface: %q", r)
+		}
+	default:
+		// Private-use schemes must be a reverse domain name.
+		if !strings.Contains(u.Scheme, ".") {
+			return nil, redirectURIError("redirect URI scheme is not https or a reverse domain name: %q", r)
+		}
+	}
+	return u, nil
+}
+
+// hasRegisteredRedirectURI determines whether the redirect URI of an
+// authorization request is exactly one of the client's registered redirect
+// URIs.
+func hasRegisteredRedirectURI(cr models.ClientRegistration, redir string) bool {
+	if len(redir) == 0 {
+		// The client's domain is its first redirect URI, which is used
+		// when none is requested.
+		return len(cr.Metadata.RedirectURIs) == 1
+	}
+	for _, reg := range cr.Metadata.RedirectURIs {
+		if reg == redir {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicClient determines whether a registered client cannot keep a secret,
+// and so must use PKCE.
+func isPublicClient(md models.ClientMetadata) bool {
+	return md.TokenEndpointAuthMethod == noneAuthn
+}
+
+func isLoopback(u *url.URL) bool {
+	if u.Scheme != "http" {
+		return false
+	} else if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func generateRegisteredCredential() (string, error) {
+	b := make([]byte, registeredCredentialLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		util.ErrorLogger.Errorf("error marshalling oauth2 response: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if _, err = w.Write(b); err != nil {
+		util.ErrorLogger.Errorf("error writing oauth2 response: %s", err)
+	}
+}
@@ -0,0 +1,64 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oauth2
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/allinbits/apcore/app"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/services"
+	oaerrors "github.com/go-fed/oauth2/errors"
+)
+
+// RequestedScopes describes the scopes of an authorization request, for the
+// user to review before granting them. It returns an error if the request is
+// for scopes that are not registered, or that the client may not use.
+func (o *Server) RequestedScopes(r *http.Request) ([]app.Scope, error) {
+	cr, err := o.d.GetClientRegistration(r.Context(), r.FormValue("client_id"))
+	if err != nil && err != services.ErrClientNotFound {
+		return nil, err
+	}
+	scope, err := o.requestedScope(r, cr)
+	if err != nil {
+		return nil, err
+	}
+	return o.scopes.Parse(scope)
+}
+
+// requestedScope determines the scope of an authorization request. When none
+// is requested, it is the scope that a registered client may use, or else
+// app.DefaultScope.
+//
+// It is oaerrors.ErrInvalidScope if any requested scope is not registered, or
+// is not one that a registered client may use.
+func (o *Server) requestedScope(r *http.Request, cr models.ClientRegistration) (string, error) {
+	scope := strings.Join(strings.Fields(r.FormValue("scope")), " ")
+	if len(scope) == 0 {
+		scope = cr.Metadata.Scope
+	}
+	if len(scope) == 0 {
+		scope = app.DefaultScope
+	}
+	if _, err := o.scopes.Parse(scope); err != nil {
+		return "", oaerrors.ErrInvalidScope
+	} else if len(cr.Metadata.Scope) > 0 && !app.GrantsScopes(cr.Metadata.Scope, strings.Fields(scope)...) {
+		return "", oaerrors.ErrInvalidScope
+	}
+	return scope, nil
+}
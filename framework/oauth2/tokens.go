@@ -0,0 +1,226 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oauth2
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/oauth2"
+	oaerrors "github.com/go-fed/oauth2/errors"
+)
+
+const (
+	// Token type hints of RFC 7009 Section 2.1.
+	accessTokenHint  = "access_token"
+	refreshTokenHint = "refresh_token"
+	bearerTokenType  = "Bearer"
+)
+
+// introspectionResponse is the response of the token introspection endpoint,
+// as described by RFC 7662 Section 2.2.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// HandleIntrospectionRequest describes an access or refresh token to the
+// client it was issued to, as described by RFC 7662. Tokens issued to other
+// clients are described as inactive.
+func (o *Server) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := o.authenticateTokenRequest(w, r)
+	if !ok {
+		return
+	}
+	ti, refresh, err := o.findToken(r.Context(), r.PostForm.Get("token"), r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		util.ErrorLogger.Errorf("oauth2 error finding token to introspect: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if ti == nil || ti.GetClientID() != clientID || !isTokenActive(ti, refresh, now) {
+		writeJSON(w, http.StatusOK, introspectionResponse{})
+		return
+	}
+	resp := introspectionResponse{
+		Active:   true,
+		Scope:    ti.GetScope(),
+		ClientID: ti.GetClientID(),
+		Subject:  ti.GetUserID(),
+	}
+	if refresh {
+		resp.IssuedAt = ti.GetRefreshCreateAt().Unix()
+		if exp := ti.GetRefreshExpiresIn(); exp != 0 {
+			resp.ExpiresAt = ti.GetRefreshCreateAt().Add(exp).Unix()
+		}
+	} else {
+		resp.TokenType = bearerTokenType
+		resp.IssuedAt = ti.GetAccessCreateAt().Unix()
+		if exp := ti.GetAccessExpiresIn(); exp != 0 {
+			resp.ExpiresAt = ti.GetAccessCreateAt().Add(exp).Unix()
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleRevocationRequest revokes an access or refresh token issued to the
+// client, as described by RFC 7009. As the access and refresh tokens are
+// issued together, revoking either one revokes both.
+func (o *Server) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := o.authenticateTokenRequest(w, r)
+	if !ok {
+		return
+	}
+	ti, refresh, err := o.findToken(r.Context(), r.PostForm.Get("token"), r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		util.ErrorLogger.Errorf("oauth2 error finding token to revoke: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	} else if ti == nil {
+		// Invalid tokens do not cause an error, as the client cannot
+		// do anything about them.
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if ti.GetClientID() != clientID {
+		writeJSON(w, http.StatusBadRequest, &errorResponse{
+			Code:        oaerrors.ErrUnauthorizedClient.Error(),
+			Description: "The token was not issued to the client",
+		})
+		return
+	}
+	if refresh {
+		err = o.m.RemoveRefreshToken(r.Context(), ti.GetRefresh())
+	} else {
+		err = o.m.RemoveAccessToken(r.Context(), ti.GetAccess())
+	}
+	if err != nil {
+		util.ErrorLogger.Errorf("oauth2 error revoking token: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateTokenRequest authenticates the client of a token introspection
+// or revocation request in the same way as at the token endpoint, where public
+// clients only identify themselves. An error response is written if the
+// request is not valid.
+func (o *Server) authenticateTokenRequest(w http.ResponseWriter, r *http.Request) (clientID string, ok bool) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, &errorResponse{
+			Code:        oaerrors.ErrInvalidRequest.Error(),
+			Description: oaerrors.Descriptions[oaerrors.ErrInvalidRequest],
+		})
+		return
+	}
+	id, secret, err := clientInfoHandler(r)
+	var cli oauth2.ClientInfo
+	if err == nil {
+		cli, err = o.m.GetClient(r.Context(), id)
+	}
+	if err != nil || cli == nil || cli.GetID() != id ||
+		subtle.ConstantTimeCompare([]byte(cli.GetSecret()), []byte(secret)) != 1 {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+		}
+		writeJSON(w, http.StatusUnauthorized, &errorResponse{
+			Code:        oaerrors.ErrInvalidClient.Error(),
+			Description: oaerrors.Descriptions[oaerrors.ErrInvalidClient],
+		})
+		return
+	}
+	if len(r.PostForm.Get("token")) == 0 {
+		writeJSON(w, http.StatusBadRequest, &errorResponse{
+			Code:        oaerrors.ErrInvalidRequest.Error(),
+			Description: "The token parameter is missing",
+		})
+		return
+	}
+	return id, true
+}
+
+// findToken obtains the token information of an access or refresh token,
+// looking it up as the hinted type first. A nil TokenInfo is returned if it
+// is neither.
+func (o *Server) findToken(ctx context.Context, token, hint string) (ti oauth2.TokenInfo, refresh bool, err error) {
+	refresh = hint == refreshTokenHint
+	for i := 0; i < 2; i++ {
+		if refresh {
+			ti, err = o.d.GetByRefresh(ctx, token)
+		} else {
+			ti, err = o.d.GetByAccess(ctx, token)
+		}
+		if err != nil {
+			return
+		} else if ti != nil && ((refresh && ti.GetRefresh() == token) || (!refresh && ti.GetAccess() == token)) {
+			return
+		}
+		refresh = !refresh
+	}
+	return nil, false, nil
+}
+
+// isTokenActive determines whether an access or refresh token has not yet
+// expired. An access token expires early if its refresh token expires.
+func isTokenActive(ti oauth2.TokenInfo, refresh bool, now time.Time) bool {
+	if ti.GetRefresh() != "" && ti.GetRefreshExpiresIn() != 0 &&
+		ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn()).Before(now) {
+		return false
+	} else if refresh {
+		return true
+	}
+	return ti.GetAccessExpiresIn() == 0 ||
+		!ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Before(now)
+}
+
+// UserTokens lists the unexpired access tokens issued to a user, including
+// those backing first party credentials, newest first. Tokens that can still
+// be refreshed are included even if their access token has expired.
+func (o *Server) UserTokens(ctx util.Context, userID string) (ut []models.UserToken, err error) {
+	var all []models.UserToken
+	if all, err = o.d.UserTokens(ctx, userID); err != nil {
+		return
+	}
+	now := time.Now()
+	for _, t := range all {
+		if isTokenActive(&t.TokenInfo, t.GetRefresh() != "", now) {
+			ut = append(ut, t)
+		}
+	}
+	sort.Slice(ut, func(i, j int) bool {
+		return ut[i].CreateTime().After(ut[j].CreateTime())
+	})
+	return
+}
+
+// RevokeUserToken deletes one of a user's access tokens, signing the user out
+// of the client or, for first party credentials, the browser session it was
+// issued to.
+func (o *Server) RevokeUserToken(ctx util.Context, userID, id string) error {
+	return o.d.RevokeUserToken(ctx, userID, id)
+}
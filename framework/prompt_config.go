@@ -38,7 +38,8 @@ Let's go!`)))
 	var s string
 	s, err = promptSelection(
 		"Please choose the database you are using",
-		postgresDB)
+		postgresDB,
+		sqliteDB)
 	if err != nil {
 		return
 	}
@@ -171,6 +172,8 @@ Let's go!`)))
 	switch c.DatabaseConfig.DatabaseKind {
 	case postgresDB:
 		err = promptPostgresConfig(c)
+	case sqliteDB:
+		err = promptSqliteConfig(c)
 	default:
 		err = fmt.Errorf("unknown database kind: %s", c.DatabaseConfig.DatabaseKind)
 	}
@@ -232,3 +235,14 @@ Toodlemoo~`,
 	}
 	return
 }
+
+func promptSqliteConfig(c *config.Config) (err error) {
+	fmt.Println("Prompting for SQLite database configuration options...")
+	c.DatabaseConfig.SqliteConfig.File, err = promptStringWithDefault(
+		"Enter the path to the sqlite database file",
+		"apcore.db")
+	if err != nil {
+		return
+	}
+	return
+}
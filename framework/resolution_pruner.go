@@ -0,0 +1,74 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package framework
+
+import (
+	"context"
+	"time"
+
+	"github.com/allinbits/apcore/framework/config"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/activity/pub"
+)
+
+var _ StartStopper = &ResolutionPruner{}
+
+// ResolutionPruner periodically deletes the recorded results of applying
+// policies that are older than the configured retention period.
+type ResolutionPruner struct {
+	clock     pub.Clock
+	policies  *services.Policies
+	retention time.Duration
+	pruneFn   *util.SafeStartStop
+}
+
+func NewResolutionPruner(c *config.Config, clock pub.Clock, policies *services.Policies) *ResolutionPruner {
+	r := &ResolutionPruner{
+		clock:     clock,
+		policies:  policies,
+		retention: time.Duration(c.ActivityPubConfig.ResolutionRetentionSeconds) * time.Second,
+	}
+	if r.retention > 0 {
+		r.pruneFn = util.NewSafeStartStop(r.prune, time.Duration(c.ActivityPubConfig.ResolutionPrunePeriodSeconds)*time.Second)
+	}
+	return r
+}
+
+func (r *ResolutionPruner) Start() {
+	if r.pruneFn == nil {
+		util.InfoLogger.Info("Policy resolutions are retained indefinitely")
+		return
+	}
+	r.pruneFn.Start()
+}
+
+func (r *ResolutionPruner) Stop() {
+	if r.pruneFn == nil {
+		return
+	}
+	r.pruneFn.Stop()
+}
+
+func (r *ResolutionPruner) prune(ctx context.Context) {
+	n, err := r.policies.PruneResolutions(util.Context{ctx}, r.clock.Now().Add(-r.retention))
+	if err != nil {
+		util.ErrorLogger.Errorf("policy resolution pruning failed: %s", err)
+		return
+	}
+	util.InfoLogger.Infof("Pruned %d policy resolutions older than %s", n, r.retention)
+}
@@ -0,0 +1,67 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package framework
+
+import (
+	"context"
+	"time"
+
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/activity/pub"
+)
+
+// retiredKeyPrunePeriod is how often the keys replaced by key rotation are
+// checked for having outlived their grace period.
+const retiredKeyPrunePeriod = time.Hour
+
+var _ StartStopper = &RetiredKeyPruner{}
+
+// RetiredKeyPruner periodically stops publishing the keys replaced by key
+// rotation once their grace period has ended.
+type RetiredKeyPruner struct {
+	clock   pub.Clock
+	users   *services.Users
+	pruneFn *util.SafeStartStop
+}
+
+func NewRetiredKeyPruner(clock pub.Clock, users *services.Users) *RetiredKeyPruner {
+	r := &RetiredKeyPruner{
+		clock: clock,
+		users: users,
+	}
+	r.pruneFn = util.NewSafeStartStop(r.prune, retiredKeyPrunePeriod)
+	return r
+}
+
+func (r *RetiredKeyPruner) Start() {
+	r.pruneFn.Start()
+}
+
+func (r *RetiredKeyPruner) Stop() {
+	r.pruneFn.Stop()
+}
+
+func (r *RetiredKeyPruner) prune(ctx context.Context) {
+	n, err := r.users.PruneRetiredKeys(util.Context{ctx}, r.clock.Now())
+	if err != nil {
+		util.ErrorLogger.Errorf("retired key pruning failed: %s", err)
+		return
+	} else if n > 0 {
+		util.InfoLogger.Infof("Pruned %d retired keys", n)
+	}
+}
@@ -18,8 +18,10 @@ package framework
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/allinbits/apcore/app"
 	"github.com/allinbits/apcore/framework/oauth2"
@@ -39,6 +41,12 @@ type RoutingDatabase interface {
 	GetPublicOutbox(c context.Context, outboxIRI *url.URL) (outbox vocab.ActivityStreamsOrderedCollectionPage, err error)
 }
 
+// FetchAuthorizer determines whether an ActivityPub GET request without an
+// OAuth2 access token may be served when authorized fetch is enabled.
+type FetchAuthorizer interface {
+	AuthorizeFetch(c util.Context, r *http.Request) (authorized bool, err error)
+}
+
 type Router struct {
 	router            *mux.Router
 	oauth             *oauth2.Server
@@ -50,6 +58,8 @@ type Router struct {
 	scheme            string
 	errorHandler      http.Handler
 	badRequestHandler http.Handler
+	fetchAuth         FetchAuthorizer
+	scopes            routeScopes
 }
 
 func NewRouter(router *mux.Router,
@@ -61,8 +71,9 @@ func NewRouter(router *mux.Router,
 	host string,
 	scheme string,
 	errorHandler http.Handler,
-	badRequestHandler http.Handler) *Router {
-	return &Router{
+	badRequestHandler http.Handler,
+	fetchAuth FetchAuthorizer) *Router {
+	r := &Router{
 		router:            router,
 		oauth:             oauth,
 		userActor:         userActor,
@@ -73,7 +84,11 @@ func NewRouter(router *mux.Router,
 		scheme:            scheme,
 		errorHandler:      errorHandler,
 		badRequestHandler: badRequestHandler,
+		fetchAuth:         fetchAuth,
+		scopes:            make(routeScopes),
 	}
+	router.Use(r.requireScopes)
+	return r
 }
 
 func (r *Router) wrap(route *mux.Route) *Route {
@@ -89,6 +104,8 @@ func (r *Router) wrap(route *mux.Route) *Route {
 		errorHandler:      r.errorHandler,
 		badRequestHandler: r.badRequestHandler,
 		notFoundHandler:   r.router.NotFoundHandler,
+		fetchAuth:         r.fetchAuth,
+		scopes:            r.scopes,
 	}
 }
 
@@ -158,6 +175,10 @@ func (r *Router) HandleAccessTokenRequest(path string) app.Route {
 	return r.wrap(r.router.NewRoute()).HandleAccessTokenRequest(path)
 }
 
+func (r *Router) RequireScopes(scopes ...string) app.Route {
+	return r.wrap(r.router.NewRoute()).RequireScopes(scopes...)
+}
+
 func (r *Router) Get(name string) app.Route {
 	return r.wrap(r.router.Get(name))
 }
@@ -222,6 +243,37 @@ func (r *Router) Walk(walkFn mux.WalkFunc) error {
 	return r.router.Walk(walkFn)
 }
 
+// requireScopes is middleware serving the routes that require scopes only to
+// requests whose OAuth2 access token or first party credential grants them, as
+// described by RFC 6750 Section 3.1.
+func (r *Router) requireScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		required := r.scopes[mux.CurrentRoute(req)]
+		if len(required) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		_, scope, authenticated, err := r.oauth.ValidateScope(w, req)
+		if err != nil {
+			util.ErrorLogger.Errorf("Error validating scope of request: %s", err)
+			authenticated = false
+		}
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if !app.GrantsScopes(scope, required...) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, scope=%q", "insufficient_scope", strings.Join(required, " ")))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// routeScopes are the OAuth2 scopes required by routes.
+type routeScopes map[*mux.Route][]string
+
 var _ app.Route = &Route{}
 
 type Route struct {
@@ -236,6 +288,8 @@ type Route struct {
 	errorHandler      http.Handler
 	badRequestHandler http.Handler
 	notFoundHandler   http.Handler
+	fetchAuth         FetchAuthorizer
+	scopes            routeScopes
 }
 
 func (r *Route) wrap(router *mux.Router) *Router {
@@ -250,6 +304,8 @@ func (r *Route) wrap(router *mux.Router) *Router {
 		scheme:            r.scheme,
 		errorHandler:      r.errorHandler,
 		badRequestHandler: r.badRequestHandler,
+		fetchAuth:         r.fetchAuth,
+		scopes:            r.scopes,
 	}
 }
 
@@ -354,6 +410,9 @@ func (r *Route) actorGetInbox(actor pub.Actor, path string, web func(w http.Resp
 				return
 			}
 			c := util.WithUserAPHTTPContext(r.scheme, r.host, req, uuid, userID)
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			isApRequest, err := actor.GetInbox(c.Context, w, req)
 			if err != nil {
 				util.ErrorLogger.Errorf("Error in ActorGetInbox: %s", err)
@@ -405,6 +464,9 @@ func (r *Route) actorGetOutbox(actor pub.Actor, path string, web func(w http.Res
 				return
 			}
 			c := util.WithUserAPHTTPContext(r.scheme, r.host, req, uuid, userID)
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			isApRequest, err := actor.GetOutbox(c.Context, w, req)
 			if err != nil {
 				util.ErrorLogger.Errorf("Error in ActorGetOutbox: %s", err)
@@ -438,6 +500,9 @@ func (r *Route) ActivityPubOnlyHandleFunc(path string, authFn app.AuthorizeFunc)
 	r.route = r.route.Path(path).Schemes(r.scheme).HandlerFunc(
 		func(w http.ResponseWriter, req *http.Request) {
 			c := util.WithAPHTTPContext(r.scheme, r.host, req)
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			permit := true
 			if authFn != nil {
 				var err error
@@ -472,6 +537,9 @@ func (r *Route) ActivityPubAndWebHandleFunc(path string, authFn app.AuthorizeFun
 	r.route = r.route.Path(path).Schemes(r.scheme).HandlerFunc(
 		func(w http.ResponseWriter, req *http.Request) {
 			c := util.WithAPHTTPContext(r.scheme, r.host, req)
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			permit := true
 			if authFn != nil {
 				var err error
@@ -520,6 +588,9 @@ func (r *Route) apWebCollectionPageFetchingHandleFunc(path string,
 			} else {
 				c = util.WithAPHTTPContext(r.scheme, r.host, req)
 			}
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			permit := true
 			if authFn != nil {
 				var err error
@@ -577,6 +648,9 @@ func (r *Route) apWebVocabFetchingHandleFunc(path string,
 			} else {
 				c = util.WithAPHTTPContext(r.scheme, r.host, req)
 			}
+			if !r.permitFetch(c, w, req) {
+				return
+			}
 			permit := true
 			if authFn != nil {
 				var err error
@@ -615,6 +689,40 @@ func (r *Route) apWebVocabFetchingHandleFunc(path string,
 	return r
 }
 
+// permitFetch enforces authorized fetch, permitting an ActivityPub GET
+// request only if it has an OAuth2 access token or an authorized HTTP
+// Signature. The instance actor can always be fetched, as peers verifying
+// its signatures must not need to sign their own fetch of its key.
+//
+// If the request is not permitted, a response has been written.
+func (r *Route) permitFetch(c util.Context, w http.ResponseWriter, req *http.Request) bool {
+	if r.fetchAuth == nil || !isActivityPubGet(req) || paths.IsInstanceActorPath(req.URL) {
+		return true
+	}
+	if _, authenticated, err := r.oauth.Validate(w, req); err == nil && authenticated {
+		return true
+	}
+	authorized, err := r.fetchAuth.AuthorizeFetch(c, req)
+	if err != nil {
+		util.ErrorLogger.Errorf("Error authorizing fetch: %s", err)
+		r.errorHandler.ServeHTTP(w, req)
+		return false
+	} else if !authorized {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// isActivityPubGet determines whether a GET request accepts ActivityStreams
+// media types, and would be served ActivityPub data.
+func isActivityPubGet(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return req.Method == "GET" &&
+		(strings.Contains(accept, "application/activity+json") ||
+			strings.Contains(accept, "application/ld+json"))
+}
+
 func (r *Route) HandleAuthorizationRequest(path string) app.Route {
 	r.route = r.route.Path(path).HandlerFunc(r.oauth.HandleAuthorizationRequest)
 	return r
@@ -625,6 +733,13 @@ func (r *Route) HandleAccessTokenRequest(path string) app.Route {
 	return r
 }
 
+// RequireScopes only serves the route to requests authorized for all of the
+// scopes. Unauthorized requests are rejected before reaching any handler.
+func (r *Route) RequireScopes(scopes ...string) app.Route {
+	r.scopes[r.route] = append(r.scopes[r.route], scopes...)
+	return r
+}
+
 func (r *Route) WebOnlyHandler(path string, handler http.Handler) app.Route {
 	r.route = r.route.Path(path).Handler(handler)
 	return r
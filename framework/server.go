@@ -46,6 +46,9 @@ type Server struct {
 	httpServer  *http.Server
 	httpsServer *http.Server
 	ss          []StartStopper
+	// stopped is closed once the internal systems have stopped after the
+	// server is shut down.
+	stopped chan struct{}
 }
 
 func NewInsecureServer(c *config.Config, h http.Handler, a app.Application, sqldb *sql.DB, d models.SqlDialect, models []models.Model, ss []StartStopper) (s *Server, err error) {
@@ -63,6 +66,7 @@ func NewInsecureServer(c *config.Config, h http.Handler, a app.Application, sqld
 		d:          d,
 		httpServer: httpServer,
 		ss:         ss,
+		stopped:    make(chan struct{}),
 	}
 
 	// Post-creation hooks
@@ -94,6 +98,7 @@ func NewHTTPSServer(c *config.Config, h http.Handler, a app.Application, sqldb *
 		httpServer:  httpServer,
 		httpsServer: httpsServer,
 		ss:          ss,
+		stopped:     make(chan struct{}),
 	}
 
 	// Post-creation hooks
@@ -176,6 +181,7 @@ func (s *Server) startHTTPS() error {
 		util.ErrorLogger.Errorf("Error shutting down https server: %s", err)
 	} else {
 		util.InfoLogger.Infof("HTTPS server shutdown")
+		<-s.stopped
 	}
 	return nil
 }
@@ -187,6 +193,7 @@ func (s *Server) startHTTP() error {
 		util.ErrorLogger.Errorf("Error shutting down http server: %s", err)
 	} else {
 		util.InfoLogger.Infof("HTTP server shutdown")
+		<-s.stopped
 	}
 	return nil
 }
@@ -232,4 +239,5 @@ func (s *Server) onStop() {
 	for _, m := range s.models {
 		m.Close()
 	}
+	close(s.stopped)
 }
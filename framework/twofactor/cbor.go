@@ -0,0 +1,171 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package twofactor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// maxCBORDepth limits the nesting of CBOR arrays and maps, which WebAuthn
+// data never nests deeply.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR data item in b, as described by RFC 8949,
+// and returns the bytes following it.
+//
+// Only the subset of CBOR used by WebAuthn is supported: integers are decoded
+// as int64, byte strings as []byte, text strings as string, arrays as
+// []interface{}, maps as map[interface{}]interface{} with int64 or string
+// keys, and the simple values false, true, null, and undefined. Indefinite
+// lengths, tags, and floating point numbers are not supported.
+func decodeCBOR(b []byte) (v interface{}, rest []byte, err error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (v interface{}, rest []byte, err error) {
+	if depth > maxCBORDepth {
+		err = fmt.Errorf("cbor: nested too deeply")
+		return
+	} else if len(b) == 0 {
+		err = fmt.Errorf("cbor: unexpected end of data")
+		return
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			v = false
+		case 21:
+			v = true
+		case 22, 23:
+			v = nil
+		default:
+			err = fmt.Errorf("cbor: unsupported simple value %d", info)
+			return
+		}
+		rest = b[1:]
+		return
+	}
+	var n uint64
+	if n, rest, err = decodeCBORArgument(info, b[1:]); err != nil {
+		return
+	}
+	switch major {
+	case 0:
+		if n > 1<<63-1 {
+			err = fmt.Errorf("cbor: integer overflows int64")
+			return
+		}
+		v = int64(n)
+	case 1:
+		if n > 1<<63-1 {
+			err = fmt.Errorf("cbor: integer overflows int64")
+			return
+		}
+		v = -1 - int64(n)
+	case 2, 3:
+		if n > uint64(len(rest)) {
+			err = fmt.Errorf("cbor: string length %d exceeds data", n)
+			return
+		}
+		if major == 2 {
+			v = append([]byte(nil), rest[:n]...)
+		} else {
+			v = string(rest[:n])
+		}
+		rest = rest[n:]
+	case 4:
+		// Every item is at least one byte long.
+		if n > uint64(len(rest)) {
+			err = fmt.Errorf("cbor: array length %d exceeds data", n)
+			return
+		}
+		a := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return
+			}
+			a = append(a, item)
+		}
+		v = a
+	case 5:
+		if n > uint64(len(rest)) {
+			err = fmt.Errorf("cbor: map length %d exceeds data", n)
+			return
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value interface{}
+			if key, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				err = fmt.Errorf("cbor: unsupported map key type %T", key)
+				return
+			}
+			if value, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return
+			}
+			m[key] = value
+		}
+		v = m
+	default:
+		err = fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+	return
+}
+
+// decodeCBORArgument decodes the argument of a data item's initial byte, which
+// is either its value or its length.
+func decodeCBORArgument(info byte, b []byte) (n uint64, rest []byte, err error) {
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		err = fmt.Errorf("cbor: unsupported additional information %d", info)
+		return
+	}
+	if len(b) < size {
+		err = fmt.Errorf("cbor: unexpected end of data")
+		return
+	}
+	switch size {
+	case 1:
+		n = uint64(b[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		n = binary.BigEndian.Uint64(b)
+	}
+	rest = b[size:]
+	return
+}
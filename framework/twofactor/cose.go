@@ -0,0 +1,142 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package twofactor
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE_Key parameters and values used by WebAuthn, as registered by RFC 9053.
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// coseAlgorithms are the signature algorithms that credentials may use, in
+// order of preference.
+var coseAlgorithms = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// coseKey is a credential public key.
+type coseKey struct {
+	alg int64
+	pub crypto.PublicKey
+}
+
+// parseCOSEKey parses a COSE_Key encoded public key, as described by RFC 9052
+// Section 7, returning the bytes following it.
+func parseCOSEKey(b []byte) (k coseKey, rest []byte, err error) {
+	var v interface{}
+	if v, rest, err = decodeCBOR(b); err != nil {
+		return
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		err = fmt.Errorf("cose key is not a map")
+		return
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	k.alg, _ = m[int64(coseAlgorithm)].(int64)
+	switch {
+	case kty == coseKeyTypeEC2 && k.alg == coseAlgES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			err = fmt.Errorf("cose key is not a valid P-256 key")
+			return
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			err = fmt.Errorf("cose key is not on the P-256 curve")
+			return
+		}
+		k.pub = pub
+	case kty == coseKeyTypeOKP && k.alg == coseAlgEdDSA:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			err = fmt.Errorf("cose key is not a valid Ed25519 key")
+			return
+		}
+		k.pub = ed25519.PublicKey(x)
+	case kty == coseKeyTypeRSA && k.alg == coseAlgRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			err = fmt.Errorf("cose key is not a valid RSA key of at least 2048 bits")
+			return
+		}
+		k.pub = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	default:
+		err = fmt.Errorf("unsupported cose key type %d with algorithm %d", kty, k.alg)
+	}
+	return
+}
+
+// verify checks the signature of the data made by the credential's private
+// key.
+func (k coseKey) verify(data, sig []byte) error {
+	switch pub := k.pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, h[:], sig) {
+			return fmt.Errorf("invalid ES256 signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("invalid EdDSA signature")
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+			return fmt.Errorf("invalid RS256 signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported cose key type %T", k.pub)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// totpSecretSize is the number of bytes in a TOTP secret, which is the
+	// size recommended by RFC 4226 Section 4.
+	totpSecretSize = 20
+	totpDigits     = 6
+	totpModulus    = 1000000 // 10^totpDigits
+	totpPeriod     = 30 * time.Second
+	// totpSkew is how many time steps a code may be off by, to allow for
+	// clock drift and for the time it takes the user to enter it.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() ([]byte, error) {
+	b := make([]byte, totpSecretSize)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// totpStep is the time step of a TOTP code at the time, as described by RFC
+// 6238 Section 4.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode computes the code for the time step with the HOTP algorithm, as
+// described by RFC 4226 Section 5.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%totpModulus)
+}
+
+// validTOTP determines whether the code is valid for the secret at the time,
+// returning the time step of the code.
+func validTOTP(secret []byte, code string, now time.Time) (step int64, valid bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return
+	} else if _, err := strconv.Atoi(code); err != nil {
+		return
+	}
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		s := totpStep(now) + d
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return
+}
+
+// totpURI is the key URI that authenticator apps import a secret from, which
+// is usually shown to the user as a QR code.
+func totpURI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", totpEncoding.EncodeToString(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(totpDigits))
+	v.Set("period", strconv.Itoa(int(totpPeriod/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,327 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package twofactor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/allinbits/apcore/app"
+	"github.com/allinbits/apcore/framework/web"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/paths"
+	"github.com/allinbits/apcore/services"
+	"github.com/allinbits/apcore/util"
+)
+
+// ErrInvalidSecondFactor is returned when the code or credential that a user
+// enrolls a second factor with is not valid.
+var ErrInvalidSecondFactor = errors.New("invalid second factor")
+
+// SecondFactor is what a user provides to log in in addition to their
+// password. Only one of its fields needs to be set.
+type SecondFactor struct {
+	TOTPCode     string
+	RecoveryCode string
+	// WebAuthnCredential is the JSON serialization of the
+	// PublicKeyCredential returned by navigator.credentials.get.
+	WebAuthnCredential []byte
+}
+
+// Server verifies the second factors that users log in with, and enrolls
+// them.
+type Server struct {
+	d *services.TwoFactor
+	u *services.Users
+	// rpID is the WebAuthn relying party identifier, which is the host
+	// without any port.
+	rpID   string
+	origin string
+}
+
+func NewServer(scheme, host string, d *services.TwoFactor, u *services.Users) *Server {
+	rpID := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		rpID = h
+	}
+	return &Server{
+		d:      d,
+		u:      u,
+		rpID:   rpID,
+		origin: scheme + "://" + host,
+	}
+}
+
+// Status fetches the second factors of the user.
+func (s *Server) Status(c util.Context, userID string) (services.TwoFactorStatus, error) {
+	return s.d.Status(c, userID)
+}
+
+// Enabled determines whether the user must provide a second factor to log in.
+func (s *Server) Enabled(c util.Context, userID string) (bool, error) {
+	st, err := s.d.Status(c, userID)
+	return st.Enabled(), err
+}
+
+// Verify checks the second factor that the user provided to log in. Used
+// recovery codes and WebAuthn challenges are deleted, so that they cannot be
+// used again.
+//
+// After too many invalid second factors in a row, the user is locked out for a
+// while, during which every second factor is invalid.
+func (s *Server) Verify(c util.Context, sn *web.Session, userID string, f SecondFactor) (valid bool, err error) {
+	allowed, err := s.d.BeginSecondFactorAttempt(c, userID, time.Now())
+	if err != nil {
+		return
+	} else if !allowed {
+		util.InfoLogger.Infof("Second factor of locked out user %s not verified", userID)
+		return false, nil
+	}
+	switch {
+	case len(f.TOTPCode) > 0:
+		valid, err = s.verifyTOTP(c, userID, f.TOTPCode)
+	case len(f.RecoveryCode) > 0:
+		valid, err = s.d.UseRecoveryCode(c, userID, f.RecoveryCode)
+	case len(f.WebAuthnCredential) > 0:
+		valid, err = s.verifyWebAuthn(c, sn, userID, f.WebAuthnCredential)
+	}
+	if err != nil || !valid {
+		return
+	}
+	return true, s.d.SucceedSecondFactorAttempt(c, userID)
+}
+
+func (s *Server) verifyTOTP(c util.Context, userID, code string) (valid bool, err error) {
+	ts, found, err := s.d.TOTPSecret(c, userID)
+	if err != nil || !found || !ts.Confirmed {
+		return
+	}
+	step, valid := validTOTP(ts.Secret, code, time.Now())
+	if !valid {
+		return
+	}
+	// Do not let a code be used twice, in case it was observed.
+	return s.d.UseTOTPStep(c, userID, step)
+}
+
+func (s *Server) verifyWebAuthn(c util.Context, sn *web.Session, userID string, b []byte) (valid bool, err error) {
+	challenge, ok, err := s.useChallenge(c, sn)
+	if err != nil || !ok {
+		return
+	}
+	st, err := s.d.Status(c, userID)
+	if err != nil {
+		return
+	}
+	used, signCount, err := s.verifyAssertion(b, challenge, st.WebAuthnCredentials)
+	if err != nil {
+		util.InfoLogger.Infof("Invalid WebAuthn login for user %s: %s", userID, err)
+		return false, nil
+	}
+	return true, s.d.UseWebAuthnCredential(c, used.ID, signCount)
+}
+
+// LoginOptions starts a WebAuthn login of the user, returning the options to
+// pass to navigator.credentials.get as JSON. The challenge is kept in the
+// session, which must be saved afterwards.
+//
+// If the user is not yet known, indicated by an empty userID, no credentials
+// are allowed in the options so that the browser offers the discoverable
+// credentials it has for this server.
+func (s *Server) LoginOptions(c util.Context, sn *web.Session, userID string) ([]byte, error) {
+	var st services.TwoFactorStatus
+	if len(userID) > 0 {
+		var err error
+		if st, err = s.d.Status(c, userID); err != nil {
+			return nil, err
+		}
+	}
+	challenge, err := s.newChallenge(c, sn)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(requestOptions{
+		Challenge:        challenge,
+		RPID:             s.rpID,
+		Timeout:          webAuthnTimeout,
+		AllowCredentials: credentialDescriptors(st.WebAuthnCredentials),
+		UserVerification: "preferred",
+	})
+}
+
+// BeginTOTP creates a new secret for the user to add to their authenticator
+// app. It is not required to log in until ConfirmTOTP is called with a code
+// from their app.
+func (s *Server) BeginTOTP(c util.Context, userID string) (te app.TOTPEnrollment, err error) {
+	var u *services.User
+	if u, err = s.user(c, userID); err != nil {
+		return
+	}
+	var secret []byte
+	if secret, err = newTOTPSecret(); err != nil {
+		return
+	} else if err = s.d.BeginTOTP(c, userID, secret); err != nil {
+		return
+	}
+	te = app.TOTPEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(s.rpID, u.Email, secret),
+	}
+	return
+}
+
+// ConfirmTOTP requires the user's new secret to log in, once they prove they
+// added it to their authenticator app with a code. If this enables two-factor
+// authentication for the user, their new recovery codes are returned.
+func (s *Server) ConfirmTOTP(c util.Context, userID, code string) (recoveryCodes []string, err error) {
+	ts, found, err := s.d.TOTPSecret(c, userID)
+	if err != nil {
+		return
+	} else if !found {
+		err = services.ErrTOTPNotEnrolling
+		return
+	}
+	step, valid := validTOTP(ts.Secret, code, time.Now())
+	if !valid {
+		err = ErrInvalidSecondFactor
+		return
+	}
+	return s.d.ConfirmTOTP(c, userID, step)
+}
+
+// DisableTOTP removes the user's time-based one-time password secret.
+func (s *Server) DisableTOTP(c util.Context, userID string) error {
+	return s.d.DisableTOTP(c, userID)
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes.
+func (s *Server) RegenerateRecoveryCodes(c util.Context, userID string) ([]string, error) {
+	return s.d.RegenerateRecoveryCodes(c, userID)
+}
+
+// BeginWebAuthnRegistration starts registering a WebAuthn credential for the
+// user, returning the options to pass to navigator.credentials.create as
+// JSON. The challenge is kept in the session, which must be saved afterwards.
+func (s *Server) BeginWebAuthnRegistration(c util.Context, sn *web.Session, userID string) ([]byte, error) {
+	u, err := s.user(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.d.Status(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.newChallenge(c, sn)
+	if err != nil {
+		return nil, err
+	}
+	params := make([]credentialParameters, 0, len(coseAlgorithms))
+	for _, alg := range coseAlgorithms {
+		params = append(params, credentialParameters{
+			Type: publicKeyCredentialType,
+			Alg:  alg,
+		})
+	}
+	return json.Marshal(creationOptions{
+		Challenge: challenge,
+		RP: relyingParty{
+			ID:   s.rpID,
+			Name: s.rpID,
+		},
+		User: webAuthnUser{
+			ID:          webAuthnEncoding.EncodeToString([]byte(userID)),
+			Name:        u.Email,
+			DisplayName: u.Email,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            webAuthnTimeout,
+		ExcludeCredentials: credentialDescriptors(st.WebAuthnCredentials),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	})
+}
+
+// FinishWebAuthnRegistration stores the credential that the browser created
+// for the challenge in the session, under the name that the user gave it. If
+// this enables two-factor authentication for the user, their new recovery
+// codes are returned.
+func (s *Server) FinishWebAuthnRegistration(c util.Context, sn *web.Session, userID, name string, credential []byte) (recoveryCodes []string, err error) {
+	challenge, ok, err := s.useChallenge(c, sn)
+	if err != nil {
+		return
+	} else if !ok {
+		err = fmt.Errorf("%w: no webauthn registration was started", ErrInvalidSecondFactor)
+		return
+	}
+	var wc models.WebAuthnCredential
+	if wc, err = s.verifyRegistration(credential, challenge); err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidSecondFactor, err)
+		return
+	}
+	wc.Name = name
+	_, recoveryCodes, err = s.d.AddWebAuthnCredential(c, userID, wc)
+	return
+}
+
+// RemoveWebAuthnCredential deletes one of the user's WebAuthn credentials.
+func (s *Server) RemoveWebAuthnCredential(c util.Context, userID, id string) error {
+	return s.d.RemoveWebAuthnCredential(c, userID, id)
+}
+
+// newChallenge creates a WebAuthn challenge for the browser. It is stored until
+// it is used or expires, and kept in the session to know which one the browser
+// was sent.
+func (s *Server) newChallenge(c util.Context, sn *web.Session) (string, error) {
+	challenge, err := newWebAuthnChallenge()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err = s.d.AddWebAuthnChallenge(c, challenge, now, now.Add(webAuthnTimeout*time.Millisecond)); err != nil {
+		return "", err
+	}
+	sn.SetWebAuthnChallenge(challenge)
+	return challenge, nil
+}
+
+// useChallenge takes the WebAuthn challenge out of the session. It is not ok
+// if there is none, or if it was already used or has expired, so that an old
+// session cannot be replayed.
+func (s *Server) useChallenge(c util.Context, sn *web.Session) (challenge string, ok bool, err error) {
+	if challenge, err = sn.WebAuthnChallenge(); err != nil {
+		return "", false, nil
+	}
+	sn.DeleteWebAuthnChallenge()
+	ok, err = s.d.UseWebAuthnChallenge(c, challenge, time.Now())
+	return
+}
+
+func (s *Server) user(c util.Context, userID string) (*services.User, error) {
+	u, err := s.u.UserByID(c, paths.UUID(userID))
+	if err != nil {
+		return nil, err
+	} else if u == nil {
+		return nil, fmt.Errorf("user %s not found", userID)
+	}
+	return u, nil
+}
@@ -0,0 +1,333 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package twofactor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/allinbits/apcore/models"
+)
+
+const (
+	// webAuthnChallengeSize is the number of random bytes in a challenge.
+	webAuthnChallengeSize = 32
+	// webAuthnTimeout is how long the browser waits for the user, in
+	// milliseconds.
+	webAuthnTimeout = 5 * 60 * 1000
+
+	publicKeyCredentialType = "public-key"
+	webAuthnCreateType      = "webauthn.create"
+	webAuthnGetType         = "webauthn.get"
+
+	// Authenticator data flags, as described by the Web Authentication
+	// specification Section 6.1.
+	flagUserPresent            = 0x01
+	flagAttestedCredentialData = 0x40
+)
+
+var webAuthnEncoding = base64.RawURLEncoding
+
+// decodeWebAuthnBase64 decodes the base64url values of the WebAuthn JSON
+// serializations, tolerating padding.
+func decodeWebAuthnBase64(s string) ([]byte, error) {
+	return webAuthnEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newWebAuthnChallenge() (string, error) {
+	b := make([]byte, webAuthnChallengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return webAuthnEncoding.EncodeToString(b), nil
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type credentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type webAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// creationOptions are the options for registering a credential, as serialized
+// for PublicKeyCredential.parseCreationOptionsFromJSON.
+type creationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     relyingParty           `json:"rp"`
+	User                   webAuthnUser           `json:"user"`
+	PubKeyCredParams       []credentialParameters `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// requestOptions are the options for logging in with a credential, as
+// serialized for PublicKeyCredential.parseRequestOptionsFromJSON.
+type requestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func credentialDescriptors(wc []models.WebAuthnCredential) []credentialDescriptor {
+	cd := make([]credentialDescriptor, 0, len(wc))
+	for _, c := range wc {
+		cd = append(cd, credentialDescriptor{
+			Type: publicKeyCredentialType,
+			ID:   webAuthnEncoding.EncodeToString(c.CredentialID),
+		})
+	}
+	return cd
+}
+
+// publicKeyCredential is a credential created or used by the browser, as
+// serialized by PublicKeyCredential.toJSON.
+type publicKeyCredential struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+	} `json:"response"`
+}
+
+func parsePublicKeyCredential(b []byte) (pkc publicKeyCredential, err error) {
+	if err = json.Unmarshal(b, &pkc); err != nil {
+		return
+	} else if pkc.Type != publicKeyCredentialType {
+		err = fmt.Errorf("credential type is %q", pkc.Type)
+	}
+	return
+}
+
+// clientData is the data that the browser passed to the authenticator, as
+// described by the Web Authentication specification Section 5.8.1.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the data that the authenticator signed, as described by
+// the Web Authentication specification Section 6.1.
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// The following are only set when registering a credential.
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(b []byte) (ad authenticatorData, err error) {
+	if len(b) < 37 {
+		err = fmt.Errorf("authenticator data is too short")
+		return
+	}
+	ad.rpIDHash = b[:32]
+	ad.flags = b[32]
+	ad.signCount = binary.BigEndian.Uint32(b[33:37])
+	if ad.flags&flagAttestedCredentialData == 0 {
+		return
+	}
+	// Skip the authenticator's AAGUID.
+	b = b[37:]
+	if len(b) < 18 {
+		err = fmt.Errorf("attested credential data is too short")
+		return
+	}
+	n := int(binary.BigEndian.Uint16(b[16:18]))
+	b = b[18:]
+	if len(b) < n {
+		err = fmt.Errorf("credential id length %d exceeds attested credential data", n)
+		return
+	}
+	ad.credentialID = b[:n]
+	b = b[n:]
+	var rest []byte
+	if _, rest, err = parseCOSEKey(b); err != nil {
+		return
+	}
+	ad.publicKey = b[:len(b)-len(rest)]
+	return
+}
+
+// verifyClientData checks that the client data is of the ceremony type, for
+// the challenge, and from this server's origin, returning its hash.
+func (s *Server) verifyClientData(b []byte, ceremony, challenge string) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(b, &cd); err != nil {
+		return nil, err
+	} else if cd.Type != ceremony {
+		return nil, fmt.Errorf("client data type is %q instead of %q", cd.Type, ceremony)
+	} else if len(challenge) == 0 || cd.Challenge != challenge {
+		return nil, fmt.Errorf("client data challenge does not match")
+	} else if cd.Origin != s.origin {
+		return nil, fmt.Errorf("client data origin %q is not %q", cd.Origin, s.origin)
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+// verifyAuthenticatorData checks that the authenticator data is for this
+// server, and that the user was present.
+func (s *Server) verifyAuthenticatorData(ad authenticatorData) error {
+	h := sha256.Sum256([]byte(s.rpID))
+	if !bytes.Equal(ad.rpIDHash, h[:]) {
+		return fmt.Errorf("authenticator data is for another relying party")
+	} else if ad.flags&flagUserPresent == 0 {
+		return fmt.Errorf("user was not present")
+	}
+	return nil
+}
+
+// verifyRegistration checks a credential created by the browser for the
+// challenge, as described by the Web Authentication specification Section
+// 7.1.
+//
+// Attestation statements are not verified, as "none" attestation is requested.
+func (s *Server) verifyRegistration(b []byte, challenge string) (wc models.WebAuthnCredential, err error) {
+	var pkc publicKeyCredential
+	if pkc, err = parsePublicKeyCredential(b); err != nil {
+		return
+	}
+	var cdj, ao []byte
+	if cdj, err = decodeWebAuthnBase64(pkc.Response.ClientDataJSON); err != nil {
+		return
+	} else if ao, err = decodeWebAuthnBase64(pkc.Response.AttestationObject); err != nil {
+		return
+	}
+	if _, err = s.verifyClientData(cdj, webAuthnCreateType, challenge); err != nil {
+		return
+	}
+	var v interface{}
+	if v, _, err = decodeCBOR(ao); err != nil {
+		return
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		err = fmt.Errorf("attestation object is not a map")
+		return
+	}
+	adb, ok := m["authData"].([]byte)
+	if !ok {
+		err = fmt.Errorf("attestation object has no authenticator data")
+		return
+	}
+	var ad authenticatorData
+	if ad, err = parseAuthenticatorData(adb); err != nil {
+		return
+	} else if err = s.verifyAuthenticatorData(ad); err != nil {
+		return
+	} else if len(ad.credentialID) == 0 {
+		err = fmt.Errorf("authenticator data has no attested credential")
+		return
+	}
+	wc = models.WebAuthnCredential{
+		CredentialID: ad.credentialID,
+		PublicKey:    ad.publicKey,
+		SignCount:    int64(ad.signCount),
+	}
+	return
+}
+
+// verifyAssertion checks that the browser used one of the credentials to sign
+// the challenge, as described by the Web Authentication specification Section
+// 7.2, returning the credential used and its new signature counter.
+func (s *Server) verifyAssertion(b []byte, challenge string, wc []models.WebAuthnCredential) (used models.WebAuthnCredential, signCount int64, err error) {
+	var pkc publicKeyCredential
+	if pkc, err = parsePublicKeyCredential(b); err != nil {
+		return
+	}
+	var rawID, cdj, adb, sig []byte
+	if rawID, err = decodeWebAuthnBase64(pkc.RawID); err != nil {
+		return
+	} else if cdj, err = decodeWebAuthnBase64(pkc.Response.ClientDataJSON); err != nil {
+		return
+	} else if adb, err = decodeWebAuthnBase64(pkc.Response.AuthenticatorData); err != nil {
+		return
+	} else if sig, err = decodeWebAuthnBase64(pkc.Response.Signature); err != nil {
+		return
+	}
+	found := false
+	for _, c := range wc {
+		if bytes.Equal(c.CredentialID, rawID) {
+			used = c
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = fmt.Errorf("credential is not registered to the user")
+		return
+	}
+	var cdh []byte
+	if cdh, err = s.verifyClientData(cdj, webAuthnGetType, challenge); err != nil {
+		return
+	}
+	var ad authenticatorData
+	if ad, err = parseAuthenticatorData(adb); err != nil {
+		return
+	} else if err = s.verifyAuthenticatorData(ad); err != nil {
+		return
+	}
+	var k coseKey
+	if k, _, err = parseCOSEKey(used.PublicKey); err != nil {
+		return
+	} else if err = k.verify(append(append([]byte(nil), adb...), cdh...), sig); err != nil {
+		return
+	}
+	// Authenticators that support signature counters always increase
+	// them, so a counter that did not increase suggests the credential
+	// was cloned.
+	signCount = int64(ad.signCount)
+	if (signCount != 0 || used.SignCount != 0) && signCount <= used.SignCount {
+		err = fmt.Errorf("signature counter did not increase, the authenticator may be cloned")
+	}
+	return
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/allinbits/apcore/framework/config"
 	"github.com/allinbits/apcore/util"
@@ -135,9 +136,68 @@ func (s *Session) DeleteFirstPartyCredentialID() {
 	delete(s.gs.Values, firstPartyCredentialKey)
 }
 
+const (
+	secondFactorUserIDKey  = "2fauserid"
+	secondFactorExpiresKey = "2faexpires"
+	webAuthnChallengeKey   = "webauthnchal"
+)
+
+// SetSecondFactorUserID records that the user entered their password, and
+// must provide a second factor before the expiration to log in.
+func (s *Session) SetSecondFactorUserID(uuid string, expires time.Time) {
+	s.gs.Values[secondFactorUserIDKey] = uuid
+	s.gs.Values[secondFactorExpiresKey] = expires.Unix()
+}
+
+// SecondFactorUserID returns the user who entered their password but has yet
+// to provide a second factor, unless their time to do so expired.
+func (s *Session) SecondFactorUserID(now time.Time) (uuid string, err error) {
+	if v, ok := s.gs.Values[secondFactorUserIDKey]; !ok {
+		err = fmt.Errorf("no second factor user id in session")
+		return
+	} else if uuid, ok = v.(string); !ok {
+		err = fmt.Errorf("second factor user id in session is not a string")
+		return
+	}
+	if v, ok := s.gs.Values[secondFactorExpiresKey].(int64); !ok {
+		err = fmt.Errorf("second factor expiration in session is not an int64")
+		return
+	} else if now.After(time.Unix(v, 0)) {
+		err = fmt.Errorf("second factor user id in session expired")
+		return
+	}
+	return
+}
+
+func (s *Session) DeleteSecondFactorUserID() {
+	delete(s.gs.Values, secondFactorUserIDKey)
+	delete(s.gs.Values, secondFactorExpiresKey)
+}
+
+func (s *Session) SetWebAuthnChallenge(challenge string) {
+	s.gs.Values[webAuthnChallengeKey] = challenge
+}
+
+func (s *Session) WebAuthnChallenge() (challenge string, err error) {
+	if v, ok := s.gs.Values[webAuthnChallengeKey]; !ok {
+		err = fmt.Errorf("no webauthn challenge in session")
+		return
+	} else if challenge, ok = v.(string); !ok {
+		err = fmt.Errorf("webauthn challenge in session is not a string")
+		return
+	}
+	return
+}
+
+func (s *Session) DeleteWebAuthnChallenge() {
+	delete(s.gs.Values, webAuthnChallengeKey)
+}
+
 func (s *Session) Clear() {
 	s.DeleteUserID()
 	s.DeleteFirstPartyCredentialID()
+	s.DeleteSecondFactorUserID()
+	s.DeleteWebAuthnChallenge()
 }
 
 func (s *Session) Set(k string, i interface{}) {
@@ -56,6 +56,7 @@ func (c *ClientInfo) GetUserID() string {
 type ClientInfos struct {
 	create  *sql.Stmt
 	getByID *sql.Stmt
+	del     *sql.Stmt
 }
 
 func (c *ClientInfos) Prepare(db *sql.DB, s SqlDialect) error {
@@ -63,6 +64,7 @@ func (c *ClientInfos) Prepare(db *sql.DB, s SqlDialect) error {
 		stmtPairs{
 			{&(c.create), s.CreateClientInfo()},
 			{&(c.getByID), s.GetClientInfoByID()},
+			{&(c.del), s.DeleteClientInfo()},
 		})
 }
 
@@ -74,6 +76,7 @@ func (c *ClientInfos) CreateTable(t *sql.Tx, s SqlDialect) error {
 func (c *ClientInfos) Close() {
 	c.create.Close()
 	c.getByID.Close()
+	c.del.Close()
 }
 
 // Create adds a ClientInfo into the database.
@@ -105,3 +108,10 @@ func (c *ClientInfos) GetByID(ctx util.Context, tx *sql.Tx, id string) (oauth2.C
 		return r.Scan(&(ci.ID), &(ci.Secret), &(ci.Domain), &(ci.UserID))
 	})
 }
+
+// Delete removes a ClientInfo from the database, along with the tokens issued
+// to it.
+func (c *ClientInfos) Delete(ctx util.Context, tx *sql.Tx, id string) error {
+	r, err := tx.Stmt(c.del).ExecContext(ctx, id)
+	return mustChangeOneRow(r, err, "ClientInfos.Delete")
+}
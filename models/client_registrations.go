@@ -0,0 +1,133 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// ClientMetadata is the metadata of an OAuth2 client registered through the
+// dynamic client registration endpoint, as described by RFC 7591.
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	Contacts                []string `json:"contacts,omitempty"`
+	TOSURI                  string   `json:"tos_uri,omitempty"`
+	PolicyURI               string   `json:"policy_uri,omitempty"`
+	SoftwareID              string   `json:"software_id,omitempty"`
+	SoftwareVersion         string   `json:"software_version,omitempty"`
+}
+
+var _ driver.Valuer = ClientMetadata{}
+var _ sql.Scanner = &ClientMetadata{}
+
+func (m ClientMetadata) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+func (m *ClientMetadata) Scan(src interface{}) error {
+	return unmarshal(src, m)
+}
+
+// ClientRegistration is an OAuth2 client registered through the dynamic client
+// registration endpoint.
+type ClientRegistration struct {
+	ClientID   string
+	Metadata   ClientMetadata
+	CreateTime time.Time
+}
+
+var _ Model = &ClientRegistrations{}
+
+// ClientRegistrations is a Model that provides additional database methods
+// for the metadata of dynamically registered OAuth2 clients.
+type ClientRegistrations struct {
+	create        *sql.Stmt
+	getByClientID *sql.Stmt
+	getAll        *sql.Stmt
+}
+
+func (c *ClientRegistrations) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(c.create), s.CreateClientRegistration()},
+			{&(c.getByClientID), s.GetClientRegistration()},
+			{&(c.getAll), s.GetClientRegistrations()},
+		})
+}
+
+func (c *ClientRegistrations) CreateTable(t *sql.Tx, s SqlDialect) error {
+	_, err := t.Exec(s.CreateClientRegistrationsTable())
+	return err
+}
+
+func (c *ClientRegistrations) Close() {
+	c.create.Close()
+	c.getByClientID.Close()
+	c.getAll.Close()
+}
+
+// Create records the metadata of a registered client.
+func (c *ClientRegistrations) Create(ctx util.Context, tx *sql.Tx, clientID string, md ClientMetadata) error {
+	r, err := tx.Stmt(c.create).ExecContext(ctx, clientID, md)
+	return mustChangeOneRow(r, err, "ClientRegistrations.Create")
+}
+
+// GetByClientID fetches the registration of a client, which is not found if
+// the client was not dynamically registered.
+func (c *ClientRegistrations) GetByClientID(ctx util.Context, tx *sql.Tx, clientID string) (cr ClientRegistration, found bool, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(c.getByClientID).QueryContext(ctx, clientID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "ClientRegistrations.GetByClientID", func(r SingleRow) error {
+		found = true
+		return r.Scan(&(cr.ClientID), &(cr.Metadata), &(cr.CreateTime))
+	})
+	return
+}
+
+// GetAll fetches the registrations of all registered clients, newest first.
+func (c *ClientRegistrations) GetAll(ctx util.Context, tx *sql.Tx) (cr []ClientRegistration, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(c.getAll).QueryContext(ctx)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return cr, doForRows(rows, "ClientRegistrations.GetAll", func(r SingleRow) error {
+		var reg ClientRegistration
+		if err := r.Scan(&(reg.ClientID), &(reg.Metadata), &(reg.CreateTime)); err != nil {
+			return err
+		}
+		cr = append(cr, reg)
+		return nil
+	})
+}
@@ -19,17 +19,21 @@ package models
 import (
 	"database/sql"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/allinbits/apcore/util"
 )
 
 // These constants are used to mark the simple state of the delivery attempt.
+//
+// New and failed attempts are queued for delivery, while successful and
+// abandoned attempts are finished.
 const (
-	newDeliveryAttempt       = "new"
-	successDeliveryAttempt   = "success"
-	failedDeliveryAttempt    = "failed"
-	abandonedDeliveryAttempt = "abandoned"
+	NewDeliveryAttempt       = "new"
+	SuccessDeliveryAttempt   = "success"
+	FailedDeliveryAttempt    = "failed"
+	AbandonedDeliveryAttempt = "abandoned"
 )
 
 var _ Model = &DeliveryAttempts{}
@@ -41,8 +45,14 @@ type DeliveryAttempts struct {
 	markDeliveryAttemptSuccessful *sql.Stmt
 	markDeliveryAttemptFailed     *sql.Stmt
 	markDeliveryAttemptAbandoned  *sql.Stmt
-	firstRetryablePage            *sql.Stmt
-	nextRetryablePage             *sql.Stmt
+	getDue                        *sql.Stmt
+	claim                         *sql.Stmt
+	release                       *sql.Stmt
+	postpone                      *sql.Stmt
+	abandonTo                     *sql.Stmt
+	get                           *sql.Stmt
+	requeue                       *sql.Stmt
+	delete                        *sql.Stmt
 }
 
 func (d *DeliveryAttempts) Prepare(db *sql.DB, s SqlDialect) error {
@@ -52,13 +62,22 @@ func (d *DeliveryAttempts) Prepare(db *sql.DB, s SqlDialect) error {
 			{&(d.markDeliveryAttemptSuccessful), s.MarkSuccessfulAttempt()},
 			{&(d.markDeliveryAttemptFailed), s.MarkFailedAttempt()},
 			{&(d.markDeliveryAttemptAbandoned), s.MarkAbandonedAttempt()},
-			{&(d.firstRetryablePage), s.FirstPageRetryableFailures()},
-			{&(d.nextRetryablePage), s.NextPageRetryableFailures()},
+			{&(d.getDue), s.GetDueAttempts()},
+			{&(d.claim), s.ClaimAttempt()},
+			{&(d.release), s.ReleaseAttempt()},
+			{&(d.postpone), s.PostponeAttempts()},
+			{&(d.abandonTo), s.AbandonAttemptsTo()},
+			{&(d.get), s.GetAttempts()},
+			{&(d.requeue), s.RequeueAttempts()},
+			{&(d.delete), s.DeleteAttempts()},
 		})
 }
 
 func (d *DeliveryAttempts) CreateTable(t *sql.Tx, s SqlDialect) error {
-	_, err := t.Exec(s.CreateDeliveryAttemptsTable())
+	if _, err := t.Exec(s.CreateDeliveryAttemptsTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexNextAttemptDeliveryAttemptsTable())
 	return err
 }
 
@@ -66,6 +85,15 @@ func (d *DeliveryAttempts) Close() {
 	d.insertDeliveryAttempt.Close()
 	d.markDeliveryAttemptSuccessful.Close()
 	d.markDeliveryAttemptFailed.Close()
+	d.markDeliveryAttemptAbandoned.Close()
+	d.getDue.Close()
+	d.claim.Close()
+	d.release.Close()
+	d.postpone.Close()
+	d.abandonTo.Close()
+	d.get.Close()
+	d.requeue.Close()
+	d.delete.Close()
 }
 
 // Create a new delivery attempt.
@@ -75,7 +103,7 @@ func (d *DeliveryAttempts) Create(c util.Context, tx *sql.Tx, from string, toAct
 		from,
 		toActor.String(),
 		payload,
-		newDeliveryAttempt)
+		NewDeliveryAttempt)
 	if err != nil {
 		return
 	}
@@ -89,15 +117,17 @@ func (d *DeliveryAttempts) Create(c util.Context, tx *sql.Tx, from string, toAct
 func (d *DeliveryAttempts) MarkSuccessful(c util.Context, tx *sql.Tx, id string) error {
 	r, err := tx.Stmt(d.markDeliveryAttemptSuccessful).ExecContext(c,
 		id,
-		successDeliveryAttempt)
+		SuccessDeliveryAttempt)
 	return mustChangeOneRow(r, err, "DeliveryAttempts.MarkSuccessful")
 }
 
-// MarkFailed marks a delivery attempt as failed.
-func (d *DeliveryAttempts) MarkFailed(c util.Context, tx *sql.Tx, id string) error {
+// MarkFailed marks a delivery attempt as failed, to be attempted again at the
+// given time.
+func (d *DeliveryAttempts) MarkFailed(c util.Context, tx *sql.Tx, id string, next time.Time) error {
 	r, err := tx.Stmt(d.markDeliveryAttemptFailed).ExecContext(c,
 		id,
-		failedDeliveryAttempt)
+		FailedDeliveryAttempt,
+		next)
 	return mustChangeOneRow(r, err, "DeliveryAttempts.MarkFailed")
 }
 
@@ -105,51 +135,180 @@ func (d *DeliveryAttempts) MarkFailed(c util.Context, tx *sql.Tx, id string) err
 func (d *DeliveryAttempts) MarkAbandoned(c util.Context, tx *sql.Tx, id string) error {
 	r, err := tx.Stmt(d.markDeliveryAttemptAbandoned).ExecContext(c,
 		id,
-		abandonedDeliveryAttempt)
+		AbandonedDeliveryAttempt)
 	return mustChangeOneRow(r, err, "DeliveryAttempts.Abandoned")
 }
 
-type RetryableFailure struct {
+// DueAttempt is a new or failed delivery attempt that is due to be delivered.
+type DueAttempt struct {
 	ID          string
 	UserID      string
 	DeliverTo   URL
 	Payload     []byte
 	NAttempts   int
 	LastAttempt time.Time
+	NextAttempt time.Time
 }
 
-// FirstPageFailures obtains the first page of retryable failures.
-func (d *DeliveryAttempts) FirstPageFailures(c util.Context, tx *sql.Tx, fetchTime time.Time, n int) (rf []RetryableFailure, err error) {
+// GetDue obtains up to n new or failed delivery attempts that are due to be
+// delivered at the given time, the longest overdue first.
+func (d *DeliveryAttempts) GetDue(c util.Context, tx *sql.Tx, now time.Time, n int) (da []DueAttempt, err error) {
 	var rows *sql.Rows
-	rows, err = tx.Stmt(d.firstRetryablePage).QueryContext(c, failedDeliveryAttempt, fetchTime, n)
+	rows, err = tx.Stmt(d.getDue).QueryContext(c, NewDeliveryAttempt, FailedDeliveryAttempt, now, n)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
-	return rf, doForRows(rows, "DeliveryAttempts.FirstPageFailures", func(r SingleRow) error {
-		var rt RetryableFailure
-		if err := r.Scan(&(rt.ID), &(rt.UserID), &(rt.DeliverTo), &(rt.Payload), &(rt.NAttempts), &(rt.LastAttempt)); err != nil {
+	return da, doForRows(rows, "DeliveryAttempts.GetDue", func(r SingleRow) error {
+		var a DueAttempt
+		if err := r.Scan(&(a.ID), &(a.UserID), &(a.DeliverTo), &(a.Payload), &(a.NAttempts), &(a.LastAttempt), &(a.NextAttempt)); err != nil {
 			return err
 		}
-		rf = append(rf, rt)
+		da = append(da, a)
 		return nil
 	})
 }
 
-// NextPageFailures obtains the next page of retryable failures.
-func (d *DeliveryAttempts) NextPageFailures(c util.Context, tx *sql.Tx, prevID string, fetchTime time.Time, n int) (rf []RetryableFailure, err error) {
+// Claim delays a delivery attempt that is still due at the given time until
+// the claim expires, so that it is not obtained again in the meantime. It
+// reports false if the attempt is no longer due, such as when it was already
+// claimed by another process.
+func (d *DeliveryAttempts) Claim(c util.Context, tx *sql.Tx, id string, now, until time.Time) (ok bool, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.claim).ExecContext(c,
+		id,
+		NewDeliveryAttempt,
+		FailedDeliveryAttempt,
+		now,
+		until)
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = r.RowsAffected()
+	return n == 1, err
+}
+
+// Release ends the claim on a delivery attempt that was not made, making it
+// due again at the given time. An attempt that is no longer queued is left
+// unchanged.
+func (d *DeliveryAttempts) Release(c util.Context, tx *sql.Tx, id string, next time.Time) error {
+	_, err := tx.Stmt(d.release).ExecContext(c,
+		id,
+		NewDeliveryAttempt,
+		FailedDeliveryAttempt,
+		next)
+	return err
+}
+
+// PostponeToPrefix delays all new or failed delivery attempts to IRIs
+// beginning with the prefix until at least the given time.
+func (d *DeliveryAttempts) PostponeToPrefix(c util.Context, tx *sql.Tx, prefix string, until time.Time) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.postpone).ExecContext(c,
+		NewDeliveryAttempt,
+		FailedDeliveryAttempt,
+		likePrefix(prefix),
+		until)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
+
+// AbandonTo abandons all new or failed delivery attempts to the IRI.
+func (d *DeliveryAttempts) AbandonTo(c util.Context, tx *sql.Tx, to *url.URL) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.abandonTo).ExecContext(c,
+		NewDeliveryAttempt,
+		FailedDeliveryAttempt,
+		to.String(),
+		AbandonedDeliveryAttempt)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
+
+// DeliveryAttemptFilter selects delivery attempts. The zero value of a field
+// does not filter on it.
+type DeliveryAttemptFilter struct {
+	State string
+	// Host of the IRI being delivered to.
+	Host   string
+	FromID string
+	// CreatedBefore is required.
+	CreatedBefore time.Time
+}
+
+func (f DeliveryAttemptFilter) args() []interface{} {
+	var host string
+	if len(f.Host) > 0 {
+		host = "%://" + likeEscape(f.Host) + "/%"
+	}
+	return []interface{}{f.State, host, f.FromID, f.CreatedBefore}
+}
+
+// DeliveryAttempt is a delivery attempt without its payload.
+type DeliveryAttempt struct {
+	ID          string
+	UserID      string
+	DeliverTo   URL
+	State       string
+	NAttempts   int
+	CreateTime  time.Time
+	LastAttempt time.Time
+	NextAttempt time.Time
+}
+
+// Get obtains up to n delivery attempts that match the filter, the most
+// recently created first.
+func (d *DeliveryAttempts) Get(c util.Context, tx *sql.Tx, f DeliveryAttemptFilter, n int) (da []DeliveryAttempt, err error) {
 	var rows *sql.Rows
-	rows, err = tx.Stmt(d.nextRetryablePage).QueryContext(c, failedDeliveryAttempt, fetchTime, n, prevID)
+	rows, err = tx.Stmt(d.get).QueryContext(c, append(f.args(), n)...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
-	return rf, doForRows(rows, "DeliveryAttempts.NextPageFailures", func(r SingleRow) error {
-		var rt RetryableFailure
-		if err := r.Scan(&(rt.ID), &(rt.UserID), &(rt.DeliverTo), &(rt.Payload), &(rt.NAttempts), &(rt.LastAttempt)); err != nil {
+	return da, doForRows(rows, "DeliveryAttempts.Get", func(r SingleRow) error {
+		var a DeliveryAttempt
+		if err := r.Scan(&(a.ID), &(a.UserID), &(a.DeliverTo), &(a.State), &(a.NAttempts), &(a.CreateTime), &(a.LastAttempt), &(a.NextAttempt)); err != nil {
 			return err
 		}
-		rf = append(rf, rt)
+		da = append(da, a)
 		return nil
 	})
 }
+
+// Requeue queues all delivery attempts that match the filter to be delivered
+// immediately, as if they were new.
+func (d *DeliveryAttempts) Requeue(c util.Context, tx *sql.Tx, f DeliveryAttemptFilter) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.requeue).ExecContext(c, append(f.args(), NewDeliveryAttempt)...)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
+
+// Delete removes all delivery attempts that match the filter.
+func (d *DeliveryAttempts) Delete(c util.Context, tx *sql.Tx, f DeliveryAttemptFilter) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.delete).ExecContext(c, f.args()...)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
+
+// likePrefix returns a LIKE pattern, escaped with '\', that matches values
+// beginning with the prefix.
+func likePrefix(prefix string) string {
+	return likeEscape(prefix) + "%"
+}
+
+// likeEscape escapes the special characters of a LIKE pattern with '\'.
+func likeEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,124 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// CachedObject is a federated object or actor as fetched from a peer, along
+// with what is needed to revalidate it.
+type CachedObject struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	// Expires is when the object must be revalidated before being used.
+	Expires time.Time
+}
+
+var _ Model = &DereferenceCache{}
+
+// DereferenceCache is a Model that provides additional database methods for
+// caching federated data fetched from peers.
+type DereferenceCache struct {
+	get        *sql.Stmt
+	upsert     *sql.Stmt
+	revalidate *sql.Stmt
+	delete     *sql.Stmt
+	prune      *sql.Stmt
+}
+
+func (d *DereferenceCache) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(d.get), s.GetDereferenceCache()},
+			{&(d.upsert), s.UpsertDereferenceCache()},
+			{&(d.revalidate), s.RevalidateDereferenceCache()},
+			{&(d.delete), s.DeleteDereferenceCache()},
+			{&(d.prune), s.PruneDereferenceCache()},
+		})
+}
+
+func (d *DereferenceCache) CreateTable(t *sql.Tx, s SqlDialect) error {
+	if _, err := t.Exec(s.CreateDereferenceCacheTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexExpiresDereferenceCacheTable())
+	return err
+}
+
+func (d *DereferenceCache) Close() {
+	d.get.Close()
+	d.upsert.Close()
+	d.revalidate.Close()
+	d.delete.Close()
+	d.prune.Close()
+}
+
+// Get retrieves the cached object with the IRI, if there is one.
+func (d *DereferenceCache) Get(c util.Context, tx *sql.Tx, iri *url.URL) (o CachedObject, found bool, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(d.get).QueryContext(c, iri.String())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "DereferenceCache.Get", func(r SingleRow) error {
+		found = true
+		return r.Scan(&(o.Body), &(o.ETag), &(o.LastModified), &(o.Expires))
+	})
+	return
+}
+
+// Put caches the object with the IRI, replacing any already cached.
+func (d *DereferenceCache) Put(c util.Context, tx *sql.Tx, iri *url.URL, o CachedObject) error {
+	r, err := tx.Stmt(d.upsert).ExecContext(c,
+		iri.String(),
+		o.Body,
+		o.ETag,
+		o.LastModified,
+		o.Expires)
+	return mustChangeOneRow(r, err, "DereferenceCache.Put")
+}
+
+// Revalidate sets when the cached object with the IRI must next be
+// revalidated.
+func (d *DereferenceCache) Revalidate(c util.Context, tx *sql.Tx, iri *url.URL, expires time.Time) error {
+	_, err := tx.Stmt(d.revalidate).ExecContext(c, iri.String(), expires)
+	return err
+}
+
+// Delete removes the cached object with the IRI, if there is one.
+func (d *DereferenceCache) Delete(c util.Context, tx *sql.Tx, iri *url.URL) error {
+	_, err := tx.Stmt(d.delete).ExecContext(c, iri.String())
+	return err
+}
+
+// Prune removes the cached objects that needed revalidating before the given
+// time.
+func (d *DereferenceCache) Prune(c util.Context, tx *sql.Tx, before time.Time) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(d.prune).ExecContext(c, before)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
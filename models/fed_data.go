@@ -33,6 +33,7 @@ type FedData struct {
 	fedCreate *sql.Stmt
 	fedUpdate *sql.Stmt
 	fedDelete *sql.Stmt
+	getRecent *sql.Stmt
 }
 
 func (f *FedData) Prepare(db *sql.DB, s SqlDialect) error {
@@ -43,6 +44,7 @@ func (f *FedData) Prepare(db *sql.DB, s SqlDialect) error {
 			{&(f.fedCreate), s.FedCreate()},
 			{&(f.fedUpdate), s.FedUpdate()},
 			{&(f.fedDelete), s.FedDelete()},
+			{&(f.getRecent), s.FedGetRecent()},
 		})
 }
 
@@ -60,6 +62,7 @@ func (f *FedData) Close() {
 	f.fedCreate.Close()
 	f.fedUpdate.Close()
 	f.fedDelete.Close()
+	f.getRecent.Close()
 }
 
 // Exists determines if the ID is stored in the federated table.
@@ -109,3 +112,23 @@ func (f *FedData) Delete(c util.Context, tx *sql.Tx, fedIDIRI *url.URL) error {
 	r, err := tx.Stmt(f.fedDelete).ExecContext(c, fedIDIRI.String())
 	return mustChangeOneRow(r, err, "FedData.Delete")
 }
+
+// GetRecent retrieves the JSON payloads of the most recently stored federated
+// data, newest first. They are not deserialized, as some may not be
+// understood.
+func (f *FedData) GetRecent(c util.Context, tx *sql.Tx, limit int) (payloads [][]byte, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(f.getRecent).QueryContext(c, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return payloads, doForRows(rows, "FedData.GetRecent", func(r SingleRow) error {
+		var b []byte
+		if err := r.Scan(&b); err != nil {
+			return err
+		}
+		payloads = append(payloads, b)
+		return nil
+	})
+}
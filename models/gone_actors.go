@@ -0,0 +1,136 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// GoneActor is a federated actor whose inbox was reported as permanently gone.
+type GoneActor struct {
+	ActorIRI URL
+	Inbox    URL
+	GoneAt   time.Time
+	// Expires is when deliveries to the inbox are attempted again.
+	Expires time.Time
+}
+
+var _ Model = &GoneActors{}
+
+// GoneActors is a Model that provides additional database methods for the
+// federated actors that are gone.
+type GoneActors struct {
+	inboxExists *sql.Stmt
+	upsert      *sql.Stmt
+	getAll      *sql.Stmt
+	delete      *sql.Stmt
+	deleteAll   *sql.Stmt
+}
+
+func (g *GoneActors) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(g.inboxExists), s.GoneActorInboxExists()},
+			{&(g.upsert), s.UpsertGoneActor()},
+			{&(g.getAll), s.GetGoneActors()},
+			{&(g.delete), s.DeleteGoneActor()},
+			{&(g.deleteAll), s.DeleteGoneActors()},
+		})
+}
+
+func (g *GoneActors) CreateTable(t *sql.Tx, s SqlDialect) error {
+	if _, err := t.Exec(s.CreateGoneActorsTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexInboxGoneActorsTable())
+	return err
+}
+
+func (g *GoneActors) Close() {
+	g.inboxExists.Close()
+	g.upsert.Close()
+	g.getAll.Close()
+	g.delete.Close()
+	g.deleteAll.Close()
+}
+
+// InboxExists determines if the inbox belongs to an actor that is gone, and
+// has not yet expired at the given time.
+func (g *GoneActors) InboxExists(c util.Context, tx *sql.Tx, inbox *url.URL, now time.Time) (exists bool, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(g.inboxExists).QueryContext(c, inbox.String(), now)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "GoneActors.InboxExists", func(r SingleRow) error {
+		return r.Scan(&exists)
+	})
+	return
+}
+
+// Upsert records the actor with the inbox as gone until the expiry, replacing
+// any earlier record of the actor.
+func (g *GoneActors) Upsert(c util.Context, tx *sql.Tx, actor, inbox *url.URL, expires time.Time) error {
+	r, err := tx.Stmt(g.upsert).ExecContext(c, actor.String(), inbox.String(), expires)
+	return mustChangeOneRow(r, err, "GoneActors.Upsert")
+}
+
+// GetAll returns all actors recorded as gone, most recently gone first,
+// including those that have expired.
+func (g *GoneActors) GetAll(c util.Context, tx *sql.Tx) (ga []GoneActor, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(g.getAll).QueryContext(c)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return ga, doForRows(rows, "GoneActors.GetAll", func(r SingleRow) error {
+		var a GoneActor
+		if err := r.Scan(&(a.ActorIRI), &(a.Inbox), &(a.GoneAt), &(a.Expires)); err != nil {
+			return err
+		}
+		ga = append(ga, a)
+		return nil
+	})
+}
+
+// Delete forgets that the actor is gone, returning whether it was recorded.
+func (g *GoneActors) Delete(c util.Context, tx *sql.Tx, actor *url.URL) (deleted bool, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(g.delete).ExecContext(c, actor.String())
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = r.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteAll forgets every actor that is gone, returning how many there were.
+func (g *GoneActors) DeleteAll(c util.Context, tx *sql.Tx) (n int64, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(g.deleteAll).ExecContext(c)
+	if err != nil {
+		return
+	}
+	return r.RowsAffected()
+}
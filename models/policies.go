@@ -24,18 +24,86 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/allinbits/apcore/util"
 	"github.com/tidwall/gjson"
 )
 
 const (
+	// FederatedBlockPurpose rejects a matching federated activity.
 	FederatedBlockPurpose Purpose = "federated_block"
+	// QuarantinePurpose keeps a matching federated activity, but hides it
+	// from the recipient's inbox.
+	QuarantinePurpose Purpose = "quarantine"
+	// StripMediaPurpose removes the attachments of a matching federated
+	// activity and its embedded objects.
+	StripMediaPurpose Purpose = "strip_media"
+	// DropMentionsPurpose removes the Mention tags of a matching federated
+	// activity and its embedded objects.
+	DropMentionsPurpose Purpose = "drop_mentions"
+	// ForceSensitivePurpose marks a matching federated activity and its
+	// embedded objects as sensitive.
+	ForceSensitivePurpose Purpose = "force_sensitive"
 )
 
 type Purpose string
 
+const (
+	// ActorPolicyScope applies a policy to the activities received by a
+	// single actor.
+	ActorPolicyScope PolicyScope = "actor"
+	// InstancePolicyScope applies a policy to all federated activities
+	// received by the instance.
+	InstancePolicyScope PolicyScope = "instance"
+	// DomainPolicyScope applies a policy to all federated activities from
+	// actors on a domain or its subdomains.
+	DomainPolicyScope PolicyScope = "domain"
+)
+
+// PolicyScope determines which federated activities a Policy applies to.
+type PolicyScope string
+
+// ProofStatusProperty is the property of a federated activity that holds the
+// ProofStatus of its Data Integrity proof when policies are resolved against
+// it, so that they can match on it. Any value sent by the peer is replaced.
+const ProofStatusProperty = "apcoreProofStatus"
+
+const (
+	// ProofAbsent is the status of a federated activity without a proof.
+	ProofAbsent ProofStatus = "absent"
+	// ProofValid is the status of a federated activity with a proof that
+	// verifies it was created by its actor.
+	ProofValid ProofStatus = "valid"
+	// ProofInvalid is the status of a federated activity with a proof that
+	// could not be verified.
+	ProofInvalid ProofStatus = "invalid"
+)
+
+// ProofStatus is the outcome of verifying the Data Integrity proof of a
+// federated activity.
+type ProofStatus string
+
+// Purposes lists all of the supported Purposes.
+var Purposes = []Purpose{
+	FederatedBlockPurpose,
+	QuarantinePurpose,
+	StripMediaPurpose,
+	DropMentionsPurpose,
+	ForceSensitivePurpose,
+}
+
+func (p Purpose) Validate() error {
+	for _, known := range Purposes {
+		if p == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown purpose: %q", string(p))
+}
+
 var _ driver.Valuer = Policy{}
 var _ sql.Scanner = &Policy{}
 
@@ -43,6 +111,19 @@ type Policy struct {
 	Name        string       `json:"name,omitempty"`
 	Description string       `json:"description,omitempty"`
 	Matchers    []*KVMatcher `json:"matchers,omitempty"`
+	Lists       Lists        `json:"lists,omitempty"`
+}
+
+// Lists are named sets of strings that a Value can test membership in.
+type Lists map[string][]string
+
+func (l Lists) contains(name, v string) bool {
+	for _, e := range l[name] {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
 
 func (p Policy) Value() (driver.Value, error) {
@@ -58,7 +139,7 @@ func (p Policy) Validate() error {
 		return errors.New("missing name")
 	}
 	for _, m := range p.Matchers {
-		if err := m.Validate(); err != nil {
+		if err := m.Validate(p.Lists); err != nil {
 			return err
 		}
 	}
@@ -70,7 +151,7 @@ func (p Policy) Resolve(json []byte, r *Resolution) error {
 	var err error
 	for idx, m := range p.Matchers {
 		r.Logf("resolving matcher %d", idx)
-		if err2 := m.Resolve(json, r); err2 != nil {
+		if err2 := m.Resolve(json, p.Lists, r); err2 != nil {
 			if err == nil {
 				err = err2
 			} else {
@@ -87,23 +168,23 @@ type KVMatcher struct {
 	ValueMatcher *UnaryMatcher `json:"valueMatcher,omitempty"`
 }
 
-func (k KVMatcher) Validate() error {
+func (k KVMatcher) Validate(l Lists) error {
 	if len(k.KeyPathQuery) == 0 {
 		return errors.New("missing keyPathQuery")
 	} else if k.ValueMatcher == nil {
 		return errors.New("missing valueMatcher")
 	}
-	return k.ValueMatcher.Validate()
+	return k.ValueMatcher.Validate(l)
 }
 
-func (k KVMatcher) Resolve(json []byte, r *Resolution) (err error) {
+func (k KVMatcher) Resolve(json []byte, l Lists, r *Resolution) (err error) {
 	if r.Matched {
 		r.Logf("resolution already found match, skipping examining %q", k.KeyPathQuery)
 		return
 	}
 	r.Logf("examining value of %q", k.KeyPathQuery)
 	result := gjson.GetBytes(json, k.KeyPathQuery)
-	r.Matched, err = k.ValueMatcher.Match(result, json, r)
+	r.Matched, err = k.ValueMatcher.Match(result, json, l, r)
 	return
 }
 
@@ -115,7 +196,7 @@ type UnaryMatcher struct {
 	Empty bool           `json:"empty,omitempty"`
 }
 
-func (u UnaryMatcher) Validate() error {
+func (u UnaryMatcher) Validate(l Lists) error {
 	n := 0
 	if u.Not != nil {
 		n++
@@ -137,12 +218,21 @@ func (u UnaryMatcher) Validate() error {
 	} else if n == 0 {
 		return errors.New("unary matcher has no fields set")
 	}
+	if u.Not != nil {
+		return u.Not.Validate(l)
+	} else if u.And != nil {
+		return u.And.Validate(l)
+	} else if u.Or != nil {
+		return u.Or.Validate(l)
+	} else if u.Value != nil {
+		return u.Value.Validate(l)
+	}
 	return nil
 }
 
-func (u UnaryMatcher) Match(res gjson.Result, json []byte, r *Resolution) (bool, error) {
+func (u UnaryMatcher) Match(res gjson.Result, json []byte, l Lists, r *Resolution) (bool, error) {
 	if u.Not != nil {
-		in, err := u.Not.Match(res, json, r)
+		in, err := u.Not.Match(res, json, l, r)
 		if err != nil {
 			return false, err
 		}
@@ -150,7 +240,7 @@ func (u UnaryMatcher) Match(res gjson.Result, json []byte, r *Resolution) (bool,
 		r.Logf("apply NOT(%v)=>%v", in, v)
 		return v, nil
 	} else if u.And != nil {
-		lhs, rhs, err := u.And.Match(res, json, r)
+		lhs, rhs, err := u.And.Match(res, json, l, r)
 		if err != nil {
 			return false, err
 		}
@@ -158,7 +248,7 @@ func (u UnaryMatcher) Match(res gjson.Result, json []byte, r *Resolution) (bool,
 		r.Logf("apply AND(%v, %v)=>%v", lhs, rhs, v)
 		return v, nil
 	} else if u.Or != nil {
-		lhs, rhs, err := u.Or.Match(res, json, r)
+		lhs, rhs, err := u.Or.Match(res, json, l, r)
 		if err != nil {
 			return false, err
 		}
@@ -166,7 +256,7 @@ func (u UnaryMatcher) Match(res gjson.Result, json []byte, r *Resolution) (bool,
 		r.Logf("apply OR(%v, %v)=>%v", lhs, rhs, v)
 		return v, nil
 	} else if u.Value != nil {
-		v, err := u.Value.Match(res, json, r)
+		v, err := u.Value.Match(res, json, l, r)
 		return v, err
 	} else if u.Empty {
 		v := !res.Exists()
@@ -182,23 +272,23 @@ type BinaryMatcher struct {
 	R *UnaryMatcher `json:"right"`
 }
 
-func (b BinaryMatcher) Validate() error {
+func (b BinaryMatcher) Validate(l Lists) error {
 	if b.L == nil {
 		return errors.New("missing left")
 	} else if b.R == nil {
 		return errors.New("missing right")
-	} else if err := b.L.Validate(); err != nil {
+	} else if err := b.L.Validate(l); err != nil {
 		return err
 	}
-	return b.R.Validate()
+	return b.R.Validate(l)
 }
 
-func (b BinaryMatcher) Match(res gjson.Result, json []byte, r *Resolution) (lhs, rhs bool, err error) {
-	lhs, err = b.L.Match(res, json, r)
+func (b BinaryMatcher) Match(res gjson.Result, json []byte, l Lists, r *Resolution) (lhs, rhs bool, err error) {
+	lhs, err = b.L.Match(res, json, l, r)
 	if err != nil {
 		return
 	}
-	rhs, err = b.R.Match(res, json, r)
+	rhs, err = b.R.Match(res, json, l, r)
 	if err != nil {
 		return
 	}
@@ -212,9 +302,28 @@ type Value struct {
 	LenEquals      *int   `json:"lenEquals,omitempty"`
 	LenGreater     *int   `json:"lenGreater,omitempty"`
 	LenLess        *int   `json:"lenLess,omitempty"`
+	// Regex is an RE2 regular expression, see
+	// https://github.com/google/re2/wiki/Syntax
+	Regex       string   `json:"regex,omitempty"`
+	GreaterThan *float64 `json:"greaterThan,omitempty"`
+	LessThan    *float64 `json:"lessThan,omitempty"`
+	// HostEquals matches IRIs whose host is exactly the given host.
+	HostEquals string `json:"hostEquals,omitempty"`
+	// HostSuffix matches IRIs whose host is the given host or any of its
+	// subdomains.
+	HostSuffix string `json:"hostSuffix,omitempty"`
+	// InList is the name of one of the Policy's Lists.
+	InList string `json:"inList,omitempty"`
+	// Before and After are RFC3339 timestamps.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// NewerThan and OlderThan are durations, such as "168h", relative to
+	// the time of the Resolution.
+	NewerThan string `json:"newerThan,omitempty"`
+	OlderThan string `json:"olderThan,omitempty"`
 }
 
-func (u Value) Validate() error {
+func (u Value) Validate(l Lists) error {
 	n := 0
 	if len(u.JSONPath) > 0 {
 		n++
@@ -234,15 +343,70 @@ func (u Value) Validate() error {
 	if u.LenLess != nil {
 		n++
 	}
+	if len(u.Regex) > 0 {
+		n++
+	}
+	if u.GreaterThan != nil {
+		n++
+	}
+	if u.LessThan != nil {
+		n++
+	}
+	if len(u.HostEquals) > 0 {
+		n++
+	}
+	if len(u.HostSuffix) > 0 {
+		n++
+	}
+	if len(u.InList) > 0 {
+		n++
+	}
+	if len(u.Before) > 0 {
+		n++
+	}
+	if len(u.After) > 0 {
+		n++
+	}
+	if len(u.NewerThan) > 0 {
+		n++
+	}
+	if len(u.OlderThan) > 0 {
+		n++
+	}
 	if n > 1 {
 		return errors.New("value has >1 field set")
 	} else if n == 0 {
 		return errors.New("value has no fields set")
 	}
+	if len(u.Regex) > 0 {
+		if _, err := regexp.Compile(u.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	} else if len(u.InList) > 0 {
+		if _, ok := l[u.InList]; !ok {
+			return fmt.Errorf("unknown list: %q", u.InList)
+		}
+	} else if len(u.Before) > 0 {
+		if _, err := time.Parse(time.RFC3339, u.Before); err != nil {
+			return fmt.Errorf("invalid before: %w", err)
+		}
+	} else if len(u.After) > 0 {
+		if _, err := time.Parse(time.RFC3339, u.After); err != nil {
+			return fmt.Errorf("invalid after: %w", err)
+		}
+	} else if len(u.NewerThan) > 0 {
+		if _, err := time.ParseDuration(u.NewerThan); err != nil {
+			return fmt.Errorf("invalid newerThan: %w", err)
+		}
+	} else if len(u.OlderThan) > 0 {
+		if _, err := time.ParseDuration(u.OlderThan); err != nil {
+			return fmt.Errorf("invalid olderThan: %w", err)
+		}
+	}
 	return nil
 }
 
-func (u Value) Match(res gjson.Result, json []byte, r *Resolution) (bool, error) {
+func (u Value) Match(res gjson.Result, json []byte, l Lists, r *Resolution) (bool, error) {
 	if len(u.JSONPath) > 0 {
 		other := gjson.GetBytes(json, u.JSONPath)
 		v := resultsEqual(res, other)
@@ -271,6 +435,100 @@ func (u Value) Match(res gjson.Result, json []byte, r *Resolution) (bool, error)
 		v := l < *u.LenLess
 		r.Logf("apply LESS(LEN(), %d)=>%v", *u.LenLess, v)
 		return v, nil
+	} else if len(u.Regex) > 0 {
+		re, err := regexp.Compile(u.Regex)
+		if err != nil {
+			r.Logf("error: invalid regex %q", u.Regex)
+			return false, err
+		}
+		v := anyResult(res, func(e gjson.Result) bool {
+			return re.MatchString(e.String())
+		})
+		r.Logf("apply MATCHES(%s)=>%v", u.Regex, v)
+		return v, nil
+	} else if u.GreaterThan != nil {
+		v := anyResult(res, func(e gjson.Result) bool {
+			return e.Type == gjson.Number && e.Float() > *u.GreaterThan
+		})
+		r.Logf("apply GREATER(%v)=>%v", *u.GreaterThan, v)
+		return v, nil
+	} else if u.LessThan != nil {
+		v := anyResult(res, func(e gjson.Result) bool {
+			return e.Type == gjson.Number && e.Float() < *u.LessThan
+		})
+		r.Logf("apply LESS(%v)=>%v", *u.LessThan, v)
+		return v, nil
+	} else if len(u.HostEquals) > 0 {
+		want := strings.ToLower(u.HostEquals)
+		v := anyResult(res, func(e gjson.Result) bool {
+			return resultHost(e) == want
+		})
+		r.Logf("apply HOST_EQUALS(%s)=>%v", u.HostEquals, v)
+		return v, nil
+	} else if len(u.HostSuffix) > 0 {
+		want := strings.TrimPrefix(strings.ToLower(u.HostSuffix), "*.")
+		v := anyResult(res, func(e gjson.Result) bool {
+			h := resultHost(e)
+			return len(h) > 0 && (h == want || strings.HasSuffix(h, "."+want))
+		})
+		r.Logf("apply HOST_SUFFIX(%s)=>%v", u.HostSuffix, v)
+		return v, nil
+	} else if len(u.InList) > 0 {
+		v := anyResult(res, func(e gjson.Result) bool {
+			return l.contains(u.InList, e.String())
+		})
+		r.Logf("apply IN_LIST(%s)=>%v", u.InList, v)
+		return v, nil
+	} else if len(u.Before) > 0 {
+		t, err := time.Parse(time.RFC3339, u.Before)
+		if err != nil {
+			r.Logf("error: invalid before %q", u.Before)
+			return false, err
+		}
+		v := anyResult(res, func(e gjson.Result) bool {
+			rt, ok := resultTime(e)
+			return ok && rt.Before(t)
+		})
+		r.Logf("apply BEFORE(%s)=>%v", u.Before, v)
+		return v, nil
+	} else if len(u.After) > 0 {
+		t, err := time.Parse(time.RFC3339, u.After)
+		if err != nil {
+			r.Logf("error: invalid after %q", u.After)
+			return false, err
+		}
+		v := anyResult(res, func(e gjson.Result) bool {
+			rt, ok := resultTime(e)
+			return ok && rt.After(t)
+		})
+		r.Logf("apply AFTER(%s)=>%v", u.After, v)
+		return v, nil
+	} else if len(u.NewerThan) > 0 {
+		d, err := time.ParseDuration(u.NewerThan)
+		if err != nil {
+			r.Logf("error: invalid newerThan %q", u.NewerThan)
+			return false, err
+		}
+		t := r.Time.Add(-d)
+		v := anyResult(res, func(e gjson.Result) bool {
+			rt, ok := resultTime(e)
+			return ok && rt.After(t)
+		})
+		r.Logf("apply NEWER_THAN(%s)=>%v", u.NewerThan, v)
+		return v, nil
+	} else if len(u.OlderThan) > 0 {
+		d, err := time.ParseDuration(u.OlderThan)
+		if err != nil {
+			r.Logf("error: invalid olderThan %q", u.OlderThan)
+			return false, err
+		}
+		t := r.Time.Add(-d)
+		v := anyResult(res, func(e gjson.Result) bool {
+			rt, ok := resultTime(e)
+			return ok && rt.Before(t)
+		})
+		r.Logf("apply OLDER_THAN(%s)=>%v", u.OlderThan, v)
+		return v, nil
 	}
 	r.Log("error: Match called with invalid Value")
 	return false, errors.New("Match called with invalid Value")
@@ -280,6 +538,41 @@ func resultsEqual(lhs, rhs gjson.Result) bool {
 	return reflect.DeepEqual(lhs.Value(), rhs.Value())
 }
 
+// anyResult applies fn to each element of an array, or to the result itself
+// if it is not an array, and determines if any satisfy it.
+func anyResult(r gjson.Result, fn func(gjson.Result) bool) bool {
+	if !r.Exists() {
+		return false
+	}
+	if !r.IsArray() {
+		return fn(r)
+	}
+	for _, e := range r.Array() {
+		if fn(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// resultHost obtains the lowercase host of an IRI, or of the "id" of an
+// embedded object.
+func resultHost(r gjson.Result) string {
+	if r.IsObject() {
+		r = r.Get("id")
+	}
+	u, err := url.Parse(r.String())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func resultTime(r gjson.Result) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, r.String())
+	return t, err == nil
+}
+
 func resultsLen(r gjson.Result) int {
 	l := 0
 	if r.Exists() {
@@ -308,6 +601,24 @@ type PolicyAndID struct {
 	Policy Policy
 }
 
+type CreateScopedPolicy struct {
+	Scope PolicyScope
+	// Domain is only used by the DomainPolicyScope.
+	Domain  string
+	Purpose Purpose
+	Policy  Policy
+}
+
+type ScopedPolicy struct {
+	ID string
+	// ActorID is only used by the ActorPolicyScope.
+	ActorID string
+	Scope   PolicyScope
+	Domain  string
+	Purpose Purpose
+	Policy  Policy
+}
+
 var _ Model = &Policies{}
 
 // Policies is a Model that provides additional database methods for the
@@ -316,6 +627,14 @@ type Policies struct {
 	create                *sql.Stmt
 	getForActor           *sql.Stmt
 	getForActorAndPurpose *sql.Stmt
+	createScoped          *sql.Stmt
+	getInstance           *sql.Stmt
+	getForDomain          *sql.Stmt
+	getScoped             *sql.Stmt
+	deleteScoped          *sql.Stmt
+	get                   *sql.Stmt
+	update                *sql.Stmt
+	delete                *sql.Stmt
 }
 
 func (p *Policies) Prepare(db *sql.DB, s SqlDialect) error {
@@ -324,11 +643,22 @@ func (p *Policies) Prepare(db *sql.DB, s SqlDialect) error {
 			{&(p.create), s.CreatePolicy()},
 			{&(p.getForActor), s.GetPoliciesForActor()},
 			{&(p.getForActorAndPurpose), s.GetPoliciesForActorAndPurpose()},
+			{&(p.createScoped), s.CreateScopedPolicy()},
+			{&(p.getInstance), s.GetInstancePolicies()},
+			{&(p.getForDomain), s.GetPoliciesForDomain()},
+			{&(p.getScoped), s.GetScopedPolicies()},
+			{&(p.deleteScoped), s.DeleteScopedPolicy()},
+			{&(p.get), s.GetPolicy()},
+			{&(p.update), s.UpdatePolicy()},
+			{&(p.delete), s.DeletePolicy()},
 		})
 }
 
 func (p *Policies) CreateTable(t *sql.Tx, s SqlDialect) error {
-	_, err := t.Exec(s.CreatePoliciesTable())
+	if _, err := t.Exec(s.CreatePoliciesTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexScopePoliciesTable())
 	return err
 }
 
@@ -336,6 +666,14 @@ func (p *Policies) Close() {
 	p.create.Close()
 	p.getForActor.Close()
 	p.getForActorAndPurpose.Close()
+	p.createScoped.Close()
+	p.getInstance.Close()
+	p.getForDomain.Close()
+	p.getScoped.Close()
+	p.deleteScoped.Close()
+	p.get.Close()
+	p.update.Close()
+	p.delete.Close()
 }
 
 // Create a new Policy
@@ -389,3 +727,106 @@ func (p *Policies) GetForActorAndPurpose(c util.Context, tx *sql.Tx, actorID *ur
 		return nil
 	})
 }
+
+// CreateScoped creates a new instance or domain scoped Policy.
+func (p *Policies) CreateScoped(c util.Context, tx *sql.Tx, cp CreateScopedPolicy) (policyID string, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.createScoped).QueryContext(c,
+		cp.Scope,
+		cp.Domain,
+		cp.Purpose,
+		cp.Policy)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return policyID, enforceOneRow(rows, "Policies.CreateScoped", func(r SingleRow) error {
+		return r.Scan(&(policyID))
+	})
+}
+
+// GetInstance obtains all instance scoped policies.
+func (p *Policies) GetInstance(c util.Context, tx *sql.Tx) (po []PolicyAndPurpose, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.getInstance).QueryContext(c)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return po, doForRows(rows, "Policies.GetInstance", func(r SingleRow) error {
+		var pp PolicyAndPurpose
+		if err := r.Scan(&(pp.ID), &(pp.Purpose), &(pp.Policy)); err != nil {
+			return err
+		}
+		po = append(po, pp)
+		return nil
+	})
+}
+
+// GetForDomain obtains all domain scoped policies that apply to a host,
+// including those of its parent domains.
+func (p *Policies) GetForDomain(c util.Context, tx *sql.Tx, host string) (po []PolicyAndPurpose, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.getForDomain).QueryContext(c, host)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return po, doForRows(rows, "Policies.GetForDomain", func(r SingleRow) error {
+		var pp PolicyAndPurpose
+		if err := r.Scan(&(pp.ID), &(pp.Purpose), &(pp.Policy)); err != nil {
+			return err
+		}
+		po = append(po, pp)
+		return nil
+	})
+}
+
+// GetScoped obtains all instance and domain scoped policies.
+func (p *Policies) GetScoped(c util.Context, tx *sql.Tx) (po []ScopedPolicy, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.getScoped).QueryContext(c)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return po, doForRows(rows, "Policies.GetScoped", func(r SingleRow) error {
+		var sp ScopedPolicy
+		if err := r.Scan(&(sp.ID), &(sp.Scope), &(sp.Domain), &(sp.Purpose), &(sp.Policy)); err != nil {
+			return err
+		}
+		po = append(po, sp)
+		return nil
+	})
+}
+
+// DeleteScoped removes an instance or domain scoped policy.
+func (p *Policies) DeleteScoped(c util.Context, tx *sql.Tx, policyID string) error {
+	r, err := tx.Stmt(p.deleteScoped).ExecContext(c, policyID)
+	return mustChangeOneRow(r, err, "Policies.DeleteScoped")
+}
+
+// Get obtains a Policy of any scope.
+func (p *Policies) Get(c util.Context, tx *sql.Tx, policyID string) (sp ScopedPolicy, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.get).QueryContext(c, policyID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sp, enforceOneRow(rows, "Policies.Get", func(r SingleRow) error {
+		return r.Scan(&(sp.ID), &(sp.ActorID), &(sp.Scope), &(sp.Domain), &(sp.Purpose), &(sp.Policy))
+	})
+}
+
+// Update replaces the Purpose and Policy of a Policy of any scope.
+func (p *Policies) Update(c util.Context, tx *sql.Tx, policyID string, u Purpose, policy Policy) error {
+	r, err := tx.Stmt(p.update).ExecContext(c, policyID, u, policy)
+	return mustChangeOneRow(r, err, "Policies.Update")
+}
+
+// Delete removes a Policy of any scope.
+func (p *Policies) Delete(c util.Context, tx *sql.Tx, policyID string) error {
+	r, err := tx.Stmt(p.delete).ExecContext(c, policyID)
+	return mustChangeOneRow(r, err, "Policies.Delete")
+}
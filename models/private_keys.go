@@ -18,6 +18,7 @@ package models
 
 import (
 	"database/sql"
+	"net/url"
 
 	"github.com/allinbits/apcore/util"
 )
@@ -30,6 +31,8 @@ type PrivateKeys struct {
 	createPrivateKey *sql.Stmt
 	getByUserID      *sql.Stmt
 	getInstanceActor *sql.Stmt
+	rotate           *sql.Stmt
+	usersWithout     *sql.Stmt
 }
 
 func (p *PrivateKeys) Prepare(db *sql.DB, s SqlDialect) error {
@@ -38,6 +41,8 @@ func (p *PrivateKeys) Prepare(db *sql.DB, s SqlDialect) error {
 			{&(p.createPrivateKey), s.CreatePrivateKey()},
 			{&(p.getByUserID), s.GetPrivateKeyByUserID()},
 			{&(p.getInstanceActor), s.GetPrivateKeyForInstanceActor()},
+			{&(p.rotate), s.RotatePrivateKey()},
+			{&(p.usersWithout), s.GetUsersWithoutPrivateKey()},
 		})
 }
 
@@ -50,6 +55,8 @@ func (p *PrivateKeys) Close() {
 	p.createPrivateKey.Close()
 	p.getByUserID.Close()
 	p.getInstanceActor.Close()
+	p.rotate.Close()
+	p.usersWithout.Close()
 }
 
 // Create a new private key entry in the database.
@@ -59,27 +66,58 @@ func (p *PrivateKeys) Create(c util.Context, tx *sql.Tx, userID, purpose string,
 }
 
 // GetByUserID fetches a private key by the userID and purpose of the key.
-func (p *PrivateKeys) GetByUserID(c util.Context, tx *sql.Tx, userID, purpose string) (b []byte, err error) {
+//
+// The id of its public key is only valid if the key was rotated; otherwise
+// the id is the default one for the purpose.
+func (p *PrivateKeys) GetByUserID(c util.Context, tx *sql.Tx, userID, purpose string) (b []byte, keyID sql.NullString, err error) {
 	var rows *sql.Rows
 	rows, err = tx.Stmt(p.getByUserID).QueryContext(c, userID, purpose)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
-	return b, enforceOneRow(rows, "PrivateKeys.GetByUserID", func(r SingleRow) error {
-		return r.Scan(&(b))
+	return b, keyID, enforceOneRow(rows, "PrivateKeys.GetByUserID", func(r SingleRow) error {
+		return r.Scan(&(b), &(keyID))
 	})
 }
 
 // GetInstanceActor fetches a private key for the single instance actor.
-func (p *PrivateKeys) GetInstanceActor(c util.Context, tx *sql.Tx, purpose string) (b []byte, err error) {
+//
+// The id of its public key is only valid if the key was rotated.
+func (p *PrivateKeys) GetInstanceActor(c util.Context, tx *sql.Tx, purpose string) (b []byte, keyID sql.NullString, err error) {
 	var rows *sql.Rows
 	rows, err = tx.Stmt(p.getInstanceActor).QueryContext(c, purpose)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
-	return b, enforceOneRow(rows, "PrivateKeys.GetInstanceActor", func(r SingleRow) error {
-		return r.Scan(&(b))
+	return b, keyID, enforceOneRow(rows, "PrivateKeys.GetInstanceActor", func(r SingleRow) error {
+		return r.Scan(&(b), &(keyID))
+	})
+}
+
+// Rotate replaces the user's private key for the purpose with a new one, whose
+// public key has the id.
+func (p *PrivateKeys) Rotate(c util.Context, tx *sql.Tx, userID, purpose string, privKey []byte, keyID *url.URL) error {
+	r, err := tx.Stmt(p.rotate).ExecContext(c, userID, purpose, privKey, keyID.String())
+	return mustChangeOneRow(r, err, "PrivateKeys.Rotate")
+}
+
+// UsersWithout fetches the ids of the users that do not have a private key
+// for the purpose.
+func (p *PrivateKeys) UsersWithout(c util.Context, tx *sql.Tx, purpose string) (ids []string, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(p.usersWithout).QueryContext(c, purpose)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return ids, doForRows(rows, "PrivateKeys.UsersWithout", func(r SingleRow) error {
+		var id string
+		if err := r.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
 	})
 }
@@ -0,0 +1,96 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+
+	"github.com/allinbits/apcore/util"
+)
+
+var _ Model = &RecoveryCodes{}
+
+// RecoveryCodes is a Model that provides additional database methods for the
+// single-use codes that users can log in with when they do not have their
+// other second factors. Only hashes of the codes are stored.
+type RecoveryCodes struct {
+	create *sql.Stmt
+	count  *sql.Stmt
+	use    *sql.Stmt
+	delAll *sql.Stmt
+}
+
+func (r *RecoveryCodes) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(r.create), s.CreateRecoveryCode()},
+			{&(r.count), s.CountRecoveryCodes()},
+			{&(r.use), s.UseRecoveryCode()},
+			{&(r.delAll), s.DeleteRecoveryCodes()},
+		})
+}
+
+func (r *RecoveryCodes) CreateTable(t *sql.Tx, s SqlDialect) error {
+	_, err := t.Exec(s.CreateRecoveryCodesTable())
+	return err
+}
+
+func (r *RecoveryCodes) Close() {
+	r.create.Close()
+	r.count.Close()
+	r.use.Close()
+	r.delAll.Close()
+}
+
+// Create stores the hash of a recovery code of the user.
+func (r *RecoveryCodes) Create(c util.Context, tx *sql.Tx, userID string, hashed []byte) error {
+	res, err := tx.Stmt(r.create).ExecContext(c, userID, hashed)
+	return mustChangeOneRow(res, err, "RecoveryCodes.Create")
+}
+
+// Count returns how many unused recovery codes the user has.
+func (r *RecoveryCodes) Count(c util.Context, tx *sql.Tx, userID string) (n int, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.count).QueryContext(c, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return n, enforceOneRow(rows, "RecoveryCodes.Count", func(r SingleRow) error {
+		return r.Scan(&n)
+	})
+}
+
+// Use deletes the user's recovery code with the hash, returning whether the
+// user had such a code.
+func (r *RecoveryCodes) Use(c util.Context, tx *sql.Tx, userID string, hashed []byte) (used bool, err error) {
+	var res sql.Result
+	res, err = tx.Stmt(r.use).ExecContext(c, userID, hashed)
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = res.RowsAffected()
+	used = n == 1
+	return
+}
+
+// DeleteAll removes all of the user's recovery codes.
+func (r *RecoveryCodes) DeleteAll(c util.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.Stmt(r.delAll).ExecContext(c, userID)
+	return err
+}
@@ -0,0 +1,103 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// RemotePublicKey is the public key of a federated peer, used to verify the
+// HTTP Signatures of its requests.
+type RemotePublicKey struct {
+	PublicKeyPEM string
+	// Owner is the actor that the key document names as owning the key.
+	Owner URL
+	// Expires is when the key must be fetched again before being used.
+	Expires time.Time
+}
+
+var _ Model = &RemotePublicKeys{}
+
+// RemotePublicKeys is a Model that provides additional database methods for
+// caching the public keys of federated peers.
+type RemotePublicKeys struct {
+	get    *sql.Stmt
+	upsert *sql.Stmt
+	prune  *sql.Stmt
+}
+
+func (r *RemotePublicKeys) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(r.get), s.GetRemotePublicKey()},
+			{&(r.upsert), s.UpsertRemotePublicKey()},
+			{&(r.prune), s.PruneRemotePublicKeys()},
+		})
+}
+
+func (r *RemotePublicKeys) CreateTable(t *sql.Tx, s SqlDialect) error {
+	if _, err := t.Exec(s.CreateRemotePublicKeysTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexExpiresRemotePublicKeysTable())
+	return err
+}
+
+func (r *RemotePublicKeys) Close() {
+	r.get.Close()
+	r.upsert.Close()
+	r.prune.Close()
+}
+
+// Get retrieves the public key with the id, if there is one.
+func (r *RemotePublicKeys) Get(c util.Context, tx *sql.Tx, keyID *url.URL) (k RemotePublicKey, found bool, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.get).QueryContext(c, keyID.String())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "RemotePublicKeys.Get", func(r SingleRow) error {
+		found = true
+		return r.Scan(&(k.PublicKeyPEM), &(k.Owner), &(k.Expires))
+	})
+	return
+}
+
+// Put caches the public key with the id, replacing any already cached.
+func (r *RemotePublicKeys) Put(c util.Context, tx *sql.Tx, keyID *url.URL, k RemotePublicKey) error {
+	res, err := tx.Stmt(r.upsert).ExecContext(c,
+		keyID.String(),
+		k.PublicKeyPEM,
+		k.Owner,
+		k.Expires)
+	return mustChangeOneRow(res, err, "RemotePublicKeys.Put")
+}
+
+// Prune removes the public keys that expired before the given time.
+func (r *RemotePublicKeys) Prune(c util.Context, tx *sql.Tx, before time.Time) (n int64, err error) {
+	var res sql.Result
+	res, err = tx.Stmt(r.prune).ExecContext(c, before)
+	if err != nil {
+		return
+	}
+	return res.RowsAffected()
+}
@@ -18,6 +18,8 @@ package models
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"time"
@@ -33,6 +35,17 @@ type Resolution struct {
 	MatchLog []string `json:"matchLog",omitempty`
 }
 
+var _ driver.Valuer = Resolution{}
+var _ sql.Scanner = &Resolution{}
+
+func (r Resolution) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *Resolution) Scan(src interface{}) error {
+	return unmarshal(src, r)
+}
+
 func (r *Resolution) Logf(s string, i ...interface{}) {
 	r.Log(fmt.Sprintf(s, i...))
 }
@@ -47,28 +60,62 @@ type CreateResolution struct {
 	R        Resolution
 }
 
+// StoredResolution is a Resolution recorded when applying a Policy to
+// federated data.
+type StoredResolution struct {
+	ID         string
+	PolicyID   string
+	IRI        *url.URL
+	R          Resolution
+	CreateTime time.Time
+}
+
 var _ Model = &Resolutions{}
 
 // Resolutions is a Model that provides additional database methods for the
 // Resolution type.
 type Resolutions struct {
-	create *sql.Stmt
+	create         *sql.Stmt
+	getForPolicy   *sql.Stmt
+	getForDataIRI  *sql.Stmt
+	getInTimeRange *sql.Stmt
+	getByMatched   *sql.Stmt
+	deleteBefore   *sql.Stmt
 }
 
 func (r *Resolutions) Prepare(db *sql.DB, s SqlDialect) error {
 	return prepareStmtPairs(db,
 		stmtPairs{
 			{&(r.create), s.CreateResolution()},
+			{&(r.getForPolicy), s.GetResolutionsForPolicy()},
+			{&(r.getForDataIRI), s.GetResolutionsForDataIRI()},
+			{&(r.getInTimeRange), s.GetResolutionsInTimeRange()},
+			{&(r.getByMatched), s.GetResolutionsByMatched()},
+			{&(r.deleteBefore), s.DeleteResolutionsBefore()},
 		})
 }
 
 func (r *Resolutions) CreateTable(t *sql.Tx, s SqlDialect) error {
-	_, err := t.Exec(s.CreateResolutionsTable())
+	if _, err := t.Exec(s.CreateResolutionsTable()); err != nil {
+		return err
+	}
+	if _, err := t.Exec(s.CreateIndexPolicyIDResolutionsTable()); err != nil {
+		return err
+	}
+	if _, err := t.Exec(s.CreateIndexDataIRIResolutionsTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexCreateTimeResolutionsTable())
 	return err
 }
 
 func (r *Resolutions) Close() {
 	r.create.Close()
+	r.getForPolicy.Close()
+	r.getForDataIRI.Close()
+	r.getInTimeRange.Close()
+	r.getByMatched.Close()
+	r.deleteBefore.Close()
 }
 
 // Create a new Resolution
@@ -79,3 +126,83 @@ func (r *Resolutions) Create(c util.Context, tx *sql.Tx, cr CreateResolution) er
 		cr.R)
 	return mustChangeOneRow(rows, err, "Resolutions.Create")
 }
+
+// GetForPolicy obtains a page of the Resolutions of a Policy, newest first.
+func (r *Resolutions) GetForPolicy(c util.Context, tx *sql.Tx, policyID string, offset, limit int) (sr []StoredResolution, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.getForPolicy).QueryContext(c, policyID, offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sr, doForRows(rows, "Resolutions.GetForPolicy", func(row SingleRow) error {
+		return scanStoredResolution(row, &sr)
+	})
+}
+
+// GetForDataIRI obtains a page of the Resolutions of federated data, newest
+// first.
+func (r *Resolutions) GetForDataIRI(c util.Context, tx *sql.Tx, dataIRI *url.URL, offset, limit int) (sr []StoredResolution, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.getForDataIRI).QueryContext(c, dataIRI.String(), offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sr, doForRows(rows, "Resolutions.GetForDataIRI", func(row SingleRow) error {
+		return scanStoredResolution(row, &sr)
+	})
+}
+
+// GetInTimeRange obtains a page of the Resolutions created at or after start
+// and before end, newest first.
+func (r *Resolutions) GetInTimeRange(c util.Context, tx *sql.Tx, start, end time.Time, offset, limit int) (sr []StoredResolution, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.getInTimeRange).QueryContext(c, start, end, offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sr, doForRows(rows, "Resolutions.GetInTimeRange", func(row SingleRow) error {
+		return scanStoredResolution(row, &sr)
+	})
+}
+
+// GetByMatched obtains a page of the Resolutions that did or did not match,
+// newest first.
+func (r *Resolutions) GetByMatched(c util.Context, tx *sql.Tx, matched bool, offset, limit int) (sr []StoredResolution, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.getByMatched).QueryContext(c, matched, offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sr, doForRows(rows, "Resolutions.GetByMatched", func(row SingleRow) error {
+		return scanStoredResolution(row, &sr)
+	})
+}
+
+// DeleteBefore removes all Resolutions created before the given time,
+// returning how many were removed.
+func (r *Resolutions) DeleteBefore(c util.Context, tx *sql.Tx, t time.Time) (n int64, err error) {
+	var res sql.Result
+	res, err = tx.Stmt(r.deleteBefore).ExecContext(c, t)
+	if err != nil {
+		return
+	}
+	return res.RowsAffected()
+}
+
+func scanStoredResolution(row SingleRow, sr *[]StoredResolution) error {
+	var s StoredResolution
+	var iri string
+	if err := row.Scan(&(s.ID), &(s.PolicyID), &iri, &(s.R), &(s.CreateTime)); err != nil {
+		return err
+	}
+	var err error
+	if s.IRI, err = url.Parse(iri); err != nil {
+		return err
+	}
+	*sr = append(*sr, s)
+	return nil
+}
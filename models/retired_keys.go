@@ -0,0 +1,99 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// RetiredKey is a key of a user that was replaced by rotating its keys, which
+// stays published on the user's actor until it expires so that signatures
+// made with it can still be verified.
+type RetiredKey struct {
+	ID     string
+	UserID string
+	KeyID  URL
+	// Expires is when the key is no longer published.
+	Expires time.Time
+}
+
+var _ Model = &RetiredKeys{}
+
+// RetiredKeys is a Model that provides additional database methods for the
+// keys replaced by key rotation.
+type RetiredKeys struct {
+	create     *sql.Stmt
+	getExpired *sql.Stmt
+	del        *sql.Stmt
+}
+
+func (r *RetiredKeys) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(r.create), s.CreateRetiredKey()},
+			{&(r.getExpired), s.GetExpiredRetiredKeys()},
+			{&(r.del), s.DeleteRetiredKey()},
+		})
+}
+
+func (r *RetiredKeys) CreateTable(t *sql.Tx, s SqlDialect) error {
+	if _, err := t.Exec(s.CreateRetiredKeysTable()); err != nil {
+		return err
+	}
+	_, err := t.Exec(s.CreateIndexExpiresRetiredKeysTable())
+	return err
+}
+
+func (r *RetiredKeys) Close() {
+	r.create.Close()
+	r.getExpired.Close()
+	r.del.Close()
+}
+
+// Create records that the user's key with the id is retired until it expires.
+func (r *RetiredKeys) Create(c util.Context, tx *sql.Tx, userID string, keyID *url.URL, expires time.Time) error {
+	res, err := tx.Stmt(r.create).ExecContext(c, userID, keyID.String(), expires)
+	return mustChangeOneRow(res, err, "RetiredKeys.Create")
+}
+
+// GetExpired fetches the retired keys that expired before the given time.
+func (r *RetiredKeys) GetExpired(c util.Context, tx *sql.Tx, before time.Time) (rk []RetiredKey, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(r.getExpired).QueryContext(c, before)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return rk, doForRows(rows, "RetiredKeys.GetExpired", func(r SingleRow) error {
+		var k RetiredKey
+		if err := r.Scan(&(k.ID), &(k.UserID), &(k.KeyID), &(k.Expires)); err != nil {
+			return err
+		}
+		rk = append(rk, k)
+		return nil
+	})
+}
+
+// Delete removes the record of a retired key.
+func (r *RetiredKeys) Delete(c util.Context, tx *sql.Tx, id string) error {
+	res, err := tx.Stmt(r.del).ExecContext(c, id)
+	return mustChangeOneRow(res, err, "RetiredKeys.Delete")
+}
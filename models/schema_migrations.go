@@ -0,0 +1,106 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// Migration is a single versioned change to the database schema, provided by
+// a SqlDialect.
+//
+// Up is the list of statements that applies the change, and Down is the list
+// of statements that reverts it. Both are executed in order within a single
+// transaction.
+type Migration struct {
+	Version     int
+	Description string
+	Up          []string
+	Down        []string
+}
+
+// SchemaMigration is a record of a Migration that has been applied to the
+// database.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+var _ Model = &SchemaMigrations{}
+
+// SchemaMigrations is a Model that provides additional database methods for
+// recording which Migrations have been applied.
+type SchemaMigrations struct {
+	getForComponent *sql.Stmt
+	create          *sql.Stmt
+	delete          *sql.Stmt
+}
+
+func (s *SchemaMigrations) Prepare(db *sql.DB, d SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(s.getForComponent), d.GetSchemaMigrations()},
+			{&(s.create), d.InsertSchemaMigration()},
+			{&(s.delete), d.DeleteSchemaMigration()},
+		})
+}
+
+func (s *SchemaMigrations) CreateTable(t *sql.Tx, d SqlDialect) error {
+	_, err := t.Exec(d.CreateSchemaMigrationsTable())
+	return err
+}
+
+func (s *SchemaMigrations) Close() {
+	s.getForComponent.Close()
+	s.create.Close()
+	s.delete.Close()
+}
+
+// GetForComponent returns the applied migrations for a component, in
+// ascending version order.
+func (s *SchemaMigrations) GetForComponent(c util.Context, tx *sql.Tx, component string) (sm []SchemaMigration, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(s.getForComponent).QueryContext(c, component)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return sm, doForRows(rows, "SchemaMigrations.GetForComponent", func(r SingleRow) error {
+		var m SchemaMigration
+		if err := r.Scan(&(m.Version), &(m.Description), &(m.AppliedAt)); err != nil {
+			return err
+		}
+		sm = append(sm, m)
+		return nil
+	})
+}
+
+// Create records a migration as applied for a component.
+func (s *SchemaMigrations) Create(c util.Context, tx *sql.Tx, component string, version int, description string) error {
+	r, err := tx.Stmt(s.create).ExecContext(c, component, version, description)
+	return mustChangeOneRow(r, err, "SchemaMigrations.Create")
+}
+
+// Delete removes the record of an applied migration for a component.
+func (s *SchemaMigrations) Delete(c util.Context, tx *sql.Tx, component string, version int) error {
+	r, err := tx.Stmt(s.delete).ExecContext(c, component, version)
+	return mustChangeOneRow(r, err, "SchemaMigrations.Delete")
+}
@@ -0,0 +1,93 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+var _ Model = &SecondFactorFailures{}
+
+// SecondFactorFailures is a Model that provides additional database methods
+// for counting the consecutive second factors that users failed to log in
+// with.
+type SecondFactorFailures struct {
+	get    *sql.Stmt
+	upsert *sql.Stmt
+	del    *sql.Stmt
+}
+
+func (s *SecondFactorFailures) Prepare(db *sql.DB, d SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(s.get), d.GetSecondFactorFailures()},
+			{&(s.upsert), d.UpsertSecondFactorFailures()},
+			{&(s.del), d.DeleteSecondFactorFailures()},
+		})
+}
+
+func (s *SecondFactorFailures) CreateTable(tx *sql.Tx, d SqlDialect) error {
+	_, err := tx.Exec(d.CreateSecondFactorFailuresTable())
+	return err
+}
+
+func (s *SecondFactorFailures) Close() {
+	s.get.Close()
+	s.upsert.Close()
+	s.del.Close()
+}
+
+// Get fetches how many times in a row the user failed to provide a second
+// factor, and until when they may not try again. A zero time means they are
+// not locked out.
+func (s *SecondFactorFailures) Get(c util.Context, tx *sql.Tx, userID string) (n int, lockedUntil time.Time, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(s.get).QueryContext(c, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "SecondFactorFailures.Get", func(r SingleRow) error {
+		var lu sql.NullTime
+		if err := r.Scan(&n, &lu); err != nil {
+			return err
+		}
+		lockedUntil = lu.Time
+		return nil
+	})
+	return
+}
+
+// Set records how many times in a row the user failed to provide a second
+// factor, and until when they may not try again. A zero time means they are
+// not locked out.
+func (s *SecondFactorFailures) Set(c util.Context, tx *sql.Tx, userID string, n int, lockedUntil time.Time) error {
+	r, err := tx.Stmt(s.upsert).ExecContext(c, userID, n, sql.NullTime{
+		Time:  lockedUntil,
+		Valid: !lockedUntil.IsZero(),
+	})
+	return mustChangeOneRow(r, err, "SecondFactorFailures.Set")
+}
+
+// Delete forgets the user's failures, if any.
+func (s *SecondFactorFailures) Delete(c util.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.Stmt(s.del).ExecContext(c, userID)
+	return err
+}
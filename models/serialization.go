@@ -49,11 +49,15 @@ func Marshal(v vocab.Type) (b []byte, err error) {
 
 // unmarhsal attempts to deserialize JSON bytes into a value.
 func unmarshal(maybeByte, v interface{}) error {
-	b, ok := maybeByte.([]byte)
-	if !ok {
+	switch b := maybeByte.(type) {
+	case []byte:
+		return json.Unmarshal(b, v)
+	case string:
+		// Some drivers, such as SQLite, return JSON stored as text.
+		return json.Unmarshal([]byte(b), v)
+	default:
 		return errors.New("failed to assert scan to []byte type")
 	}
-	return json.Unmarshal(b, v)
 }
 
 // SingleRow allows *sql.Rows to be treated as *sql.Row
@@ -513,7 +517,7 @@ func (n NullDuration) Value() (driver.Value, error) {
 	if !n.Valid {
 		return nil, nil
 	}
-	return n.Duration, nil
+	return int64(n.Duration), nil
 }
 
 func (n *NullDuration) Scan(src interface{}) error {
@@ -52,6 +52,28 @@ type SqlDialect interface {
 	CreateResolutionsTable() string
 	// CreateFirstPartyCredentialsTable for first party credentials model.
 	CreateFirstPartyCredentialsTable() string
+	// CreateGoneActorsTable for the GoneActors model.
+	CreateGoneActorsTable() string
+	// CreateDereferenceCacheTable for the DereferenceCache model.
+	CreateDereferenceCacheTable() string
+	// CreateRemotePublicKeysTable for the RemotePublicKeys model.
+	CreateRemotePublicKeysTable() string
+	// CreateRetiredKeysTable for the RetiredKeys model.
+	CreateRetiredKeysTable() string
+	// CreateClientRegistrationsTable for the ClientRegistrations model.
+	CreateClientRegistrationsTable() string
+	// CreateTOTPSecretsTable for the TOTPSecrets model.
+	CreateTOTPSecretsTable() string
+	// CreateRecoveryCodesTable for the RecoveryCodes model.
+	CreateRecoveryCodesTable() string
+	// CreateWebAuthnCredentialsTable for the WebAuthnCredentials model.
+	CreateWebAuthnCredentialsTable() string
+	// CreateSecondFactorFailuresTable for the SecondFactorFailures model.
+	CreateSecondFactorFailuresTable() string
+	// CreateWebAuthnChallengesTable for the WebAuthnChallenges model.
+	CreateWebAuthnChallengesTable() string
+	// CreateSchemaMigrationsTable for the SchemaMigrations model.
+	CreateSchemaMigrationsTable() string
 
 	/* Indexes */
 
@@ -74,6 +96,33 @@ type SqlDialect interface {
 	// CreateIndexIDLikedTable creates an index on the `id` of a liked
 	// collection.
 	CreateIndexIDLikedTable() string
+	// CreateIndexScopePoliciesTable creates an index on the `scope` and
+	// `domain` of a policy.
+	CreateIndexScopePoliciesTable() string
+	// CreateIndexPolicyIDResolutionsTable creates an index on the
+	// `policy_id` of a resolution.
+	CreateIndexPolicyIDResolutionsTable() string
+	// CreateIndexDataIRIResolutionsTable creates an index on the
+	// `data_iri` of a resolution.
+	CreateIndexDataIRIResolutionsTable() string
+	// CreateIndexCreateTimeResolutionsTable creates an index on the
+	// `create_time` of a resolution.
+	CreateIndexCreateTimeResolutionsTable() string
+	// CreateIndexNextAttemptDeliveryAttemptsTable creates an index on the
+	// `state` and `next_attempt` of a delivery attempt.
+	CreateIndexNextAttemptDeliveryAttemptsTable() string
+	// CreateIndexExpiresDereferenceCacheTable creates an index on the
+	// `expires` of a cached object.
+	CreateIndexExpiresDereferenceCacheTable() string
+	// CreateIndexExpiresRemotePublicKeysTable creates an index on the
+	// `expires` of a cached public key.
+	CreateIndexExpiresRemotePublicKeysTable() string
+	// CreateIndexExpiresRetiredKeysTable creates an index on the `expires`
+	// of a retired key.
+	CreateIndexExpiresRetiredKeysTable() string
+	// CreateIndexInboxGoneActorsTable creates an index on the `inbox` of a
+	// gone actor.
+	CreateIndexInboxGoneActorsTable() string
 
 	/* Queries */
 
@@ -202,6 +251,12 @@ type SqlDialect interface {
 	//   ID          string
 	//  Returns
 	FedDelete() string
+	// FedGetRecent fetches the most recently stored federated data.
+	//  Params
+	//   Limit       int
+	//  Returns (Multiple)
+	//   Payload     []byte
+	FedGetRecent() string
 
 	// LocalExists:
 	//  Params
@@ -395,6 +450,8 @@ type SqlDialect interface {
 	// MarkFailedAttempt:
 	//  Params
 	//   ID          string
+	//   State       string
+	//   NextAttempt time.Time
 	//  Returns
 	MarkFailedAttempt() string
 	// MarkAbandonedAttempt:
@@ -402,10 +459,11 @@ type SqlDialect interface {
 	//   ID          string
 	//  Returns
 	MarkAbandonedAttempt() string
-	// FirstPageRetryableFailures:
+	// GetDueAttempts:
 	//  Params
-	//   State       string
-	//   FetchTime   time.Time
+	//   NewState    string
+	//   FailedState string
+	//   Now         time.Time
 	//   Limit       int
 	//  Returns
 	//   ID          string
@@ -414,21 +472,164 @@ type SqlDialect interface {
 	//   Payload     []byte
 	//   NAttempts   int
 	//   LastAttempt time.Time
-	FirstPageRetryableFailures() string
-	// NextPageRetryableFailures:
+	//   NextAttempt time.Time
+	GetDueAttempts() string
+	// ClaimAttempt:
+	//  Params
+	//   ID          string
+	//   NewState    string
+	//   FailedState string
+	//   Now         time.Time
+	//   Until       time.Time
+	//  Returns
+	ClaimAttempt() string
+	// ReleaseAttempt:
 	//  Params
+	//   ID          string
+	//   NewState    string
+	//   FailedState string
+	//   NextAttempt time.Time
+	//  Returns
+	ReleaseAttempt() string
+	// PostponeAttempts:
+	//  Params
+	//   NewState    string
+	//   FailedState string
+	//   DeliverTo   string (LIKE pattern with '\' escapes)
+	//   NextAttempt time.Time
+	//  Returns
+	PostponeAttempts() string
+	// AbandonAttemptsTo:
+	//  Params
+	//   NewState    string
+	//   FailedState string
+	//   DeliverTo   string
 	//   State       string
-	//   FetchTime   time.Time
+	//  Returns
+	AbandonAttemptsTo() string
+	// GetAttempts:
+	//  Params
+	//   State       string (empty matches all)
+	//   DeliverTo   string (LIKE pattern with '\' escapes, empty matches all)
+	//   FromID      string (empty matches all)
+	//   Before      time.Time
 	//   Limit       int
-	//   PrevID      string
 	//  Returns
 	//   ID          string
 	//   FromID      string
 	//   DeliverTo   string
-	//   Payload     []byte
+	//   State       string
 	//   NAttempts   int
+	//   CreateTime  time.Time
 	//   LastAttempt time.Time
-	NextPageRetryableFailures() string
+	//   NextAttempt time.Time
+	GetAttempts() string
+	// RequeueAttempts:
+	//  Params
+	//   State       string (empty matches all)
+	//   DeliverTo   string (LIKE pattern with '\' escapes, empty matches all)
+	//   FromID      string (empty matches all)
+	//   Before      time.Time
+	//   NewState    string
+	//  Returns
+	RequeueAttempts() string
+	// DeleteAttempts:
+	//  Params
+	//   State       string (empty matches all)
+	//   DeliverTo   string (LIKE pattern with '\' escapes, empty matches all)
+	//   FromID      string (empty matches all)
+	//   Before      time.Time
+	//  Returns
+	DeleteAttempts() string
+
+	// GoneActorInboxExists:
+	//  Params
+	//   Inbox       string
+	//   Now         time.Time
+	//  Returns
+	//   Exists      bool
+	GoneActorInboxExists() string
+	// UpsertGoneActor:
+	//  Params
+	//   ActorIRI    string
+	//   Inbox       string
+	//   Expires     time.Time
+	//  Returns
+	UpsertGoneActor() string
+	// GetGoneActors:
+	//  Params
+	//  Returns
+	//   ActorIRI    string
+	//   Inbox       string
+	//   GoneAt      time.Time
+	//   Expires     time.Time
+	GetGoneActors() string
+	// DeleteGoneActor:
+	//  Params
+	//   ActorIRI    string
+	//  Returns
+	DeleteGoneActor() string
+	// DeleteGoneActors:
+	//  Params
+	//  Returns
+	DeleteGoneActors() string
+
+	// GetDereferenceCache:
+	//  Params
+	//   IRI          string
+	//  Returns
+	//   Body         []byte
+	//   ETag         string
+	//   LastModified string
+	//   Expires      time.Time
+	GetDereferenceCache() string
+	// UpsertDereferenceCache:
+	//  Params
+	//   IRI          string
+	//   Body         []byte
+	//   ETag         string
+	//   LastModified string
+	//   Expires      time.Time
+	//  Returns
+	UpsertDereferenceCache() string
+	// RevalidateDereferenceCache:
+	//  Params
+	//   IRI          string
+	//   Expires      time.Time
+	//  Returns
+	RevalidateDereferenceCache() string
+	// DeleteDereferenceCache:
+	//  Params
+	//   IRI          string
+	//  Returns
+	DeleteDereferenceCache() string
+	// PruneDereferenceCache:
+	//  Params
+	//   Before       time.Time
+	//  Returns
+	PruneDereferenceCache() string
+
+	// GetRemotePublicKey:
+	//  Params
+	//   KeyID        string
+	//  Returns
+	//   PublicKeyPEM string
+	//   Owner        string
+	//   Expires      time.Time
+	GetRemotePublicKey() string
+	// UpsertRemotePublicKey:
+	//  Params
+	//   KeyID        string
+	//   PublicKeyPEM string
+	//   Owner        string
+	//   Expires      time.Time
+	//  Returns
+	UpsertRemotePublicKey() string
+	// PruneRemotePublicKeys:
+	//  Params
+	//   Before       time.Time
+	//  Returns
+	PruneRemotePublicKeys() string
 
 	// CreatePrivateKey:
 	//  Params
@@ -443,13 +644,51 @@ type SqlDialect interface {
 	//   Purpose     string
 	//  Returns
 	//   PrivKey     []byte
+	//   KeyID       sql.NullString
 	GetPrivateKeyByUserID() string
 	// GetPrivateKeyForInstanceActor:
 	//  Params
 	//   Purpose     string
 	//  Returns
 	//   PrivKey     []byte
+	//   KeyID       sql.NullString
 	GetPrivateKeyForInstanceActor() string
+	// RotatePrivateKey:
+	//  Params
+	//   UserID      string
+	//   Purpose     string
+	//   PrivKey     []byte
+	//   KeyID       string
+	//  Returns
+	RotatePrivateKey() string
+	// GetUsersWithoutPrivateKey:
+	//  Params
+	//   Purpose     string
+	//  Returns
+	//   UserID      string
+	GetUsersWithoutPrivateKey() string
+
+	// CreateRetiredKey:
+	//  Params
+	//   UserID      string
+	//   KeyID       string
+	//   Expires     time.Time
+	//  Returns
+	CreateRetiredKey() string
+	// GetExpiredRetiredKeys:
+	//  Params
+	//   Before      time.Time
+	//  Returns
+	//   ID          string
+	//   UserID      string
+	//   KeyID       *url.URL
+	//   Expires     time.Time
+	GetExpiredRetiredKeys() string
+	// DeleteRetiredKey:
+	//  Params
+	//   ID          string
+	//  Returns
+	DeleteRetiredKey() string
 
 	// CreateClientInfo:
 	//  Params
@@ -468,6 +707,33 @@ type SqlDialect interface {
 	//   Domain      string
 	//   UserID      string
 	GetClientInfoByID() string
+	// DeleteClientInfo:
+	//  Params
+	//   ID          string
+	//  Returns
+	DeleteClientInfo() string
+
+	// CreateClientRegistration:
+	//  Params
+	//   ClientID    string
+	//   Metadata    []byte
+	//  Returns
+	CreateClientRegistration() string
+	// GetClientRegistration:
+	//  Params
+	//   ClientID    string
+	//  Returns
+	//   ClientID    string
+	//   Metadata    []byte
+	//   CreateTime  time.Time
+	GetClientRegistration() string
+	// GetClientRegistrations:
+	//  Params
+	//  Returns
+	//   ClientID    string
+	//   Metadata    []byte
+	//   CreateTime  time.Time
+	GetClientRegistrations() string
 
 	// CreateTokenInfo:
 	//  Params
@@ -564,6 +830,37 @@ type SqlDialect interface {
 	//   RefrCreated time.Time
 	//   RefrExpires time.Duration
 	GetTokenInfoByRefresh() string
+	// GetTokenInfosForUser fetches the access tokens issued to a user,
+	// along with their first party credential and registered client name,
+	// if any.
+	//  Params
+	//   UserID      string
+	//  Returns
+	//   ID          string
+	//   ClientID    string
+	//   UserID      string
+	//   RedirURI    string
+	//   Scope       string
+	//   Code        string
+	//   CodeCreated time.Time
+	//   CodeExpires time.Duration
+	//   CodeChal    string
+	//   CodeChalMtd string
+	//   Access      string
+	//   AccessCtd   time.Time
+	//   AccessExp   time.Duration
+	//   Refresh     string
+	//   RefrCreated time.Time
+	//   RefrExpires time.Duration
+	//   CredCreated time.Time
+	//   ClientName  string
+	GetTokenInfosForUser() string
+	// RemoveTokenInfoForUser:
+	//  Params
+	//   ID          string
+	//   UserID      string
+	//  Returns
+	RemoveTokenInfoForUser() string
 
 	// InsertFollowers:
 	//  Params
@@ -757,6 +1054,68 @@ type SqlDialect interface {
 	//   ID          string
 	//   Payload     []byte
 	GetPoliciesForActorAndPurpose() string
+	// CreateScopedPolicy creates an instance or domain scoped policy.
+	//  Params
+	//   Scope       string
+	//   Domain      string
+	//   Purpose     string
+	//   Payload     []byte
+	//  Returns
+	//   ID          string
+	CreateScopedPolicy() string
+	// GetInstancePolicies:
+	//  Params
+	//  Returns (Multiple)
+	//   ID          string
+	//   Purpose     string
+	//   Payload     []byte
+	GetInstancePolicies() string
+	// GetPoliciesForDomain fetches the domain scoped policies whose domain
+	// is the given host or one of its parent domains.
+	//  Params
+	//   Host        string
+	//  Returns (Multiple)
+	//   ID          string
+	//   Purpose     string
+	//   Payload     []byte
+	GetPoliciesForDomain() string
+	// GetScopedPolicies fetches all instance and domain scoped policies.
+	//  Params
+	//  Returns (Multiple)
+	//   ID          string
+	//   Scope       string
+	//   Domain      string
+	//   Purpose     string
+	//   Payload     []byte
+	GetScopedPolicies() string
+	// DeleteScopedPolicy:
+	//  Params
+	//   ID          string
+	//  Returns
+	DeleteScopedPolicy() string
+	// GetPolicy fetches a policy of any scope.
+	//  Params
+	//   ID          string
+	//  Returns
+	//   ID          string
+	//   ActorID     string
+	//   Scope       string
+	//   Domain      string
+	//   Purpose     string
+	//   Payload     []byte
+	GetPolicy() string
+	// UpdatePolicy:
+	//  Params
+	//   ID          string
+	//   Purpose     string
+	//   Payload     []byte
+	//  Returns
+	UpdatePolicy() string
+	// DeletePolicy deletes a policy of any scope.
+	//  Params
+	//   ID          string
+	//  Returns
+	DeletePolicy() string
 
 	// CreateResolution:
 	//  Params
@@ -765,6 +1124,64 @@ type SqlDialect interface {
 	//   Payload     []byte
 	//  Returns
 	CreateResolution() string
+	// GetResolutionsForPolicy fetches a page of a policy's resolutions,
+	// newest first.
+	//  Params
+	//   PolicyID    string
+	//   Offset      int
+	//   Limit       int
+	//  Returns (Multiple)
+	//   ID          string
+	//   PolicyID    string
+	//   DataIRI     string
+	//   Payload     []byte
+	//   CreateTime  time.Time
+	GetResolutionsForPolicy() string
+	// GetResolutionsForDataIRI fetches a page of the resolutions of
+	// federated data, newest first.
+	//  Params
+	//   DataIRI     string
+	//   Offset      int
+	//   Limit       int
+	//  Returns (Multiple)
+	//   ID          string
+	//   PolicyID    string
+	//   DataIRI     string
+	//   Payload     []byte
+	//   CreateTime  time.Time
+	GetResolutionsForDataIRI() string
+	// GetResolutionsInTimeRange fetches a page of the resolutions created
+	// at or after Start and before End, newest first.
+	//  Params
+	//   Start       time.Time
+	//   End         time.Time
+	//   Offset      int
+	//   Limit       int
+	//  Returns (Multiple)
+	//   ID          string
+	//   PolicyID    string
+	//   DataIRI     string
+	//   Payload     []byte
+	//   CreateTime  time.Time
+	GetResolutionsInTimeRange() string
+	// GetResolutionsByMatched fetches a page of the resolutions that did or
+	// did not match, newest first.
+	//  Params
+	//   Matched     bool
+	//   Offset      int
+	//   Limit       int
+	//  Returns (Multiple)
+	//   ID          string
+	//   PolicyID    string
+	//   DataIRI     string
+	//   Payload     []byte
+	//   CreateTime  time.Time
+	GetResolutionsByMatched() string
+	// DeleteResolutionsBefore:
+	//  Params
+	//   Time        time.Time
+	//  Returns
+	DeleteResolutionsBefore() string
 
 	// CreateFirstPartyCredential:
 	//  Params
@@ -830,10 +1247,178 @@ type SqlDialect interface {
 	//   RefrExpires time.Duration
 	GetTokenInfoForCredentialID() string
 
+	// CreateTOTPSecret:
+	//  Params
+	//   UserID      string
+	//   Secret      []byte
+	//  Returns
+	CreateTOTPSecret() string
+	// GetTOTPSecret:
+	//  Params
+	//   UserID      string
+	//  Returns
+	//   Secret      []byte
+	//   Confirmed   bool
+	//   LastStep    int64
+	GetTOTPSecret() string
+	// ConfirmTOTPSecret:
+	//  Params
+	//   UserID      string
+	//  Returns
+	ConfirmTOTPSecret() string
+	// UpdateTOTPLastStep only updates the last used time step if it is later
+	// than the stored one, so that codes cannot be used twice.
+	//  Params
+	//   UserID      string
+	//   LastStep    int64
+	//  Returns
+	UpdateTOTPLastStep() string
+	// DeleteTOTPSecret:
+	//  Params
+	//   UserID      string
+	//  Returns
+	DeleteTOTPSecret() string
+
+	// CreateRecoveryCode:
+	//  Params
+	//   UserID      string
+	//   HashedCode  []byte
+	//  Returns
+	CreateRecoveryCode() string
+	// CountRecoveryCodes:
+	//  Params
+	//   UserID      string
+	//  Returns
+	//   Count       int
+	CountRecoveryCodes() string
+	// UseRecoveryCode deletes a recovery code, so that it cannot be used
+	// again.
+	//  Params
+	//   UserID      string
+	//   HashedCode  []byte
+	//  Returns
+	UseRecoveryCode() string
+	// DeleteRecoveryCodes:
+	//  Params
+	//   UserID      string
+	//  Returns
+	DeleteRecoveryCodes() string
+
+	// CreateWebAuthnCredential:
+	//  Params
+	//   UserID      string
+	//   CredID      []byte
+	//   PublicKey   []byte
+	//   SignCount   int64
+	//   Name        string
+	//  Returns
+	//   ID          string
+	CreateWebAuthnCredential() string
+	// GetWebAuthnCredentialsForUser fetches the credentials of a user,
+	// oldest first.
+	//  Params
+	//   UserID      string
+	//  Returns (Multiple)
+	//   ID          string
+	//   CredID      []byte
+	//   PublicKey   []byte
+	//   SignCount   int64
+	//   Name        string
+	//   CreateTime  time.Time
+	//   LastUsed    sql.NullTime
+	GetWebAuthnCredentialsForUser() string
+	// UpdateWebAuthnCredentialSignCount also records the credential as
+	// last used now.
+	//  Params
+	//   ID          string
+	//   SignCount   int64
+	//  Returns
+	UpdateWebAuthnCredentialSignCount() string
+	// DeleteWebAuthnCredential:
+	//  Params
+	//   ID          string
+	//   UserID      string
+	//  Returns
+	DeleteWebAuthnCredential() string
+
+	// CreateWebAuthnChallenge:
+	//  Params
+	//   Challenge   string
+	//   Expires     time.Time
+	//  Returns
+	CreateWebAuthnChallenge() string
+	// UseWebAuthnChallenge deletes a challenge that has not expired, so that
+	// it cannot be used again.
+	//  Params
+	//   Challenge   string
+	//   Now         time.Time
+	//  Returns
+	UseWebAuthnChallenge() string
+	// DeleteExpiredWebAuthnChallenges:
+	//  Params
+	//   Now         time.Time
+	//  Returns
+	DeleteExpiredWebAuthnChallenges() string
+
+	// GetSecondFactorFailures:
+	//  Params
+	//   UserID      string
+	//  Returns
+	//   NFailures   int
+	//   LockedUntil sql.NullTime
+	GetSecondFactorFailures() string
+	// UpsertSecondFactorFailures:
+	//  Params
+	//   UserID      string
+	//   NFailures   int
+	//   LockedUntil sql.NullTime
+	//  Returns
+	UpsertSecondFactorFailures() string
+	// DeleteSecondFactorFailures:
+	//  Params
+	//   UserID      string
+	//  Returns
+	DeleteSecondFactorFailures() string
+
 	// GetOpenFollowRequests
 	//  Params
 	//   ID          string
 	//  Returns (Multiple)
 	//   Payload     []byte
 	GetOpenFollowRequests() string
+
+	// GetSchemaMigrations fetches the applied migrations of a component in
+	// ascending version order.
+	//  Params
+	//   Component   string
+	//  Returns (Multiple)
+	//   Version     int
+	//   Description string
+	//   AppliedAt   time.Time
+	GetSchemaMigrations() string
+	// InsertSchemaMigration:
+	//  Params
+	//   Component   string
+	//   Version     int
+	//   Description string
+	//  Returns
+	InsertSchemaMigration() string
+	// DeleteSchemaMigration:
+	//  Params
+	//   Component   string
+	//   Version     int
+	//  Returns
+	DeleteSchemaMigration() string
+
+	/* Migrations */
+
+	// Migrations returns the ordered schema migrations for this dialect.
+	//
+	// Versions must be positive and strictly increasing. Once released, a
+	// migration must never be changed; new schema changes are instead
+	// appended as a new version. The tables created by the Create*Table
+	// statements must always reflect the schema after the last migration,
+	// as a newly initialized database is recorded as having applied them
+	// all.
+	Migrations() []Migration
 }
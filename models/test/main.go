@@ -33,9 +33,11 @@ import (
 	"github.com/go-fed/activity/streams/vocab"
 	"github.com/go-fed/oauth2"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-var dburl = flag.String("db", "", "database url to connect to")
+var dialect = flag.String("dialect", "postgres", "sql dialect to test: postgres or sqlite")
+var dburl = flag.String("db", "", "database url (or file, for sqlite) to connect to")
 var schema = flag.String("schema", "modeltest", "schema to use in the sql dialect")
 
 var users = &models.Users{}
@@ -53,6 +55,16 @@ var followers = &models.Followers{}
 var liked = &models.Liked{}
 var policies = &models.Policies{}
 var resolutions = &models.Resolutions{}
+var goneActors = &models.GoneActors{}
+var dereferenceCache = &models.DereferenceCache{}
+var remotePublicKeys = &models.RemotePublicKeys{}
+var retiredKeys = &models.RetiredKeys{}
+var clientRegistrations = &models.ClientRegistrations{}
+var totpSecrets = &models.TOTPSecrets{}
+var recoveryCodes = &models.RecoveryCodes{}
+var webAuthnCredentials = &models.WebAuthnCredentials{}
+var secondFactorFailures = &models.SecondFactorFailures{}
+var webAuthnChallenges = &models.WebAuthnChallenges{}
 var testModels []models.Model
 
 func init() {
@@ -72,6 +84,16 @@ func init() {
 		liked,
 		policies,
 		resolutions,
+		goneActors,
+		dereferenceCache,
+		remotePublicKeys,
+		retiredKeys,
+		clientRegistrations,
+		totpSecrets,
+		recoveryCodes,
+		webAuthnCredentials,
+		secondFactorFailures,
+		webAuthnChallenges,
 	}
 }
 
@@ -79,7 +101,19 @@ func main() {
 	flag.Parse()
 
 	ctx := util.Context{context.Background()}
-	db, err := connectPostgres(*dburl)
+	var db *sql.DB
+	var d models.SqlDialect
+	var err error
+	switch *dialect {
+	case "postgres":
+		db, err = connectPostgres(*dburl)
+		d = dialectPostgres(*schema)
+	case "sqlite":
+		db, err = connectSqlite(*dburl)
+		d = dialectSqlite()
+	default:
+		err = fmt.Errorf("unknown dialect: %s", *dialect)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -89,7 +123,6 @@ func main() {
 		panic(err)
 	}
 
-	d := dialectPostgres(*schema)
 	fmt.Println("Creating tables...")
 	if err = createTables(ctx, db, d); err != nil {
 		panic(err)
@@ -122,10 +155,26 @@ func main() {
 	if err = runDeliveryAttemptsCalls(ctx, db); err != nil {
 		panic(err)
 	}
+	fmt.Println("Running GoneActors calls...")
+	if err = runGoneActorsCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running DereferenceCache calls...")
+	if err = runDereferenceCacheCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running RemotePublicKeys calls...")
+	if err = runRemotePublicKeysCalls(ctx, db); err != nil {
+		panic(err)
+	}
 	fmt.Println("Running PrivateKeys calls...")
 	if err = runPrivateKeysCalls(ctx, db); err != nil {
 		panic(err)
 	}
+	fmt.Println("Running RetiredKeys calls...")
+	if err = runRetiredKeysCalls(ctx, db); err != nil {
+		panic(err)
+	}
 	fmt.Println("Running ClientInfos calls...")
 	clientInfoID, err := runClientInfosCalls(ctx, db)
 	if err != nil {
@@ -139,6 +188,30 @@ func main() {
 	if err = runCredentialsCalls(ctx, db, clientInfoID); err != nil {
 		panic(err)
 	}
+	fmt.Println("Running ClientRegistrations calls...")
+	if err = runClientRegistrationsCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running TOTPSecrets calls...")
+	if err = runTOTPSecretsCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running RecoveryCodes calls...")
+	if err = runRecoveryCodesCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running WebAuthnCredentials calls...")
+	if err = runWebAuthnCredentialsCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running SecondFactorFailures calls...")
+	if err = runSecondFactorFailuresCalls(ctx, db); err != nil {
+		panic(err)
+	}
+	fmt.Println("Running WebAuthnChallenges calls...")
+	if err = runWebAuthnChallengesCalls(ctx, db); err != nil {
+		panic(err)
+	}
 	fmt.Println("Running Followers calls...")
 	if err = runFollowersCalls(ctx, db); err != nil {
 		panic(err)
@@ -1018,6 +1091,14 @@ func runTokenInfosCalls(ctx util.Context, db *sql.DB, clientID string) error {
 		return err
 	}
 	fmt.Printf("> GetByRefresh: %v\n", ti)
+	ut, err := runTokenInfosGetForUser(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("> GetForUser: %v\n", ut)
+	if err := runTokenInfosRemoveForUser(ctx, db, ut); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -1203,6 +1284,27 @@ func runTokenInfosGetByRefresh(ctx util.Context, db *sql.DB, clientID string) (t
 	})
 }
 
+func runTokenInfosGetForUser(ctx util.Context, db *sql.DB) (ut []models.UserToken, err error) {
+	var uid string
+	uid, err = getUserID(ctx, db)
+	if err != nil {
+		return
+	}
+	return ut, doWithTx(ctx, db, func(tx *sql.Tx) error {
+		ut, err = tokenInfos.GetForUser(ctx, tx, uid)
+		return err
+	})
+}
+
+func runTokenInfosRemoveForUser(ctx util.Context, db *sql.DB, ut []models.UserToken) error {
+	if len(ut) == 0 {
+		return fmt.Errorf("no user tokens to remove")
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return tokenInfos.RemoveForUser(ctx, tx, ut[0].ID, ut[0].UserID)
+	})
+}
+
 /* ClientInfos */
 
 func runClientInfosCalls(ctx util.Context, db *sql.DB) (string, error) {
@@ -1243,6 +1345,245 @@ func runClientInfosGetByID(ctx util.Context, db *sql.DB, id string) (ci oauth2.C
 	})
 }
 
+/* ClientRegistrations */
+
+func runClientRegistrationsCalls(ctx util.Context, db *sql.DB) error {
+	uid, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	ci := &models.ClientInfo{
+		ID:     "cr_id",
+		Secret: sql.NullString{"cr_secret", true},
+		Domain: "https://app.example.com/callback",
+		UserID: uid,
+	}
+	md := models.ClientMetadata{
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		ClientName:   "cr_name",
+	}
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := clientInfos.Create(ctx, tx, ci); err != nil {
+			return err
+		}
+		return clientRegistrations.Create(ctx, tx, ci.ID, md)
+	}); err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		cr, found, err := clientRegistrations.GetByClientID(ctx, tx, ci.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetByClientID: found=%v %v\n", found, cr)
+		all, err := clientRegistrations.GetAll(ctx, tx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetAll: %v\n", all)
+		if err := clientInfos.Delete(ctx, tx, ci.ID); err != nil {
+			return err
+		}
+		_, found, err = clientRegistrations.GetByClientID(ctx, tx, ci.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetByClientID after ClientInfos.Delete: found=%v\n", found)
+		return nil
+	})
+}
+
+/* TOTPSecrets */
+
+func runTOTPSecretsCalls(ctx util.Context, db *sql.DB) error {
+	uid, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		if err := totpSecrets.Create(ctx, tx, uid, []byte("totp_secret")); err != nil {
+			return err
+		}
+		ts, found, err := totpSecrets.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get: found=%v %v\n", found, ts)
+		if err := totpSecrets.Confirm(ctx, tx, uid); err != nil {
+			return err
+		}
+		updated, err := totpSecrets.UpdateLastStep(ctx, tx, uid, 100)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> UpdateLastStep(100): %v\n", updated)
+		updated, err = totpSecrets.UpdateLastStep(ctx, tx, uid, 100)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> UpdateLastStep(100) again: %v\n", updated)
+		ts, found, err = totpSecrets.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get: found=%v %v\n", found, ts)
+		if err := totpSecrets.Delete(ctx, tx, uid); err != nil {
+			return err
+		}
+		_, found, err = totpSecrets.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get after Delete: found=%v\n", found)
+		return nil
+	})
+}
+
+/* RecoveryCodes */
+
+func runRecoveryCodesCalls(ctx util.Context, db *sql.DB) error {
+	uid, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		for _, code := range []string{"code_1", "code_2"} {
+			if err := recoveryCodes.Create(ctx, tx, uid, []byte(code)); err != nil {
+				return err
+			}
+		}
+		n, err := recoveryCodes.Count(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Count: %d\n", n)
+		used, err := recoveryCodes.Use(ctx, tx, uid, []byte("code_1"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Use(code_1): %v\n", used)
+		used, err = recoveryCodes.Use(ctx, tx, uid, []byte("code_1"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Use(code_1) again: %v\n", used)
+		if err := recoveryCodes.DeleteAll(ctx, tx, uid); err != nil {
+			return err
+		}
+		n, err = recoveryCodes.Count(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Count after DeleteAll: %d\n", n)
+		return nil
+	})
+}
+
+/* WebAuthnCredentials */
+
+func runWebAuthnCredentialsCalls(ctx util.Context, db *sql.DB) error {
+	uid, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		id, err := webAuthnCredentials.Create(ctx, tx, uid, models.WebAuthnCredential{
+			CredentialID: []byte("cred_id"),
+			PublicKey:    []byte("public_key"),
+			SignCount:    1,
+			Name:         "security key",
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Create: %s\n", id)
+		if err := webAuthnCredentials.UpdateSignCount(ctx, tx, id, 2); err != nil {
+			return err
+		}
+		wc, err := webAuthnCredentials.GetForUser(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetForUser: %v\n", wc)
+		if err := webAuthnCredentials.Delete(ctx, tx, id, uid); err != nil {
+			return err
+		}
+		wc, err = webAuthnCredentials.GetForUser(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetForUser after Delete: %v\n", wc)
+		return nil
+	})
+}
+
+/* SecondFactorFailures */
+
+func runSecondFactorFailuresCalls(ctx util.Context, db *sql.DB) error {
+	uid, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		n, lockedUntil, err := secondFactorFailures.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get: %d %v\n", n, lockedUntil)
+		if err := secondFactorFailures.Set(ctx, tx, uid, 1, time.Time{}); err != nil {
+			return err
+		}
+		if err := secondFactorFailures.Set(ctx, tx, uid, 5, time.Now().Add(time.Minute)); err != nil {
+			return err
+		}
+		n, lockedUntil, err = secondFactorFailures.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get: %d %v\n", n, lockedUntil)
+		if err := secondFactorFailures.Delete(ctx, tx, uid); err != nil {
+			return err
+		}
+		n, lockedUntil, err = secondFactorFailures.Get(ctx, tx, uid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get after Delete: %d %v\n", n, lockedUntil)
+		return nil
+	})
+}
+
+/* WebAuthnChallenges */
+
+func runWebAuthnChallengesCalls(ctx util.Context, db *sql.DB) error {
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		now := time.Now()
+		if err := webAuthnChallenges.Create(ctx, tx, "challenge1", now.Add(time.Minute)); err != nil {
+			return err
+		}
+		if err := webAuthnChallenges.Create(ctx, tx, "challenge2", now.Add(-time.Minute)); err != nil {
+			return err
+		}
+		used, err := webAuthnChallenges.Use(ctx, tx, "challenge1", now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Use(challenge1): %v\n", used)
+		used, err = webAuthnChallenges.Use(ctx, tx, "challenge1", now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Use(challenge1) again: %v\n", used)
+		// The second challenge has expired.
+		used, err = webAuthnChallenges.Use(ctx, tx, "challenge2", now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Use(challenge2): %v\n", used)
+		return webAuthnChallenges.DeleteExpired(ctx, tx, now)
+	})
+}
+
 /* PrivateKeys */
 
 func runPrivateKeysCalls(ctx util.Context, db *sql.DB) error {
@@ -1259,7 +1600,12 @@ func runPrivateKeysCalls(ctx util.Context, db *sql.DB) error {
 		return err
 	}
 	fmt.Printf("> GetForInstanceActor: %v\n", b)
-	return nil
+	ids, err := runPrivateKeysUsersWithout(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("> UsersWithout: %v\n", ids)
+	return runPrivateKeysRotate(ctx, db)
 }
 
 func runPrivateKeysCreate(ctx util.Context, db *sql.DB) error {
@@ -1278,7 +1624,33 @@ func runPrivateKeysGetByUserID(ctx util.Context, db *sql.DB) (b []byte, err erro
 		return nil, err
 	}
 	return b, doWithTx(ctx, db, func(tx *sql.Tx) error {
-		b, err = privateKeys.GetByUserID(ctx, tx, id, "test")
+		b, _, err = privateKeys.GetByUserID(ctx, tx, id, "test")
+		return err
+	})
+}
+
+func runPrivateKeysRotate(ctx util.Context, db *sql.DB) error {
+	id, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		err := privateKeys.Rotate(ctx, tx, id, "test", []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, mustParse(testActor1IRI+"#rotated"))
+		if err != nil {
+			return err
+		}
+		b, keyID, err := privateKeys.GetByUserID(ctx, tx, id, "test")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Rotate: %v %v\n", b, keyID)
+		return nil
+	})
+}
+
+func runPrivateKeysUsersWithout(ctx util.Context, db *sql.DB) (ids []string, err error) {
+	return ids, doWithTx(ctx, db, func(tx *sql.Tx) error {
+		ids, err = privateKeys.UsersWithout(ctx, tx, "test")
 		return err
 	})
 }
@@ -1295,7 +1667,7 @@ func runPrivateKeysGetForInstanceActor(ctx util.Context, db *sql.DB) (b []byte,
 		return
 	}
 	return b, doWithTx(ctx, db, func(tx *sql.Tx) error {
-		b, err = privateKeys.GetInstanceActor(ctx, tx, "test")
+		b, _, err = privateKeys.GetInstanceActor(ctx, tx, "test")
 		return err
 	})
 }
@@ -1317,25 +1689,39 @@ func runDeliveryAttemptsCalls(ctx util.Context, db *sql.DB) error {
 	if err := runDeliveryAttemptsMarkAbandoned(ctx, db); err != nil {
 		return err
 	}
-	rf, ft, err := runDeliveryAttemptsFirstPage(ctx, db)
+	da, err := runDeliveryAttemptsGetDue(ctx, db)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("> FirstPage: len=%d\n", len(rf))
-	for i, r := range rf {
-		fmt.Printf("> [%d]=%v\n", i, r)
+	fmt.Printf("> GetDue: len=%d\n", len(da))
+	for i, a := range da {
+		fmt.Printf("> [%d]=%v\n", i, a)
 	}
-	for k := 0; k < 3; k++ {
-		last := rf[len(rf)-1]
-		rf, err = runDeliveryAttemptsNextPage(ctx, db, last.ID, ft)
-		if err != nil {
+	// A claimed attempt is no longer due, so cannot be claimed again.
+	for i := 0; i < 2; i++ {
+		var ok bool
+		if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+			ok, err = deliveryAttempts.Claim(ctx, tx, da[0].ID, time.Now(), time.Now().Add(time.Hour))
+			return err
+		}); err != nil {
 			return err
 		}
-		fmt.Printf("> NextPage[%d, %s]: len=%d\n", k, last.ID, len(rf))
-		for i, r := range rf {
-			fmt.Printf("> [%d]=%v\n", i, r)
-		}
+		fmt.Printf("> Claim(%s): %v\n", da[0].ID, ok)
+	}
+	// A released attempt is due again.
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return deliveryAttempts.Release(ctx, tx, da[0].ID, da[0].NextAttempt)
+	}); err != nil {
+		return err
+	}
+	var ok bool
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		ok, err = deliveryAttempts.Claim(ctx, tx, da[0].ID, time.Now(), time.Now().Add(time.Hour))
+		return err
+	}); err != nil {
+		return err
 	}
+	fmt.Printf("> Claim(%s) after Release: %v\n", da[0].ID, ok)
 	return nil
 }
 
@@ -1380,7 +1766,7 @@ func runDeliveryAttemptsMarkFailed(ctx util.Context, db *sql.DB) error {
 		return err
 	}
 	return doWithTx(ctx, db, func(tx *sql.Tx) error {
-		return deliveryAttempts.MarkFailed(ctx, tx, daID)
+		return deliveryAttempts.MarkFailed(ctx, tx, daID, time.Now())
 	})
 }
 
@@ -1401,63 +1787,264 @@ func runDeliveryAttemptsMarkAbandoned(ctx util.Context, db *sql.DB) error {
 	})
 }
 
-func runDeliveryAttemptsFirstPage(ctx util.Context, db *sql.DB) (rf []models.RetryableFailure, ft time.Time, err error) {
+func runDeliveryAttemptsGetDue(ctx util.Context, db *sql.DB) (da []models.DueAttempt, err error) {
 	var id string
 	id, err = getUserID(ctx, db)
 	if err != nil {
 		return
 	}
 	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
-		// Make 24 additional failed, in addition to the existing one.
+		// Make 24 additional failed that are due, in addition to the
+		// existing ones.
 		for i := 0; i < 24; i++ {
 			var daID string
 			daID, err = deliveryAttempts.Create(ctx, tx, id, mustParse(testPeerActor1InboxIRI), []byte("hello_fetch_me"))
 			if err != nil {
 				return err
 			}
-			err = deliveryAttempts.MarkFailed(ctx, tx, daID)
+			err = deliveryAttempts.MarkFailed(ctx, tx, daID, time.Now().Add(-time.Minute))
 			if err != nil {
 				return err
 			}
 		}
-		// Get the current "fetch" time
-		ft = time.Now()
-		return nil
-	}); err != nil {
-		return
-	}
-	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
-		// Make 10 more failed, which should be skipped
+		// Make 10 more failed that are not yet due, which should be
+		// skipped
 		for i := 0; i < 10; i++ {
 			var daID string
 			daID, err = deliveryAttempts.Create(ctx, tx, id, mustParse(testPeerActor2InboxIRI), []byte("hello_no_fetch"))
 			if err != nil {
 				return err
 			}
-			err = deliveryAttempts.MarkFailed(ctx, tx, daID)
+			err = deliveryAttempts.MarkFailed(ctx, tx, daID, time.Now().Add(time.Hour))
 			if err != nil {
 				return err
 			}
 		}
-		return err
+		return nil
 	}); err != nil {
 		return
 	}
 	err = doWithTx(ctx, db, func(tx *sql.Tx) error {
-		rf, err = deliveryAttempts.FirstPageFailures(ctx, tx, ft, 10)
+		da, err = deliveryAttempts.GetDue(ctx, tx, time.Now(), 50)
 		return err
 	})
 	return
 }
 
-func runDeliveryAttemptsNextPage(ctx util.Context, db *sql.DB, prev string, ft time.Time) (rf []models.RetryableFailure, err error) {
+/* GoneActors */
+
+func runGoneActorsCalls(ctx util.Context, db *sql.DB) error {
+	if err := runGoneActorsUpsert(ctx, db, testPeerActor1IRI, testPeerActor1InboxIRI, time.Now().Add(time.Hour)); err != nil {
+		return err
+	}
+	// Recording an actor as gone again must not fail.
+	if err := runGoneActorsUpsert(ctx, db, testPeerActor1IRI, testPeerActor1InboxIRI, time.Now().Add(time.Hour)); err != nil {
+		return err
+	}
+	if err := runGoneActorsUpsert(ctx, db, testPeerActor2IRI, testPeerActor2InboxIRI, time.Now().Add(-time.Hour)); err != nil {
+		return err
+	}
+	ex, err := runGoneActorsInboxExists(ctx, db, testPeerActor1InboxIRI)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("> InboxExists(%s): %v\n", testPeerActor1InboxIRI, ex)
+	// The second actor has expired.
+	ex, err = runGoneActorsInboxExists(ctx, db, testPeerActor2InboxIRI)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("> InboxExists(%s): %v\n", testPeerActor2InboxIRI, ex)
+	var n int64
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		n, err = deliveryAttempts.AbandonTo(ctx, tx, mustParse(testPeerActor1InboxIRI))
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> AbandonTo(%s): %d\n", testPeerActor1InboxIRI, n)
+	var ga []models.GoneActor
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		ga, err = goneActors.GetAll(ctx, tx)
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> GetAll: %d\n", len(ga))
+	var deleted bool
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		deleted, err = goneActors.Delete(ctx, tx, mustParse(testPeerActor1IRI))
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> Delete(%s): %v\n", testPeerActor1IRI, deleted)
+	if err = doWithTx(ctx, db, func(tx *sql.Tx) error {
+		n, err = goneActors.DeleteAll(ctx, tx)
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> DeleteAll: %d\n", n)
+	return nil
+}
+
+func runGoneActorsUpsert(ctx util.Context, db *sql.DB, actor, inbox string, expires time.Time) error {
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return goneActors.Upsert(ctx, tx, mustParse(actor), mustParse(inbox), expires)
+	})
+}
+
+func runGoneActorsInboxExists(ctx util.Context, db *sql.DB, inbox string) (v bool, err error) {
 	err = doWithTx(ctx, db, func(tx *sql.Tx) error {
-		rf, err = deliveryAttempts.NextPageFailures(ctx, tx, prev, ft, 10)
+		v, err = goneActors.InboxExists(ctx, tx, mustParse(inbox), time.Now())
 		return err
 	})
 	return
 }
 
+/* DereferenceCache */
+
+func runDereferenceCacheCalls(ctx util.Context, db *sql.DB) error {
+	iri := mustParse(testPeerActor1IRI)
+	o := models.CachedObject{
+		Body:    []byte(`{"id":"` + testPeerActor1IRI + `"}`),
+		ETag:    `"v1"`,
+		Expires: time.Now().Add(time.Hour),
+	}
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return dereferenceCache.Put(ctx, tx, iri, o)
+	}); err != nil {
+		return err
+	}
+	// Caching an object again must replace it.
+	o.ETag = `"v2"`
+	o.LastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return dereferenceCache.Put(ctx, tx, iri, o)
+	}); err != nil {
+		return err
+	}
+	if err := runDereferenceCacheGet(ctx, db, iri); err != nil {
+		return err
+	}
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return dereferenceCache.Revalidate(ctx, tx, iri, time.Now().Add(-time.Hour))
+	}); err != nil {
+		return err
+	}
+	if err := runDereferenceCacheGet(ctx, db, iri); err != nil {
+		return err
+	}
+	var n int64
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) (err error) {
+		n, err = dereferenceCache.Prune(ctx, tx, time.Now())
+		return
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> Prune: %d\n", n)
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return dereferenceCache.Delete(ctx, tx, iri)
+	}); err != nil {
+		return err
+	}
+	return runDereferenceCacheGet(ctx, db, iri)
+}
+
+func runDereferenceCacheGet(ctx util.Context, db *sql.DB, iri *url.URL) error {
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		o, found, err := dereferenceCache.Get(ctx, tx, iri)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get(%s): found=%v etag=%s last_modified=%s expires=%s body=%s\n", iri, found, o.ETag, o.LastModified, o.Expires, o.Body)
+		return nil
+	})
+}
+
+/* RemotePublicKeys */
+
+func runRemotePublicKeysCalls(ctx util.Context, db *sql.DB) error {
+	keyID := mustParse(testPeerActor1IRI + "#main-key")
+	k := models.RemotePublicKey{
+		PublicKeyPEM: "old",
+		Owner:        models.URL{mustParse(testPeerActor1IRI)},
+		Expires:      time.Now().Add(time.Hour),
+	}
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return remotePublicKeys.Put(ctx, tx, keyID, k)
+	}); err != nil {
+		return err
+	}
+	// Caching a rotated key must replace the old one.
+	k.PublicKeyPEM = "new"
+	k.Expires = time.Now().Add(-time.Hour)
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		return remotePublicKeys.Put(ctx, tx, keyID, k)
+	}); err != nil {
+		return err
+	}
+	if err := runRemotePublicKeysGet(ctx, db, keyID); err != nil {
+		return err
+	}
+	var n int64
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) (err error) {
+		n, err = remotePublicKeys.Prune(ctx, tx, time.Now())
+		return
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("> Prune: %d\n", n)
+	return runRemotePublicKeysGet(ctx, db, keyID)
+}
+
+/* RetiredKeys */
+
+func runRetiredKeysCalls(ctx util.Context, db *sql.DB) error {
+	id, err := getUserID(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := doWithTx(ctx, db, func(tx *sql.Tx) error {
+		if err := retiredKeys.Create(ctx, tx, id, mustParse(testActor1IRI+"#expired"), time.Now().Add(-time.Hour)); err != nil {
+			return err
+		}
+		return retiredKeys.Create(ctx, tx, id, mustParse(testActor1IRI+"#unexpired"), time.Now().Add(time.Hour))
+	}); err != nil {
+		return err
+	}
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		rk, err := retiredKeys.GetExpired(ctx, tx, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetExpired: %v\n", rk)
+		for _, k := range rk {
+			if err := retiredKeys.Delete(ctx, tx, k.ID); err != nil {
+				return err
+			}
+		}
+		rk, err = retiredKeys.GetExpired(ctx, tx, time.Now().Add(2*time.Hour))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> GetExpired after Delete: %v\n", rk)
+		return nil
+	})
+}
+
+func runRemotePublicKeysGet(ctx util.Context, db *sql.DB, keyID *url.URL) error {
+	return doWithTx(ctx, db, func(tx *sql.Tx) error {
+		k, found, err := remotePublicKeys.Get(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("> Get(%s): found=%v pem=%s owner=%s expires=%s\n", keyID, found, k.PublicKeyPEM, k.Owner.URL, k.Expires)
+		return nil
+	})
+}
+
 /* Outboxes */
 
 func runOutboxesCalls(ctx util.Context, db *sql.DB) error {
@@ -2269,6 +2856,14 @@ func dialectPostgres(schema string) models.SqlDialect {
 	return db.NewPgV0(schema)
 }
 
+func connectSqlite(file string) (*sql.DB, error) {
+	return sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_txlock=immediate&_busy_timeout=5000", file))
+}
+
+func dialectSqlite() models.SqlDialect {
+	return db.NewSqliteV0()
+}
+
 func mustParse(s string) *url.URL {
 	u, err := url.Parse(s)
 	if err != nil {
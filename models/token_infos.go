@@ -230,6 +230,16 @@ func (t *TokenInfo) scanFromSingleRow(r SingleRow) error {
 		&(t.RefreshExpires))
 }
 
+// UserToken is an access token issued to a user, along with the creation time
+// of the first party credential it backs and the name of the registered
+// client it was issued to, if any.
+type UserToken struct {
+	ID string
+	TokenInfo
+	CredCreated sql.NullTime
+	ClientName  sql.NullString
+}
+
 // TokenInfos is a Model that provides additional database methods for OAuth2
 // token information.
 type TokenInfos struct {
@@ -240,6 +250,8 @@ type TokenInfos struct {
 	getByCode       *sql.Stmt
 	getByAccess     *sql.Stmt
 	getByRefresh    *sql.Stmt
+	getForUser      *sql.Stmt
+	removeForUser   *sql.Stmt
 }
 
 func (t *TokenInfos) Prepare(db *sql.DB, s SqlDialect) error {
@@ -252,6 +264,8 @@ func (t *TokenInfos) Prepare(db *sql.DB, s SqlDialect) error {
 			{&(t.getByCode), s.GetTokenInfoByCode()},
 			{&(t.getByAccess), s.GetTokenInfoByAccess()},
 			{&(t.getByRefresh), s.GetTokenInfoByRefresh()},
+			{&(t.getForUser), s.GetTokenInfosForUser()},
+			{&(t.removeForUser), s.RemoveTokenInfoForUser()},
 		})
 }
 
@@ -268,6 +282,8 @@ func (t *TokenInfos) Close() {
 	t.getByCode.Close()
 	t.getByAccess.Close()
 	t.getByRefresh.Close()
+	t.getForUser.Close()
+	t.removeForUser.Close()
 }
 
 // Create saves the new token information.
@@ -312,9 +328,13 @@ func (t *TokenInfos) RemoveByAccess(c util.Context, tx *sql.Tx, access string) e
 }
 
 // RemoveByRefresh deletes the token information based on the refresh token.
+//
+// It is not an error if no token information is deleted: when refreshing, the
+// old access and refresh tokens share one row, which is deleted by removing
+// the old access token first.
 func (t *TokenInfos) RemoveByRefresh(c util.Context, tx *sql.Tx, refresh string) error {
-	r, err := tx.Stmt(t.removeByRefresh).ExecContext(c, refresh)
-	return mustChangeOneRow(r, err, "TokenInfos.RemoveByRefresh")
+	_, err := tx.Stmt(t.removeByRefresh).ExecContext(c, refresh)
+	return err
 }
 
 // GetByCode fetches tokens based on the authorization code.
@@ -355,3 +375,55 @@ func (t *TokenInfos) GetByRefresh(c util.Context, tx *sql.Tx, refresh string) (o
 		return ti.scanFromSingleRow(r)
 	})
 }
+
+// GetForUser fetches the access tokens issued to a user.
+func (t *TokenInfos) GetForUser(c util.Context, tx *sql.Tx, userID string) (ut []UserToken, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(t.getForUser).QueryContext(c, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return ut, doForRows(rows, "TokenInfos.GetForUser", func(r SingleRow) error {
+		var u UserToken
+		if err := r.Scan(&(u.ID),
+			&(u.ClientID),
+			&(u.UserID),
+			&(u.RedirectURI),
+			&(u.Scope),
+			&(u.Code),
+			&(u.CodeCreated),
+			&(u.CodeExpires),
+			&(u.CodeChallenge),
+			&(u.CodeChallengeMethod),
+			&(u.Access),
+			&(u.AccessCreated),
+			&(u.AccessExpires),
+			&(u.Refresh),
+			&(u.RefreshCreated),
+			&(u.RefreshExpires),
+			&(u.CredCreated),
+			&(u.ClientName)); err != nil {
+			return err
+		}
+		ut = append(ut, u)
+		return nil
+	})
+}
+
+// RemoveForUser deletes the token information issued to a user.
+func (t *TokenInfos) RemoveForUser(c util.Context, tx *sql.Tx, id, userID string) error {
+	r, err := tx.Stmt(t.removeForUser).ExecContext(c, id, userID)
+	return mustChangeOneRow(r, err, "TokenInfos.RemoveForUser")
+}
+
+// CreateTime determines when the user signed in to obtain the token, which is
+// kept when the token is refreshed.
+func (u UserToken) CreateTime() time.Time {
+	if u.CredCreated.Valid {
+		return u.CredCreated.Time
+	} else if u.RefreshCreated.Valid {
+		return u.RefreshCreated.Time
+	}
+	return u.GetAccessCreateAt()
+}
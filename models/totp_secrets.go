@@ -0,0 +1,119 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// TOTPSecret is the shared secret of a user's time-based one-time password
+// authenticator, as described by RFC 6238.
+type TOTPSecret struct {
+	Secret []byte
+	// Confirmed is false until the user proves their authenticator has the
+	// secret, and only then is it required to log in.
+	Confirmed bool
+	// LastStep is the time step of the last code used to log in, so that
+	// no code can be used twice.
+	LastStep int64
+}
+
+var _ Model = &TOTPSecrets{}
+
+// TOTPSecrets is a Model that provides additional database methods for the
+// secrets of users' time-based one-time password authenticators.
+type TOTPSecrets struct {
+	create         *sql.Stmt
+	get            *sql.Stmt
+	confirm        *sql.Stmt
+	updateLastStep *sql.Stmt
+	del            *sql.Stmt
+}
+
+func (t *TOTPSecrets) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(t.create), s.CreateTOTPSecret()},
+			{&(t.get), s.GetTOTPSecret()},
+			{&(t.confirm), s.ConfirmTOTPSecret()},
+			{&(t.updateLastStep), s.UpdateTOTPLastStep()},
+			{&(t.del), s.DeleteTOTPSecret()},
+		})
+}
+
+func (t *TOTPSecrets) CreateTable(tx *sql.Tx, s SqlDialect) error {
+	_, err := tx.Exec(s.CreateTOTPSecretsTable())
+	return err
+}
+
+func (t *TOTPSecrets) Close() {
+	t.create.Close()
+	t.get.Close()
+	t.confirm.Close()
+	t.updateLastStep.Close()
+	t.del.Close()
+}
+
+// Create stores an unconfirmed secret for the user.
+func (t *TOTPSecrets) Create(c util.Context, tx *sql.Tx, userID string, secret []byte) error {
+	r, err := tx.Stmt(t.create).ExecContext(c, userID, secret)
+	return mustChangeOneRow(r, err, "TOTPSecrets.Create")
+}
+
+// Get fetches the user's secret, which is not found if the user has none.
+func (t *TOTPSecrets) Get(c util.Context, tx *sql.Tx, userID string) (ts TOTPSecret, found bool, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(t.get).QueryContext(c, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	err = enforceOneRow(rows, "TOTPSecrets.Get", func(r SingleRow) error {
+		found = true
+		return r.Scan(&(ts.Secret), &(ts.Confirmed), &(ts.LastStep))
+	})
+	return
+}
+
+// Confirm requires the user's secret to log in.
+func (t *TOTPSecrets) Confirm(c util.Context, tx *sql.Tx, userID string) error {
+	r, err := tx.Stmt(t.confirm).ExecContext(c, userID)
+	return mustChangeOneRow(r, err, "TOTPSecrets.Confirm")
+}
+
+// UpdateLastStep records the time step of a code used by the user. It does
+// not update anything, and returns false, if a code of the same or a later time
+// step was already used.
+func (t *TOTPSecrets) UpdateLastStep(c util.Context, tx *sql.Tx, userID string, step int64) (updated bool, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(t.updateLastStep).ExecContext(c, userID, step)
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = r.RowsAffected()
+	updated = n == 1
+	return
+}
+
+// Delete removes the user's secret.
+func (t *TOTPSecrets) Delete(c util.Context, tx *sql.Tx, userID string) error {
+	r, err := tx.Stmt(t.del).ExecContext(c, userID)
+	return mustChangeOneRow(r, err, "TOTPSecrets.Delete")
+}
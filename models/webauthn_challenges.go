@@ -0,0 +1,80 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+var _ Model = &WebAuthnChallenges{}
+
+// WebAuthnChallenges is a Model that provides additional database methods for
+// the outstanding challenges of WebAuthn logins and registrations.
+type WebAuthnChallenges struct {
+	create     *sql.Stmt
+	use        *sql.Stmt
+	delExpired *sql.Stmt
+}
+
+func (w *WebAuthnChallenges) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(w.create), s.CreateWebAuthnChallenge()},
+			{&(w.use), s.UseWebAuthnChallenge()},
+			{&(w.delExpired), s.DeleteExpiredWebAuthnChallenges()},
+		})
+}
+
+func (w *WebAuthnChallenges) CreateTable(tx *sql.Tx, s SqlDialect) error {
+	_, err := tx.Exec(s.CreateWebAuthnChallengesTable())
+	return err
+}
+
+func (w *WebAuthnChallenges) Close() {
+	w.create.Close()
+	w.use.Close()
+	w.delExpired.Close()
+}
+
+// Create stores a challenge that can be used until it expires.
+func (w *WebAuthnChallenges) Create(c util.Context, tx *sql.Tx, challenge string, expires time.Time) error {
+	r, err := tx.Stmt(w.create).ExecContext(c, challenge, expires)
+	return mustChangeOneRow(r, err, "WebAuthnChallenges.Create")
+}
+
+// Use deletes the challenge, returning false if it does not exist or has
+// expired.
+func (w *WebAuthnChallenges) Use(c util.Context, tx *sql.Tx, challenge string, now time.Time) (used bool, err error) {
+	var r sql.Result
+	r, err = tx.Stmt(w.use).ExecContext(c, challenge, now)
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = r.RowsAffected()
+	used = n == 1
+	return
+}
+
+// DeleteExpired removes the challenges that expired without being used.
+func (w *WebAuthnChallenges) DeleteExpired(c util.Context, tx *sql.Tx, now time.Time) error {
+	_, err := tx.Stmt(w.delExpired).ExecContext(c, now)
+	return err
+}
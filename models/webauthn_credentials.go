@@ -0,0 +1,116 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/allinbits/apcore/util"
+)
+
+// WebAuthnCredential is a public key credential that a user registered with
+// an authenticator, as described by the Web Authentication specification.
+type WebAuthnCredential struct {
+	ID           string
+	CredentialID []byte
+	// PublicKey is the credential's COSE_Key encoded public key.
+	PublicKey []byte
+	// SignCount is the authenticator's signature counter when the
+	// credential was last used, which helps detect cloned authenticators.
+	SignCount    int64
+	Name         string
+	CreateTime   time.Time
+	LastUsedTime sql.NullTime
+}
+
+var _ Model = &WebAuthnCredentials{}
+
+// WebAuthnCredentials is a Model that provides additional database methods
+// for the WebAuthn credentials that users log in with as a second factor.
+type WebAuthnCredentials struct {
+	create          *sql.Stmt
+	getForUser      *sql.Stmt
+	updateSignCount *sql.Stmt
+	del             *sql.Stmt
+}
+
+func (w *WebAuthnCredentials) Prepare(db *sql.DB, s SqlDialect) error {
+	return prepareStmtPairs(db,
+		stmtPairs{
+			{&(w.create), s.CreateWebAuthnCredential()},
+			{&(w.getForUser), s.GetWebAuthnCredentialsForUser()},
+			{&(w.updateSignCount), s.UpdateWebAuthnCredentialSignCount()},
+			{&(w.del), s.DeleteWebAuthnCredential()},
+		})
+}
+
+func (w *WebAuthnCredentials) CreateTable(t *sql.Tx, s SqlDialect) error {
+	_, err := t.Exec(s.CreateWebAuthnCredentialsTable())
+	return err
+}
+
+func (w *WebAuthnCredentials) Close() {
+	w.create.Close()
+	w.getForUser.Close()
+	w.updateSignCount.Close()
+	w.del.Close()
+}
+
+// Create stores a newly registered credential of the user.
+func (w *WebAuthnCredentials) Create(c util.Context, tx *sql.Tx, userID string, wc WebAuthnCredential) (id string, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(w.create).QueryContext(c, userID, wc.CredentialID, wc.PublicKey, wc.SignCount, wc.Name)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return id, enforceOneRow(rows, "WebAuthnCredentials.Create", func(r SingleRow) error {
+		return r.Scan(&id)
+	})
+}
+
+// GetForUser fetches the credentials of the user, oldest first.
+func (w *WebAuthnCredentials) GetForUser(c util.Context, tx *sql.Tx, userID string) (wc []WebAuthnCredential, err error) {
+	var rows *sql.Rows
+	rows, err = tx.Stmt(w.getForUser).QueryContext(c, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return wc, doForRows(rows, "WebAuthnCredentials.GetForUser", func(r SingleRow) error {
+		var cred WebAuthnCredential
+		if err := r.Scan(&(cred.ID), &(cred.CredentialID), &(cred.PublicKey), &(cred.SignCount), &(cred.Name), &(cred.CreateTime), &(cred.LastUsedTime)); err != nil {
+			return err
+		}
+		wc = append(wc, cred)
+		return nil
+	})
+}
+
+// UpdateSignCount records that the credential was used, with the
+// authenticator's new signature counter.
+func (w *WebAuthnCredentials) UpdateSignCount(c util.Context, tx *sql.Tx, id string, signCount int64) error {
+	r, err := tx.Stmt(w.updateSignCount).ExecContext(c, id, signCount)
+	return mustChangeOneRow(r, err, "WebAuthnCredentials.UpdateSignCount")
+}
+
+// Delete removes the user's credential.
+func (w *WebAuthnCredentials) Delete(c util.Context, tx *sql.Tx, id, userID string) error {
+	r, err := tx.Stmt(w.del).ExecContext(c, id, userID)
+	return mustChangeOneRow(r, err, "WebAuthnCredentials.Delete")
+}
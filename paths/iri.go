@@ -65,6 +65,7 @@ const (
 	LikedFirstPathKey             = "likedFirst"
 	LikedLastPathKey              = "likedLast"
 	HttpSigPubKeyKey              = "httpsigPubKey"
+	Ed25519PubKeyKey              = "ed25519PubKey"
 )
 
 var knownPaths map[PathKey]string = map[PathKey]string{
@@ -85,6 +86,7 @@ var knownPaths map[PathKey]string = map[PathKey]string{
 	LikedFirstPathKey:     "{user}/liked",
 	LikedLastPathKey:      "{user}/liked",
 	HttpSigPubKeyKey:      "{user}",
+	Ed25519PubKeyKey:      "{user}",
 }
 
 func knownPath(prefix string, k PathKey) string {
@@ -149,6 +151,7 @@ var knownUserPathQuery map[PathKey]string = map[PathKey]string{
 
 var knownUserPathFragment map[PathKey]string = map[PathKey]string{
 	HttpSigPubKeyKey: "public-httpsig",
+	Ed25519PubKeyKey: "public-ed25519",
 }
 
 type UUID string
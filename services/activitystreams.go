@@ -77,7 +77,7 @@ func addNextPrevCol(page vocab.ActivityStreamsCollectionPage, start, n int, isEn
 
 func toPersonActor(uuid paths.UUID,
 	scheme, host, username, preferredUsername, summary string,
-	pubKey string) (vocab.ActivityStreamsPerson, *url.URL) {
+	pubKey, multikey string) (vocab.ActivityStreamsPerson, *url.URL) {
 	p := streams.NewActivityStreamsPerson()
 	// id
 	idProp := streams.NewJSONLDIdProperty()
@@ -137,29 +137,58 @@ func toPersonActor(uuid paths.UUID,
 
 	// publicKey property
 	publicKeyProp := streams.NewW3IDSecurityV1PublicKeyProperty()
+	pubKeyIRI := paths.UUIDIRIFor(scheme, host, paths.HttpSigPubKeyKey, uuid)
+	multikeyIRI := paths.UUIDIRIFor(scheme, host, paths.Ed25519PubKeyKey, uuid)
+	publicKeyProp.AppendW3IDSecurityV1PublicKey(toPublicKey(pubKeyIRI, idIRI, pubKey))
+	p.SetW3IDSecurityV1PublicKey(publicKeyProp)
+
+	// assertionMethod property
+	setMultikey(p, idIRI, multikeyIRI, multikey)
+	return p, idIRI
+}
 
-	// publicKey type
+// toPublicKey creates a publicKey of an actor, for verifying its HTTP
+// Signatures.
+func toPublicKey(keyID, actorID *url.URL, pubKey string) vocab.W3IDSecurityV1PublicKey {
 	publicKeyType := streams.NewW3IDSecurityV1PublicKey()
 
 	// publicKey id
 	pubKeyIdProp := streams.NewJSONLDIdProperty()
-	pubKeyIRI := paths.UUIDIRIFor(scheme, host, paths.HttpSigPubKeyKey, uuid)
-	pubKeyIdProp.SetIRI(pubKeyIRI)
+	pubKeyIdProp.SetIRI(keyID)
 	publicKeyType.SetJSONLDId(pubKeyIdProp)
 
 	// publicKey owner
 	ownerProp := streams.NewW3IDSecurityV1OwnerProperty()
-	ownerProp.SetIRI(idIRI)
+	ownerProp.SetIRI(actorID)
 	publicKeyType.SetW3IDSecurityV1Owner(ownerProp)
 
 	// publicKey publicKeyPem
 	publicKeyPemProp := streams.NewW3IDSecurityV1PublicKeyPemProperty()
 	publicKeyPemProp.Set(pubKey)
 	publicKeyType.SetW3IDSecurityV1PublicKeyPem(publicKeyPemProp)
+	return publicKeyType
+}
 
-	publicKeyProp.AppendW3IDSecurityV1PublicKey(publicKeyType)
-	p.SetW3IDSecurityV1PublicKey(publicKeyProp)
-	return p, idIRI
+// setMultikey publishes the actor's Ed25519 public key as a Multikey in its
+// assertionMethod, replacing any already published.
+//
+// The property is unknown to the go-fed vocabulary, so it can only be set as an
+// unknown property.
+func setMultikey(t unknownPropertiesHaver, actorID, keyID *url.URL, multikey string) {
+	t.GetUnknownProperties()["assertionMethod"] = []interface{}{
+		map[string]interface{}{
+			"id":                 keyID.String(),
+			"type":               "Multikey",
+			"controller":         actorID.String(),
+			"publicKeyMultibase": multikey,
+		},
+	}
+}
+
+// unknownPropertiesHaver is an ActivityStreams type that keeps the properties
+// unknown to the go-fed vocabulary.
+type unknownPropertiesHaver interface {
+	GetUnknownProperties() map[string]interface{}
 }
 
 func emptyInbox(actorID *url.URL) (vocab.ActivityStreamsOrderedCollection, error) {
@@ -302,7 +331,7 @@ func emptyCollection(id, first, last *url.URL) vocab.ActivityStreamsCollection {
 
 func toApplicationActor(c paths.Actor, scheme, host string,
 	username, preferredUsername string,
-	pubKey string) (vocab.ActivityStreamsApplication, *url.URL) {
+	pubKey, multikey string) (vocab.ActivityStreamsApplication, *url.URL) {
 	p := streams.NewActivityStreamsApplication()
 	// id
 	idProp := streams.NewJSONLDIdProperty()
@@ -357,27 +386,12 @@ func toApplicationActor(c paths.Actor, scheme, host string,
 
 	// publicKey property
 	publicKeyProp := streams.NewW3IDSecurityV1PublicKeyProperty()
-
-	// publicKey type
-	publicKeyType := streams.NewW3IDSecurityV1PublicKey()
-
-	// publicKey id
-	pubKeyIdProp := streams.NewJSONLDIdProperty()
 	pubKeyIRI := paths.ActorIRIFor(scheme, host, paths.HttpSigPubKeyKey, c)
-	pubKeyIdProp.SetIRI(pubKeyIRI)
-	publicKeyType.SetJSONLDId(pubKeyIdProp)
-
-	// publicKey owner
-	ownerProp := streams.NewW3IDSecurityV1OwnerProperty()
-	ownerProp.SetIRI(idIRI)
-	publicKeyType.SetW3IDSecurityV1Owner(ownerProp)
-
-	// publicKey publicKeyPem
-	publicKeyPemProp := streams.NewW3IDSecurityV1PublicKeyPemProperty()
-	publicKeyPemProp.Set(pubKey)
-	publicKeyType.SetW3IDSecurityV1PublicKeyPem(publicKeyPemProp)
-
-	publicKeyProp.AppendW3IDSecurityV1PublicKey(publicKeyType)
+	multikeyIRI := paths.ActorIRIFor(scheme, host, paths.Ed25519PubKeyKey, c)
+	publicKeyProp.AppendW3IDSecurityV1PublicKey(toPublicKey(pubKeyIRI, idIRI, pubKey))
 	p.SetW3IDSecurityV1PublicKey(publicKeyProp)
+
+	// assertionMethod property
+	setMultikey(p, idIRI, multikeyIRI, multikey)
 	return p, idIRI
 }
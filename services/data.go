@@ -220,6 +220,29 @@ func (d *Data) Update(c util.Context, v vocab.Type) (err error) {
 					d.Liked.GetPage,
 					d.Liked.PrependItem)
 			})
+		} else if paths.IsInstanceActorPath(iri) {
+			err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+				as, err := d.Users.InstanceActorUser(c, tx)
+				if err != nil {
+					return err
+				}
+				if err = checkActorOwner(c, paths.UUID(as.ID), iri); err != nil {
+					return err
+				}
+				return d.Users.UpdateActor(c, tx, as.ID, models.ActivityStreams{v})
+			})
+		} else if paths.IsUserPath(iri) {
+			var uid paths.UUID
+			uid, err = paths.UUIDFromUserPath(iri.Path)
+			if err != nil {
+				return
+			}
+			if err = checkActorOwner(c, uid, iri); err != nil {
+				return
+			}
+			err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+				return d.Users.UpdateActor(c, tx, string(uid), models.ActivityStreams{v})
+			})
 		} else {
 			err = doInTx(c, d.DB, func(tx *sql.Tx) error {
 				return d.LocalData.Update(c, tx, iri, models.ActivityStreams{v})
@@ -233,6 +256,18 @@ func (d *Data) Update(c util.Context, v vocab.Type) (err error) {
 	return
 }
 
+// checkActorOwner ensures that an actor is only updated through its own outbox,
+// since go-fed does not check who owns the object of an Update.
+func checkActorOwner(c util.Context, owner paths.UUID, iri *url.URL) error {
+	uid, err := c.UserPathUUID()
+	if err != nil {
+		return err
+	} else if uid != owner {
+		return fmt.Errorf("Update of actor %s not sent by its owner", iri)
+	}
+	return nil
+}
+
 // Delete removes the ActivityStreams payload locally or federated.
 func (d *Data) Delete(c util.Context, iri *url.URL) (err error) {
 	if d.Owns(iri) {
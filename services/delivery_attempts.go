@@ -18,6 +18,8 @@ package services
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/url"
 	"time"
 
@@ -26,13 +28,26 @@ import (
 	"github.com/allinbits/apcore/util"
 )
 
+// ErrInboxGone is returned when attempting to deliver to the inbox of an actor
+// that is gone.
+var ErrInboxGone = errors.New("inbox is gone")
+
 type DeliveryAttempts struct {
 	DB               *sql.DB
 	DeliveryAttempts *models.DeliveryAttempts
+	GoneActors       *models.GoneActors
 }
 
+// InsertAttempt queues a delivery, unless the inbox belongs to an actor that
+// is gone in which case ErrInboxGone is returned.
 func (d *DeliveryAttempts) InsertAttempt(c util.Context, from paths.UUID, toActor *url.URL, payload []byte) (id string, err error) {
 	return id, doInTx(c, d.DB, func(tx *sql.Tx) error {
+		gone, err := d.GoneActors.InboxExists(c, tx, toActor, time.Now())
+		if err != nil {
+			return err
+		} else if gone {
+			return ErrInboxGone
+		}
 		id, err = d.DeliveryAttempts.Create(c, tx, string(from), toActor, payload)
 		return err
 	})
@@ -44,9 +59,9 @@ func (d *DeliveryAttempts) MarkSuccessfulAttempt(c util.Context, id string) (err
 	})
 }
 
-func (d *DeliveryAttempts) MarkRetryFailureAttempt(c util.Context, id string) (err error) {
+func (d *DeliveryAttempts) MarkRetryFailureAttempt(c util.Context, id string, next time.Time) (err error) {
 	return doInTx(c, d.DB, func(tx *sql.Tx) error {
-		return d.DeliveryAttempts.MarkFailed(c, tx, id)
+		return d.DeliveryAttempts.MarkFailed(c, tx, id, next)
 	})
 }
 
@@ -56,59 +71,248 @@ func (d *DeliveryAttempts) MarkAbandonedAttempt(c util.Context, id string) (err
 	})
 }
 
-type RetryableFailure struct {
+// MarkGoneAttempt abandons the delivery to the personal inbox of an actor that
+// is gone, along with all other queued deliveries to the same inbox, and
+// records the actor as gone so no more deliveries are queued for its inbox
+// until the expiry.
+func (d *DeliveryAttempts) MarkGoneAttempt(c util.Context, id string, actor, inbox *url.URL, expires time.Time) (err error) {
+	return doInTx(c, d.DB, func(tx *sql.Tx) error {
+		if err := d.DeliveryAttempts.MarkAbandoned(c, tx, id); err != nil {
+			return err
+		}
+		if _, err := d.DeliveryAttempts.AbandonTo(c, tx, inbox); err != nil {
+			return err
+		}
+		return d.GoneActors.Upsert(c, tx, actor, inbox, expires)
+	})
+}
+
+// GoneActor is a federated actor whose inbox no longer receives deliveries.
+type GoneActor struct {
+	ActorIRI *url.URL
+	Inbox    *url.URL
+	GoneAt   time.Time
+	// Expires is when deliveries to the inbox are queued again.
+	Expires time.Time
+}
+
+// ListGoneActors lists the actors recorded as gone, most recently gone first,
+// including those that have expired.
+func (d *DeliveryAttempts) ListGoneActors(c util.Context) (ga []GoneActor, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		ma, err := d.GoneActors.GetAll(c, tx)
+		if err != nil {
+			return err
+		}
+		for _, a := range ma {
+			ga = append(ga, GoneActor{
+				ActorIRI: a.ActorIRI.URL,
+				Inbox:    a.Inbox.URL,
+				GoneAt:   a.GoneAt,
+				Expires:  a.Expires,
+			})
+		}
+		return nil
+	})
+	return
+}
+
+// ClearGoneActor forgets that the actor is gone, so that deliveries to its
+// inbox are queued again, returning whether it was recorded as gone.
+func (d *DeliveryAttempts) ClearGoneActor(c util.Context, actor *url.URL) (cleared bool, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		cleared, err = d.GoneActors.Delete(c, tx, actor)
+		return err
+	})
+	return
+}
+
+// ClearGoneActors forgets every actor that is gone, returning how many there
+// were.
+func (d *DeliveryAttempts) ClearGoneActors(c util.Context) (n int64, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		n, err = d.GoneActors.DeleteAll(c, tx)
+		return err
+	})
+	return
+}
+
+// DueDelivery is a queued delivery that is due to be attempted.
+type DueDelivery struct {
 	ID          string
 	UserID      string
-	FetchTime   time.Time
 	DeliverTo   *url.URL
 	Payload     []byte
 	NAttempts   int
 	LastAttempt time.Time
+	// NextAttempt is when the delivery was due before it was claimed.
+	NextAttempt time.Time
 }
 
-func (d *DeliveryAttempts) FirstPageRetryableFailures(c util.Context, n int) (rf []RetryableFailure, err error) {
-	now := time.Now()
+// DueDeliveries obtains up to n queued deliveries that are due at the given
+// time, the longest overdue first.
+//
+// The deliveries are claimed until the given time, so that other processes
+// sharing the database do not obtain them too. A delivery that is neither
+// attempted nor rescheduled by then becomes due again.
+func (d *DeliveryAttempts) DueDeliveries(c util.Context, now, until time.Time, n int) (dd []DueDelivery, err error) {
 	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
-		f, err := d.DeliveryAttempts.FirstPageFailures(c, tx, now, n)
+		da, err := d.DeliveryAttempts.GetDue(c, tx, now, n)
 		if err != nil {
 			return err
 		}
-		for _, a := range f {
-			r := RetryableFailure{
+		for _, a := range da {
+			if ok, err := d.DeliveryAttempts.Claim(c, tx, a.ID, now, until); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			dd = append(dd, DueDelivery{
 				ID:          a.ID,
 				UserID:      a.UserID,
-				FetchTime:   now,
 				DeliverTo:   a.DeliverTo.URL,
 				Payload:     a.Payload,
 				NAttempts:   a.NAttempts,
 				LastAttempt: a.LastAttempt,
+				NextAttempt: a.NextAttempt,
+			})
+		}
+		return nil
+	})
+	return
+}
+
+// ReleaseDeliveries ends the claims on due deliveries that were not attempted,
+// so that they are due again when they were before being claimed.
+func (d *DeliveryAttempts) ReleaseDeliveries(c util.Context, dd []DueDelivery) error {
+	return doInTx(c, d.DB, func(tx *sql.Tx) error {
+		for _, dl := range dd {
+			if err := d.DeliveryAttempts.Release(c, tx, dl.ID, dl.NextAttempt); err != nil {
+				return err
 			}
-			rf = append(rf, r)
 		}
 		return nil
 	})
+}
+
+// PostponeDeliveriesTo delays all queued deliveries to IRIs beginning with the
+// prefix until at least the given time.
+func (d *DeliveryAttempts) PostponeDeliveriesTo(c util.Context, prefix string, until time.Time) (n int64, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		n, err = d.DeliveryAttempts.PostponeToPrefix(c, tx, prefix, until)
+		return err
+	})
 	return
 }
 
-func (d *DeliveryAttempts) NextPageRetryableFailures(c util.Context, prevID string, fetch time.Time, n int) (rf []RetryableFailure, err error) {
+// DeliveryFilter selects deliveries for inspection and maintenance. The zero
+// value of a field does not filter on it.
+type DeliveryFilter struct {
+	// State is one of "new", "failed", "success", or "abandoned".
+	State string
+	// Host is the host of the IRI being delivered to.
+	Host   string
+	UserID paths.UUID
+	// OlderThan is the minimum age of the deliveries.
+	OlderThan time.Duration
+}
+
+func (f DeliveryFilter) toModel() (mf models.DeliveryAttemptFilter, err error) {
+	switch f.State {
+	case "", models.NewDeliveryAttempt, models.FailedDeliveryAttempt, models.SuccessDeliveryAttempt, models.AbandonedDeliveryAttempt:
+	default:
+		err = fmt.Errorf("unknown delivery state: %q", f.State)
+		return
+	}
+	if f.OlderThan < 0 {
+		err = fmt.Errorf("negative delivery age: %s", f.OlderThan)
+		return
+	}
+	mf = models.DeliveryAttemptFilter{
+		State:         f.State,
+		Host:          f.Host,
+		FromID:        string(f.UserID),
+		CreatedBefore: time.Now().Add(-f.OlderThan),
+	}
+	return
+}
+
+// Delivery is a delivery that is queued or finished.
+type Delivery struct {
+	ID          string
+	UserID      string
+	DeliverTo   *url.URL
+	State       string
+	NAttempts   int
+	CreateTime  time.Time
+	LastAttempt time.Time
+	NextAttempt time.Time
+}
+
+// ListDeliveries obtains up to n deliveries that match the filter, the most
+// recently created first.
+func (d *DeliveryAttempts) ListDeliveries(c util.Context, f DeliveryFilter, n int) (ds []Delivery, err error) {
+	var mf models.DeliveryAttemptFilter
+	if mf, err = f.toModel(); err != nil {
+		return
+	}
 	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
-		f, err := d.DeliveryAttempts.NextPageFailures(c, tx, prevID, fetch, n)
+		da, err := d.DeliveryAttempts.Get(c, tx, mf, n)
 		if err != nil {
 			return err
 		}
-		for _, a := range f {
-			r := RetryableFailure{
+		for _, a := range da {
+			ds = append(ds, Delivery{
 				ID:          a.ID,
 				UserID:      a.UserID,
-				FetchTime:   fetch,
 				DeliverTo:   a.DeliverTo.URL,
-				Payload:     a.Payload,
+				State:       a.State,
 				NAttempts:   a.NAttempts,
+				CreateTime:  a.CreateTime,
 				LastAttempt: a.LastAttempt,
-			}
-			rf = append(rf, r)
+				NextAttempt: a.NextAttempt,
+			})
 		}
 		return nil
 	})
 	return
 }
+
+// RequeueDeliveries queues the abandoned or failed deliveries that match the
+// filter to be delivered again immediately, with their attempts reset. Only
+// abandoned deliveries are requeued if the filter has no state.
+func (d *DeliveryAttempts) RequeueDeliveries(c util.Context, f DeliveryFilter) (n int64, err error) {
+	if len(f.State) == 0 {
+		f.State = models.AbandonedDeliveryAttempt
+	} else if f.State != models.AbandonedDeliveryAttempt && f.State != models.FailedDeliveryAttempt {
+		err = fmt.Errorf("only abandoned or failed deliveries can be requeued, not %q", f.State)
+		return
+	}
+	var mf models.DeliveryAttemptFilter
+	if mf, err = f.toModel(); err != nil {
+		return
+	}
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		n, err = d.DeliveryAttempts.Requeue(c, tx, mf)
+		return err
+	})
+	return
+}
+
+// PurgeDeliveries deletes the deliveries that match the filter, which must
+// have a state so that queued deliveries are not deleted by accident.
+func (d *DeliveryAttempts) PurgeDeliveries(c util.Context, f DeliveryFilter) (n int64, err error) {
+	if len(f.State) == 0 {
+		err = fmt.Errorf("the state of the deliveries to purge is required")
+		return
+	}
+	var mf models.DeliveryAttemptFilter
+	if mf, err = f.toModel(); err != nil {
+		return
+	}
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		n, err = d.DeliveryAttempts.Delete(c, tx, mf)
+		return err
+	})
+	return
+}
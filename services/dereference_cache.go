@@ -0,0 +1,68 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+)
+
+type DereferenceCache struct {
+	DB               *sql.DB
+	DereferenceCache *models.DereferenceCache
+}
+
+// Get returns the cached object with the IRI, whether or not it is fresh.
+func (d *DereferenceCache) Get(c util.Context, iri *url.URL) (o models.CachedObject, found bool, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		o, found, err = d.DereferenceCache.Get(c, tx, iri)
+		return err
+	})
+	return
+}
+
+func (d *DereferenceCache) Put(c util.Context, iri *url.URL, o models.CachedObject) error {
+	return doInTx(c, d.DB, func(tx *sql.Tx) error {
+		return d.DereferenceCache.Put(c, tx, iri, o)
+	})
+}
+
+func (d *DereferenceCache) Revalidate(c util.Context, iri *url.URL, expires time.Time) error {
+	return doInTx(c, d.DB, func(tx *sql.Tx) error {
+		return d.DereferenceCache.Revalidate(c, tx, iri, expires)
+	})
+}
+
+func (d *DereferenceCache) Delete(c util.Context, iri *url.URL) error {
+	return doInTx(c, d.DB, func(tx *sql.Tx) error {
+		return d.DereferenceCache.Delete(c, tx, iri)
+	})
+}
+
+// Prune removes the cached objects that needed revalidating before the given
+// time.
+func (d *DereferenceCache) Prune(c util.Context, before time.Time) (n int64, err error) {
+	err = doInTx(c, d.DB, func(tx *sql.Tx) error {
+		n, err = d.DereferenceCache.Prune(c, tx, before)
+		return err
+	})
+	return
+}
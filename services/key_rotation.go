@@ -0,0 +1,174 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/paths"
+	"github.com/allinbits/apcore/util"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+)
+
+// publicKeyHaver is an actor that publishes the public keys for verifying its
+// HTTP Signatures.
+type publicKeyHaver interface {
+	GetW3IDSecurityV1PublicKey() vocab.W3IDSecurityV1PublicKeyProperty
+	SetW3IDSecurityV1PublicKey(i vocab.W3IDSecurityV1PublicKeyProperty)
+}
+
+// RotateHTTPSignatureKey replaces the user's key for signing HTTP Signatures
+// with a newly generated one. The old key stays published on the user's actor
+// until retireAt, so that signatures made with it can still be verified.
+//
+// The updated actor is returned so that it can be federated.
+func (u *Users) RotateHTTPSignatureKey(c util.Context, userID paths.UUID, rsaKeySize int, now, retireAt time.Time) (actor vocab.Type, err error) {
+	_, actor, err = u.rotateHTTPSignatureKey(c, rsaKeySize, now, retireAt, func(tx *sql.Tx) (*models.User, error) {
+		return u.Users.UserByID(c, tx, string(userID))
+	})
+	return
+}
+
+// RotateInstanceActorHTTPSignatureKey is like RotateHTTPSignatureKey, for the
+// instance actor. The instance actor's user ID is also returned, so that the
+// updated actor can be federated on its behalf.
+func (u *Users) RotateInstanceActorHTTPSignatureKey(c util.Context, rsaKeySize int, now, retireAt time.Time) (userID paths.UUID, actor vocab.Type, err error) {
+	return u.rotateHTTPSignatureKey(c, rsaKeySize, now, retireAt, func(tx *sql.Tx) (*models.User, error) {
+		return u.Users.InstanceActorUser(c, tx)
+	})
+}
+
+func (u *Users) rotateHTTPSignatureKey(c util.Context, rsaKeySize int, now, retireAt time.Time, userFn func(tx *sql.Tx) (*models.User, error)) (userID paths.UUID, actor vocab.Type, err error) {
+	var privKey []byte
+	var pubKey string
+	privKey, pubKey, err = createAndSerializeRSAKeys(rsaKeySize)
+	if err != nil {
+		return
+	}
+	err = doInTx(c, u.DB, func(tx *sql.Tx) error {
+		user, err := userFn(tx)
+		if err != nil {
+			return err
+		} else if user == nil {
+			return errors.New("no user to rotate the keys of")
+		}
+		pkh, ok := user.Actor.Type.(publicKeyHaver)
+		if !ok {
+			return fmt.Errorf("actor of type %T cannot publish a publicKey", user.Actor.Type)
+		}
+		actorID, err := pub.GetId(user.Actor.Type)
+		if err != nil {
+			return err
+		}
+		// The new key needs a new id, so that the old one can still be
+		// found by its own id.
+		keyID, err := paths.IRIForActorID(paths.HttpSigPubKeyKey, actorID)
+		if err != nil {
+			return err
+		}
+		oldKeyID := &url.URL{}
+		*oldKeyID = *keyID
+		_, storedKeyID, err := u.PrivateKeys.GetByUserID(c, tx, user.ID, pKeyHttpSigPurpose)
+		if err != nil {
+			return err
+		} else if storedKeyID.Valid {
+			if oldKeyID, err = url.Parse(storedKeyID.String); err != nil {
+				return err
+			}
+		}
+		keyID.Fragment = fmt.Sprintf("%s-%d", keyID.Fragment, now.Unix())
+		if keyID.String() == oldKeyID.String() {
+			return errors.New("keys were already rotated at this time")
+		}
+		if err = u.PrivateKeys.Rotate(c, tx, user.ID, pKeyHttpSigPurpose, privKey, keyID); err != nil {
+			return err
+		} else if err = u.RetiredKeys.Create(c, tx, user.ID, oldKeyID, retireAt); err != nil {
+			return err
+		}
+		// Publish the new key first, since many peers only use the
+		// first publicKey of an actor.
+		publicKeyProp := streams.NewW3IDSecurityV1PublicKeyProperty()
+		publicKeyProp.AppendW3IDSecurityV1PublicKey(toPublicKey(keyID, actorID, pubKey))
+		if old := pkh.GetW3IDSecurityV1PublicKey(); old != nil {
+			for iter := old.Begin(); iter != old.End(); iter = iter.Next() {
+				if iter.IsW3IDSecurityV1PublicKey() {
+					publicKeyProp.AppendW3IDSecurityV1PublicKey(iter.Get())
+				} else if iter.IsIRI() {
+					publicKeyProp.AppendIRI(iter.GetIRI())
+				}
+			}
+		}
+		pkh.SetW3IDSecurityV1PublicKey(publicKeyProp)
+		userID = paths.UUID(user.ID)
+		actor = user.Actor.Type
+		return u.Users.UpdateActor(c, tx, user.ID, user.Actor)
+	})
+	return
+}
+
+// PruneRetiredKeys stops publishing the keys replaced by key rotation that
+// expired before the given time, returning how many were removed.
+func (u *Users) PruneRetiredKeys(c util.Context, before time.Time) (n int, err error) {
+	return n, doInTx(c, u.DB, func(tx *sql.Tx) error {
+		rks, err := u.RetiredKeys.GetExpired(c, tx, before)
+		if err != nil {
+			return err
+		}
+		for _, rk := range rks {
+			user, err := u.Users.UserByID(c, tx, rk.UserID)
+			if err != nil {
+				return err
+			} else if user != nil && removePublicKey(user.Actor.Type, rk.KeyID.URL) {
+				if err = u.Users.UpdateActor(c, tx, user.ID, user.Actor); err != nil {
+					return err
+				}
+			}
+			if err = u.RetiredKeys.Delete(c, tx, rk.ID); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+// removePublicKey removes the publicKey with the id from an actor, returning
+// whether it was found.
+func removePublicKey(actor vocab.Type, keyID *url.URL) (removed bool) {
+	pkh, ok := actor.(publicKeyHaver)
+	if !ok || pkh.GetW3IDSecurityV1PublicKey() == nil {
+		return
+	}
+	publicKeyProp := pkh.GetW3IDSecurityV1PublicKey()
+	for i := publicKeyProp.Len() - 1; i >= 0; i-- {
+		iter := publicKeyProp.At(i)
+		id, err := pub.ToId(iter)
+		if err != nil || id.String() != keyID.String() {
+			continue
+		}
+		publicKeyProp.Remove(i)
+		removed = true
+	}
+	return
+}
@@ -0,0 +1,344 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/allinbits/apcore/app"
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+)
+
+// CoreMigrationComponent is the component that apcore's own migrations are
+// recorded under. Application migrations are recorded under the name of the
+// application's software.
+const CoreMigrationComponent = "apcore"
+
+// ErrNoMigrationToRevert is returned when attempting to revert a migration
+// but none have been applied.
+var ErrNoMigrationToRevert = errors.New("no applied migrations to revert")
+
+// MigrationStatus is the state of a single migration of a component.
+type MigrationStatus struct {
+	Component   string
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	// Unknown is true when the database records a migration that this
+	// software does not know about, such as when a newer version of the
+	// software has migrated the database.
+	Unknown bool
+}
+
+type migrationStep struct {
+	version     int
+	description string
+	up          func(c util.Context, tx *sql.Tx) error
+	down        func(c util.Context, tx *sql.Tx) error
+}
+
+type migrationComponent struct {
+	name  string
+	steps []migrationStep
+}
+
+type Migrations struct {
+	DB               *sql.DB
+	Dialect          models.SqlDialect
+	App              app.Application
+	APCoreConfig     app.APCoreConfig
+	SchemaMigrations *models.SchemaMigrations
+}
+
+// Status returns the state of every known and recorded migration, ordered by
+// component and then version.
+func (m *Migrations) Status(c util.Context) (s []MigrationStatus, err error) {
+	var comps []migrationComponent
+	comps, err = m.components()
+	if err != nil {
+		return
+	}
+	err = doInTx(c, m.DB, func(tx *sql.Tx) error {
+		for _, comp := range comps {
+			applied, err := m.applied(c, tx, comp.name)
+			if err != nil {
+				return err
+			}
+			known := make(map[int]bool, len(comp.steps))
+			for _, step := range comp.steps {
+				known[step.version] = true
+				ms := MigrationStatus{
+					Component:   comp.name,
+					Version:     step.version,
+					Description: step.description,
+				}
+				if a, ok := applied[step.version]; ok {
+					ms.Applied = true
+					ms.AppliedAt = a.AppliedAt
+				}
+				s = append(s, ms)
+			}
+			for _, a := range sortedMigrations(applied) {
+				if known[a.Version] {
+					continue
+				}
+				s = append(s, MigrationStatus{
+					Component:   comp.name,
+					Version:     a.Version,
+					Description: a.Description,
+					Applied:     true,
+					AppliedAt:   a.AppliedAt,
+					Unknown:     true,
+				})
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Migrate applies all pending migrations, apcore's before the application's.
+// Each migration is applied in its own transaction, so a failure leaves the
+// database at the last successfully applied version.
+func (m *Migrations) Migrate(c util.Context) (done []MigrationStatus, err error) {
+	var comps []migrationComponent
+	comps, err = m.components()
+	if err != nil {
+		return
+	}
+	for _, comp := range comps {
+		for _, step := range comp.steps {
+			var ran bool
+			err = doInTx(c, m.DB, func(tx *sql.Tx) error {
+				applied, err := m.applied(c, tx, comp.name)
+				if err != nil {
+					return err
+				}
+				if latest := latestMigration(applied); latest > comp.steps[len(comp.steps)-1].version {
+					return fmt.Errorf("%s database schema version %d is newer than the latest known version %d", comp.name, latest, comp.steps[len(comp.steps)-1].version)
+				}
+				if _, ok := applied[step.version]; ok {
+					return nil
+				}
+				util.InfoLogger.Infof("Applying %s migration %d: %s", comp.name, step.version, step.description)
+				if err := step.up(c, tx); err != nil {
+					return fmt.Errorf("applying %s migration %d: %s", comp.name, step.version, err)
+				}
+				ran = true
+				return m.SchemaMigrations.Create(c, tx, comp.name, step.version, step.description)
+			})
+			if err != nil {
+				return
+			}
+			if ran {
+				done = append(done, MigrationStatus{
+					Component:   comp.name,
+					Version:     step.version,
+					Description: step.description,
+					Applied:     true,
+				})
+			}
+		}
+	}
+	return
+}
+
+// MigrateDown reverts the single most recent migration. The application's
+// migrations are reverted before any of apcore's.
+func (m *Migrations) MigrateDown(c util.Context) (reverted MigrationStatus, err error) {
+	var comps []migrationComponent
+	comps, err = m.components()
+	if err != nil {
+		return
+	}
+	err = doInTx(c, m.DB, func(tx *sql.Tx) error {
+		for i := len(comps) - 1; i >= 0; i-- {
+			comp := comps[i]
+			applied, err := m.applied(c, tx, comp.name)
+			if err != nil {
+				return err
+			}
+			latest := latestMigration(applied)
+			if latest == 0 {
+				continue
+			}
+			var step *migrationStep
+			for j := range comp.steps {
+				if comp.steps[j].version == latest {
+					step = &comp.steps[j]
+					break
+				}
+			}
+			if step == nil {
+				return fmt.Errorf("%s migration %d is not known to this software and cannot be reverted", comp.name, latest)
+			}
+			util.InfoLogger.Infof("Reverting %s migration %d: %s", comp.name, step.version, step.description)
+			if err := step.down(c, tx); err != nil {
+				return fmt.Errorf("reverting %s migration %d: %s", comp.name, step.version, err)
+			}
+			reverted = MigrationStatus{
+				Component:   comp.name,
+				Version:     step.version,
+				Description: step.description,
+			}
+			return m.SchemaMigrations.Delete(c, tx, comp.name, step.version)
+		}
+		return ErrNoMigrationToRevert
+	})
+	return
+}
+
+// MarkAllApplied records every known migration as applied without running
+// them. It is used when the tables are freshly created at their latest
+// version.
+func (m *Migrations) MarkAllApplied(c util.Context) error {
+	comps, err := m.components()
+	if err != nil {
+		return err
+	}
+	return doInTx(c, m.DB, func(tx *sql.Tx) error {
+		for _, comp := range comps {
+			applied, err := m.applied(c, tx, comp.name)
+			if err != nil {
+				return err
+			}
+			for _, step := range comp.steps {
+				if _, ok := applied[step.version]; ok {
+					continue
+				}
+				if err := m.SchemaMigrations.Create(c, tx, comp.name, step.version, step.description); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrations) applied(c util.Context, tx *sql.Tx, component string) (map[int]models.SchemaMigration, error) {
+	sm, err := m.SchemaMigrations.GetForComponent(c, tx, component)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]models.SchemaMigration, len(sm))
+	for _, a := range sm {
+		applied[a.Version] = a
+	}
+	return applied, nil
+}
+
+// components gathers and validates the migrations of apcore and, if it
+// supports them, the application.
+func (m *Migrations) components() (comps []migrationComponent, err error) {
+	core := migrationComponent{name: CoreMigrationComponent}
+	for _, mig := range m.Dialect.Migrations() {
+		mig := mig
+		core.steps = append(core.steps, migrationStep{
+			version:     mig.Version,
+			description: mig.Description,
+			up: func(c util.Context, tx *sql.Tx) error {
+				return execAll(c, tx, mig.Up)
+			},
+			down: func(c util.Context, tx *sql.Tx) error {
+				return execAll(c, tx, mig.Down)
+			},
+		})
+	}
+	comps = append(comps, core)
+	if ma, ok := m.App.(app.MigratingApplication); ok {
+		ac := migrationComponent{name: m.App.Software().Name}
+		if ac.name == CoreMigrationComponent {
+			err = fmt.Errorf("application software name %q is reserved for migrations", ac.name)
+			return
+		}
+		for _, mig := range ma.Migrations(m.APCoreConfig) {
+			mig := mig
+			ac.steps = append(ac.steps, migrationStep{
+				version:     mig.Version,
+				description: mig.Description,
+				up: func(c util.Context, tx *sql.Tx) error {
+					return doTxBuilderFn(c, tx, mig.Up)
+				},
+				down: func(c util.Context, tx *sql.Tx) error {
+					return doTxBuilderFn(c, tx, mig.Down)
+				},
+			})
+		}
+		comps = append(comps, ac)
+	}
+	for _, comp := range comps {
+		prev := 0
+		for _, step := range comp.steps {
+			if step.version <= prev {
+				err = fmt.Errorf("%s migration versions must be positive and strictly increasing: %d follows %d", comp.name, step.version, prev)
+				return
+			}
+			prev = step.version
+		}
+	}
+	return
+}
+
+func execAll(c util.Context, tx *sql.Tx, stmts []string) error {
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(c, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doTxBuilderFn runs the operations queued by fn within an existing
+// transaction.
+func doTxBuilderFn(c util.Context, tx *sql.Tx, fn func(app.TxBuilder)) error {
+	if fn == nil {
+		return errors.New("migration step is not implemented")
+	}
+	tb := &txBuilder{}
+	fn(tb)
+	for _, op := range tb.ops {
+		if err := op.Do(c, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func latestMigration(applied map[int]models.SchemaMigration) (v int) {
+	for k := range applied {
+		if k > v {
+			v = k
+		}
+	}
+	return
+}
+
+func sortedMigrations(applied map[int]models.SchemaMigration) (sm []models.SchemaMigration) {
+	for _, a := range applied {
+		sm = append(sm, a)
+	}
+	sort.Slice(sm, func(i, j int) bool {
+		return sm[i].Version < sm[j].Version
+	})
+	return
+}
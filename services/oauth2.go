@@ -19,6 +19,7 @@ package services
 import (
 	"context"
 	"database/sql"
+	"errors"
 
 	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/util"
@@ -28,12 +29,21 @@ import (
 var _ oauth2.ClientStore = &OAuth2{}
 var _ oauth2.TokenStore = &OAuth2{}
 
+// ErrClientNotFound is returned when a registered OAuth2 client does not
+// exist.
+var ErrClientNotFound = errors.New("oauth2 client not found")
+
+// ErrTokenNotFound is returned when a user's OAuth2 token does not exist.
+var ErrTokenNotFound = errors.New("oauth2 token not found")
+
 // OAuth2 implements services for the oauth2 server package.
 type OAuth2 struct {
-	DB     *sql.DB
-	Client *models.ClientInfos
-	Token  *models.TokenInfos
-	Creds  *models.Credentials
+	DB            *sql.DB
+	Client        *models.ClientInfos
+	Token         *models.TokenInfos
+	Creds         *models.Credentials
+	Registrations *models.ClientRegistrations
+	Users         *models.Users
 }
 
 func (o *OAuth2) GetByID(ctx context.Context, id string) (ci oauth2.ClientInfo, err error) {
@@ -150,3 +160,95 @@ func (o *OAuth2) DeleteExpiredFirstPartyCredentials(ctx context.Context) error {
 		return o.Creds.DeleteExpired(c, tx)
 	})
 }
+
+// RegisterClient creates a third party client with its registered metadata.
+// As it does not act on behalf of any one user, the client is owned by the
+// instance actor.
+func (o *OAuth2) RegisterClient(ctx context.Context, id, secret string, md models.ClientMetadata) error {
+	c := util.Context{ctx}
+	return doInTx(c, o.DB, func(tx *sql.Tx) error {
+		ia, err := o.Users.InstanceActorUser(c, tx)
+		if err != nil {
+			return err
+		}
+		ci := &models.ClientInfo{
+			ID:     id,
+			Secret: sql.NullString{String: secret, Valid: len(secret) > 0},
+			Domain: md.RedirectURIs[0],
+			UserID: ia.ID,
+		}
+		if _, err = o.Client.Create(c, tx, ci); err != nil {
+			return err
+		}
+		return o.Registrations.Create(c, tx, id, md)
+	})
+}
+
+// GetClientRegistration obtains the registration of a client, returning
+// ErrClientNotFound if it was not registered.
+func (o *OAuth2) GetClientRegistration(ctx context.Context, id string) (cr models.ClientRegistration, err error) {
+	c := util.Context{ctx}
+	var found bool
+	err = doInTx(c, o.DB, func(tx *sql.Tx) error {
+		cr, found, err = o.Registrations.GetByClientID(c, tx, id)
+		return err
+	})
+	if err == nil && !found {
+		err = ErrClientNotFound
+	}
+	return
+}
+
+// ClientRegistrations lists the registered clients, newest first.
+func (o *OAuth2) ClientRegistrations(ctx context.Context) (cr []models.ClientRegistration, err error) {
+	c := util.Context{ctx}
+	return cr, doInTx(c, o.DB, func(tx *sql.Tx) error {
+		cr, err = o.Registrations.GetAll(c, tx)
+		return err
+	})
+}
+
+// RevokeClient deletes a registered client along with the tokens issued to
+// it, returning ErrClientNotFound if it was not registered. First party
+// clients cannot be revoked.
+func (o *OAuth2) RevokeClient(ctx context.Context, id string) error {
+	c := util.Context{ctx}
+	return doInTx(c, o.DB, func(tx *sql.Tx) error {
+		_, found, err := o.Registrations.GetByClientID(c, tx, id)
+		if err != nil {
+			return err
+		} else if !found {
+			return ErrClientNotFound
+		}
+		return o.Client.Delete(c, tx, id)
+	})
+}
+
+// UserTokens lists the access tokens issued to a user, including those backing
+// first party credentials.
+func (o *OAuth2) UserTokens(ctx context.Context, userID string) (ut []models.UserToken, err error) {
+	c := util.Context{ctx}
+	return ut, doInTx(c, o.DB, func(tx *sql.Tx) error {
+		ut, err = o.Token.GetForUser(c, tx, userID)
+		return err
+	})
+}
+
+// RevokeUserToken deletes an access token issued to a user, along with any
+// first party credential it backs, returning ErrTokenNotFound if the user has
+// no such token.
+func (o *OAuth2) RevokeUserToken(ctx context.Context, userID, id string) error {
+	c := util.Context{ctx}
+	return doInTx(c, o.DB, func(tx *sql.Tx) error {
+		ut, err := o.Token.GetForUser(c, tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, t := range ut {
+			if t.ID == id {
+				return o.Token.RemoveForUser(c, tx, id, userID)
+			}
+		}
+		return ErrTokenNotFound
+	})
+}
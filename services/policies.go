@@ -18,54 +18,438 @@ package services
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/allinbits/apcore/models"
 	"github.com/allinbits/apcore/util"
 	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
 )
 
+// ErrPolicyNotFound is returned when a policy does not exist.
+var ErrPolicyNotFound = errors.New("policy not found")
+
+// ErrInvalidPolicy wraps the errors returned when a policy, its purpose, or
+// its domain is rejected before anything is written.
+var ErrInvalidPolicy = errors.New("invalid policy")
+
 type Policies struct {
 	Clock       pub.Clock
 	DB          *sql.DB
 	Policies    *models.Policies
 	Resolutions *models.Resolutions
+	FedData     *models.FedData
+}
+
+// PolicyOutcome is the combined effect of the policies that matched a
+// federated activity.
+type PolicyOutcome struct {
+	Blocked        bool
+	Quarantine     bool
+	StripMedia     bool
+	DropMentions   bool
+	ForceSensitive bool
+}
+
+// Rewrites determines whether the activity must be modified before it is
+// processed.
+func (o PolicyOutcome) Rewrites() bool {
+	return o.StripMedia || o.DropMentions || o.ForceSensitive
 }
 
-func (p *Policies) IsBlocked(c util.Context, actorID *url.URL, a pub.Activity) (blocked bool, err error) {
+func (o *PolicyOutcome) add(u models.Purpose) {
+	switch u {
+	case models.FederatedBlockPurpose:
+		o.Blocked = true
+	case models.QuarantinePurpose:
+		o.Quarantine = true
+	case models.StripMediaPurpose:
+		o.StripMedia = true
+	case models.DropMentionsPurpose:
+		o.DropMentions = true
+	case models.ForceSensitivePurpose:
+		o.ForceSensitive = true
+	default:
+		util.ErrorLogger.Errorf("Ignoring matched policy with unknown purpose: %q", u)
+	}
+}
+
+// Evaluate resolves the instance's policies, the domain policies of the
+// activity's senders, and then the actor's own policies against a federated
+// activity, recording each resolution, and returns their combined outcome.
+// The status of the activity's proof is available to the policies as its
+// models.ProofStatusProperty.
+//
+// Once a scope of policies has blocked the activity, the narrower scopes are
+// not evaluated.
+func (p *Policies) Evaluate(c util.Context, actorID *url.URL, senders []*url.URL, a pub.Activity, proof models.ProofStatus) (o PolicyOutcome, err error) {
 	var iri *url.URL
 	iri, err = pub.GetId(a)
 	if err != nil {
 		return
 	}
 	var jsonb []byte
-	jsonb, err = models.Marshal(a)
+	jsonb, err = withProofStatus(a, proof)
+	if err != nil {
+		return
+	}
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		scopes := []func() ([]models.PolicyAndPurpose, error){
+			func() ([]models.PolicyAndPurpose, error) {
+				return p.Policies.GetInstance(c, tx)
+			},
+			func() ([]models.PolicyAndPurpose, error) {
+				return p.domainPolicies(c, tx, senders)
+			},
+			func() ([]models.PolicyAndPurpose, error) {
+				return p.Policies.GetForActor(c, tx, actorID)
+			},
+		}
+		for _, scope := range scopes {
+			pd, err := scope()
+			if err != nil {
+				return err
+			}
+			for _, policy := range pd {
+				var res models.Resolution
+				res.Time = p.Clock.Now()
+				err = policy.Policy.Resolve(jsonb, &res)
+				if err != nil {
+					return err
+				}
+				err = p.Resolutions.Create(c, tx, models.CreateResolution{
+					PolicyID: policy.ID,
+					IRI:      iri,
+					R:        res,
+				})
+				if err != nil {
+					return err
+				}
+				if res.Matched {
+					o.add(policy.Purpose)
+				}
+			}
+			if o.Blocked {
+				return nil
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// BlocksFetch resolves the instance's federated_block policies and those of
+// the actor's domain against a fetch signed by the actor, and determines
+// whether any matched. Policies are resolved against an object whose only
+// property is the "actor".
+//
+// Fetches are not federated data, so the resolutions are not recorded.
+func (p *Policies) BlocksFetch(c util.Context, actorID *url.URL) (blocked bool, err error) {
+	var jsonb []byte
+	jsonb, err = json.Marshal(map[string]string{"actor": actorID.String()})
 	if err != nil {
 		return
 	}
 	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
-		pd, err := p.Policies.GetForActorAndPurpose(c, tx, actorID, models.FederatedBlockPurpose)
+		pd, err := p.Policies.GetInstance(c, tx)
 		if err != nil {
 			return err
 		}
-		for _, policy := range pd {
+		dpd, err := p.domainPolicies(c, tx, []*url.URL{actorID})
+		if err != nil {
+			return err
+		}
+		for _, policy := range append(pd, dpd...) {
+			if policy.Purpose != models.FederatedBlockPurpose {
+				continue
+			}
 			var res models.Resolution
 			res.Time = p.Clock.Now()
-			err = policy.Policy.Resolve(jsonb, &res)
-			if err != nil {
+			if err = policy.Policy.Resolve(jsonb, &res); err != nil {
 				return err
+			} else if res.Matched {
+				blocked = true
+				return nil
 			}
-			err = p.Resolutions.Create(c, tx, models.CreateResolution{
-				PolicyID: policy.ID,
-				IRI:      iri,
-				R:        res,
-			})
-			if err != nil {
-				return err
-			}
-			blocked = blocked || res.Matched
 		}
 		return nil
 	})
 	return
 }
+
+// withProofStatus serializes the activity for resolving policies against it,
+// setting its models.ProofStatusProperty.
+func withProofStatus(a pub.Activity, proof models.ProofStatus) ([]byte, error) {
+	m, err := streams.Serialize(a)
+	if err != nil {
+		return nil, err
+	}
+	m[models.ProofStatusProperty] = string(proof)
+	return json.Marshal(m)
+}
+
+// domainPolicies obtains the distinct domain policies that apply to any of
+// the senders' hosts.
+func (p *Policies) domainPolicies(c util.Context, tx *sql.Tx, senders []*url.URL) (po []models.PolicyAndPurpose, err error) {
+	seenHost := make(map[string]bool, len(senders))
+	seenID := make(map[string]bool)
+	for _, sender := range senders {
+		host := strings.ToLower(sender.Hostname())
+		if len(host) == 0 || seenHost[host] {
+			continue
+		}
+		seenHost[host] = true
+		var pd []models.PolicyAndPurpose
+		pd, err = p.Policies.GetForDomain(c, tx, host)
+		if err != nil {
+			return
+		}
+		for _, policy := range pd {
+			if seenID[policy.ID] {
+				continue
+			}
+			seenID[policy.ID] = true
+			po = append(po, policy)
+		}
+	}
+	return
+}
+
+// CreateInstancePolicy creates a policy applied to every federated activity
+// received by the instance.
+func (p *Policies) CreateInstancePolicy(c util.Context, purpose models.Purpose, policy models.Policy) (policyID string, err error) {
+	return p.createScoped(c, models.CreateScopedPolicy{
+		Scope:   models.InstancePolicyScope,
+		Purpose: purpose,
+		Policy:  policy,
+	})
+}
+
+// CreateDomainPolicy creates a policy applied to every federated activity
+// sent by actors on the domain or any of its subdomains.
+func (p *Policies) CreateDomainPolicy(c util.Context, domain string, purpose models.Purpose, policy models.Policy) (policyID string, err error) {
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+		return
+	}
+	return p.createScoped(c, models.CreateScopedPolicy{
+		Scope:   models.DomainPolicyScope,
+		Domain:  domain,
+		Purpose: purpose,
+		Policy:  policy,
+	})
+}
+
+func (p *Policies) createScoped(c util.Context, cp models.CreateScopedPolicy) (policyID string, err error) {
+	if err = validatePolicy(cp.Purpose, cp.Policy); err != nil {
+		return
+	}
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		policyID, err = p.Policies.CreateScoped(c, tx, cp)
+		return err
+	})
+	return
+}
+
+// CreateActorPolicy creates a policy applied to the federated activities
+// received by a single actor.
+func (p *Policies) CreateActorPolicy(c util.Context, actorID *url.URL, purpose models.Purpose, policy models.Policy) (policyID string, err error) {
+	if err = validatePolicy(purpose, policy); err != nil {
+		return
+	}
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		policyID, err = p.Policies.Create(c, tx, models.CreatePolicy{
+			ActorID: actorID,
+			Purpose: purpose,
+			Policy:  policy,
+		})
+		return err
+	})
+	return
+}
+
+// GetActorPolicies obtains the policies of a single actor.
+func (p *Policies) GetActorPolicies(c util.Context, actorID *url.URL) (po []models.PolicyAndPurpose, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		po, err = p.Policies.GetForActor(c, tx, actorID)
+		return err
+	})
+	return
+}
+
+// GetPolicy obtains a policy of any scope, returning ErrPolicyNotFound if it
+// does not exist. An id that is not a UUID cannot name a policy, so it is not
+// looked up, which some databases would refuse.
+func (p *Policies) GetPolicy(c util.Context, policyID string) (sp models.ScopedPolicy, err error) {
+	if uuid.Validate(policyID) != nil {
+		err = ErrPolicyNotFound
+		return
+	}
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		sp, err = p.Policies.Get(c, tx, policyID)
+		return err
+	})
+	if err == nil && len(sp.ID) == 0 {
+		err = ErrPolicyNotFound
+	}
+	return
+}
+
+// UpdatePolicy replaces the purpose and policy of a policy of any scope.
+func (p *Policies) UpdatePolicy(c util.Context, policyID string, purpose models.Purpose, policy models.Policy) error {
+	if err := validatePolicy(purpose, policy); err != nil {
+		return err
+	}
+	return doInTx(c, p.DB, func(tx *sql.Tx) error {
+		return p.Policies.Update(c, tx, policyID, purpose, policy)
+	})
+}
+
+// DeletePolicy deletes a policy of any scope, along with its resolutions.
+func (p *Policies) DeletePolicy(c util.Context, policyID string) error {
+	return doInTx(c, p.DB, func(tx *sql.Tx) error {
+		return p.Policies.Delete(c, tx, policyID)
+	})
+}
+
+// GetScopedPolicies obtains all instance and domain policies.
+func (p *Policies) GetScopedPolicies(c util.Context) (po []models.ScopedPolicy, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		po, err = p.Policies.GetScoped(c, tx)
+		return err
+	})
+	return
+}
+
+// DeleteScopedPolicy deletes an instance or domain policy, along with its
+// resolutions.
+func (p *Policies) DeleteScopedPolicy(c util.Context, policyID string) error {
+	return doInTx(c, p.DB, func(tx *sql.Tx) error {
+		return p.Policies.DeleteScoped(c, tx, policyID)
+	})
+}
+
+// ResolutionsForPolicy obtains a page of the recorded resolutions of a
+// policy, newest first.
+func (p *Policies) ResolutionsForPolicy(c util.Context, policyID string, offset, limit int) (sr []models.StoredResolution, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		sr, err = p.Resolutions.GetForPolicy(c, tx, policyID, offset, limit)
+		return err
+	})
+	return
+}
+
+// ResolutionsForDataIRI obtains a page of the recorded resolutions of
+// federated data, newest first.
+func (p *Policies) ResolutionsForDataIRI(c util.Context, dataIRI *url.URL, offset, limit int) (sr []models.StoredResolution, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		sr, err = p.Resolutions.GetForDataIRI(c, tx, dataIRI, offset, limit)
+		return err
+	})
+	return
+}
+
+// ResolutionsInTimeRange obtains a page of the resolutions recorded at or
+// after start and before end, newest first.
+func (p *Policies) ResolutionsInTimeRange(c util.Context, start, end time.Time, offset, limit int) (sr []models.StoredResolution, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		sr, err = p.Resolutions.GetInTimeRange(c, tx, start, end, offset, limit)
+		return err
+	})
+	return
+}
+
+// ResolutionsByMatched obtains a page of the recorded resolutions that did or
+// did not match, newest first.
+func (p *Policies) ResolutionsByMatched(c util.Context, matched bool, offset, limit int) (sr []models.StoredResolution, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		sr, err = p.Resolutions.GetByMatched(c, tx, matched, offset, limit)
+		return err
+	})
+	return
+}
+
+// PruneResolutions deletes the resolutions recorded before the given time.
+func (p *Policies) PruneResolutions(c util.Context, before time.Time) (n int64, err error) {
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		n, err = p.Resolutions.DeleteBefore(c, tx, before)
+		return err
+	})
+	return
+}
+
+// DryRunResult is the resolution of a candidate policy against a single
+// payload.
+type DryRunResult struct {
+	IRI        string
+	Resolution models.Resolution
+	// Err is why the policy could not be resolved against the payload,
+	// in which case the resolution is incomplete.
+	Err error
+}
+
+// DryRun resolves a candidate policy against each JSON payload, without
+// recording any resolutions. A payload that the policy cannot be resolved
+// against is reported in its result rather than ending the dry run.
+func (p *Policies) DryRun(policy models.Policy, payloads [][]byte) (res []DryRunResult, err error) {
+	if err = policy.Validate(); err != nil {
+		return
+	}
+	for _, b := range payloads {
+		dr := DryRunResult{
+			IRI: gjson.GetBytes(b, "id").String(),
+		}
+		dr.Resolution.Time = p.Clock.Now()
+		if !gjson.ValidBytes(b) {
+			dr.Err = errors.New("payload is not valid JSON")
+		} else {
+			dr.Err = policy.Resolve(b, &(dr.Resolution))
+		}
+		res = append(res, dr)
+	}
+	return
+}
+
+// DryRunStored resolves a candidate policy against the most recently received
+// federated data, without recording any resolutions.
+func (p *Policies) DryRunStored(c util.Context, policy models.Policy, limit int) (res []DryRunResult, err error) {
+	var payloads [][]byte
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		payloads, err = p.FedData.GetRecent(c, tx, limit)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	return p.DryRun(policy, payloads)
+}
+
+// validatePolicy checks a purpose and policy, wrapping any problem in
+// ErrInvalidPolicy.
+func validatePolicy(purpose models.Purpose, policy models.Policy) error {
+	if err := purpose.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	} else if err := policy.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	return nil
+}
+
+// normalizeDomain lower-cases a domain name and ensures it is a bare host.
+func normalizeDomain(domain string) (string, error) {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(d) == 0 {
+		return "", errors.New("missing domain")
+	} else if strings.ContainsAny(d, "/:@?# ") {
+		return "", fmt.Errorf("domain must be a bare host name: %q", domain)
+	}
+	return d, nil
+}
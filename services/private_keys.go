@@ -18,6 +18,7 @@ package services
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -40,6 +41,7 @@ const (
 
 const (
 	pKeyHttpSigPurpose = "http-signature"
+	pKeyEd25519Purpose = "ed25519"
 )
 
 type PrivateKeys struct {
@@ -51,8 +53,9 @@ type PrivateKeys struct {
 
 func (p *PrivateKeys) GetUserHTTPSignatureKey(c util.Context, userID paths.UUID) (k *rsa.PrivateKey, iri *url.URL, err error) {
 	var kb []byte
+	var keyID sql.NullString
 	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
-		kb, err = p.PrivateKeys.GetByUserID(c, tx, string(userID), pKeyHttpSigPurpose)
+		kb, keyID, err = p.PrivateKeys.GetByUserID(c, tx, string(userID), pKeyHttpSigPurpose)
 		return err
 	})
 	if err != nil {
@@ -66,14 +69,19 @@ func (p *PrivateKeys) GetUserHTTPSignatureKey(c util.Context, userID paths.UUID)
 		err = errors.New("private key is not of type *rsa.PrivateKey")
 		return
 	}
+	if keyID.Valid {
+		iri, err = url.Parse(keyID.String)
+		return
+	}
 	iri = paths.UUIDIRIFor(p.Scheme, p.Host, paths.HttpSigPubKeyKey, userID)
 	return
 }
 
 func (p *PrivateKeys) GetUserHTTPSignatureKeyForInstanceActor(c util.Context) (k *rsa.PrivateKey, iri *url.URL, err error) {
 	var kb []byte
+	var keyID sql.NullString
 	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
-		kb, err = p.PrivateKeys.GetInstanceActor(c, tx, pKeyHttpSigPurpose)
+		kb, keyID, err = p.PrivateKeys.GetInstanceActor(c, tx, pKeyHttpSigPurpose)
 		return err
 	})
 	if err != nil {
@@ -87,10 +95,50 @@ func (p *PrivateKeys) GetUserHTTPSignatureKeyForInstanceActor(c util.Context) (k
 		err = errors.New("private key is not of type *rsa.PrivateKey")
 		return
 	}
+	if keyID.Valid {
+		iri, err = url.Parse(keyID.String)
+		return
+	}
 	iri = paths.ActorIRIFor(p.Scheme, p.Host, paths.HttpSigPubKeyKey, paths.InstanceActor)
 	return
 }
 
+// GetUserEd25519Key obtains the Ed25519 key of a user, which secures their
+// activities with Data Integrity proofs.
+func (p *PrivateKeys) GetUserEd25519Key(c util.Context, userID paths.UUID) (k ed25519.PrivateKey, iri *url.URL, err error) {
+	var kb []byte
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		kb, _, err = p.PrivateKeys.GetByUserID(c, tx, string(userID), pKeyEd25519Purpose)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if k, err = deserializeEd25519PrivateKey(kb); err != nil {
+		return
+	}
+	iri = paths.UUIDIRIFor(p.Scheme, p.Host, paths.Ed25519PubKeyKey, userID)
+	return
+}
+
+// GetEd25519KeyForInstanceActor is like GetUserEd25519Key, for the instance
+// actor.
+func (p *PrivateKeys) GetEd25519KeyForInstanceActor(c util.Context) (k ed25519.PrivateKey, iri *url.URL, err error) {
+	var kb []byte
+	err = doInTx(c, p.DB, func(tx *sql.Tx) error {
+		kb, _, err = p.PrivateKeys.GetInstanceActor(c, tx, pKeyEd25519Purpose)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if k, err = deserializeEd25519PrivateKey(kb); err != nil {
+		return
+	}
+	iri = paths.ActorIRIFor(p.Scheme, p.Host, paths.Ed25519PubKeyKey, paths.InstanceActor)
+	return
+}
+
 // CreateKeyFile writes a symmetric key of random bytes to a file.
 func CreateKeyFile(file string) (err error) {
 	c := 32
@@ -123,6 +171,23 @@ func createAndSerializeRSAKeys(n int) (priv []byte, pub string, err error) {
 	return
 }
 
+// createAndSerializeEd25519Keys creates a new Ed25519 Private key and returns
+// its PKCS8 encoded form and the public key's Multikey form.
+func createAndSerializeEd25519Keys() (priv []byte, pub string, err error) {
+	var pk ed25519.PublicKey
+	var k ed25519.PrivateKey
+	pk, k, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	priv, err = x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return
+	}
+	pub, err = util.EncodeMultikey(pk)
+	return
+}
+
 // createRSAPrivateKey creates a new RSA Private key of a given size.
 //
 // Returns an error if the size is less than minKeySize.
@@ -157,3 +222,17 @@ func serializeRSAPrivateKey(k *rsa.PrivateKey) ([]byte, error) {
 func deserializeRSAPrivateKey(b []byte) (crypto.PrivateKey, error) {
 	return x509.ParsePKCS8PrivateKey(b)
 }
+
+// deserializeEd25519PrivateKey decodes an Ed25519 private key from PKCS8
+// format.
+func deserializeEd25519PrivateKey(b []byte) (ed25519.PrivateKey, error) {
+	pk, err := x509.ParsePKCS8PrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := pk.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not of type ed25519.PrivateKey")
+	}
+	return k, nil
+}
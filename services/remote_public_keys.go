@@ -0,0 +1,56 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+)
+
+type RemotePublicKeys struct {
+	DB               *sql.DB
+	RemotePublicKeys *models.RemotePublicKeys
+}
+
+// Get returns the cached public key with the id, whether or not it has
+// expired.
+func (r *RemotePublicKeys) Get(c util.Context, keyID *url.URL) (k models.RemotePublicKey, found bool, err error) {
+	err = doInTx(c, r.DB, func(tx *sql.Tx) error {
+		k, found, err = r.RemotePublicKeys.Get(c, tx, keyID)
+		return err
+	})
+	return
+}
+
+func (r *RemotePublicKeys) Put(c util.Context, keyID *url.URL, k models.RemotePublicKey) error {
+	return doInTx(c, r.DB, func(tx *sql.Tx) error {
+		return r.RemotePublicKeys.Put(c, tx, keyID, k)
+	})
+}
+
+// Prune removes the public keys that expired before the given time.
+func (r *RemotePublicKeys) Prune(c util.Context, before time.Time) (n int64, err error) {
+	err = doInTx(c, r.DB, func(tx *sql.Tx) error {
+		n, err = r.RemotePublicKeys.Prune(c, tx, before)
+		return err
+	})
+	return
+}
@@ -0,0 +1,375 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allinbits/apcore/models"
+	"github.com/allinbits/apcore/util"
+)
+
+const (
+	// nRecoveryCodes is how many recovery codes a user is given at a time.
+	nRecoveryCodes = 10
+	// recoveryCodeSize is the number of random bytes in a recovery code.
+	recoveryCodeSize = 5
+	// maxSecondFactorAttempts is how many times in a row a user may try to
+	// provide a second factor before being locked out.
+	maxSecondFactorAttempts = 5
+	// secondFactorLockout is how long a user is first locked out for,
+	// which doubles with every further attempt up to maxSecondFactorLockout.
+	secondFactorLockout    = time.Minute
+	maxSecondFactorLockout = 24 * time.Hour
+)
+
+// ErrTOTPAlreadyEnabled is returned when starting to enroll a user's
+// time-based one-time password authenticator while they already have one.
+var ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+
+// ErrTOTPNotEnrolling is returned when confirming a user's time-based
+// one-time password authenticator without having started to enroll it.
+var ErrTOTPNotEnrolling = errors.New("totp enrollment not started")
+
+// ErrWebAuthnCredentialNotFound is returned when a user's WebAuthn credential
+// does not exist.
+var ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+
+// TwoFactorStatus describes the second factors that a user can log in with.
+type TwoFactorStatus struct {
+	TOTP                bool
+	RecoveryCodes       int
+	WebAuthnCredentials []models.WebAuthnCredential
+}
+
+// Enabled determines whether the user must provide a second factor to log in.
+func (s TwoFactorStatus) Enabled() bool {
+	return s.TOTP || len(s.WebAuthnCredentials) > 0
+}
+
+// TwoFactor service provides high level service methods for the second
+// factors that users log in with, in addition to their password.
+//
+// Recovery codes are created when a user enables their first second factor,
+// and deleted when they disable their last one.
+type TwoFactor struct {
+	DB                   *sql.DB
+	TOTPSecrets          *models.TOTPSecrets
+	RecoveryCodes        *models.RecoveryCodes
+	WebAuthnCredentials  *models.WebAuthnCredentials
+	SecondFactorFailures *models.SecondFactorFailures
+	WebAuthnChallenges   *models.WebAuthnChallenges
+}
+
+// Status fetches the second factors of the user.
+func (t *TwoFactor) Status(c util.Context, userID string) (s TwoFactorStatus, err error) {
+	return s, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		s, err = t.status(c, tx, userID)
+		return err
+	})
+}
+
+// BeginTOTP stores the secret of a time-based one-time password authenticator
+// that the user is enrolling, replacing any they did not confirm.
+func (t *TwoFactor) BeginTOTP(c util.Context, userID string, secret []byte) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		ts, found, err := t.TOTPSecrets.Get(c, tx, userID)
+		if err != nil {
+			return err
+		} else if found && ts.Confirmed {
+			return ErrTOTPAlreadyEnabled
+		} else if found {
+			if err := t.TOTPSecrets.Delete(c, tx, userID); err != nil {
+				return err
+			}
+		}
+		return t.TOTPSecrets.Create(c, tx, userID, secret)
+	})
+}
+
+// TOTPSecret fetches the secret of the user's time-based one-time password
+// authenticator, which is not found if the user has none.
+func (t *TwoFactor) TOTPSecret(c util.Context, userID string) (ts models.TOTPSecret, found bool, err error) {
+	return ts, found, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		ts, found, err = t.TOTPSecrets.Get(c, tx, userID)
+		return err
+	})
+}
+
+// ConfirmTOTP requires the user's time-based one-time password authenticator
+// to log in, once they have used a code of the time step. If this enables
+// two-factor authentication for the user, their new recovery codes are
+// returned.
+func (t *TwoFactor) ConfirmTOTP(c util.Context, userID string, step int64) (recoveryCodes []string, err error) {
+	return recoveryCodes, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		ts, found, err := t.TOTPSecrets.Get(c, tx, userID)
+		if err != nil {
+			return err
+		} else if !found {
+			return ErrTOTPNotEnrolling
+		} else if ts.Confirmed {
+			return ErrTOTPAlreadyEnabled
+		}
+		s, err := t.status(c, tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := t.TOTPSecrets.Confirm(c, tx, userID); err != nil {
+			return err
+		} else if _, err := t.TOTPSecrets.UpdateLastStep(c, tx, userID, step); err != nil {
+			return err
+		}
+		if !s.Enabled() {
+			recoveryCodes, err = t.newRecoveryCodes(c, tx, userID)
+		}
+		return err
+	})
+}
+
+// UseTOTPStep records that the user logged in with a code of the time step. It
+// returns false if a code of the same or a later time step was already used.
+func (t *TwoFactor) UseTOTPStep(c util.Context, userID string, step int64) (used bool, err error) {
+	return used, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		used, err = t.TOTPSecrets.UpdateLastStep(c, tx, userID, step)
+		return err
+	})
+}
+
+// DisableTOTP removes the user's time-based one-time password authenticator.
+func (t *TwoFactor) DisableTOTP(c util.Context, userID string) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		if _, found, err := t.TOTPSecrets.Get(c, tx, userID); err != nil {
+			return err
+		} else if !found {
+			return nil
+		}
+		if err := t.TOTPSecrets.Delete(c, tx, userID); err != nil {
+			return err
+		}
+		return t.deleteUnusedRecoveryCodes(c, tx, userID)
+	})
+}
+
+// UseRecoveryCode determines whether the code is one of the user's recovery
+// codes, and if so deletes it so that it cannot be used again.
+func (t *TwoFactor) UseRecoveryCode(c util.Context, userID, code string) (used bool, err error) {
+	return used, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		used, err = t.RecoveryCodes.Use(c, tx, userID, hashRecoveryCode(code))
+		return err
+	})
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes. It is an
+// error if the user has not enabled two-factor authentication.
+func (t *TwoFactor) RegenerateRecoveryCodes(c util.Context, userID string) (recoveryCodes []string, err error) {
+	return recoveryCodes, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		s, err := t.status(c, tx, userID)
+		if err != nil {
+			return err
+		} else if !s.Enabled() {
+			return fmt.Errorf("cannot regenerate recovery codes: two-factor authentication is not enabled")
+		}
+		recoveryCodes, err = t.newRecoveryCodes(c, tx, userID)
+		return err
+	})
+}
+
+// AddWebAuthnCredential stores a credential that the user registered. If this
+// enables two-factor authentication for the user, their new recovery codes are
+// returned.
+func (t *TwoFactor) AddWebAuthnCredential(c util.Context, userID string, wc models.WebAuthnCredential) (id string, recoveryCodes []string, err error) {
+	return id, recoveryCodes, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		s, err := t.status(c, tx, userID)
+		if err != nil {
+			return err
+		}
+		if id, err = t.WebAuthnCredentials.Create(c, tx, userID, wc); err != nil {
+			return err
+		}
+		if !s.Enabled() {
+			recoveryCodes, err = t.newRecoveryCodes(c, tx, userID)
+		}
+		return err
+	})
+}
+
+// UseWebAuthnCredential records that the user logged in with the credential,
+// with the authenticator's new signature counter.
+func (t *TwoFactor) UseWebAuthnCredential(c util.Context, id string, signCount int64) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		return t.WebAuthnCredentials.UpdateSignCount(c, tx, id, signCount)
+	})
+}
+
+// RemoveWebAuthnCredential deletes one of the user's credentials.
+func (t *TwoFactor) RemoveWebAuthnCredential(c util.Context, userID, id string) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		wc, err := t.WebAuthnCredentials.GetForUser(c, tx, userID)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, cred := range wc {
+			if cred.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrWebAuthnCredentialNotFound
+		}
+		if err := t.WebAuthnCredentials.Delete(c, tx, id, userID); err != nil {
+			return err
+		}
+		return t.deleteUnusedRecoveryCodes(c, tx, userID)
+	})
+}
+
+// AddWebAuthnChallenge stores a challenge sent to the user's browser, which
+// can be used once until it expires. Challenges that expired unused are
+// deleted.
+func (t *TwoFactor) AddWebAuthnChallenge(c util.Context, challenge string, now, expires time.Time) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		if err := t.WebAuthnChallenges.DeleteExpired(c, tx, now); err != nil {
+			return err
+		}
+		return t.WebAuthnChallenges.Create(c, tx, challenge, expires)
+	})
+}
+
+// UseWebAuthnChallenge determines whether the challenge was sent to a browser
+// and has not expired, and if so deletes it so that it cannot be used again.
+func (t *TwoFactor) UseWebAuthnChallenge(c util.Context, challenge string, now time.Time) (used bool, err error) {
+	return used, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		used, err = t.WebAuthnChallenges.Use(c, tx, challenge, now)
+		return err
+	})
+}
+
+// BeginSecondFactorAttempt determines whether the user may try to provide a
+// second factor, which is not allowed while they are locked out after too many
+// attempts in a row. An allowed attempt counts as a failure until
+// SucceedSecondFactorAttempt is called, so that concurrent attempts cannot get
+// around the lockout.
+func (t *TwoFactor) BeginSecondFactorAttempt(c util.Context, userID string, now time.Time) (allowed bool, err error) {
+	return allowed, doInTx(c, t.DB, func(tx *sql.Tx) error {
+		n, lockedUntil, err := t.SecondFactorFailures.Get(c, tx, userID)
+		if err != nil {
+			return err
+		} else if now.Before(lockedUntil) {
+			return nil
+		}
+		allowed = true
+		n++
+		if n >= maxSecondFactorAttempts {
+			lockedUntil = now.Add(secondFactorLockoutAfter(n))
+		}
+		return t.SecondFactorFailures.Set(c, tx, userID, n, lockedUntil)
+	})
+}
+
+// SucceedSecondFactorAttempt forgets the user's failed attempts once they
+// provide a valid second factor.
+func (t *TwoFactor) SucceedSecondFactorAttempt(c util.Context, userID string) error {
+	return doInTx(c, t.DB, func(tx *sql.Tx) error {
+		return t.SecondFactorFailures.Delete(c, tx, userID)
+	})
+}
+
+// secondFactorLockoutAfter determines how long a user is locked out after the
+// given number of attempts in a row.
+func secondFactorLockoutAfter(n int) time.Duration {
+	d := secondFactorLockout
+	for i := maxSecondFactorAttempts; i < n && d < maxSecondFactorLockout; i++ {
+		d *= 2
+	}
+	if d > maxSecondFactorLockout {
+		d = maxSecondFactorLockout
+	}
+	return d
+}
+
+func (t *TwoFactor) status(c util.Context, tx *sql.Tx, userID string) (s TwoFactorStatus, err error) {
+	var ts models.TOTPSecret
+	var found bool
+	if ts, found, err = t.TOTPSecrets.Get(c, tx, userID); err != nil {
+		return
+	}
+	s.TOTP = found && ts.Confirmed
+	if s.RecoveryCodes, err = t.RecoveryCodes.Count(c, tx, userID); err != nil {
+		return
+	}
+	s.WebAuthnCredentials, err = t.WebAuthnCredentials.GetForUser(c, tx, userID)
+	return
+}
+
+// newRecoveryCodes replaces the user's recovery codes.
+func (t *TwoFactor) newRecoveryCodes(c util.Context, tx *sql.Tx, userID string) (codes []string, err error) {
+	if err = t.RecoveryCodes.DeleteAll(c, tx, userID); err != nil {
+		return
+	}
+	for i := 0; i < nRecoveryCodes; i++ {
+		var code string
+		if code, err = newRecoveryCode(); err != nil {
+			return
+		} else if err = t.RecoveryCodes.Create(c, tx, userID, hashRecoveryCode(code)); err != nil {
+			return
+		}
+		codes = append(codes, code)
+	}
+	return
+}
+
+// deleteUnusedRecoveryCodes deletes the user's recovery codes once they no
+// longer have any second factor enabled.
+func (t *TwoFactor) deleteUnusedRecoveryCodes(c util.Context, tx *sql.Tx, userID string) error {
+	s, err := t.status(c, tx, userID)
+	if err != nil {
+		return err
+	} else if s.Enabled() {
+		return nil
+	}
+	return t.RecoveryCodes.DeleteAll(c, tx, userID)
+}
+
+// newRecoveryCode creates a random recovery code of eight lowercase base32
+// characters, formatted as two groups of four such as "abcd-efgh".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return s[:len(s)/2] + "-" + s[len(s)/2:], nil
+}
+
+// hashRecoveryCode hashes a recovery code as entered by the user, ignoring its
+// case, spaces, and dashes.
+//
+// Recovery codes are random enough to not need a salt nor a slow hash.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(code))
+	return h[:]
+}
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"sync"
 
@@ -68,6 +69,7 @@ type Users struct {
 	DB          *sql.DB
 	Users       *models.Users
 	PrivateKeys *models.PrivateKeys
+	RetiredKeys *models.RetiredKeys
 	Inboxes     *models.Inboxes
 	Outboxes    *models.Outboxes
 	Followers   *models.Followers
@@ -136,13 +138,14 @@ func (u *Users) createApplicationActor(c util.Context, actor paths.Actor, scheme
 		models.Preferences{
 			OnFollow: models.OnFollowBehavior(pub.OnFollowDoNothing),
 		},
-		func(userID, pubKey string) (models.ActivityStreams, *url.URL) {
+		func(userID, pubKey, multikey string) (models.ActivityStreams, *url.URL) {
 			actorAS, actorID := toApplicationActor(actor,
 				scheme,
 				host,
 				host, // username
 				prefUsername,
-				pubKey)
+				pubKey,
+				multikey)
 			return models.ActivityStreams{actorAS}, actorID
 		})
 }
@@ -162,14 +165,15 @@ func (u *Users) createPersonUser(c util.Context, params CreateUserParameters, pa
 		prefUsername,
 		roles,
 		prefs,
-		func(userID, pubKey string) (models.ActivityStreams, *url.URL) {
+		func(userID, pubKey, multikey string) (models.ActivityStreams, *url.URL) {
 			actor, actorID := toPersonActor(paths.UUID(userID),
 				params.Scheme,
 				params.Host,
 				params.Username,
 				prefUsername,
 				"", // summary
-				pubKey)
+				pubKey,
+				multikey)
 			return models.ActivityStreams{actor}, actorID
 		})
 }
@@ -181,14 +185,18 @@ func (u *Users) createUser(c util.Context,
 	prefUsername string,
 	roles models.Privileges,
 	prefs models.Preferences,
-	actor func(userID, pubKey string) (models.ActivityStreams, *url.URL)) (userID string, err error) {
-	// Prepare PrivateKey
-	var privKey []byte
-	var pubKey string
+	actor func(userID, pubKey, multikey string) (models.ActivityStreams, *url.URL)) (userID string, err error) {
+	// Prepare PrivateKeys
+	var privKey, edPrivKey []byte
+	var pubKey, multikey string
 	privKey, pubKey, err = createAndSerializeRSAKeys(rsaKeySize)
 	if err != nil {
 		return
 	}
+	edPrivKey, multikey, err = createAndSerializeEd25519Keys()
+	if err != nil {
+		return
+	}
 
 	u.muCheck.Lock()
 	defer u.muCheck.Unlock()
@@ -211,7 +219,7 @@ func (u *Users) createUser(c util.Context,
 			return err
 		}
 		// Create the ActivityStreams collections based on the userID.
-		actor, actorID := actor(userID, pubKey)
+		actor, actorID := actor(userID, pubKey, multikey)
 		var inbox, outbox vocab.ActivityStreamsOrderedCollection
 		inbox, err = emptyInbox(actorID)
 		if err != nil {
@@ -244,6 +252,10 @@ func (u *Users) createUser(c util.Context,
 		if err != nil {
 			return err
 		}
+		err = u.PrivateKeys.Create(c, tx, userID, pKeyEd25519Purpose, edPrivKey)
+		if err != nil {
+			return err
+		}
 		// Insert empty inbox, outbox, followers, following, liked
 		err = u.Inboxes.Create(c, tx, actorID, models.ActivityStreamsOrderedCollection{inbox})
 		if err != nil {
@@ -265,6 +277,56 @@ func (u *Users) createUser(c util.Context,
 	})
 }
 
+// AddMissingEd25519Keys creates an Ed25519 key for each user that was created
+// without one, publishing it on the user's actor.
+func (u *Users) AddMissingEd25519Keys(c util.Context) (n int, err error) {
+	var ids []string
+	if err = doInTx(c, u.DB, func(tx *sql.Tx) error {
+		ids, err = u.PrivateKeys.UsersWithout(c, tx, pKeyEd25519Purpose)
+		return err
+	}); err != nil {
+		return
+	}
+	for _, id := range ids {
+		var privKey []byte
+		var multikey string
+		privKey, multikey, err = createAndSerializeEd25519Keys()
+		if err != nil {
+			return
+		}
+		err = doInTx(c, u.DB, func(tx *sql.Tx) error {
+			user, err := u.Users.UserByID(c, tx, id)
+			if err != nil {
+				return err
+			} else if user == nil {
+				return fmt.Errorf("user disappeared while adding its Ed25519 key: %s", id)
+			}
+			actorID, err := pub.GetId(user.Actor.Type)
+			if err != nil {
+				return err
+			}
+			keyID, err := paths.IRIForActorID(paths.Ed25519PubKeyKey, actorID)
+			if err != nil {
+				return err
+			}
+			uh, ok := user.Actor.Type.(unknownPropertiesHaver)
+			if !ok {
+				return fmt.Errorf("actor of type %T cannot publish a Multikey", user.Actor.Type)
+			}
+			setMultikey(uh, actorID, keyID, multikey)
+			if err = u.Users.UpdateActor(c, tx, id, user.Actor); err != nil {
+				return err
+			}
+			return u.PrivateKeys.Create(c, tx, id, pKeyEd25519Purpose, privKey)
+		})
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
 // checkUserConstraints ensures ALL constraints related to a user are
 // maintained.
 //
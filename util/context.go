@@ -35,6 +35,8 @@ const (
 	actorIRIContextKey           = "actorIRI"
 	completeRequestURLContextKey = "completeRequestURL"
 	privateScopeContextKey       = "privateScope"
+	quarantineContextKey         = "quarantine"
+	proofStatusContextKey        = "proofStatus"
 )
 
 type Context struct {
@@ -92,6 +94,49 @@ func (c *Context) WithPrivateScope(b bool) {
 	c.Context = context.WithValue(c.Context, privateScopeContextKey, b)
 }
 
+// WithQuarantineFlag is used for federating contexts, allowing the activity to
+// later be marked as quarantined by MarkQuarantined.
+func (c *Context) WithQuarantineFlag() {
+	c.Context = context.WithValue(c.Context, quarantineContextKey, new(bool))
+}
+
+// MarkQuarantined flags the activity in a federating context as quarantined.
+//
+// The flag is shared by all contexts derived from the one it was set on, as
+// it must be set in callbacks that are unable to return a new context.
+func (c Context) MarkQuarantined() error {
+	b, ok := c.Value(quarantineContextKey).(*bool)
+	if !ok {
+		return errors.New("no quarantine flag in context")
+	}
+	*b = true
+	return nil
+}
+
+// IsQuarantined is available in federating contexts.
+func (c Context) IsQuarantined() bool {
+	b, ok := c.Value(quarantineContextKey).(*bool)
+	return ok && *b
+}
+
+// WithProofStatus is used for federating contexts, recording the outcome of
+// verifying the proof of the received activity.
+func (c *Context) WithProofStatus(status string) {
+	c.Context = context.WithValue(c.Context, proofStatusContextKey, status)
+}
+
+// ProofStatus is available in federating contexts.
+func (c Context) ProofStatus() (s string, err error) {
+	v := c.Value(proofStatusContextKey)
+	var ok bool
+	if v == nil {
+		err = errors.New("no proof status in context")
+	} else if s, ok = v.(string); !ok {
+		err = errors.New("proof status in context is not a string")
+	}
+	return
+}
+
 // Activity is available in federating contexts.
 func (c Context) Activity() (t pub.Activity, err error) {
 	v := c.Value(activityContextKey)
@@ -0,0 +1,121 @@
+// apcore is a server framework for implementing an ActivityPub application.
+// Copyright (C) 2020 Cory Slep
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// base58btcMultibase is the multibase prefix of base58btc encoded data.
+	base58btcMultibase = 'z'
+	base58Alphabet     = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// ed25519PubMulticodec is the varint encoded multicodec prefix of an Ed25519
+// public key.
+var ed25519PubMulticodec = []byte{0xed, 0x01}
+
+// EncodeMultikey encodes a public key as the publicKeyMultibase value of a
+// Multikey, as used in the assertionMethod of ActivityPub actors.
+//
+// Only Ed25519 public keys are supported.
+func EncodeMultikey(p crypto.PublicKey) (string, error) {
+	k, ok := p.(ed25519.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("unsupported Multikey public key type: %T", p)
+	}
+	b := make([]byte, 0, len(ed25519PubMulticodec)+len(k))
+	b = append(b, ed25519PubMulticodec...)
+	b = append(b, k...)
+	return EncodeMultibase(b), nil
+}
+
+// DecodeMultikey decodes the publicKeyMultibase value of a Multikey.
+//
+// Only Ed25519 public keys are supported.
+func DecodeMultikey(s string) (crypto.PublicKey, error) {
+	b, err := DecodeMultibase(s)
+	if err != nil {
+		return nil, fmt.Errorf("publicKeyMultibase: %w", err)
+	}
+	if len(b) != len(ed25519PubMulticodec)+ed25519.PublicKeySize ||
+		b[0] != ed25519PubMulticodec[0] ||
+		b[1] != ed25519PubMulticodec[1] {
+		return nil, errors.New("publicKeyMultibase is not an Ed25519 public key")
+	}
+	return ed25519.PublicKey(b[len(ed25519PubMulticodec):]), nil
+}
+
+// EncodeMultibase encodes data as a base58btc multibase string.
+func EncodeMultibase(b []byte) string {
+	return string(base58btcMultibase) + base58Encode(b)
+}
+
+// DecodeMultibase decodes a base58btc multibase string, which is the only
+// multibase encoding supported.
+func DecodeMultibase(s string) ([]byte, error) {
+	if len(s) == 0 || s[0] != base58btcMultibase {
+		return nil, errors.New("not base58btc multibase encoded")
+	}
+	return base58Decode(s[1:])
+}
+
+func base58Encode(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		sb.WriteByte(base58Alphabet[0])
+	}
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(int64(len(base58Alphabet)))
+	mod := new(big.Int)
+	var rev []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		rev = append(rev, base58Alphabet[mod.Int64()])
+	}
+	for i := len(rev) - 1; i >= 0; i-- {
+		sb.WriteByte(rev[i])
+	}
+	return sb.String()
+}
+
+func base58Decode(s string) ([]byte, error) {
+	var zeros int
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	n := new(big.Int)
+	radix := big.NewInt(int64(len(base58Alphabet)))
+	for i := zeros; i < len(s); i++ {
+		d := strings.IndexByte(base58Alphabet, s[i])
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character: %q", s[i])
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}